@@ -0,0 +1,605 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xhd2015/xgo/support/cmd"
+	"github.com/xhd2015/xgo/support/coverage"
+	"github.com/xhd2015/xgo/support/git"
+)
+
+// changes to these files may affect any package,
+// all tests are run
+var affectedFullRunFiles = map[string]bool{
+	"go.mod":      true,
+	"go.sum":      true,
+	"go.work":     true,
+	"go.work.sum": true,
+}
+
+// affectedSelection is the result of xgo test --affected
+type affectedSelection struct {
+	base string
+	// run all tests, fullReason tells why
+	full       bool
+	fullReason string
+	pkgs       []*affectedPkg
+	// tells why the test index is not used
+	indexNote string
+}
+
+type affectedPkg struct {
+	pkg    string
+	reason string
+	// nil if all tests of the package are run
+	tests []*affectedTest
+}
+
+type affectedTest struct {
+	name   string
+	reason string
+}
+
+// affectedGroup is packages tested by one go test, -run
+// applies to all packages of a go test, so packages
+// narrowed to different tests are tested separately
+type affectedGroup struct {
+	// empty if all tests are run
+	runPattern string
+	pkgs       []string
+}
+
+type goListPkg struct {
+	ImportPath   string
+	Name         string
+	Dir          string
+	Standard     bool
+	ForTest      string
+	Deps         []string
+	GoFiles      []string
+	CgoFiles     []string
+	TestGoFiles  []string
+	XTestGoFiles []string
+}
+
+// pkgChange is what changed in a package
+type pkgChange struct {
+	files []string
+	// only _test.go files changed, which
+	// cannot be observed by other packages
+	testOnly bool
+	// changes not confined to function bodies,
+	// the index cannot tell which tests observe them
+	coarse      bool
+	coarseFile  string
+	changedFunc map[string]bool
+}
+
+// selectAffectedTests selects test packages observing changes
+// since the merge base of base and HEAD. If the test index
+// exists and narrowTests is true, tests are further narrowed
+// to those calling changed functions.
+func selectAffectedTests(projectDir string, base string, mod string, pkgArgs []string, narrowTests bool) (*affectedSelection, error) {
+	dir := projectDir
+	if dir == "" {
+		dir = "."
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	base, err = git.ResolveBaseRef(dir, base)
+	if err != nil {
+		return nil, err
+	}
+	topLevel, err := git.ShowTopLevel(dir)
+	if err != nil {
+		return nil, err
+	}
+	files, err := git.ChangedFiles(dir, base)
+	if err != nil {
+		return nil, err
+	}
+	sel := &affectedSelection{base: base}
+	for _, file := range files {
+		if affectedFullRunFiles[path.Base(file)] || file == "vendor/modules.txt" || strings.HasSuffix(file, "/vendor/modules.txt") {
+			sel.full = true
+			sel.fullReason = file + " changed"
+			return sel, nil
+		}
+	}
+	if len(files) == 0 {
+		return sel, nil
+	}
+
+	pkgs, err := listTestPkgs(dir, mod, pkgArgs)
+	if err != nil {
+		return nil, err
+	}
+	pkgByPath := make(map[string]*goListPkg)
+	var dirPkgs []*goListPkg
+	for _, pkg := range pkgs {
+		if pkg.Standard || pkg.ForTest != "" || strings.HasSuffix(pkg.ImportPath, ".test") || pkg.Dir == "" {
+			continue
+		}
+		pkgByPath[pkg.ImportPath] = pkg
+		dirPkgs = append(dirPkgs, pkg)
+	}
+	// the deepest dir first
+	sort.Slice(dirPkgs, func(i, j int) bool {
+		return len(dirPkgs[i].Dir) > len(dirPkgs[j].Dir)
+	})
+
+	var lineChanges map[string]*git.FileChange
+	if narrowTests {
+		diffs, err := git.DiffLines(dir, base)
+		if err != nil {
+			return nil, err
+		}
+		lineChanges = make(map[string]*git.FileChange, len(diffs))
+		for _, diff := range diffs {
+			lineChanges[diff.File] = diff
+		}
+	}
+
+	changes := make(map[string]*pkgChange)
+	for _, file := range files {
+		absFile := filepath.Join(topLevel, filepath.FromSlash(file))
+		pkg := findDirPkg(dirPkgs, absFile)
+		if pkg == nil {
+			// not built by any selected test
+			continue
+		}
+		change := changes[pkg.ImportPath]
+		if change == nil {
+			change = &pkgChange{testOnly: true, changedFunc: make(map[string]bool)}
+			changes[pkg.ImportPath] = change
+		}
+		change.files = append(change.files, file)
+		if strings.HasSuffix(file, "_test.go") && filepath.Dir(absFile) == pkg.Dir {
+			continue
+		}
+		change.testOnly = false
+		if !narrowTests || change.coarse {
+			continue
+		}
+		if !strings.HasSuffix(file, ".go") || filepath.Dir(absFile) != pkg.Dir {
+			// embedded files, assembly...
+			change.coarse = true
+			change.coarseFile = file
+			continue
+		}
+		funcs, ok := changedFuncs(absFile, lineChanges[file])
+		if !ok {
+			change.coarse = true
+			change.coarseFile = file
+			continue
+		}
+		for _, fn := range funcs {
+			change.changedFunc[fn] = true
+		}
+	}
+
+	var index *coverage.TestIndex
+	if narrowTests {
+		indexFile := filepath.Join(topLevel, filepath.FromSlash(coverage.TestIndexFile))
+		_, statErr := os.Stat(indexFile)
+		if statErr == nil {
+			index, err = coverage.LoadTestIndex(indexFile)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			sel.indexNote = fmt.Sprintf("%s not found, run xgo test --cover-per-test to narrow down tests", coverage.TestIndexFile)
+		}
+	}
+
+	for _, testPkg := range pkgs {
+		if !strings.HasSuffix(testPkg.ImportPath, ".test") || testPkg.ForTest != "" {
+			continue
+		}
+		pkgPath := strings.TrimSuffix(testPkg.ImportPath, ".test")
+		pkg := pkgByPath[pkgPath]
+		if pkg == nil {
+			continue
+		}
+		own := changes[pkgPath]
+		var depChanged []string
+		for _, dep := range testPkg.Deps {
+			dep = trimTestVariant(dep)
+			if dep == pkgPath {
+				continue
+			}
+			if change := changes[dep]; change != nil && !change.testOnly {
+				depChanged = append(depChanged, dep)
+			}
+		}
+		depChanged = dedupSorted(depChanged)
+		if own == nil && len(depChanged) == 0 {
+			continue
+		}
+		affected := &affectedPkg{pkg: pkgPath}
+		if own != nil {
+			affected.reason = "changed " + strings.Join(own.files, ", ")
+			if len(depChanged) > 0 {
+				affected.reason += "; depends on changed " + strings.Join(depChanged, ", ")
+			}
+		} else {
+			affected.reason = "depends on changed " + strings.Join(depChanged, ", ")
+		}
+		if index != nil {
+			tests, note := narrowAffectedTests(index, pkg, own, depChanged, changes)
+			if note != "" {
+				affected.reason += " (" + note + ")"
+			}
+			if tests != nil && len(tests) == 0 {
+				// no test observes the change
+				continue
+			}
+			affected.tests = tests
+		}
+		sel.pkgs = append(sel.pkgs, affected)
+	}
+	sort.Slice(sel.pkgs, func(i, j int) bool {
+		return sel.pkgs[i].pkg < sel.pkgs[j].pkg
+	})
+	return sel, nil
+}
+
+func listTestPkgs(dir string, mod string, pkgArgs []string) ([]*goListPkg, error) {
+	args := []string{"list", "-json", "-deps", "-test"}
+	if mod != "" {
+		args = append(args, "-mod="+mod)
+	}
+	if len(pkgArgs) == 0 {
+		pkgArgs = []string{"."}
+	}
+	args = append(args, pkgArgs...)
+	output, err := cmd.Dir(dir).Output("go", args...)
+	if err != nil {
+		return nil, err
+	}
+	var pkgs []*goListPkg
+	dec := json.NewDecoder(strings.NewReader(output))
+	for dec.More() {
+		var pkg *goListPkg
+		err := dec.Decode(&pkg)
+		if err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, nil
+}
+
+// narrowAffectedTests returns tests of pkg observing the changes,
+// nil if all tests should run, with a note telling why
+func narrowAffectedTests(index *coverage.TestIndex, pkg *goListPkg, own *pkgChange, depChanged []string, changes map[string]*pkgChange) ([]*affectedTest, string) {
+	if own != nil && own.coarse {
+		return nil, own.coarseFile + " changed outside of functions"
+	}
+	for _, dep := range depChanged {
+		if changes[dep].coarse {
+			return nil, changes[dep].coarseFile + " changed outside of functions"
+		}
+	}
+	if own != nil {
+		for _, file := range own.files {
+			if strings.HasSuffix(file, "_test.go") {
+				return nil, "test files changed"
+			}
+		}
+	}
+	indexed := make(map[string]*coverage.TestEntry)
+	for _, entry := range index.Tests {
+		if entry.Pkg == pkg.ImportPath {
+			indexed[entry.Name] = entry
+		}
+	}
+	if len(indexed) == 0 {
+		return nil, "not in the test index"
+	}
+
+	changedPkgs := depChanged
+	if own != nil {
+		changedPkgs = append([]string{pkg.ImportPath}, depChanged...)
+	}
+	// calls are only recorded while a test runs, those from
+	// init, TestMain or variable initializers are not in the
+	// index, so any test may observe such changes
+	for _, changedPkg := range changedPkgs {
+		for _, fn := range sortedFuncs(changes[changedPkg].changedFunc) {
+			if fn == "init" {
+				return nil, changedPkg + ".init changed"
+			}
+			if len(index.TestsOfFunc(changedPkg, fn)) == 0 {
+				return nil, changedPkg + "." + fn + " not called by any indexed test"
+			}
+		}
+	}
+	tests := []*affectedTest{}
+	names := sortedKeys(indexed)
+	for _, name := range names {
+		entry := indexed[name]
+		var calls []string
+		for _, f := range entry.Funcs {
+			for _, changedPkg := range changedPkgs {
+				if f.Pkg == changedPkg && changes[changedPkg].changedFunc[f.Name] {
+					calls = append(calls, f.Pkg+"."+f.Name)
+				}
+			}
+		}
+		if len(calls) > 0 {
+			tests = append(tests, &affectedTest{name: name, reason: "calls changed " + strings.Join(calls, ", ")})
+		}
+	}
+	// tests added after the index was saved
+	for _, name := range listTestFuncs(pkg) {
+		if indexed[name] == nil {
+			tests = append(tests, &affectedTest{name: name, reason: "not in the test index"})
+		}
+	}
+	return tests, ""
+}
+
+// changedFuncs returns functions containing changed lines of file,
+// ok is false if any change is outside of function bodies or the
+// file is generic, deleted or cannot be parsed
+func changedFuncs(absFile string, change *git.FileChange) (funcs []string, ok bool) {
+	if change == nil {
+		// deleted or binary
+		return nil, false
+	}
+	fset := token.NewFileSet()
+	astFile, err := parser.ParseFile(fset, absFile, nil, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, false
+	}
+	type funcRange struct {
+		name       string
+		start, end int
+	}
+	var ranges []funcRange
+	for _, decl := range astFile.Decls {
+		fn, isFunc := decl.(*ast.FuncDecl)
+		if !isFunc || fn.Body == nil {
+			continue
+		}
+		ranges = append(ranges, funcRange{
+			name: coverage.FuncName(fn),
+			// the signature is part of the function
+			start: fset.Position(fn.Pos()).Line,
+			end:   fset.Position(fn.End()).Line,
+		})
+		if fn.Type.TypeParams != nil || isGenericRecv(fn) {
+			// instances may be named differently
+			ranges[len(ranges)-1].name = ""
+		}
+	}
+	seen := make(map[string]bool)
+	addLine := func(line int) bool {
+		for _, r := range ranges {
+			if line < r.start || line > r.end {
+				continue
+			}
+			if r.name == "" {
+				return false
+			}
+			if !seen[r.name] {
+				seen[r.name] = true
+				funcs = append(funcs, r.name)
+			}
+			return true
+		}
+		return false
+	}
+	for _, lines := range change.Lines {
+		for line := lines.Start; line <= lines.End; line++ {
+			if !addLine(line) {
+				return nil, false
+			}
+		}
+	}
+	for _, line := range change.DeletedAfter {
+		// deleted between line and line+1, both inside
+		// the same function
+		if !addLine(line) || !addLine(line+1) {
+			return nil, false
+		}
+	}
+	return funcs, true
+}
+
+func isGenericRecv(fn *ast.FuncDecl) bool {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return false
+	}
+	recv := fn.Recv.List[0].Type
+	if star, ok := recv.(*ast.StarExpr); ok {
+		recv = star.X
+	}
+	switch recv.(type) {
+	case *ast.IndexExpr, *ast.IndexListExpr:
+		return true
+	}
+	return false
+}
+
+// listTestFuncs returns names of Test, Fuzz and
+// Example functions of pkg, those matched by -run
+func listTestFuncs(pkg *goListPkg) []string {
+	var names []string
+	fset := token.NewFileSet()
+	for _, file := range append(append([]string(nil), pkg.TestGoFiles...), pkg.XTestGoFiles...) {
+		astFile, err := parser.ParseFile(fset, filepath.Join(pkg.Dir, file), nil, parser.SkipObjectResolution)
+		if err != nil {
+			continue
+		}
+		for _, decl := range astFile.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv != nil {
+				continue
+			}
+			name := fn.Name.Name
+			if isTestFunc(name, "Test") || isTestFunc(name, "Fuzz") || isTestFunc(name, "Example") {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// same as go test: TestXxx, but not Testxxx
+func isTestFunc(name string, prefix string) bool {
+	if !strings.HasPrefix(name, prefix) {
+		return false
+	}
+	if len(name) == len(prefix) {
+		return true
+	}
+	c := name[len(prefix)]
+	return !(c >= 'a' && c <= 'z')
+}
+
+// findDirPkg finds the package containing absFile, dirPkgs
+// are sorted with the deepest dir first
+func findDirPkg(dirPkgs []*goListPkg, absFile string) *goListPkg {
+	for _, pkg := range dirPkgs {
+		if strings.HasPrefix(absFile, pkg.Dir+string(filepath.Separator)) {
+			return pkg
+		}
+	}
+	return nil
+}
+
+// a [a.test] -> a
+func trimTestVariant(pkg string) string {
+	if idx := strings.Index(pkg, " ["); idx >= 0 {
+		return pkg[:idx]
+	}
+	return pkg
+}
+
+// groupAffectedPkgs groups packages by their -run pattern,
+// in the order of pkgs
+func groupAffectedPkgs(pkgs []*affectedPkg) []*affectedGroup {
+	var groups []*affectedGroup
+	byPattern := make(map[string]*affectedGroup)
+	for _, pkg := range pkgs {
+		pattern := runPattern(pkg)
+		group := byPattern[pattern]
+		if group == nil {
+			group = &affectedGroup{runPattern: pattern}
+			byPattern[pattern] = group
+			groups = append(groups, group)
+		}
+		group.pkgs = append(group.pkgs, pkg.pkg)
+	}
+	return groups
+}
+
+func runPattern(pkg *affectedPkg) string {
+	if pkg.tests == nil {
+		return ""
+	}
+	names := make([]string, 0, len(pkg.tests))
+	for _, test := range pkg.tests {
+		names = append(names, test.name)
+	}
+	return "^(" + strings.Join(dedupSorted(names), "|") + ")$"
+}
+
+// affectedGroupArgs inserts -run and packages of group
+// into args of go test at pos, before -args if any
+func affectedGroupArgs(args []string, pos int, group *affectedGroup) []string {
+	groupArgs := make([]string, 0, len(args)+len(group.pkgs)+2)
+	groupArgs = append(groupArgs, args[:pos]...)
+	if group.runPattern != "" {
+		groupArgs = append(groupArgs, "-run", group.runPattern)
+	}
+	groupArgs = append(groupArgs, group.pkgs...)
+	return append(groupArgs, args[pos:]...)
+}
+
+// runAffectedGroups runs the go test command once per group,
+// all groups are run even if some fail
+func runAffectedGroups(goTest *exec.Cmd, pos int, groups []*affectedGroup) error {
+	var firstErr error
+	for _, group := range groups {
+		c := exec.Command(goTest.Path, affectedGroupArgs(goTest.Args[1:], pos, group)...)
+		c.Env = goTest.Env
+		c.Dir = goTest.Dir
+		c.Stdout = goTest.Stdout
+		c.Stderr = goTest.Stderr
+		logDebug("command: %v", c.Args)
+		err := c.Run()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func dedupSorted(list []string) []string {
+	sort.Strings(list)
+	n := 0
+	for i, s := range list {
+		if i > 0 && s == list[n-1] {
+			continue
+		}
+		list[n] = s
+		n++
+	}
+	return list[:n]
+}
+
+func sortedKeys(m map[string]*coverage.TestEntry) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedFuncs(m map[string]bool) []string {
+	funcs := make([]string, 0, len(m))
+	for fn := range m {
+		funcs = append(funcs, fn)
+	}
+	sort.Strings(funcs)
+	return funcs
+}
+
+func writeAffected(w io.Writer, sel *affectedSelection) {
+	if sel.full {
+		fmt.Fprintf(w, "affected: %s, running all tests\n", sel.fullReason)
+		return
+	}
+	if len(sel.pkgs) == 0 {
+		fmt.Fprintf(w, "affected: no tests affected by changes since %s\n", sel.base)
+		return
+	}
+	fmt.Fprintf(w, "affected: %d package(s) affected by changes since %s\n", len(sel.pkgs), sel.base)
+	for _, pkg := range sel.pkgs {
+		fmt.Fprintf(w, "  %s: %s\n", pkg.pkg, pkg.reason)
+		for _, test := range pkg.tests {
+			fmt.Fprintf(w, "    %s: %s\n", test.name, test.reason)
+		}
+	}
+	if sel.indexNote != "" {
+		fmt.Fprintf(w, "  note: %s\n", sel.indexNote)
+	}
+}
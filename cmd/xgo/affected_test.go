@@ -0,0 +1,218 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xhd2015/xgo/support/coverage"
+)
+
+// go test -run TestSelectAffectedTests -v ./cmd/xgo
+func TestSelectAffectedTests(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("requires git")
+	}
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":      "module example.com/m\n\ngo 1.18\n",
+		"a/a.go":      "package a\n\nfunc A() int {\n\treturn 1\n}\n\nfunc B() int {\n\treturn 2\n}\n",
+		"a/a_test.go": "package a\n\nimport \"testing\"\n\nfunc TestA(t *testing.T) { A() }\n",
+		"b/b.go":      "package b\n\nimport \"example.com/m/a\"\n\nfunc C() int {\n\treturn a.A() + a.B()\n}\n",
+		"b/b_test.go": "package b\n\nimport \"testing\"\n\nfunc TestUseA(t *testing.T) {}\n\nfunc TestUseB(t *testing.T) {}\n",
+		"c/c.go":      "package c\n",
+		"c/c_test.go": "package c\n\nimport \"testing\"\n\nfunc TestC(t *testing.T) {}\n",
+	})
+	runGit(t, dir, "init", "-q", "-b", "main")
+	runGit(t, dir, "add", "-A")
+	runGit(t, dir, "-c", "user.name=test", "-c", "user.email=test@test", "commit", "-q", "-m", "init")
+	runGit(t, dir, "checkout", "-q", "-b", "feature")
+
+	// change B only
+	writeFiles(t, dir, map[string]string{
+		"a/a.go": "package a\n\nfunc A() int {\n\treturn 1\n}\n\nfunc B() int {\n\treturn 3\n}\n",
+	})
+
+	sel, err := selectAffectedTests(dir, "main", "", []string{"./..."}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sel.full || len(sel.pkgs) != 2 || len(groupAffectedPkgs(sel.pkgs)) != 1 || groupAffectedPkgs(sel.pkgs)[0].runPattern != "" {
+		t.Fatalf("expect a and b without index, actual: %+v", sel)
+	}
+	if sel.pkgs[0].pkg != "example.com/m/a" || sel.pkgs[1].pkg != "example.com/m/b" {
+		t.Fatalf("unexpected packages: %s, %s", sel.pkgs[0].pkg, sel.pkgs[1].pkg)
+	}
+	if sel.pkgs[1].reason != "depends on changed example.com/m/a" {
+		t.Fatalf("unexpected reason: %s", sel.pkgs[1].reason)
+	}
+
+	index := &coverage.TestIndex{Tests: make(map[string]*coverage.TestEntry)}
+	index.Update([]*coverage.TestEntry{
+		{Pkg: "example.com/m/a", Name: "TestA", Funcs: []*coverage.FuncHit{{Pkg: "example.com/m/a", Name: "A"}}},
+		{Pkg: "example.com/m/b", Name: "TestUseA", Funcs: []*coverage.FuncHit{{Pkg: "example.com/m/a", Name: "A"}}},
+		{Pkg: "example.com/m/b", Name: "TestUseB", Funcs: []*coverage.FuncHit{{Pkg: "example.com/m/a", Name: "B"}}},
+	})
+	err = index.Save(filepath.Join(dir, filepath.FromSlash(coverage.TestIndexFile)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the index itself is ignored
+	writeFiles(t, dir, map[string]string{".gitignore": ".xgo/\n"})
+
+	sel, err = selectAffectedTests(dir, "main", "", []string{"./..."}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sel.pkgs) != 1 || sel.pkgs[0].pkg != "example.com/m/b" || runPattern(sel.pkgs[0]) != "^(TestUseB)$" {
+		t.Fatalf("expect only TestUseB, actual: %+v", sel)
+	}
+	if sel.pkgs[0].tests[0].reason != "calls changed example.com/m/a.B" {
+		t.Fatalf("unexpected reason: %s", sel.pkgs[0].tests[0].reason)
+	}
+
+	// change outside of functions
+	writeFiles(t, dir, map[string]string{
+		"a/a.go": "package a\n\nvar X = 1\n\nfunc A() int {\n\treturn 1\n}\n\nfunc B() int {\n\treturn 3\n}\n",
+	})
+	sel, err = selectAffectedTests(dir, "main", "", []string{"./..."}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sel.pkgs) != 2 || sel.pkgs[1].tests != nil || !strings.Contains(sel.pkgs[1].reason, "changed outside of functions") {
+		t.Fatalf("expect a and b fully run, actual: %+v", sel)
+	}
+
+	writeFiles(t, dir, map[string]string{"go.mod": "module example.com/m\n\ngo 1.19\n"})
+	sel, err = selectAffectedTests(dir, "main", "", []string{"./..."}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sel.full || sel.fullReason != "go.mod changed" {
+		t.Fatalf("expect full run, actual: %+v", sel)
+	}
+}
+
+// go test -run TestSelectAffectedTestsInit -v ./cmd/xgo
+func TestSelectAffectedTestsInit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("requires git")
+	}
+	dir := t.TempDir()
+	aCode := func(initValue string, setupValue string) string {
+		return "package a\n\nvar v int\n\nfunc init() {\n\tv = " + initValue + "\n\tsetup()\n}\n\nfunc setup() {\n\tv += " + setupValue + "\n}\n\nfunc A() int {\n\treturn v\n}\n"
+	}
+	writeFiles(t, dir, map[string]string{
+		"go.mod":      "module example.com/m\n\ngo 1.18\n",
+		".gitignore":  ".xgo/\n",
+		"a/a.go":      aCode("1", "1"),
+		"a/a_test.go": "package a\n\nimport \"testing\"\n\nfunc TestA(t *testing.T) { A() }\n",
+		"b/b.go":      "package b\n\nimport \"example.com/m/a\"\n\nfunc B() int {\n\treturn a.A()\n}\n",
+		"b/b_test.go": "package b\n\nimport \"testing\"\n\nfunc TestB(t *testing.T) { B() }\n",
+	})
+	runGit(t, dir, "init", "-q", "-b", "main")
+	runGit(t, dir, "add", "-A")
+	runGit(t, dir, "-c", "user.name=test", "-c", "user.email=test@test", "commit", "-q", "-m", "init")
+	runGit(t, dir, "checkout", "-q", "-b", "feature")
+
+	// init and setup run before any test, so they are never indexed
+	index := &coverage.TestIndex{Tests: make(map[string]*coverage.TestEntry)}
+	index.Update([]*coverage.TestEntry{
+		{Pkg: "example.com/m/a", Name: "TestA", Funcs: []*coverage.FuncHit{{Pkg: "example.com/m/a", Name: "A"}}},
+		{Pkg: "example.com/m/b", Name: "TestB", Funcs: []*coverage.FuncHit{{Pkg: "example.com/m/b", Name: "B"}, {Pkg: "example.com/m/a", Name: "A"}}},
+	})
+	err := index.Save(filepath.Join(dir, filepath.FromSlash(coverage.TestIndexFile)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		code   string
+		reason string
+	}{
+		{aCode("2", "1"), "example.com/m/a.init changed"},
+		{aCode("1", "2"), "example.com/m/a.setup not called by any indexed test"},
+	} {
+		writeFiles(t, dir, map[string]string{"a/a.go": tt.code})
+		sel, err := selectAffectedTests(dir, "main", "", []string{"./..."}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sel.pkgs) != 2 {
+			t.Fatalf("expect a and b, actual: %+v", sel.pkgs)
+		}
+		for _, pkg := range sel.pkgs {
+			if pkg.tests != nil || !strings.Contains(pkg.reason, tt.reason) {
+				t.Fatalf("expect all tests of %s run for %s, actual: %s %v", pkg.pkg, tt.reason, pkg.reason, pkg.tests)
+			}
+		}
+	}
+}
+
+func TestGroupAffectedPkgs(t *testing.T) {
+	tests := func(names ...string) []*affectedTest {
+		list := []*affectedTest{}
+		for _, name := range names {
+			list = append(list, &affectedTest{name: name})
+		}
+		return list
+	}
+	groups := groupAffectedPkgs([]*affectedPkg{
+		{pkg: "a", tests: tests("TestX")},
+		{pkg: "b"},
+		{pkg: "c", tests: tests("TestY", "TestX")},
+		{pkg: "d", tests: tests("TestX")},
+	})
+	var list []string
+	for _, group := range groups {
+		list = append(list, group.runPattern+" "+strings.Join(group.pkgs, ","))
+	}
+	expect := "^(TestX)$ a,d| b|^(TestX|TestY)$ c"
+	if strings.Join(list, "|") != expect {
+		t.Fatalf("expect %s, actual: %s", expect, strings.Join(list, "|"))
+	}
+
+	args := []string{"test", "-v", "-count=1", "-args", "-x"}
+	groupArgs := affectedGroupArgs(args, 3, groups[0])
+	if strings.Join(groupArgs, " ") != "test -v -count=1 -run ^(TestX)$ a d -args -x" {
+		t.Fatalf("unexpected args: %v", groupArgs)
+	}
+	if strings.Join(affectedGroupArgs(args, 3, groups[1]), " ") != "test -v -count=1 b -args -x" {
+		t.Fatalf("unexpected args without -run")
+	}
+}
+
+func TestSplitPkgArgs(t *testing.T) {
+	flags, pkgs := splitPkgArgs([]string{"-count", "1", "-race", "./a", "-timeout=1m", "./b/...", "-short"})
+	if strings.Join(flags, " ") != "-count 1 -race -timeout=1m -short" {
+		t.Fatalf("unexpected flags: %v", flags)
+	}
+	if strings.Join(pkgs, " ") != "./a ./b/..." {
+		t.Fatalf("unexpected pkgs: %v", pkgs)
+	}
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(file), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(file, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func runGit(t *testing.T, dir string, args ...string) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v %s", args, err, output)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/xhd2015/xgo/support/coverage"
+)
+
+// written by runtime/trace, calls since the previous line
+type callCoverageExport struct {
+	Funcs []*funcHitExport
+}
+
+// saveCallCoverage merges calls of all test binaries
+// of this run into outFile
+func saveCallCoverage(rawFile string, projectDir string, outFile string) error {
+	file, err := os.Open(rawFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// no test run
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	root, err := resolveProjectRoot(projectDir)
+	if err != nil {
+		return err
+	}
+	var covs []*coverage.CallCoverage
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var export callCoverageExport
+		if json.Unmarshal(scanner.Bytes(), &export) != nil {
+			continue
+		}
+		cov := &coverage.CallCoverage{Funcs: make([]*coverage.FuncCall, 0, len(export.Funcs))}
+		for _, f := range export.Funcs {
+			cov.Funcs = append(cov.Funcs, &coverage.FuncCall{
+				Pkg:   f.Pkg,
+				Name:  f.IdentityName,
+				File:  relativeToRoot(root, f.File),
+				Line:  f.Line,
+				Count: f.Count,
+			})
+		}
+		covs = append(covs, cov)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	merged := coverage.MergeCallCoverage(covs...)
+	err = merged.Save(outFile)
+	if err != nil {
+		return err
+	}
+	var invoked int
+	for _, f := range merged.Funcs {
+		if f.Count > 0 {
+			invoked++
+		}
+	}
+	fmt.Fprintf(os.Stderr, "call coverage: %d/%d functions invoked, written to %s\n", invoked, len(merged.Funcs), outFile)
+	return nil
+}
@@ -0,0 +1,141 @@
+package coverage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/xhd2015/xgo/support/coverage"
+)
+
+// CallReport is invoked and never invoked functions of
+// call coverage files, grouped by package
+type CallReport struct {
+	Funcs    int
+	Invoked  int
+	Percent  float64
+	Packages []*PkgCallReport
+}
+
+type PkgCallReport struct {
+	Pkg             string
+	Funcs           int
+	Invoked         int
+	Percent         float64
+	InvokedFuncs    []*coverage.FuncCall
+	NotInvokedFuncs []*coverage.FuncCall
+}
+
+func handleCalls(args []string) error {
+	var format string
+	var outFile string
+	var exportedOnly bool
+	opts := &incrementalOptions{}
+	files, err := parseProfileArgs(args, opts, func(flag string, value func() (string, error)) (bool, error) {
+		var err error
+		switch flag {
+		case "--exported":
+			exportedOnly = true
+		case "--format":
+			format, err = value()
+		case "-o":
+			outFile, err = value()
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+	if err != nil {
+		return err
+	}
+	if format == "" {
+		format = "text"
+	}
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unrecognized format: %s, expect text or json", format)
+	}
+	covs := make([]*coverage.CallCoverage, 0, len(files))
+	for _, file := range files {
+		cov, err := coverage.LoadCallCoverage(file)
+		if err != nil {
+			return err
+		}
+		covs = append(covs, cov)
+	}
+	report := buildCallReport(coverage.MergeCallCoverage(covs...), opts.excludePrefix, exportedOnly)
+
+	var out io.Writer = os.Stdout
+	if outFile != "" {
+		file, err := os.Create(outFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	if format == "json" {
+		data, err := json.MarshalIndent(report, "", "    ")
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(append(data, '\n'))
+		return err
+	}
+	return writeCallText(out, report)
+}
+
+// buildCallReport groups functions by package, cov is
+// expected to be sorted by package
+func buildCallReport(cov *coverage.CallCoverage, excludePrefix []string, exportedOnly bool) *CallReport {
+	report := &CallReport{Packages: []*PkgCallReport{}}
+	var pkg *PkgCallReport
+	for _, f := range cov.Funcs {
+		if hasAnyPrefix(f.Pkg, excludePrefix) || (exportedOnly && !f.Exported()) {
+			continue
+		}
+		if pkg == nil || pkg.Pkg != f.Pkg {
+			pkg = &PkgCallReport{Pkg: f.Pkg}
+			report.Packages = append(report.Packages, pkg)
+		}
+		pkg.Funcs++
+		report.Funcs++
+		if f.Count > 0 {
+			pkg.Invoked++
+			report.Invoked++
+			pkg.InvokedFuncs = append(pkg.InvokedFuncs, f)
+		} else {
+			pkg.NotInvokedFuncs = append(pkg.NotInvokedFuncs, f)
+		}
+	}
+	for _, pkg := range report.Packages {
+		pkg.Percent = percent(pkg.Invoked, pkg.Funcs)
+	}
+	report.Percent = percent(report.Invoked, report.Funcs)
+	return report
+}
+
+func writeCallText(w io.Writer, report *CallReport) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "PACKAGE\tFUNCS\tINVOKED\tPERCENT\n")
+	for _, pkg := range report.Packages {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", pkg.Pkg, pkg.Funcs, pkg.Invoked, formatPercent(pkg.Percent))
+	}
+	fmt.Fprintf(tw, "total\t%d\t%d\t%s\n", report.Funcs, report.Invoked, formatPercent(report.Percent))
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+	if report.Invoked == report.Funcs {
+		return nil
+	}
+	fmt.Fprintf(w, "\nNever invoked:\n")
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, pkg := range report.Packages {
+		for _, f := range pkg.NotInvokedFuncs {
+			fmt.Fprintf(tw, "  %s\t%s:%d\n", f.Key(), f.File, f.Line)
+		}
+	}
+	return tw.Flush()
+}
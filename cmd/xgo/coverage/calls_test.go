@@ -0,0 +1,42 @@
+package coverage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/xhd2015/xgo/support/coverage"
+)
+
+func TestBuildCallReport(t *testing.T) {
+	cov := coverage.MergeCallCoverage(&coverage.CallCoverage{Funcs: []*coverage.FuncCall{
+		{Pkg: "example.com/a", Name: "A", File: "a/a.go", Line: 3, Count: 2},
+		{Pkg: "example.com/a", Name: "b", File: "a/a.go", Line: 7},
+		{Pkg: "example.com/a", Name: "(*T).C", File: "a/a.go", Line: 11},
+		{Pkg: "example.com/b", Name: "B", File: "b/b.go", Line: 3, Count: 1},
+		{Pkg: "example.com/c", Name: "C", File: "c/c.go", Line: 3},
+	}})
+	report := buildCallReport(cov, []string{"example.com/c"}, false)
+	if report.Funcs != 4 || report.Invoked != 2 || len(report.Packages) != 2 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if a := report.Packages[0]; a.Pkg != "example.com/a" || a.Funcs != 3 || a.Invoked != 1 || len(a.NotInvokedFuncs) != 2 {
+		t.Fatalf("unexpected package: %+v", a)
+	}
+
+	exported := buildCallReport(cov, nil, true)
+	if exported.Funcs != 4 || exported.Packages[0].Funcs != 2 {
+		t.Fatalf("unexpected exported report: %+v", exported)
+	}
+
+	var b strings.Builder
+	err := writeCallText(&b, report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := b.String()
+	for _, s := range []string{"example.com/a  3      1        33.3%", "total          4      2        50.0%", "Never invoked:", "example.com/a.(*T).C  a/a.go:11"} {
+		if !strings.Contains(text, s) {
+			t.Fatalf("expect %q in:\n%s", s, text)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package coverage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/xhd2015/xgo/support/coverage"
+)
+
+var errCheckFailed = errors.New("coverage check failed")
+
+// CheckResult is the output of xgo tool coverage check,
+// statement coverage is used for total and packages, line
+// coverage for incremental, see IncrementalReport
+type CheckResult struct {
+	Passed      bool
+	Total       *StmtCoverage
+	Packages    []*StmtCoverage
+	Incremental *IncrementalReport `json:",omitempty"`
+	Violations  []*Violation
+}
+
+type StmtCoverage struct {
+	// empty for total
+	Pkg        string `json:",omitempty"`
+	Statements int
+	Covered    int
+	Percent    float64
+}
+
+type Violation struct {
+	// total, package or incremental
+	Kind string
+	// package, empty for total and incremental
+	Name      string `json:",omitempty"`
+	Percent   float64
+	Threshold float64
+}
+
+type checkThresholds struct {
+	total       float64
+	pkg         float64
+	incremental float64
+	// package prefix -> threshold, the longest prefix wins
+	pkgOverrides map[string]float64
+}
+
+func handleCheck(args []string) error {
+	var format string
+	var outFile string
+	thresholds := &checkThresholds{
+		total:        -1,
+		pkg:          -1,
+		incremental:  -1,
+		pkgOverrides: make(map[string]float64),
+	}
+	opts := &incrementalOptions{}
+	files, err := parseProfileArgs(args, opts, func(flag string, value func() (string, error)) (bool, error) {
+		var err error
+		switch flag {
+		case "--base":
+			opts.base, err = value()
+		case "--min-total":
+			thresholds.total, err = parseThreshold(flag, value)
+		case "--min-incremental":
+			thresholds.incremental, err = parseThreshold(flag, value)
+		case "--min-package":
+			// either 60 or example.com/pkg=60
+			var s string
+			s, err = value()
+			if err != nil {
+				break
+			}
+			idx := strings.LastIndex(s, "=")
+			if idx < 0 {
+				thresholds.pkg, err = parsePercent(flag, s)
+				break
+			}
+			if idx == 0 {
+				err = fmt.Errorf("%s: requires package before =", flag)
+				break
+			}
+			thresholds.pkgOverrides[s[:idx]], err = parsePercent(flag, s[idx+1:])
+		case "--format":
+			format, err = value()
+		case "-o":
+			outFile, err = value()
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+	if err != nil {
+		return err
+	}
+	if format == "" {
+		format = "text"
+	}
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unrecognized format: %s, expect text or json", format)
+	}
+
+	lines, err := loadProfiles(files, opts.excludePrefix)
+	if err != nil {
+		return err
+	}
+	_, blocksByFile, err := coverage.ParseBlocks(lines)
+	if err != nil {
+		return err
+	}
+	var incremental *IncrementalReport
+	if thresholds.incremental >= 0 {
+		incremental, err = computeIncremental(files, opts)
+		if err != nil {
+			return err
+		}
+	}
+	result := checkCoverage(blocksByFile, incremental, thresholds)
+
+	var out io.Writer = os.Stdout
+	if outFile != "" {
+		file, err := os.Create(outFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	if format == "json" {
+		data, err := json.MarshalIndent(result, "", "    ")
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(append(data, '\n'))
+		if err != nil {
+			return err
+		}
+	} else {
+		err := writeCheckText(out, result)
+		if err != nil {
+			return err
+		}
+	}
+	if !result.Passed {
+		return errCheckFailed
+	}
+	return nil
+}
+
+func checkCoverage(blocksByFile map[string][]*coverage.Block, incremental *IncrementalReport, thresholds *checkThresholds) *CheckResult {
+	total, pkgs := stmtCoverages(blocksByFile)
+	result := &CheckResult{
+		Total:       total,
+		Packages:    pkgs,
+		Incremental: incremental,
+		Violations:  []*Violation{},
+	}
+
+	if thresholds.total >= 0 && total.Percent < thresholds.total {
+		result.Violations = append(result.Violations, &Violation{Kind: "total", Percent: total.Percent, Threshold: thresholds.total})
+	}
+	for _, pkgCov := range result.Packages {
+		threshold := thresholds.pkgThreshold(pkgCov.Pkg)
+		if threshold >= 0 && pkgCov.Percent < threshold {
+			result.Violations = append(result.Violations, &Violation{Kind: "package", Name: pkgCov.Pkg, Percent: pkgCov.Percent, Threshold: threshold})
+		}
+	}
+	if incremental != nil && thresholds.incremental >= 0 && incremental.Percent < thresholds.incremental {
+		result.Violations = append(result.Violations, &Violation{Kind: "incremental", Percent: incremental.Percent, Threshold: thresholds.incremental})
+	}
+	result.Passed = len(result.Violations) == 0
+	return result
+}
+
+// stmtCoverages returns statement coverage of all packages and
+// of each package, packages without statements are omitted
+func stmtCoverages(blocksByFile map[string][]*coverage.Block) (*StmtCoverage, []*StmtCoverage) {
+	total := &StmtCoverage{}
+	pkgMap := make(map[string]*StmtCoverage)
+	for _, blocks := range blocksByFile {
+		for _, block := range blocks {
+			pkg := block.Pkg()
+			pkgCov := pkgMap[pkg]
+			if pkgCov == nil {
+				pkgCov = &StmtCoverage{Pkg: pkg}
+				pkgMap[pkg] = pkgCov
+			}
+			pkgCov.Statements += block.NumStmt
+			total.Statements += block.NumStmt
+			if block.Count > 0 {
+				pkgCov.Covered += block.NumStmt
+				total.Covered += block.NumStmt
+			}
+		}
+	}
+	total.Percent = percent(total.Covered, total.Statements)
+
+	var pkgs []*StmtCoverage
+	for _, pkgCov := range pkgMap {
+		if pkgCov.Statements == 0 {
+			continue
+		}
+		pkgCov.Percent = percent(pkgCov.Covered, pkgCov.Statements)
+		pkgs = append(pkgs, pkgCov)
+	}
+	sort.Slice(pkgs, func(i, j int) bool {
+		return pkgs[i].Pkg < pkgs[j].Pkg
+	})
+	return total, pkgs
+}
+
+func parseThreshold(flag string, value func() (string, error)) (float64, error) {
+	s, err := value()
+	if err != nil {
+		return 0, err
+	}
+	return parsePercent(flag, s)
+}
+
+func parsePercent(flag string, s string) (float64, error) {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil || v < 0 || v > 100 {
+		return 0, fmt.Errorf("%s: bad percent %q", flag, s)
+	}
+	return v, nil
+}
+
+// pkgThreshold returns the threshold of the longest matching
+// prefix, or the default one, -1 if not checked
+func (c *checkThresholds) pkgThreshold(pkg string) float64 {
+	threshold := c.pkg
+	var matched string
+	for prefix, v := range c.pkgOverrides {
+		// same matching as --exclude-prefix
+		if !strings.HasPrefix(pkg, prefix) || len(prefix) <= len(matched) {
+			continue
+		}
+		matched = prefix
+		threshold = v
+	}
+	return threshold
+}
+
+func writeCheckText(w io.Writer, result *CheckResult) error {
+	fmt.Fprintf(w, "Total coverage: %s (%d/%d statements)\n", formatPercent(result.Total.Percent), result.Total.Covered, result.Total.Statements)
+	if result.Incremental != nil {
+		fmt.Fprintf(w, "Incremental coverage since %s: %s (%d/%d lines)\n", result.Incremental.Base, formatPercent(result.Incremental.Percent), result.Incremental.Covered, result.Incremental.Lines)
+	}
+	if result.Passed {
+		_, err := fmt.Fprintf(w, "coverage check passed\n")
+		return err
+	}
+	fmt.Fprintf(w, "\n%d violation(s):\n", len(result.Violations))
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "KIND\tNAME\tCOVERAGE\tTHRESHOLD\n")
+	for _, v := range result.Violations {
+		name := v.Name
+		if name == "" {
+			name = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", v.Kind, name, formatPercent(v.Percent), formatPercent(v.Threshold))
+		if v.Kind != "incremental" {
+			continue
+		}
+		// help locating uncovered changes
+		for _, file := range result.Incremental.Files {
+			if file.Covered < file.Lines || file.NoProfile {
+				fmt.Fprintf(tw, "\t%s\t%s\tuncovered: %s\n", file.File, formatPercent(file.Percent), uncoveredLines(file))
+			}
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,53 @@
+package coverage
+
+import (
+	"testing"
+
+	"github.com/xhd2015/xgo/support/coverage"
+)
+
+func TestCheckCoverage(t *testing.T) {
+	_, lines := coverage.Parse(`mode: set
+example.com/a/a.go:4.2,5.1 3 1
+example.com/a/a.go:8.2,8.12 1 0
+example.com/a/b/b.go:8.2,8.12 2 0
+example.com/a/b/b.go:9.2,9.12 2 1`)
+	_, blocksByFile, err := coverage.ParseBlocks(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thresholds := &checkThresholds{
+		total:       60,
+		pkg:         70,
+		incremental: 80,
+		pkgOverrides: map[string]float64{
+			"example.com/a/":  40,
+			"example.com/a/b": 50,
+		},
+	}
+	incremental := &IncrementalReport{Lines: 4, Covered: 3, Percent: 75}
+	result := checkCoverage(blocksByFile, incremental, thresholds)
+	if result.Total.Statements != 8 || result.Total.Covered != 5 {
+		t.Fatalf("unexpected total: %+v", result.Total)
+	}
+	if len(result.Packages) != 2 || result.Packages[0].Pkg != "example.com/a" || result.Packages[0].Percent != 75 {
+		t.Fatalf("unexpected packages: %+v", result.Packages)
+	}
+	if result.Passed {
+		t.Fatalf("expect failed")
+	}
+	// example.com/a 75% >= 70%, example.com/a/b 50% >= 50%
+	var kinds []string
+	for _, v := range result.Violations {
+		kinds = append(kinds, v.Kind)
+	}
+	if len(kinds) != 1 || kinds[0] != "incremental" {
+		t.Fatalf("unexpected violations: %v", kinds)
+	}
+
+	thresholds.pkgOverrides["example.com/a/b"] = 51
+	result = checkCoverage(blocksByFile, nil, thresholds)
+	if len(result.Violations) != 1 || result.Violations[0].Name != "example.com/a/b" || result.Violations[0].Threshold != 51 {
+		t.Fatalf("unexpected violations: %+v", result.Violations)
+	}
+}
@@ -0,0 +1,269 @@
+package coverage
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/xhd2015/xgo/support/coverage"
+)
+
+// handleConvert converts go profiles to lcov or cobertura,
+// functions and branches are reported for files found under
+// the root, see FuncCoverage
+func handleConvert(args []string) error {
+	var format string
+	var outFile string
+	opts := &incrementalOptions{}
+	files, err := parseProfileArgs(args, opts, func(flag string, value func() (string, error)) (bool, error) {
+		var err error
+		switch flag {
+		case "--format":
+			format, err = value()
+		case "-o":
+			outFile, err = value()
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+	if err != nil {
+		return err
+	}
+	if format != "lcov" && format != "cobertura" {
+		return fmt.Errorf("requires --format lcov or cobertura")
+	}
+	root, err := resolveRoot(opts.dir)
+	if err != nil {
+		return err
+	}
+	lines, err := loadProfiles(files, opts.excludePrefix)
+	if err != nil {
+		return err
+	}
+	profileFiles, blocksByFile, err := coverage.ParseBlocks(lines)
+	if err != nil {
+		return err
+	}
+	fileCovs, err := buildFileCoverages(root, profileFiles, blocksByFile)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if outFile != "" {
+		file, err := os.Create(outFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	if format == "lcov" {
+		return writeLCOV(out, fileCovs)
+	}
+	return writeCobertura(out, root, fileCovs, time.Now())
+}
+
+// see https://github.com/linux-test-project/lcov/blob/master/man/geninfo.1
+func writeLCOV(w io.Writer, files []*FileCoverage) error {
+	for _, file := range files {
+		fmt.Fprintf(w, "TN:\nSF:%s\n", file.Path)
+		funcHit := 0
+		for _, fn := range file.Funcs {
+			fmt.Fprintf(w, "FN:%d,%s\n", fn.StartLine, fn.Name)
+		}
+		for _, fn := range file.Funcs {
+			fmt.Fprintf(w, "FNDA:%d,%s\n", fn.Count, fn.Name)
+			if fn.Count > 0 {
+				funcHit++
+			}
+		}
+		fmt.Fprintf(w, "FNF:%d\nFNH:%d\n", len(file.Funcs), funcHit)
+		var branches, branchHit int
+		for i, fn := range file.Funcs {
+			for j, branch := range fn.Branches {
+				taken := "-"
+				if branch.Count > 0 {
+					taken = strconv.FormatInt(branch.Count, 10)
+					branchHit++
+				}
+				fmt.Fprintf(w, "BRDA:%d,%d,%d,%s\n", branch.Line, i, j, taken)
+				branches++
+			}
+		}
+		fmt.Fprintf(w, "BRF:%d\nBRH:%d\n", branches, branchHit)
+		for _, line := range file.Lines {
+			fmt.Fprintf(w, "DA:%d,%d\n", line.Line, line.Count)
+		}
+		_, err := fmt.Fprintf(w, "LF:%d\nLH:%d\nend_of_record\n", len(file.Lines), file.CoveredLines())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// see http://cobertura.sourceforge.net/xml/coverage-04.dtd
+type coberturaCoverage struct {
+	XMLName         xml.Name            `xml:"coverage"`
+	LineRate        string              `xml:"line-rate,attr"`
+	BranchRate      string              `xml:"branch-rate,attr"`
+	LinesCovered    int                 `xml:"lines-covered,attr"`
+	LinesValid      int                 `xml:"lines-valid,attr"`
+	BranchesCovered int                 `xml:"branches-covered,attr"`
+	BranchesValid   int                 `xml:"branches-valid,attr"`
+	Complexity      string              `xml:"complexity,attr"`
+	Version         string              `xml:"version,attr"`
+	Timestamp       int64               `xml:"timestamp,attr"`
+	Sources         []string            `xml:"sources>source"`
+	Packages        []*coberturaPackage `xml:"packages>package"`
+}
+
+type coberturaPackage struct {
+	Name       string            `xml:"name,attr"`
+	LineRate   string            `xml:"line-rate,attr"`
+	BranchRate string            `xml:"branch-rate,attr"`
+	Complexity string            `xml:"complexity,attr"`
+	Classes    []*coberturaClass `xml:"classes>class"`
+}
+
+type coberturaClass struct {
+	Name       string             `xml:"name,attr"`
+	Filename   string             `xml:"filename,attr"`
+	LineRate   string             `xml:"line-rate,attr"`
+	BranchRate string             `xml:"branch-rate,attr"`
+	Complexity string             `xml:"complexity,attr"`
+	Methods    []*coberturaMethod `xml:"methods>method"`
+	Lines      []*coberturaLine   `xml:"lines>line"`
+}
+
+type coberturaMethod struct {
+	Name       string           `xml:"name,attr"`
+	Signature  string           `xml:"signature,attr"`
+	LineRate   string           `xml:"line-rate,attr"`
+	BranchRate string           `xml:"branch-rate,attr"`
+	Complexity string           `xml:"complexity,attr"`
+	Lines      []*coberturaLine `xml:"lines>line"`
+}
+
+type coberturaLine struct {
+	Number            int    `xml:"number,attr"`
+	Hits              int64  `xml:"hits,attr"`
+	Branch            bool   `xml:"branch,attr"`
+	ConditionCoverage string `xml:"condition-coverage,attr,omitempty"`
+}
+
+func writeCobertura(w io.Writer, root string, files []*FileCoverage, now time.Time) error {
+	cov := &coberturaCoverage{
+		Complexity: "0",
+		Timestamp:  now.UnixNano() / int64(time.Millisecond),
+		Sources:    []string{root},
+	}
+	type stat struct {
+		lines, coveredLines, branches, coveredBranches int
+	}
+	pkgStats := make(map[string]*stat)
+	pkgByName := make(map[string]*coberturaPackage)
+	total := &stat{}
+	for _, file := range files {
+		pkg := pkgByName[file.Pkg]
+		if pkg == nil {
+			pkg = &coberturaPackage{Name: file.Pkg, Complexity: "0"}
+			pkgByName[file.Pkg] = pkg
+			pkgStats[file.Pkg] = &stat{}
+			cov.Packages = append(cov.Packages, pkg)
+		}
+		pkgStat := pkgStats[file.Pkg]
+		branches := file.Branches()
+		covered := file.CoveredLines()
+		coveredBranches := coveredLines(branches)
+		class := &coberturaClass{
+			Name:       path.Base(file.Path),
+			Filename:   file.Path,
+			LineRate:   lineRate(covered, len(file.Lines)),
+			BranchRate: lineRate(coveredBranches, len(branches)),
+			Complexity: "0",
+			Lines:      coberturaLines(file.Lines, branches),
+		}
+		for _, fn := range file.Funcs {
+			class.Methods = append(class.Methods, &coberturaMethod{
+				Name:       fn.Name,
+				LineRate:   lineRate(fn.CoveredLines(), len(fn.Lines)),
+				BranchRate: lineRate(coveredLines(fn.Branches), len(fn.Branches)),
+				Complexity: "0",
+				Lines:      coberturaLines(fn.Lines, fn.Branches),
+			})
+		}
+		pkg.Classes = append(pkg.Classes, class)
+		for _, s := range []*stat{pkgStat, total} {
+			s.lines += len(file.Lines)
+			s.coveredLines += covered
+			s.branches += len(branches)
+			s.coveredBranches += coveredBranches
+		}
+	}
+	for _, pkg := range cov.Packages {
+		s := pkgStats[pkg.Name]
+		pkg.LineRate = lineRate(s.coveredLines, s.lines)
+		pkg.BranchRate = lineRate(s.coveredBranches, s.branches)
+	}
+	cov.LinesValid = total.lines
+	cov.LinesCovered = total.coveredLines
+	cov.BranchesValid = total.branches
+	cov.BranchesCovered = total.coveredBranches
+	cov.LineRate = lineRate(total.coveredLines, total.lines)
+	cov.BranchRate = lineRate(total.coveredBranches, total.branches)
+
+	data, err := xml.MarshalIndent(cov, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, xml.Header+`<!DOCTYPE coverage SYSTEM "http://cobertura.sourceforge.net/xml/coverage-04.dtd">`+"\n")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// coberturaLines marks lines where branches start
+func coberturaLines(lines []*LineHit, branches []*LineHit) []*coberturaLine {
+	type branchStat struct {
+		total, covered int
+	}
+	branchByLine := make(map[int]*branchStat)
+	for _, branch := range branches {
+		s := branchByLine[branch.Line]
+		if s == nil {
+			s = &branchStat{}
+			branchByLine[branch.Line] = s
+		}
+		s.total++
+		if branch.Count > 0 {
+			s.covered++
+		}
+	}
+	result := make([]*coberturaLine, 0, len(lines))
+	for _, line := range lines {
+		cl := &coberturaLine{Number: line.Line, Hits: line.Count}
+		if s := branchByLine[line.Line]; s != nil {
+			cl.Branch = true
+			cl.ConditionCoverage = fmt.Sprintf("%d%% (%d/%d)", s.covered*100/s.total, s.covered, s.total)
+		}
+		result = append(result, cl)
+	}
+	return result
+}
+
+// lineRate is a ratio in [0,1]
+func lineRate(covered int, total int) string {
+	if total == 0 {
+		return "1"
+	}
+	return strconv.FormatFloat(float64(covered)/float64(total), 'f', 4, 64)
+}
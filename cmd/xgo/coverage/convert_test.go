@@ -0,0 +1,120 @@
+package coverage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xhd2015/xgo/support/coverage"
+)
+
+const convertTestSource = `package sub
+
+func A(x int) int {
+	return x + 1
+}
+
+func (c *T) B(x int) int {
+	if x > 10 {
+		return 10
+	}
+	return x
+}
+`
+
+func TestConvert(t *testing.T) {
+	root := t.TempDir()
+	writeFile := func(file string, content string) {
+		file = filepath.Join(root, file)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("go.mod", "module example.com/a\n")
+	writeFile("sub/a.go", convertTestSource)
+	// nested module
+	writeFile("nested/go.mod", "module example.com/a/nested\n")
+
+	_, lines := coverage.Parse(`mode: set
+example.com/a/sub/a.go:3.19,5.2 1 1
+example.com/a/sub/a.go:7.26,8.12 1 1
+example.com/a/sub/a.go:8.12,10.3 1 0
+example.com/a/sub/a.go:11.2,11.10 1 1
+example.com/other/b.go:3.10,5.2 1 0`)
+	files, blocksByFile, err := coverage.ParseBlocks(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileCovs, err := buildFileCoverages(root, files, blocksByFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if absFile, ok := resolveFile(map[string]string{"example.com/a": root, "example.com/a/nested": filepath.Join(root, "nested")}, "example.com/a/nested/n.go"); !ok || absFile != filepath.Join(root, "nested", "n.go") {
+		t.Fatalf("unexpected nested file: %s", absFile)
+	}
+
+	var lcov bytes.Buffer
+	if err := writeLCOV(&lcov, fileCovs); err != nil {
+		t.Fatal(err)
+	}
+	expect := `TN:
+SF:example.com/other/b.go
+FNF:0
+FNH:0
+BRF:0
+BRH:0
+DA:3,0
+DA:4,0
+DA:5,0
+LF:3
+LH:0
+end_of_record
+TN:
+SF:sub/a.go
+FN:3,A
+FN:7,(*T).B
+FNDA:1,A
+FNDA:1,(*T).B
+FNF:2
+FNH:2
+BRDA:8,1,0,-
+BRDA:11,1,1,1
+BRF:2
+BRH:1
+DA:3,1
+DA:4,1
+DA:5,1
+DA:7,1
+DA:8,1
+DA:9,0
+DA:10,0
+DA:11,1
+LF:8
+LH:6
+end_of_record
+`
+	if lcov.String() != expect {
+		t.Fatalf("expect lcov:\n%s\nactual:\n%s", expect, lcov.String())
+	}
+
+	var cobertura bytes.Buffer
+	if err := writeCobertura(&cobertura, root, fileCovs, time.Unix(1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`<coverage line-rate="0.5455" branch-rate="0.5000" lines-covered="6" lines-valid="11" branches-covered="1" branches-valid="2"`,
+		`<class name="a.go" filename="sub/a.go" line-rate="0.7500" branch-rate="0.5000"`,
+		`<method name="(*T).B" signature="" line-rate="0.6000" branch-rate="0.5000"`,
+		`<line number="8" hits="1" branch="true" condition-coverage="0% (0/1)"></line>`,
+	} {
+		if !strings.Contains(cobertura.String(), s) {
+			t.Fatalf("expect cobertura to contain %s, actual:\n%s", s, cobertura.String())
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package coverage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/xhd2015/xgo/support/coverage"
+)
+
+// CoverageDiff compares two profiles, blocks are matched by
+// file and position, so blocks of changed code are reflected
+// by package percentages only
+type CoverageDiff struct {
+	Total    *PkgDiff
+	Packages []*PkgDiff
+	// not covered in base, covered in new
+	NewlyCovered []*BlockDiff
+	// covered in base, not covered in new
+	NewlyUncovered   []*BlockDiff
+	FilesAppeared    []string
+	FilesDisappeared []string
+}
+
+// PkgDiff is statement coverage of a package in both
+// profiles, Base or New is nil if the package is absent
+type PkgDiff struct {
+	// empty for total
+	Pkg   string `json:",omitempty"`
+	Base  *StmtCoverage
+	New   *StmtCoverage
+	Delta float64
+}
+
+type BlockDiff struct {
+	File      string
+	StartLine int
+	StartCol  int
+	EndLine   int
+	EndCol    int
+	NumStmt   int
+	BaseCount int64
+	NewCount  int64
+}
+
+func handleDiff(args []string) error {
+	var format string
+	var outFile string
+	opts := &incrementalOptions{}
+	files, err := parseProfileArgs(args, opts, func(flag string, value func() (string, error)) (bool, error) {
+		var err error
+		switch flag {
+		case "--format":
+			format, err = value()
+		case "-o":
+			outFile, err = value()
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+	if err != nil {
+		return err
+	}
+	if len(files) != 2 {
+		return fmt.Errorf("requires exactly 2 profiles: base and new, found: %v", files)
+	}
+	if format == "" {
+		format = "text"
+	}
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unrecognized format: %s, expect text or json", format)
+	}
+	var blocks [2]map[string][]*coverage.Block
+	for i, file := range files {
+		lines, err := loadProfiles([]string{file}, opts.excludePrefix)
+		if err != nil {
+			return err
+		}
+		_, blocks[i], err = coverage.ParseBlocks(lines)
+		if err != nil {
+			return err
+		}
+	}
+	diff := diffCoverage(blocks[0], blocks[1])
+
+	var out io.Writer = os.Stdout
+	if outFile != "" {
+		file, err := os.Create(outFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	if format == "json" {
+		data, err := json.MarshalIndent(diff, "", "    ")
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(append(data, '\n'))
+		return err
+	}
+	return writeDiffText(out, diff)
+}
+
+func diffCoverage(base map[string][]*coverage.Block, cur map[string][]*coverage.Block) *CoverageDiff {
+	diff := &CoverageDiff{
+		NewlyCovered:     []*BlockDiff{},
+		NewlyUncovered:   []*BlockDiff{},
+		FilesAppeared:    []string{},
+		FilesDisappeared: []string{},
+	}
+	baseTotal, basePkgs := stmtCoverages(base)
+	curTotal, curPkgs := stmtCoverages(cur)
+	diff.Total = &PkgDiff{Base: baseTotal, New: curTotal, Delta: curTotal.Percent - baseTotal.Percent}
+
+	pkgMap := make(map[string]*PkgDiff)
+	for _, pkg := range basePkgs {
+		pkgMap[pkg.Pkg] = &PkgDiff{Pkg: pkg.Pkg, Base: pkg}
+	}
+	for _, pkg := range curPkgs {
+		pkgDiff := pkgMap[pkg.Pkg]
+		if pkgDiff == nil {
+			pkgDiff = &PkgDiff{Pkg: pkg.Pkg}
+			pkgMap[pkg.Pkg] = pkgDiff
+		}
+		pkgDiff.New = pkg
+	}
+	for _, pkgDiff := range pkgMap {
+		var basePercent, curPercent float64
+		if pkgDiff.Base != nil {
+			basePercent = pkgDiff.Base.Percent
+		}
+		if pkgDiff.New != nil {
+			curPercent = pkgDiff.New.Percent
+		}
+		pkgDiff.Delta = curPercent - basePercent
+		diff.Packages = append(diff.Packages, pkgDiff)
+	}
+	sort.Slice(diff.Packages, func(i, j int) bool {
+		return diff.Packages[i].Pkg < diff.Packages[j].Pkg
+	})
+
+	for file, curBlocks := range cur {
+		baseBlocks, ok := base[file]
+		if !ok {
+			diff.FilesAppeared = append(diff.FilesAppeared, file)
+			continue
+		}
+		type pos struct {
+			startLine, startCol, endLine, endCol int
+		}
+		baseByPos := make(map[pos]*coverage.Block, len(baseBlocks))
+		for _, block := range baseBlocks {
+			baseByPos[pos{block.StartLine, block.StartCol, block.EndLine, block.EndCol}] = block
+		}
+		for _, block := range curBlocks {
+			baseBlock := baseByPos[pos{block.StartLine, block.StartCol, block.EndLine, block.EndCol}]
+			if baseBlock == nil || (baseBlock.Count > 0) == (block.Count > 0) {
+				continue
+			}
+			blockDiff := &BlockDiff{
+				File:      file,
+				StartLine: block.StartLine,
+				StartCol:  block.StartCol,
+				EndLine:   block.EndLine,
+				EndCol:    block.EndCol,
+				NumStmt:   block.NumStmt,
+				BaseCount: baseBlock.Count,
+				NewCount:  block.Count,
+			}
+			if block.Count > 0 {
+				diff.NewlyCovered = append(diff.NewlyCovered, blockDiff)
+			} else {
+				diff.NewlyUncovered = append(diff.NewlyUncovered, blockDiff)
+			}
+		}
+	}
+	for file := range base {
+		if _, ok := cur[file]; !ok {
+			diff.FilesDisappeared = append(diff.FilesDisappeared, file)
+		}
+	}
+	sortBlockDiffs(diff.NewlyCovered)
+	sortBlockDiffs(diff.NewlyUncovered)
+	sort.Strings(diff.FilesAppeared)
+	sort.Strings(diff.FilesDisappeared)
+	return diff
+}
+
+func sortBlockDiffs(blocks []*BlockDiff) {
+	sort.Slice(blocks, func(i, j int) bool {
+		a, b := blocks[i], blocks[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.StartLine != b.StartLine {
+			return a.StartLine < b.StartLine
+		}
+		return a.StartCol < b.StartCol
+	})
+}
+
+func writeDiffText(w io.Writer, diff *CoverageDiff) error {
+	fmt.Fprintf(w, "Total coverage: %s -> %s (%s)\n", formatPercent(diff.Total.Base.Percent), formatPercent(diff.Total.New.Percent), formatDelta(diff.Total.Delta))
+
+	var changedPkgs []*PkgDiff
+	for _, pkg := range diff.Packages {
+		if pkg.Base == nil || pkg.New == nil || pkg.Delta != 0 || pkg.Base.Statements != pkg.New.Statements {
+			changedPkgs = append(changedPkgs, pkg)
+		}
+	}
+	if len(changedPkgs) > 0 {
+		fmt.Fprintf(w, "\nChanged packages:\n")
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(tw, "PACKAGE\tBASE\tNEW\tDELTA\n")
+		for _, pkg := range changedPkgs {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", pkg.Pkg, formatStmtPercent(pkg.Base), formatStmtPercent(pkg.New), formatDelta(pkg.Delta))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	writeBlockDiffs(w, "Newly covered blocks", diff.NewlyCovered)
+	writeBlockDiffs(w, "Newly uncovered blocks", diff.NewlyUncovered)
+	writeFileList(w, "Files appeared", diff.FilesAppeared)
+	writeFileList(w, "Files disappeared", diff.FilesDisappeared)
+	return nil
+}
+
+func writeBlockDiffs(w io.Writer, title string, blocks []*BlockDiff) {
+	if len(blocks) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n%s (%d):\n", title, len(blocks))
+	for _, block := range blocks {
+		fmt.Fprintf(w, "  %s:%d.%d,%d.%d %d statement(s)\n", block.File, block.StartLine, block.StartCol, block.EndLine, block.EndCol, block.NumStmt)
+	}
+}
+
+func writeFileList(w io.Writer, title string, files []string) {
+	if len(files) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n%s (%d):\n", title, len(files))
+	for _, file := range files {
+		fmt.Fprintf(w, "  %s\n", file)
+	}
+}
+
+func formatStmtPercent(cov *StmtCoverage) string {
+	if cov == nil {
+		return "-"
+	}
+	return formatPercent(cov.Percent)
+}
+
+func formatDelta(delta float64) string {
+	s := strconv.FormatFloat(delta, 'f', 1, 64) + "%"
+	if delta > 0 && s != "0.0%" {
+		return "+" + s
+	}
+	if s == "-0.0%" {
+		return "0.0%"
+	}
+	return s
+}
@@ -0,0 +1,64 @@
+package coverage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/xhd2015/xgo/support/coverage"
+)
+
+func TestDiffCoverage(t *testing.T) {
+	parse := func(profile string) map[string][]*coverage.Block {
+		_, lines := coverage.Parse(profile)
+		_, blocksByFile, err := coverage.ParseBlocks(lines)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return blocksByFile
+	}
+	base := parse(`mode: set
+example.com/a/a.go:3.10,5.2 2 0
+example.com/a/a.go:7.10,9.2 1 1
+example.com/a/old.go:3.10,5.2 1 1
+example.com/b/b.go:3.10,5.2 1 1`)
+	cur := parse(`mode: set
+example.com/a/a.go:3.10,5.2 2 1
+example.com/a/a.go:7.10,9.2 1 0
+example.com/a/new.go:3.10,5.2 1 1
+example.com/b/b.go:3.10,5.2 1 1
+example.com/c/c.go:3.10,5.2 1 0`)
+	diff := diffCoverage(base, cur)
+	if len(diff.NewlyCovered) != 1 || diff.NewlyCovered[0].StartLine != 3 || diff.NewlyCovered[0].NumStmt != 2 {
+		t.Fatalf("unexpected newly covered: %+v", diff.NewlyCovered)
+	}
+	if len(diff.NewlyUncovered) != 1 || diff.NewlyUncovered[0].StartLine != 7 {
+		t.Fatalf("unexpected newly uncovered: %+v", diff.NewlyUncovered)
+	}
+	if strings.Join(diff.FilesAppeared, ",") != "example.com/a/new.go,example.com/c/c.go" || strings.Join(diff.FilesDisappeared, ",") != "example.com/a/old.go" {
+		t.Fatalf("unexpected files: %v %v", diff.FilesAppeared, diff.FilesDisappeared)
+	}
+	if len(diff.Packages) != 3 || diff.Packages[0].Delta != 25 || diff.Packages[1].Delta != 0 || diff.Packages[2].Base != nil {
+		t.Fatalf("unexpected packages: %+v %+v %+v", diff.Packages[0], diff.Packages[1], diff.Packages[2])
+	}
+
+	var b strings.Builder
+	err := writeDiffText(&b, diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := b.String()
+	for _, s := range []string{
+		"Total coverage: 60.0% -> 66.7% (+6.7%)",
+		"example.com/a  50.0%  75.0%  +25.0%",
+		"example.com/c  -      0.0%   0.0%",
+		"Newly covered blocks (1):\n  example.com/a/a.go:3.10,5.2 2 statement(s)",
+		"Files disappeared (1):\n  example.com/a/old.go",
+	} {
+		if !strings.Contains(text, s) {
+			t.Fatalf("expect %q in:\n%s", s, text)
+		}
+	}
+	if strings.Contains(text, "example.com/b ") {
+		t.Fatalf("unchanged package should be omitted:\n%s", text)
+	}
+}
@@ -0,0 +1,262 @@
+package coverage
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xhd2015/xgo/support/coverage"
+	"github.com/xhd2015/xgo/support/git"
+	"github.com/xhd2015/xgo/support/goinfo"
+)
+
+// FileCoverage is line and function coverage of a file,
+// converted from blocks of a profile
+type FileCoverage struct {
+	// name in profiles
+	ProfileFile string
+	Pkg         string
+	// slash separated, relative to the root, or ProfileFile
+	// if the file is not found under root
+	Path string
+	// empty if the file is not found
+	AbsPath string
+	Lines   []*LineHit
+	// nil if source is not found
+	Funcs []*FuncCoverage
+}
+
+type LineHit struct {
+	Line  int
+	Count int64
+}
+
+type FuncCoverage struct {
+	// e.g. Parse, (*Block).Pkg
+	Name      string
+	StartLine int
+	EndLine   int
+	// count of the first block
+	Count int64
+	Lines []*LineHit
+	// go profiles have no branch data, blocks other than the first
+	// one are used instead, as each starts an arm of if, switch,
+	// select or for, or the code following them
+	Branches []*LineHit
+}
+
+func (c *FileCoverage) CoveredLines() int {
+	return coveredLines(c.Lines)
+}
+
+func (c *FuncCoverage) CoveredLines() int {
+	return coveredLines(c.Lines)
+}
+
+// Branches returns branches of all functions
+func (c *FileCoverage) Branches() []*LineHit {
+	var branches []*LineHit
+	for _, fn := range c.Funcs {
+		branches = append(branches, fn.Branches...)
+	}
+	return branches
+}
+
+func coveredLines(lines []*LineHit) int {
+	n := 0
+	for _, line := range lines {
+		if line.Count > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// resolveRoot returns dir if given, otherwise the git top level
+// of the current dir, or the current dir if not in a git repo
+func resolveRoot(dir string) (string, error) {
+	if dir == "" {
+		topLevel, err := git.ShowTopLevel("")
+		if err == nil && topLevel != "" {
+			dir = topLevel
+		} else {
+			dir = "."
+		}
+	}
+	return filepath.Abs(dir)
+}
+
+// findModules walks root for go.mod, returning
+// module path -> module dir
+func findModules(root string) (map[string]string, error) {
+	modules := make(map[string]string)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			name := d.Name()
+			if path != root && (strings.HasPrefix(name, ".") || name == "vendor" || name == "testdata" || name == "node_modules") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Name() != "go.mod" {
+			return nil
+		}
+		dir := filepath.Dir(path)
+		_, modPath, err := goinfo.ResolveMainModule(dir)
+		if err != nil {
+			// ignore broken go.mod
+			return nil
+		}
+		modules[modPath] = dir
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return modules, nil
+}
+
+// resolveFile finds file of profileFile, the longest
+// module path wins since modules can be nested
+func resolveFile(modules map[string]string, profileFile string) (absFile string, ok bool) {
+	var matched string
+	for modPath := range modules {
+		if len(modPath) <= len(matched) || !strings.HasPrefix(profileFile, modPath+"/") {
+			continue
+		}
+		matched = modPath
+	}
+	if matched == "" {
+		return "", false
+	}
+	return filepath.Join(modules[matched], filepath.FromSlash(profileFile[len(matched)+1:])), true
+}
+
+// buildFileCoverages converts blocks to lines, and to functions
+// when source files can be found under root
+func buildFileCoverages(root string, files []string, blocksByFile map[string][]*coverage.Block) ([]*FileCoverage, error) {
+	modules, err := findModules(root)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*FileCoverage, 0, len(files))
+	for _, file := range files {
+		blocks := blocksByFile[file]
+		fileCov := &FileCoverage{
+			ProfileFile: file,
+			Path:        file,
+			Lines:       blockLineHits(blocks),
+		}
+		if len(blocks) > 0 {
+			fileCov.Pkg = blocks[0].Pkg()
+		}
+		absFile, ok := resolveFile(modules, file)
+		if ok {
+			rel, err := filepath.Rel(root, absFile)
+			if err == nil {
+				fileCov.Path = filepath.ToSlash(rel)
+				fileCov.AbsPath = absFile
+			}
+		}
+		if fileCov.AbsPath != "" {
+			fileCov.Funcs = parseFuncCoverages(fileCov.AbsPath, blocks, fileCov.Lines)
+		}
+		result = append(result, fileCov)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Path < result[j].Path
+	})
+	return result, nil
+}
+
+// blockEndLine returns the last line of block, the end
+// is exclusive, so a block ending at column 1 does not
+// contain its end line
+func blockEndLine(block *coverage.Block) int {
+	if block.EndCol <= 1 && block.EndLine > block.StartLine {
+		return block.EndLine - 1
+	}
+	return block.EndLine
+}
+
+// blockLineHits returns lines containing statements, a line
+// spanned by several blocks takes the largest count
+func blockLineHits(blocks []*coverage.Block) []*LineHit {
+	hits := make(map[int]int64)
+	for _, block := range blocks {
+		if block.NumStmt == 0 {
+			continue
+		}
+		end := blockEndLine(block)
+		for line := block.StartLine; line <= end; line++ {
+			if count, ok := hits[line]; !ok || block.Count > count {
+				hits[line] = block.Count
+			}
+		}
+	}
+	lines := make([]*LineHit, 0, len(hits))
+	for line, count := range hits {
+		lines = append(lines, &LineHit{Line: line, Count: count})
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		return lines[i].Line < lines[j].Line
+	})
+	return lines
+}
+
+func parseFuncCoverages(absFile string, blocks []*coverage.Block, lines []*LineHit) []*FuncCoverage {
+	fset := token.NewFileSet()
+	astFile, err := parser.ParseFile(fset, absFile, nil, parser.SkipObjectResolution)
+	if err != nil {
+		return nil
+	}
+	var funcs []*FuncCoverage
+	for _, decl := range astFile.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Body == nil {
+			continue
+		}
+		start := fset.Position(fn.Pos()).Line
+		end := fset.Position(fn.End()).Line
+		funcCov := &FuncCoverage{
+			Name:      coverage.FuncName(fn),
+			StartLine: start,
+			EndLine:   end,
+		}
+		var first *coverage.Block
+		for _, block := range blocks {
+			if block.StartLine < start || block.StartLine > end {
+				continue
+			}
+			if first == nil || block.StartLine < first.StartLine || (block.StartLine == first.StartLine && block.StartCol < first.StartCol) {
+				first = block
+			}
+		}
+		if first != nil {
+			funcCov.Count = first.Count
+		}
+		for _, block := range blocks {
+			if block == first || block.StartLine < start || block.StartLine > end {
+				continue
+			}
+			funcCov.Branches = append(funcCov.Branches, &LineHit{Line: block.StartLine, Count: block.Count})
+		}
+		sort.SliceStable(funcCov.Branches, func(i, j int) bool {
+			return funcCov.Branches[i].Line < funcCov.Branches[j].Line
+		})
+		for _, line := range lines {
+			if line.Line >= start && line.Line <= end {
+				funcCov.Lines = append(funcCov.Lines, line)
+			}
+		}
+		funcs = append(funcs, funcCov)
+	}
+	return funcs
+}
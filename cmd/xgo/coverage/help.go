@@ -9,14 +9,67 @@ Usage:
 The commands are:
     merge       merge coverage profiles
     compact     compact profile
+    report      report coverage of lines changed since a git ref
+    serve       serve incremental coverage as a web page
+    check       fail when coverage is below thresholds
+    convert     convert profiles to lcov or cobertura xml
+    html        write a self-contained html page of profiles
+    diff        compare coverage of two profiles
+    tests       query tests indexed by xgo test --cover-per-test
+    calls       report functions invoked or never invoked, recorded by xgo test --call-coverage
     help        show help message
 
 Options for merge:
     -o <file>               output to file instead of stdout
     --exclude-prefix <pkg>  exclude coverage of a specific package and sub packages
 
+Options for report, serve, check, convert and html:
+    --base <ref>            not for convert, compare against the merge base of <ref> and HEAD, default: origin/HEAD, origin/main or origin/master
+    --diff                  html only, mark lines changed since --base
+    --dir <dir>             the repository, default: git top level of current dir
+    --exclude-prefix <pkg>  exclude coverage of a specific package and sub packages
+    --format <fmt>          text, json or html(report only), default: text; lcov or cobertura for convert
+    -o <file>               output to file instead of stdout
+    --port <port>           serve only, port to listen, default: 7070
+    --bind <addr>           serve only, address to bind, default: localhost
+
+Options for check:
+    --min-total <percent>         minimal statement coverage of all packages
+    --min-package <percent>       minimal statement coverage of each package
+    --min-package <pkg>=<percent> override for packages with prefix <pkg>, the longest prefix wins, repeatable
+    --min-incremental <percent>   minimal coverage of lines changed since --base
+
+Options for tests:
+    --func <pkg>[.<func>]   tests calling the function, or any function of the package
+    --file <file>[:<line>]  tests calling functions in the file, or the function at the line
+    --test <pkg>.<test>     functions called by the test
+    --index <file>          the index, default: .xgo/test-index.json under --dir
+    --dir <dir>             the repository, default: git top level of current dir
+    --format <fmt>          text or json, default: text
+
+Options for diff:
+    --exclude-prefix <pkg>  exclude coverage of a specific package and sub packages
+    --format <fmt>          text or json, default: text
+    -o <file>               output to file instead of stdout
+
+Options for calls:
+    --exported              only report exported functions and methods of exported types
+    --exclude-prefix <pkg>  exclude functions of a specific package and sub packages
+    --format <fmt>          text or json, default: text
+    -o <file>               output to file instead of stdout
+
 Examples:
     xgo tool coverage merge -o cover.a cover-a.out cover-b.out     merge multiple files into one
+    xgo tool coverage report --base origin/main cover.out          print coverage of changed lines
+    xgo tool coverage report --format html -o cover.html cover.out write a self-contained html page
+    xgo tool coverage serve cover.out                              view coverage of changed lines in browser
+    xgo tool coverage check --min-total 60 --min-incremental 80 cover.out
+                                                                   exit non-zero if coverage is too low
+    xgo tool coverage convert --format lcov -o lcov.info cover.out convert to lcov
+    xgo tool coverage html --diff -o cover.html a.out b.out        browse coverage of profiles from multiple modules
+    xgo tool coverage diff base.out new.out                        show blocks newly covered or uncovered
+    xgo tool coverage tests --file pkg/a.go:20                     tests calling the function at line 20
+    xgo tool coverage calls --exported calls.json                  list exported functions never invoked
 
 See https://github.com/xhd2015/xgo for documentation.
 
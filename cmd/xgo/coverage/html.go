@@ -0,0 +1,309 @@
+package coverage
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xhd2015/xgo/support/coverage"
+	"github.com/xhd2015/xgo/support/git"
+)
+
+const htmlReportStyles = incrementalStyles + `
+body { margin: 0; display: flex; height: 100vh; }
+.tree { width: 360px; flex-shrink: 0; overflow: auto; border-right: 1px solid #d0d7de; padding: 8px 0; font-size: 13px; }
+.tree details > summary { padding: 2px 12px; cursor: pointer; white-space: nowrap; }
+.tree a { display: block; padding: 2px 12px 2px 32px; color: #24292f; text-decoration: none; white-space: nowrap; }
+.tree a:hover { background: #f6f8fa; }
+.tree a.active { background: #ddf4ff; }
+.tree a.top { padding-left: 12px; font-weight: 600; }
+.tree .pct { float: right; margin-left: 12px; color: #57606a; }
+.tree .pct.low, .summary .low { color: #cf222e; }
+.tree .mark { color: #0969da; }
+.main { flex-grow: 1; overflow: auto; padding: 0 16px; }
+.view { display: none; }
+.line.changed { box-shadow: inset 3px 0 #0969da; }
+`
+
+// covered below this is marked as low
+const htmlLowPercent = 60
+
+type htmlPkg struct {
+	pkg     string
+	files   []*htmlFile
+	stmts   int
+	covered int
+}
+
+type htmlFile struct {
+	cov     *FileCoverage
+	stmts   int
+	covered int
+	// nil if not changed
+	change         *git.FileChange
+	changedLines   int
+	changedCovered int
+}
+
+// handleHTML writes a self-contained page of one or more
+// profiles, which may span multiple modules
+func handleHTML(args []string) error {
+	var outFile string
+	var withDiff bool
+	opts := &incrementalOptions{}
+	files, err := parseProfileArgs(args, opts, func(flag string, value func() (string, error)) (bool, error) {
+		var err error
+		switch flag {
+		case "--base":
+			opts.base, err = value()
+			withDiff = true
+		case "--diff":
+			withDiff = true
+		case "-o":
+			outFile, err = value()
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+	if err != nil {
+		return err
+	}
+	root, err := resolveRoot(opts.dir)
+	if err != nil {
+		return err
+	}
+	lines, err := loadProfiles(files, opts.excludePrefix)
+	if err != nil {
+		return err
+	}
+	profileFiles, blocksByFile, err := coverage.ParseBlocks(lines)
+	if err != nil {
+		return err
+	}
+	fileCovs, err := buildFileCoverages(root, profileFiles, blocksByFile)
+	if err != nil {
+		return err
+	}
+
+	title := "Coverage"
+	var changes map[string]*git.FileChange
+	if withDiff {
+		base, err := git.ResolveBaseRef(root, opts.base)
+		if err != nil {
+			return err
+		}
+		changes, err = changedFilesByAbsPath(root, base)
+		if err != nil {
+			return err
+		}
+		title = "Coverage, changes since " + base
+	}
+	pkgs := buildHTMLPkgs(fileCovs, blocksByFile, changes)
+
+	var out io.Writer = os.Stdout
+	if outFile != "" {
+		file, err := os.Create(outFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	return writeCoverageHTML(out, title, pkgs)
+}
+
+// changedFilesByAbsPath returns changes keyed by absolute path
+func changedFilesByAbsPath(dir string, base string) (map[string]*git.FileChange, error) {
+	topLevel, err := git.ShowTopLevel(dir)
+	if err != nil {
+		return nil, err
+	}
+	diffs, err := git.DiffLines(dir, base)
+	if err != nil {
+		return nil, err
+	}
+	changes := make(map[string]*git.FileChange, len(diffs))
+	for _, diff := range diffs {
+		changes[filepath.Join(topLevel, filepath.FromSlash(diff.File))] = diff
+	}
+	return changes, nil
+}
+
+func buildHTMLPkgs(fileCovs []*FileCoverage, blocksByFile map[string][]*coverage.Block, changes map[string]*git.FileChange) []*htmlPkg {
+	pkgMap := make(map[string]*htmlPkg)
+	var pkgs []*htmlPkg
+	for _, cov := range fileCovs {
+		file := &htmlFile{cov: cov}
+		for _, block := range blocksByFile[cov.ProfileFile] {
+			file.stmts += block.NumStmt
+			if block.Count > 0 {
+				file.covered += block.NumStmt
+			}
+		}
+		if cov.AbsPath != "" && changes[cov.AbsPath] != nil {
+			file.change = changes[cov.AbsPath]
+			for _, line := range cov.Lines {
+				if !file.change.HasLine(line.Line) {
+					continue
+				}
+				file.changedLines++
+				if line.Count > 0 {
+					file.changedCovered++
+				}
+			}
+		}
+		pkg := pkgMap[cov.Pkg]
+		if pkg == nil {
+			pkg = &htmlPkg{pkg: cov.Pkg}
+			pkgMap[cov.Pkg] = pkg
+			pkgs = append(pkgs, pkg)
+		}
+		pkg.files = append(pkg.files, file)
+		pkg.stmts += file.stmts
+		pkg.covered += file.covered
+	}
+	sort.Slice(pkgs, func(i, j int) bool {
+		return pkgs[i].pkg < pkgs[j].pkg
+	})
+	return pkgs
+}
+
+func writeCoverageHTML(w io.Writer, title string, pkgs []*htmlPkg) error {
+	var totalStmts, totalCovered int
+	for _, pkg := range pkgs {
+		totalStmts += pkg.stmts
+		totalCovered += pkg.covered
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>%s</title>\n<style>", html.EscapeString(title))
+	b.WriteString(htmlReportStyles)
+	b.WriteString("</style></head><body>\n")
+
+	// navigation
+	b.WriteString("<div class=\"tree\">\n")
+	fmt.Fprintf(&b, "<a class=\"top\" href=\"#summary\">All packages%s</a>\n", htmlPercent(totalCovered, totalStmts))
+	fileID := 0
+	for _, pkg := range pkgs {
+		fmt.Fprintf(&b, "<details><summary>%s%s</summary>\n", html.EscapeString(pkg.pkg), htmlPercent(pkg.covered, pkg.stmts))
+		for _, file := range pkg.files {
+			mark := ""
+			if file.change != nil {
+				mark = ` <span class="mark" title="changed">&#9679;</span>`
+			}
+			fmt.Fprintf(&b, "<a href=\"#file-%d\">%s%s%s</a>\n", fileID, html.EscapeString(path.Base(file.cov.Path)), mark, htmlPercent(file.covered, file.stmts))
+			fileID++
+		}
+		b.WriteString("</details>\n")
+	}
+	b.WriteString("</div>\n<div class=\"main\">\n")
+
+	// summary
+	b.WriteString("<div class=\"view\" id=\"summary\">\n")
+	fmt.Fprintf(&b, "<h1>%s: %d/%d statements, %s</h1>\n", html.EscapeString(title), totalCovered, totalStmts, formatPercent(percent(totalCovered, totalStmts)))
+	b.WriteString("<table class=\"summary\"><tr><th>Package</th><th>Statements</th><th>Covered</th><th>Percent</th></tr>\n")
+	for _, pkg := range pkgs {
+		fmt.Fprintf(&b, "<tr><td>%s</td><td class=\"num\">%d</td><td class=\"num\">%d</td><td class=\"num%s\">%s</td></tr>\n",
+			html.EscapeString(pkg.pkg), pkg.stmts, pkg.covered, lowClass(pkg.covered, pkg.stmts), formatPercent(percent(pkg.covered, pkg.stmts)))
+	}
+	b.WriteString("</table>\n</div>\n")
+
+	// files
+	fileID = 0
+	for _, pkg := range pkgs {
+		for _, file := range pkg.files {
+			fmt.Fprintf(&b, "<div class=\"view\" id=\"file-%d\">\n", fileID)
+			fileID++
+			writeHTMLFile(&b, file)
+			b.WriteString("</div>\n")
+		}
+	}
+	b.WriteString("</div>\n")
+	b.WriteString(`<script>
+function show() {
+    var id = location.hash.slice(1) || "summary";
+    document.querySelectorAll(".view").forEach(function (e) { e.style.display = e.id === id ? "block" : "none"; });
+    document.querySelectorAll(".tree a").forEach(function (a) {
+        var active = a.getAttribute("href") === "#" + id;
+        a.classList.toggle("active", active);
+        if (active && a.parentNode.tagName === "DETAILS") a.parentNode.open = true;
+    });
+}
+window.addEventListener("hashchange", show);
+show();
+</script>
+</body></html>
+`)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeHTMLFile(b *strings.Builder, file *htmlFile) {
+	cov := file.cov
+	fmt.Fprintf(b, "<h1>%s: %d/%d statements, %s</h1>\n", html.EscapeString(cov.Path), file.covered, file.stmts, formatPercent(percent(file.covered, file.stmts)))
+	if file.change != nil {
+		fmt.Fprintf(b, "<p>changed: %d/%d lines covered, %s</p>\n", file.changedCovered, file.changedLines, formatPercent(percent(file.changedCovered, file.changedLines)))
+	}
+	b.WriteString(`<p class="legend"><span class="line covered">covered</span><span class="line uncovered">uncovered</span>`)
+	if file.change != nil {
+		b.WriteString(`<span class="line changed">changed</span>`)
+	}
+	b.WriteString("</p>\n")
+	if cov.AbsPath == "" {
+		fmt.Fprintf(b, "<p class=\"no-profile\">source not found under the root, uncovered lines: %s</p>\n", html.EscapeString(formatLineRanges(uncoveredHits(cov.Lines))))
+		return
+	}
+	content, err := os.ReadFile(cov.AbsPath)
+	if err != nil {
+		fmt.Fprintf(b, "<p class=\"no-profile\">%s</p>\n", html.EscapeString(err.Error()))
+		return
+	}
+	hits := make(map[int]int64, len(cov.Lines))
+	for _, line := range cov.Lines {
+		hits[line.Line] = line.Count
+	}
+	b.WriteString("<pre>")
+	for i, text := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+		line := i + 1
+		var classes []string
+		if count, ok := hits[line]; ok {
+			if count > 0 {
+				classes = append(classes, "covered")
+			} else {
+				classes = append(classes, "uncovered")
+			}
+		}
+		if file.change != nil && file.change.HasLine(line) {
+			classes = append(classes, "changed")
+		}
+		fmt.Fprintf(b, "<span class=\"line %s\"><span class=\"no\">%d</span>%s</span>", strings.Join(classes, " "), line, html.EscapeString(text))
+	}
+	b.WriteString("</pre>\n")
+}
+
+func uncoveredHits(lines []*LineHit) []int {
+	var uncovered []int
+	for _, line := range lines {
+		if line.Count == 0 {
+			uncovered = append(uncovered, line.Line)
+		}
+	}
+	return uncovered
+}
+
+func htmlPercent(covered int, total int) string {
+	return fmt.Sprintf("<span class=\"pct%s\">%s</span>", lowClass(covered, total), formatPercent(percent(covered, total)))
+}
+
+func lowClass(covered int, total int) string {
+	if percent(covered, total) < htmlLowPercent {
+		return " low"
+	}
+	return ""
+}
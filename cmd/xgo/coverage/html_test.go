@@ -0,0 +1,78 @@
+package coverage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xhd2015/xgo/support/coverage"
+	"github.com/xhd2015/xgo/support/git"
+)
+
+func TestCoverageHTML(t *testing.T) {
+	root := t.TempDir()
+	for file, content := range map[string]string{
+		"go.mod":         "module example.com/a\n",
+		"sub/a.go":       convertTestSource,
+		"other/go.mod":   "module example.com/other\n",
+		"other/b.go":     "package other\n\nfunc C() {\n\tprintln(\"<b>\")\n}\n",
+		"other/x/x.go":   "package x\n",
+		"other/x/go.mod": "module example.com/other/x\n",
+	} {
+		file = filepath.Join(root, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// profiles of two modules
+	_, lines := coverage.Parse(`mode: set
+example.com/a/sub/a.go:3.19,5.2 1 1
+example.com/a/sub/a.go:7.26,8.12 1 1
+example.com/a/sub/a.go:8.12,10.3 1 0
+example.com/a/sub/a.go:11.2,11.10 1 1
+example.com/other/b.go:3.10,5.2 1 0`)
+	files, blocksByFile, err := coverage.ParseBlocks(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileCovs, err := buildFileCoverages(root, files, blocksByFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := map[string]*git.FileChange{
+		filepath.Join(root, "sub", "a.go"): {File: "sub/a.go", Lines: []git.LineRange{{Start: 8, End: 9}}},
+	}
+	pkgs := buildHTMLPkgs(fileCovs, blocksByFile, changes)
+	if len(pkgs) != 2 || pkgs[0].pkg != "example.com/a/sub" || pkgs[0].stmts != 4 || pkgs[0].covered != 3 {
+		t.Fatalf("unexpected packages: %+v", pkgs)
+	}
+	if file := pkgs[0].files[0]; file.changedLines != 2 || file.changedCovered != 1 {
+		t.Fatalf("unexpected changed lines: %d/%d", file.changedCovered, file.changedLines)
+	}
+
+	var b strings.Builder
+	err = writeCoverageHTML(&b, "Coverage", pkgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := b.String()
+	for _, s := range []string{
+		`<a class="top" href="#summary">All packages<span class="pct">60.0%</span></a>`,
+		`<summary>example.com/other<span class="pct low">0.0%</span></summary>`,
+		`<span class="mark" title="changed">`,
+		`<span class="line covered changed"><span class="no">8</span>`,
+		`<span class="line uncovered changed"><span class="no">9</span>`,
+		`println(&#34;&lt;b&gt;&#34;)`,
+	} {
+		if !strings.Contains(page, s) {
+			t.Fatalf("expect %q in page", s)
+		}
+	}
+	if strings.Contains(page, "http://") || strings.Contains(page, "https://") {
+		t.Fatalf("page should be self-contained")
+	}
+}
@@ -0,0 +1,204 @@
+package coverage
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xhd2015/xgo/support/coverage"
+	"github.com/xhd2015/xgo/support/git"
+	"github.com/xhd2015/xgo/support/goinfo"
+)
+
+// IncrementalReport is coverage of lines changed since Base,
+// only lines containing statements are counted
+type IncrementalReport struct {
+	Base    string
+	Lines   int
+	Covered int
+	Percent float64
+	Files   []*IncrementalFile
+}
+
+type IncrementalFile struct {
+	// slash separated, relative to the worktree root
+	File string
+	// name in profiles, e.g. github.com/xhd2015/xgo/cmd/xgo/main.go
+	ProfileFile string
+	Lines       int
+	Covered     int
+	Percent     float64
+	// the file is changed but not found in profiles,
+	// usually its package was not tested
+	NoProfile      bool  `json:",omitempty"`
+	CoveredLines   []int `json:",omitempty"`
+	UncoveredLines []int `json:",omitempty"`
+	// changed lines without statements
+	OtherLines []int `json:",omitempty"`
+
+	// absolute path, for reading source
+	absFile string
+}
+
+type incrementalOptions struct {
+	dir           string
+	base          string
+	excludePrefix []string
+}
+
+// loadProfiles parses and merges profiles
+func loadProfiles(files []string, excludePrefix []string) ([]*coverage.CovLine, error) {
+	covs := make([][]*coverage.CovLine, 0, len(files))
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		_, cov := coverage.Parse(string(content))
+		covs = append(covs, cov)
+	}
+	res := coverage.Merge(covs...)
+	return coverage.Filter(res, func(line *coverage.CovLine) bool {
+		return !hasAnyPrefix(line.Prefix, excludePrefix)
+	}), nil
+}
+
+func computeIncremental(profiles []string, opts *incrementalOptions) (*IncrementalReport, error) {
+	base, err := git.ResolveBaseRef(opts.dir, opts.base)
+	if err != nil {
+		return nil, err
+	}
+	lines, err := loadProfiles(profiles, opts.excludePrefix)
+	if err != nil {
+		return nil, err
+	}
+	_, blocksByFile, err := coverage.ParseBlocks(lines)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := git.DiffLines(opts.dir, base)
+	if err != nil {
+		return nil, err
+	}
+	topLevel, err := git.ShowTopLevel(opts.dir)
+	if err != nil {
+		return nil, err
+	}
+	resolver := &profileFileResolver{modPaths: make(map[string]string)}
+	var changedFiles []*changedFile
+	for _, change := range changes {
+		if !strings.HasSuffix(change.File, ".go") || strings.HasSuffix(change.File, "_test.go") {
+			continue
+		}
+		absFile := filepath.Join(topLevel, filepath.FromSlash(change.File))
+		profileFile := resolver.resolve(absFile)
+		if profileFile == "" || hasAnyPrefix(profileFile, opts.excludePrefix) {
+			continue
+		}
+		changedFiles = append(changedFiles, &changedFile{
+			change:      change,
+			absFile:     absFile,
+			profileFile: profileFile,
+		})
+	}
+	report := buildIncrementalReport(changedFiles, blocksByFile)
+	report.Base = base
+	return report, nil
+}
+
+type changedFile struct {
+	change      *git.FileChange
+	absFile     string
+	profileFile string
+}
+
+func buildIncrementalReport(files []*changedFile, blocksByFile map[string][]*coverage.Block) *IncrementalReport {
+	report := &IncrementalReport{}
+	for _, f := range files {
+		file := &IncrementalFile{
+			File:        f.change.File,
+			ProfileFile: f.profileFile,
+			absFile:     f.absFile,
+		}
+		blocks, ok := blocksByFile[f.profileFile]
+		if !ok {
+			file.NoProfile = true
+		}
+		for _, r := range f.change.Lines {
+			for line := r.Start; line <= r.End; line++ {
+				hasStmt, covered := lineCoverage(blocks, line)
+				if !hasStmt {
+					file.OtherLines = append(file.OtherLines, line)
+					continue
+				}
+				file.Lines++
+				if covered {
+					file.Covered++
+					file.CoveredLines = append(file.CoveredLines, line)
+				} else {
+					file.UncoveredLines = append(file.UncoveredLines, line)
+				}
+			}
+		}
+		file.Percent = percent(file.Covered, file.Lines)
+		report.Lines += file.Lines
+		report.Covered += file.Covered
+		report.Files = append(report.Files, file)
+	}
+	sort.Slice(report.Files, func(i, j int) bool {
+		return report.Files[i].File < report.Files[j].File
+	})
+	report.Percent = percent(report.Covered, report.Lines)
+	return report
+}
+
+// lineCoverage: a line has statements if any block with statements
+// spans it, and is covered if any of these blocks is executed
+func lineCoverage(blocks []*coverage.Block, line int) (hasStmt bool, covered bool) {
+	for _, block := range blocks {
+		if block.NumStmt == 0 || line < block.StartLine || line > blockEndLine(block) {
+			continue
+		}
+		hasStmt = true
+		if block.Count > 0 {
+			return true, true
+		}
+	}
+	return hasStmt, false
+}
+
+func percent(covered int, total int) float64 {
+	if total == 0 {
+		return 100
+	}
+	return float64(covered) * 100 / float64(total)
+}
+
+// profileFileResolver maps files to names used in profiles,
+// i.e. module path joined with path relative to the module
+type profileFileResolver struct {
+	// module dir -> module path
+	modPaths map[string]string
+}
+
+func (c *profileFileResolver) resolve(absFile string) string {
+	modDir, err := goinfo.FindGoModDir(filepath.Dir(absFile))
+	if err != nil {
+		return ""
+	}
+	modPath, ok := c.modPaths[modDir]
+	if !ok {
+		_, modPath, _ = goinfo.ResolveMainModule(modDir)
+		c.modPaths[modDir] = modPath
+	}
+	if modPath == "" {
+		return ""
+	}
+	rel, err := filepath.Rel(modDir, absFile)
+	if err != nil {
+		return ""
+	}
+	return path.Join(modPath, filepath.ToSlash(rel))
+}
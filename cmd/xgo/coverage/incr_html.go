@@ -0,0 +1,116 @@
+package coverage
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"strings"
+)
+
+// lines around changes shown by default
+const htmlContextLines = 3
+
+const incrementalStyles = `
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif; margin: 16px; color: #24292f; }
+h1 { font-size: 18px; }
+table.summary { border-collapse: collapse; margin-bottom: 24px; }
+table.summary th, table.summary td { padding: 4px 12px; border-bottom: 1px solid #d0d7de; text-align: left; }
+table.summary td.num { text-align: right; }
+.low { color: #cf222e; }
+.file { margin-bottom: 24px; border: 1px solid #d0d7de; border-radius: 4px; }
+.file-head { padding: 6px 12px; background: #f6f8fa; border-bottom: 1px solid #d0d7de; font-weight: 600; }
+.file-head .stat { font-weight: normal; color: #57606a; margin-left: 12px; }
+.no-profile { padding: 6px 12px; color: #9a6700; }
+pre { margin: 0; font-family: ui-monospace, SFMono-Regular, Menlo, monospace; font-size: 12px; }
+.line { display: block; white-space: pre; }
+.line .no { display: inline-block; width: 48px; padding-right: 8px; text-align: right; color: #8c959f; user-select: none; }
+.line.covered { background: #ddf4ff; }
+.line.uncovered { background: #fff8c5; }
+.line.changed .no { color: #24292f; }
+.line.hidden { display: none; }
+body.show-all .line.hidden { display: block; }
+.gap { display: block; color: #8c959f; padding-left: 56px; }
+body.show-all .gap { display: none; }
+.legend span { display: inline-block; padding: 2px 8px; margin-right: 8px; }
+`
+
+func writeIncrementalHTML(w io.Writer, report *IncrementalReport) error {
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>Incremental Coverage</title>\n<style>")
+	b.WriteString(incrementalStyles)
+	b.WriteString("</style></head><body>\n")
+	fmt.Fprintf(&b, "<h1>Incremental coverage since %s: %d/%d lines, %s</h1>\n", html.EscapeString(report.Base), report.Covered, report.Lines, formatPercent(report.Percent))
+	b.WriteString(`<p class="legend"><span class="line covered">covered</span><span class="line uncovered">uncovered</span>` +
+		`<label><input type="checkbox" onchange="document.body.classList.toggle('show-all', this.checked)"> show unchanged lines</label></p>` + "\n")
+
+	if len(report.Files) == 0 {
+		b.WriteString("<p>no go files changed</p>\n")
+	} else {
+		b.WriteString("<table class=\"summary\"><tr><th>File</th><th>Lines</th><th>Covered</th><th>Percent</th></tr>\n")
+		for i, file := range report.Files {
+			class := ""
+			if file.NoProfile || file.Covered < file.Lines {
+				class = ` class="low"`
+			}
+			fmt.Fprintf(&b, "<tr><td><a href=\"#file-%d\">%s</a></td><td class=\"num\">%d</td><td class=\"num\">%d</td><td class=\"num\"%s>%s</td></tr>\n",
+				i, html.EscapeString(file.File), file.Lines, file.Covered, class, formatPercent(file.Percent))
+		}
+		b.WriteString("</table>\n")
+	}
+	for i, file := range report.Files {
+		fmt.Fprintf(&b, "<div class=\"file\" id=\"file-%d\"><div class=\"file-head\">%s<span class=\"stat\">%d/%d lines, %s</span></div>\n",
+			i, html.EscapeString(file.File), file.Covered, file.Lines, formatPercent(file.Percent))
+		if file.NoProfile {
+			b.WriteString("<div class=\"no-profile\">no coverage data, the package may not be tested</div>\n")
+		}
+		content, err := os.ReadFile(file.absFile)
+		if err != nil {
+			fmt.Fprintf(&b, "<div class=\"no-profile\">%s</div></div>\n", html.EscapeString(err.Error()))
+			continue
+		}
+		writeFileLines(&b, file, strings.Split(strings.TrimSuffix(string(content), "\n"), "\n"))
+		b.WriteString("</div>\n")
+	}
+	b.WriteString("</body></html>\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeFileLines(b *strings.Builder, file *IncrementalFile, lines []string) {
+	status := make(map[int]string, len(file.CoveredLines)+len(file.UncoveredLines)+len(file.OtherLines))
+	for _, line := range file.OtherLines {
+		status[line] = "changed"
+	}
+	for _, line := range file.CoveredLines {
+		status[line] = "changed covered"
+	}
+	for _, line := range file.UncoveredLines {
+		status[line] = "changed uncovered"
+	}
+	near := func(line int) bool {
+		for d := -htmlContextLines; d <= htmlContextLines; d++ {
+			if status[line+d] != "" {
+				return true
+			}
+		}
+		return false
+	}
+	b.WriteString("<pre>")
+	var hiding bool
+	for i, text := range lines {
+		line := i + 1
+		class := status[line]
+		if !near(line) {
+			if !hiding {
+				b.WriteString("<span class=\"gap\">...</span>")
+				hiding = true
+			}
+			class = "hidden"
+		} else {
+			hiding = false
+		}
+		fmt.Fprintf(b, "<span class=\"line %s\"><span class=\"no\">%d</span>%s</span>", class, line, html.EscapeString(text))
+	}
+	b.WriteString("</pre>\n")
+}
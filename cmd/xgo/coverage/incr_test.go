@@ -0,0 +1,54 @@
+package coverage
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/xhd2015/xgo/support/coverage"
+	"github.com/xhd2015/xgo/support/git"
+)
+
+func TestBuildIncrementalReport(t *testing.T) {
+	_, lines := coverage.Parse(`mode: set
+example.com/a/a.go:4.2,5.1 1 1
+example.com/a/a.go:8.2,8.12 1 1
+example.com/a/a.go:9.3,10.1 1 0
+example.com/a/a.go:12.2,12.10 1 1`)
+	_, blocksByFile, err := coverage.ParseBlocks(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := buildIncrementalReport([]*changedFile{
+		{
+			change:      &git.FileChange{File: "b.go", Lines: []git.LineRange{{Start: 1, End: 2}}},
+			profileFile: "example.com/a/b.go",
+		},
+		{
+			change:      &git.FileChange{File: "a.go", Lines: []git.LineRange{{Start: 7, End: 13}}},
+			profileFile: "example.com/a/a.go",
+		},
+	}, blocksByFile)
+
+	if report.Lines != 3 || report.Covered != 2 {
+		t.Fatalf("expect 2/3, actual %d/%d", report.Covered, report.Lines)
+	}
+	a := report.Files[0]
+	if a.File != "a.go" || a.NoProfile {
+		t.Fatalf("unexpected file: %+v", a)
+	}
+	if !reflect.DeepEqual(a.CoveredLines, []int{8, 12}) || !reflect.DeepEqual(a.UncoveredLines, []int{9}) {
+		t.Fatalf("covered: %v, uncovered: %v", a.CoveredLines, a.UncoveredLines)
+	}
+	if !reflect.DeepEqual(a.OtherLines, []int{7, 10, 11, 13}) {
+		t.Fatalf("other: %v", a.OtherLines)
+	}
+	if b := report.Files[1]; !b.NoProfile || b.Lines != 0 {
+		t.Fatalf("unexpected file: %+v", b)
+	}
+}
+
+func TestFormatLineRanges(t *testing.T) {
+	if s := formatLineRanges([]int{1, 2, 3, 7, 9, 10}); s != "1-3,7,9-10" {
+		t.Fatalf("unexpected: %s", s)
+	}
+}
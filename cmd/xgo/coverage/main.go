@@ -9,6 +9,17 @@ import (
 	"github.com/xhd2015/xgo/support/coverage"
 )
 
+var handlers = map[string]func(args []string) error{
+	"serve":   handleServe,
+	"report":  handleReport,
+	"check":   handleCheck,
+	"convert": handleConvert,
+	"tests":   handleTests,
+	"calls":   handleCalls,
+	"html":    handleHTML,
+	"diff":    handleDiff,
+}
+
 func Main(args []string) {
 	if len(args) == 0 {
 		fmt.Fprintf(os.Stderr, "requires cmd\n")
@@ -20,12 +31,17 @@ func Main(args []string) {
 		fmt.Print(strings.TrimPrefix(help, "\n"))
 		return
 	}
-	if cmd != "merge" && cmd != "compact" && cmd != "serve" {
+	handler := handlers[cmd]
+	if handler == nil && cmd != "merge" && cmd != "compact" {
 		fmt.Fprintf(os.Stderr, "unrecognized cmd: %s\n", cmd)
 		return
 	}
-	if cmd == "serve" {
-		err := handleServe(args)
+	if handler != nil {
+		if len(args) > 0 && (args[0] == "--help" || args[0] == "-h") {
+			fmt.Print(strings.TrimPrefix(help, "\n"))
+			return
+		}
+		err := handler(args)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%v\n", err)
 			os.Exit(1)
@@ -0,0 +1,164 @@
+package coverage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// parseProfileArgs parses profiles and flags shared by sub commands
+// into opts, handle is called for other flags and reports whether it
+// knows flag
+func parseProfileArgs(args []string, opts *incrementalOptions, handle func(flag string, value func() (string, error)) (bool, error)) (files []string, err error) {
+	n := len(args)
+	for i := 0; i < n; i++ {
+		arg := args[i]
+		if arg == "--" {
+			files = append(files, args[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			files = append(files, arg)
+			continue
+		}
+		value := func() (string, error) {
+			if i+1 >= n {
+				return "", fmt.Errorf("%s requires arg", arg)
+			}
+			i++
+			return args[i], nil
+		}
+		switch arg {
+		case "--dir":
+			opts.dir, err = value()
+		case "--exclude-prefix":
+			var prefix string
+			prefix, err = value()
+			if err == nil && prefix == "" {
+				err = fmt.Errorf("%s requires non empty argument", arg)
+			}
+			opts.excludePrefix = append(opts.excludePrefix, prefix)
+		default:
+			var ok bool
+			ok, err = handle(arg, value)
+			if err == nil && !ok {
+				err = fmt.Errorf("unrecognized flag: %s", arg)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("requires profiles")
+	}
+	return files, nil
+}
+
+func handleReport(args []string) error {
+	var format string
+	var outFile string
+	opts := &incrementalOptions{}
+	files, err := parseProfileArgs(args, opts, func(flag string, value func() (string, error)) (bool, error) {
+		var err error
+		switch flag {
+		case "--base":
+			opts.base, err = value()
+		case "--format":
+			format, err = value()
+		case "-o":
+			outFile, err = value()
+		default:
+			return false, nil
+		}
+		return true, err
+	})
+	if err != nil {
+		return err
+	}
+	if format == "" {
+		format = "text"
+	}
+	if format != "text" && format != "json" && format != "html" {
+		return fmt.Errorf("unrecognized format: %s, expect text, json or html", format)
+	}
+	report, err := computeIncremental(files, opts)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if outFile != "" {
+		file, err := os.Create(outFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(report, "", "    ")
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(append(data, '\n'))
+		return err
+	case "html":
+		return writeIncrementalHTML(out, report)
+	default:
+		return writeIncrementalText(out, report)
+	}
+}
+
+func writeIncrementalText(w io.Writer, report *IncrementalReport) error {
+	fmt.Fprintf(w, "Incremental coverage since %s: %d/%d lines, %s\n", report.Base, report.Covered, report.Lines, formatPercent(report.Percent))
+	if len(report.Files) == 0 {
+		_, err := fmt.Fprintf(w, "no go files changed\n")
+		return err
+	}
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "FILE\tLINES\tCOVERED\tPERCENT\tUNCOVERED\n")
+	for _, file := range report.Files {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\n", file.File, file.Lines, file.Covered, formatPercent(file.Percent), uncoveredLines(file))
+	}
+	return tw.Flush()
+}
+
+func uncoveredLines(file *IncrementalFile) string {
+	if file.NoProfile {
+		return "no coverage data"
+	}
+	return formatLineRanges(file.UncoveredLines)
+}
+
+func formatPercent(p float64) string {
+	return strconv.FormatFloat(p, 'f', 1, 64) + "%"
+}
+
+// formatLineRanges formats sorted lines like 1-3,7
+func formatLineRanges(lines []int) string {
+	var b strings.Builder
+	n := len(lines)
+	for i := 0; i < n; {
+		j := i
+		for j+1 < n && lines[j+1] == lines[j]+1 {
+			j++
+		}
+		if b.Len() > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(strconv.Itoa(lines[i]))
+		if j > i {
+			b.WriteString("-")
+			b.WriteString(strconv.Itoa(lines[j]))
+		}
+		i = j + 1
+	}
+	return b.String()
+}
@@ -0,0 +1,174 @@
+package coverage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/xhd2015/xgo/support/coverage"
+)
+
+// handleTests queries the index saved by xgo test --cover-per-test:
+//
+//	--func example.com/a.(*T).B   tests calling the function
+//	--func example.com/a          tests calling any function of the package
+//	--file a/b.go:10              tests calling the function at line 10
+//	--test example.com/a.TestA    functions called by the test
+func handleTests(args []string) error {
+	var indexFile string
+	var dir string
+	var funcQuery string
+	var fileQuery string
+	var testQuery string
+	var format string
+	n := len(args)
+	for i := 0; i < n; i++ {
+		arg := args[i]
+		value := func() (string, error) {
+			if i+1 >= n {
+				return "", fmt.Errorf("%s requires arg", arg)
+			}
+			i++
+			return args[i], nil
+		}
+		var err error
+		switch arg {
+		case "--index":
+			indexFile, err = value()
+		case "--dir":
+			dir, err = value()
+		case "--func":
+			funcQuery, err = value()
+		case "--file":
+			fileQuery, err = value()
+		case "--test":
+			testQuery, err = value()
+		case "--format":
+			format, err = value()
+		default:
+			err = fmt.Errorf("unrecognized flag: %s", arg)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if format == "" {
+		format = "text"
+	}
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unrecognized format: %s, expect text or json", format)
+	}
+	if indexFile == "" {
+		root, err := resolveRoot(dir)
+		if err != nil {
+			return err
+		}
+		indexFile = filepath.Join(root, filepath.FromSlash(coverage.TestIndexFile))
+	}
+	_, statErr := os.Stat(indexFile)
+	if statErr != nil {
+		if os.IsNotExist(statErr) {
+			return fmt.Errorf("%s not found, run xgo test --cover-per-test first", indexFile)
+		}
+		return statErr
+	}
+	index, err := coverage.LoadTestIndex(indexFile)
+	if err != nil {
+		return err
+	}
+
+	if testQuery != "" {
+		entry := index.Tests[testQuery]
+		if entry == nil {
+			return fmt.Errorf("test not found: %s", testQuery)
+		}
+		if format == "json" {
+			return writeJSON(os.Stdout, entry)
+		}
+		return writeTestFuncs(os.Stdout, entry)
+	}
+
+	var tests []*coverage.TestEntry
+	switch {
+	case funcQuery != "":
+		pkg, name := splitFuncQuery(funcQuery)
+		tests = index.TestsOfFunc(pkg, name)
+	case fileQuery != "":
+		file, line, err := splitFileQuery(fileQuery)
+		if err != nil {
+			return err
+		}
+		tests = index.TestsOfFile(file, line)
+	default:
+		tests = index.SortedTests()
+	}
+	if format == "json" {
+		if tests == nil {
+			tests = []*coverage.TestEntry{}
+		}
+		return writeJSON(os.Stdout, tests)
+	}
+	return writeTests(os.Stdout, tests)
+}
+
+// splitFuncQuery splits example.com/a.(*T).B into
+// example.com/a and (*T).B, name is empty if query
+// is a package
+func splitFuncQuery(query string) (pkg string, name string) {
+	slashIdx := strings.LastIndex(query, "/")
+	dotIdx := strings.Index(query[slashIdx+1:], ".")
+	if dotIdx < 0 {
+		return query, ""
+	}
+	idx := slashIdx + 1 + dotIdx
+	return query[:idx], query[idx+1:]
+}
+
+// splitFileQuery splits a/b.go:10 into a/b.go and 10
+func splitFileQuery(query string) (file string, line int, err error) {
+	idx := strings.LastIndex(query, ":")
+	if idx < 0 || !strings.HasSuffix(query[:idx], ".go") {
+		return query, 0, nil
+	}
+	line, err = strconv.Atoi(query[idx+1:])
+	if err != nil {
+		return "", 0, fmt.Errorf("--file: bad line in %q", query)
+	}
+	return query[:idx], line, nil
+}
+
+func writeTests(w io.Writer, tests []*coverage.TestEntry) error {
+	if len(tests) == 0 {
+		_, err := fmt.Fprintf(w, "no tests found\n")
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "TEST\tFUNCS\n")
+	for _, test := range tests {
+		fmt.Fprintf(tw, "%s\t%d\n", test.Key(), len(test.Funcs))
+	}
+	return tw.Flush()
+}
+
+func writeTestFuncs(w io.Writer, test *coverage.TestEntry) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "FUNC\tFILE\tCALLS\n")
+	for _, f := range test.Funcs {
+		fmt.Fprintf(tw, "%s.%s\t%s:%d\t%d\n", f.Pkg, f.Name, f.File, f.Line, f.Count)
+	}
+	return tw.Flush()
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,37 @@
+package coverage
+
+import "testing"
+
+func TestSplitFuncQuery(t *testing.T) {
+	testCases := []struct {
+		query string
+		pkg   string
+		name  string
+	}{
+		{"example.com/a.(*T).B", "example.com/a", "(*T).B"},
+		{"example.com/a.A", "example.com/a", "A"},
+		{"example.com/a", "example.com/a", ""},
+		{"main.A", "main", "A"},
+	}
+	for _, tt := range testCases {
+		pkg, name := splitFuncQuery(tt.query)
+		if pkg != tt.pkg || name != tt.name {
+			t.Fatalf("%s: expect %s %s, actual: %s %s", tt.query, tt.pkg, tt.name, pkg, name)
+		}
+	}
+}
+
+func TestSplitFileQuery(t *testing.T) {
+	file, line, err := splitFileQuery("a/b.go:10")
+	if err != nil || file != "a/b.go" || line != 10 {
+		t.Fatalf("unexpected: %s %d %v", file, line, err)
+	}
+	file, line, err = splitFileQuery("a/b.go")
+	if err != nil || file != "a/b.go" || line != 0 {
+		t.Fatalf("unexpected: %s %d %v", file, line, err)
+	}
+	_, _, err = splitFileQuery("a/b.go:x")
+	if err == nil {
+		t.Fatalf("expect error")
+	}
+}
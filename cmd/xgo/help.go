@@ -17,6 +17,7 @@ The commands are:
     version     print xgo version
     revision    print xgo revision
     upgrade     upgrade to latest version of xgo
+    mutate      run tests against mutated code, see 'xgo mutate --help'
     tool        invoke xgo tools   
 
 Examples:
@@ -31,6 +32,13 @@ Examples of Trace:
     xgo test -run TestSomething --strace ./      test and collect stack trace
     xgo tool trace TestSomething.json            view collected stack trace
 
+Examples of Coverage:
+    xgo test --cover-per-test ./...              record functions called by each test into .xgo/test-index.json
+    xgo tool coverage tests --func pkg.Func      list tests calling pkg.Func
+    xgo test --affected --base origin/main ./... only run tests affected by changes since origin/main
+    xgo test --call-coverage calls.json ./...    record functions invoked by tests
+    xgo mutate ./pkg/...                         report mutants of ./pkg/... not detected by tests
+
 Example of Test Explorer:
     xgo e                                        open test explorer, alias for xgo tool test-explorer
     xgo explorer                                 alias for xgo tool test-explorer
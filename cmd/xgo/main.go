@@ -87,6 +87,11 @@ func main() {
 		consumeErrAndExit(err)
 		return
 	}
+	if cmd == "mutate" {
+		err := handleMutate(args)
+		consumeErrAndExit(err)
+		return
+	}
 	if cmd == "tool" {
 		err := handleTool(args)
 		consumeErrAndExit(err)
@@ -167,6 +172,45 @@ func handleBuild(cmd string, args []string) error {
 	stackTrace := opts.stackTrace
 	stackTraceDir := opts.stackTraceDir
 	trapStdlib := opts.trapStdlib
+	coverPerTest := opts.coverPerTest
+	callCoverage := opts.callCoverage
+
+	if opts.affectedBase != "" && !opts.affected {
+		return fmt.Errorf("--base requires --affected")
+	}
+	// packages with different -run are tested separately
+	var affectedGroups []*affectedGroup
+	var affectedFlags []string
+	if opts.affected {
+		flags, pkgArgs := splitPkgArgs(remainArgs)
+		// -run given by user is respected
+		sel, err := selectAffectedTests(projectDir, opts.affectedBase, mod, pkgArgs, flagRun == "")
+		if err != nil {
+			return fmt.Errorf("--affected: %w", err)
+		}
+		writeAffected(os.Stderr, sel)
+		if !sel.full {
+			if len(sel.pkgs) == 0 {
+				return nil
+			}
+			remainArgs = flags
+			for _, pkg := range sel.pkgs {
+				remainArgs = append(remainArgs, pkg.pkg)
+			}
+			groups := groupAffectedPkgs(sel.pkgs)
+			if len(groups) == 1 {
+				if groups[0].runPattern != "" {
+					flagRun = groups[0].runPattern
+				}
+			} else {
+				if opts.debug != nil || flagC {
+					return fmt.Errorf("--affected: packages narrowed to different tests cannot be tested with --debug or -c")
+				}
+				affectedGroups = groups
+				affectedFlags = flags
+			}
+		}
+	}
 
 	if cmdExec && len(remainArgs) == 0 {
 		return fmt.Errorf("exec requires command")
@@ -223,6 +267,14 @@ func handleBuild(cmd string, args []string) error {
 			tmpASTFile = filepath.Join(tmpDir, "dump-ast")
 		}
 	}
+	var coverPerTestFile string
+	if coverPerTest {
+		coverPerTestFile = filepath.Join(tmpDir, "cover-per-test.jsonl")
+	}
+	var callCoverageFile string
+	if callCoverage != "" {
+		callCoverageFile = filepath.Join(tmpDir, "call-coverage.jsonl")
+	}
 
 	// build the exec tool
 	goVersion, err := checkGoVersion(goroot, noInstrument)
@@ -432,6 +484,7 @@ func handleBuild(cmd string, args []string) error {
 
 	execCmdEnv := os.Environ()
 	var execCmd *exec.Cmd
+	var affectedArgsPos int
 	if !cmdExec {
 		if modfile != "" {
 			// make modfile absolute
@@ -468,12 +521,20 @@ func handleBuild(cmd string, args []string) error {
 			go tailLog(debugCompileLogFile)
 			logDebug("debug compile package: %s", debugCompilePkg)
 		}
-		if stackTrace == "on" && overlay == "" {
+		if (stackTrace == "on" || coverPerTest || callCoverage != "") && overlay == "" {
 			// check if xgo/runtime ready
 			impResult, impRuntimeErr := importRuntimeDep(cmdTest, instrumentGoroot, instrumentGo, goVersion, modfile, realXgoSrc, projectDir, subPaths, mainModule, mod, remainArgs)
 			if impRuntimeErr != nil {
+				flagName := "--strace"
+				if stackTrace != "on" {
+					if coverPerTest {
+						flagName = "--cover-per-test"
+					} else {
+						flagName = "--call-coverage"
+					}
+				}
 				// can be silently ignored
-				fmt.Fprintf(os.Stderr, "WARNING: --strace requires: import _ %q\n   failed to auto import %s: %v\n", RUNTIME_TRACE_PKG, RUNTIME_TRACE_PKG, impRuntimeErr)
+				fmt.Fprintf(os.Stderr, "WARNING: %s requires: import _ %q\n   failed to auto import %s: %v\n", flagName, RUNTIME_TRACE_PKG, RUNTIME_TRACE_PKG, impRuntimeErr)
 			} else if impResult != nil {
 				overlay = impResult.overlayFile
 				if impResult.mod != "" {
@@ -569,7 +630,11 @@ func handleBuild(cmd string, args []string) error {
 			}
 		}
 		if len(remainArgs) > 0 {
-			if !runDebug {
+			if len(affectedGroups) > 0 {
+				// -run and packages are inserted for each group
+				buildCmdArgs = append(buildCmdArgs, affectedFlags...)
+				affectedArgsPos = len(buildCmdArgs)
+			} else if !runDebug {
 				buildCmdArgs = append(buildCmdArgs, remainArgs...)
 			} else {
 				buildCmdArgs = append(buildCmdArgs, remainArgs[0])
@@ -632,6 +697,14 @@ func handleBuild(cmd string, args []string) error {
 			execCmd.Env = append(execCmd.Env, exec_tool.XGO_STACK_TRACE_DIR+"="+stackTraceDir)
 		}
 
+		// functions called by each test, read by runtime/trace
+		if coverPerTestFile != "" {
+			execCmd.Env = append(execCmd.Env, "XGO_COVER_PER_TEST="+coverPerTestFile)
+		}
+		if callCoverageFile != "" {
+			execCmd.Env = append(execCmd.Env, "XGO_CALL_COVERAGE="+callCoverageFile)
+		}
+
 		// trap stdlib
 		var trapStdlibEnv string
 		if trapStdlib {
@@ -663,7 +736,24 @@ func handleBuild(cmd string, args []string) error {
 	}
 	logDebug("command dir: %v", execCmd.Dir)
 	logDebug("command executable path: %v", execCmd.Path)
-	err = execCmd.Run()
+	if len(affectedGroups) > 0 {
+		err = runAffectedGroups(execCmd, affectedArgsPos, affectedGroups)
+	} else {
+		err = execCmd.Run()
+	}
+	if coverPerTestFile != "" {
+		// failed tests are indexed too
+		indexErr := saveTestIndex(coverPerTestFile, projectDir)
+		if indexErr != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: --cover-per-test: %v\n", indexErr)
+		}
+	}
+	if callCoverageFile != "" {
+		callErr := saveCallCoverage(callCoverageFile, projectDir, callCoverage)
+		if callErr != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: --call-coverage: %v\n", callErr)
+		}
+	}
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+
+	"github.com/xhd2015/xgo/support/coverage"
+)
+
+// mutation operators
+const (
+	// == to !=, < to >=, && to ||, if c to if !(c)...
+	mutateOpFlip = "flip"
+	// < to <=, > to >=...
+	mutateOpBoundary = "boundary"
+	// drop calls, assignments and ++/--
+	mutateOpDrop = "drop"
+	// true to false, err to nil, nil error to non-nil...
+	mutateOpReturn = "return"
+)
+
+var mutateOps = []string{mutateOpFlip, mutateOpBoundary, mutateOpDrop, mutateOpReturn}
+
+// mutant status
+const (
+	mutantKilled       = "killed"
+	mutantSurvived     = "survived"
+	mutantTimeout      = "timeout"
+	mutantNoCoverage   = "no_coverage"
+	mutantCompileError = "compile_error"
+)
+
+var flipOps = map[token.Token]token.Token{
+	token.EQL:  token.NEQ,
+	token.NEQ:  token.EQL,
+	token.LSS:  token.GEQ,
+	token.GEQ:  token.LSS,
+	token.GTR:  token.LEQ,
+	token.LEQ:  token.GTR,
+	token.LAND: token.LOR,
+	token.LOR:  token.LAND,
+}
+
+var boundaryOps = map[token.Token]token.Token{
+	token.LSS: token.LEQ,
+	token.LEQ: token.LSS,
+	token.GTR: token.GEQ,
+	token.GEQ: token.GTR,
+}
+
+// returned in place of a nil error, declared
+// at the end of the mutated file
+const mutantErrorType = "xgoMutantError"
+const mutantErrorDecl = "\n\ntype " + mutantErrorType + " struct{}\n\nfunc (" + mutantErrorType + ") Error() string { return \"xgo mutant\" }\n"
+
+type mutant struct {
+	ID  int
+	Pkg string
+	// slash separated, relative to the project dir
+	File     string
+	Line     int
+	Col      int
+	Func     string
+	Operator string
+	Original string
+	Mutated  string
+	Status   string `json:",omitempty"`
+	// tests run against the mutant, empty if all tests of Pkg
+	Tests []string `json:",omitempty"`
+
+	absFile string
+	// replace code[start:end]
+	start   int
+	end     int
+	replace string
+	// appended to the file
+	appendCode string
+	// false if the test index cannot tell tests
+	// calling Func, e.g. generic functions
+	indexable bool
+}
+
+// apply returns code with the mutation applied
+func (c *mutant) apply(code []byte) []byte {
+	res := make([]byte, 0, len(code)+len(c.replace)+len(c.appendCode))
+	res = append(res, code[:c.start]...)
+	res = append(res, c.replace...)
+	res = append(res, code[c.end:]...)
+	res = append(res, c.appendCode...)
+	return res
+}
+
+// findMutants parses code and returns mutants of function
+// bodies, generated files are skipped. Each mutant edits a
+// small range of the code so formatting is kept.
+func findMutants(file string, code []byte, ops map[string]bool) ([]*mutant, error) {
+	fset := token.NewFileSet()
+	astFile, err := parser.ParseFile(fset, file, code, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	if isGeneratedFile(astFile) {
+		return nil, nil
+	}
+	offset := func(pos token.Pos) int {
+		return fset.Position(pos).Offset
+	}
+	var mutants []*mutant
+	var fn *ast.FuncDecl
+	add := func(op string, node ast.Node, start token.Pos, end token.Pos, replace string) {
+		nodeStart, nodeEnd := offset(node.Pos()), offset(node.End())
+		s, e := offset(start), offset(end)
+		pos := fset.Position(start)
+		mutated := string(code[nodeStart:s]) + replace + string(code[e:nodeEnd])
+		m := &mutant{
+			Line:      pos.Line,
+			Col:       pos.Column,
+			Func:      coverage.FuncName(fn),
+			Operator:  op,
+			Original:  shortCode(string(code[nodeStart:nodeEnd])),
+			Mutated:   shortCode(mutated),
+			absFile:   file,
+			start:     s,
+			end:       e,
+			replace:   replace,
+			indexable: fn.Type.TypeParams == nil && !isGenericRecv(fn),
+		}
+		if strings.Contains(replace, mutantErrorType) {
+			m.appendCode = mutantErrorDecl
+		}
+		mutants = append(mutants, m)
+	}
+	text := func(node ast.Node) string {
+		return string(code[offset(node.Pos()):offset(node.End())])
+	}
+
+	var walk func(body ast.Node, results *ast.FieldList)
+	walk = func(body ast.Node, results *ast.FieldList) {
+		resultTypes := expandFields(results)
+		ast.Inspect(body, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.FuncLit:
+				if n.Body != nil {
+					walk(n.Body, n.Type.Results)
+				}
+				return false
+			case *ast.BinaryExpr:
+				opEnd := n.OpPos + token.Pos(len(n.Op.String()))
+				if to, ok := flipOps[n.Op]; ok && ops[mutateOpFlip] {
+					add(mutateOpFlip, n, n.OpPos, opEnd, to.String())
+				}
+				if to, ok := boundaryOps[n.Op]; ok && ops[mutateOpBoundary] {
+					add(mutateOpBoundary, n, n.OpPos, opEnd, to.String())
+				}
+			case *ast.IfStmt:
+				if !ops[mutateOpFlip] {
+					break
+				}
+				switch cond := n.Cond.(type) {
+				case *ast.BinaryExpr:
+					// flipped as binary expression
+				case *ast.UnaryExpr:
+					if cond.Op == token.NOT {
+						add(mutateOpFlip, cond, cond.Pos(), cond.End(), text(cond.X))
+					}
+				default:
+					add(mutateOpFlip, cond, cond.Pos(), cond.End(), "!("+text(cond)+")")
+				}
+			case *ast.ExprStmt:
+				if _, ok := n.X.(*ast.CallExpr); ok && ops[mutateOpDrop] && !isPanicCall(n.X) {
+					add(mutateOpDrop, n, n.Pos(), n.End(), "")
+				}
+			case *ast.IncDecStmt:
+				if ops[mutateOpDrop] {
+					add(mutateOpDrop, n, n.Pos(), n.End(), "")
+				}
+			case *ast.AssignStmt:
+				if ops[mutateOpDrop] && n.Tok != token.DEFINE && !isBlankAssign(n) {
+					add(mutateOpDrop, n, n.Pos(), n.End(), "")
+				}
+			case *ast.ReturnStmt:
+				if !ops[mutateOpReturn] {
+					break
+				}
+				for i, result := range n.Results {
+					var resultType ast.Expr
+					if len(resultTypes) == len(n.Results) {
+						resultType = resultTypes[i]
+					}
+					if replace := mutateResult(result, resultType); replace != "" {
+						add(mutateOpReturn, n, result.Pos(), result.End(), replace)
+					}
+				}
+			}
+			return true
+		})
+	}
+	for _, decl := range astFile.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Body == nil {
+			continue
+		}
+		fn = funcDecl
+		walk(fn.Body, fn.Type.Results)
+	}
+	return mutants, nil
+}
+
+// mutateResult returns replacement of a returned
+// expression, empty if not mutated
+func mutateResult(result ast.Expr, resultType ast.Expr) string {
+	switch r := result.(type) {
+	case *ast.Ident:
+		switch r.Name {
+		case "true":
+			return "false"
+		case "false":
+			return "true"
+		case "err":
+			return "nil"
+		case "nil":
+			if typ, ok := resultType.(*ast.Ident); ok && typ.Name == "error" {
+				return mutantErrorType + "{}"
+			}
+		}
+	case *ast.BasicLit:
+		switch r.Kind {
+		case token.INT:
+			if r.Value == "0" {
+				return "1"
+			}
+			return "0"
+		case token.STRING:
+			if r.Value == `""` || r.Value == "``" {
+				return `"xgo mutant"`
+			}
+			return `""`
+		}
+	}
+	return ""
+}
+
+// expandFields returns one type per result,
+// e.g. (a, b int, err error) -> int, int, error
+func expandFields(fields *ast.FieldList) []ast.Expr {
+	if fields == nil {
+		return nil
+	}
+	var types []ast.Expr
+	for _, field := range fields.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			types = append(types, field.Type)
+		}
+	}
+	return types
+}
+
+func isPanicCall(expr ast.Expr) bool {
+	call, ok := expr.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	ident, ok := call.Fun.(*ast.Ident)
+	return ok && ident.Name == "panic"
+}
+
+func isBlankAssign(stmt *ast.AssignStmt) bool {
+	for _, lhs := range stmt.Lhs {
+		if ident, ok := lhs.(*ast.Ident); !ok || ident.Name != "_" {
+			return false
+		}
+	}
+	return true
+}
+
+// see https://go.dev/s/generatedcode
+func isGeneratedFile(file *ast.File) bool {
+	for _, group := range file.Comments {
+		if group.Pos() >= file.Package {
+			return false
+		}
+		for _, comment := range group.List {
+			if strings.HasPrefix(comment.Text, "// Code generated ") && strings.HasSuffix(comment.Text, " DO NOT EDIT.") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// shortCode collapses code into a single line for display
+func shortCode(code string) string {
+	code = strings.Join(strings.Fields(code), " ")
+	const max = 80
+	if len(code) > max {
+		return code[:max-3] + "..."
+	}
+	return code
+}
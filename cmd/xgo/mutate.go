@@ -0,0 +1,604 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xhd2015/xgo/support/cmd"
+	"github.com/xhd2015/xgo/support/coverage"
+	"github.com/xhd2015/xgo/support/git"
+)
+
+const mutateHelp = `
+Xgo mutate applies small changes to the code and runs tests against each
+of them, a mutant survives if tests still pass. Mutated files are provided
+to the compiler via -overlay, source files on disk are not modified.
+
+Usage:
+    xgo mutate [options] [go test flags] [packages]
+
+Options:
+    --operators <ops>       comma separated operators, default: flip,boundary,drop,return
+    --base <ref>            only mutate lines changed since the merge base of <ref> and HEAD
+    --run <regex>           only run tests matching <regex>, by default tests of the package,
+                            narrowed to those calling the mutated function if .xgo/test-index.json exists
+    --timeout <duration>    timeout of each mutant, default: 3x duration of the first run plus 10s
+    --parallel <n>          mutants tested in parallel, default: 1
+    --min-score <percent>   exit with error if the mutation score is below <percent>
+    --project-dir <dir>     the project dir, default: current dir
+    --format <format>       text or json, default: text
+    -o <file>               output to file instead of stdout
+
+Operators:
+    flip        == to !=, < to >=, && to ||, if c to if !(c)
+    boundary    < to <=, > to >=
+    drop        drop calls, assignments and ++/--
+    return      swap returned true and false, err to nil, nil error to non-nil, 0 to 1, strings to ""
+
+Examples:
+    xgo mutate ./pkg/...                          mutate and test ./pkg/...
+    xgo mutate --base origin/main ./...           mutate lines changed since origin/main
+    xgo mutate --min-score 80 --parallel 4 ./...  fail if less than 80% mutants are killed
+`
+
+type mutateOptions struct {
+	// default: current xgo executable
+	goCommand  string
+	projectDir string
+	ops        map[string]bool
+	base       string
+	run        string
+	timeout    time.Duration
+	parallel   int
+	minScore   float64
+	format     string
+	outFile    string
+	// passed to go test
+	testFlags []string
+	pkgArgs   []string
+}
+
+type mutateReport struct {
+	// percent of killed and timed out mutants,
+	// compile errors are excluded
+	Score        float64
+	Killed       int
+	Survived     int
+	Timeout      int
+	NoCoverage   int
+	CompileError int
+	Mutants      []*mutant
+	// packages not mutated, e.g. no tests or tests fail
+	Skipped []*mutateSkipped
+}
+
+type mutateSkipped struct {
+	Pkg    string
+	Reason string
+}
+
+func handleMutate(args []string) error {
+	opts, err := parseMutateOptions(args)
+	if err != nil {
+		return err
+	}
+	if opts == nil {
+		// help
+		return nil
+	}
+	report, err := runMutate(opts)
+	if err != nil {
+		return err
+	}
+	var out io.Writer = os.Stdout
+	if opts.outFile != "" {
+		file, err := os.Create(opts.outFile)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	if opts.format == "json" {
+		data, err := json.MarshalIndent(report, "", "    ")
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(append(data, '\n'))
+		if err != nil {
+			return err
+		}
+	} else {
+		writeMutateReport(out, report)
+	}
+	if opts.minScore > 0 && report.Score < opts.minScore {
+		return fmt.Errorf("mutation score %s is below %s", formatMutateScore(report.Score), formatMutateScore(opts.minScore))
+	}
+	return nil
+}
+
+func parseMutateOptions(args []string) (*mutateOptions, error) {
+	opts := &mutateOptions{
+		parallel: 1,
+		format:   "text",
+	}
+	var ops string
+	var remainArgs []string
+	n := len(args)
+	for i := 0; i < n; i++ {
+		arg := args[i]
+		if arg == "--" {
+			remainArgs = append(remainArgs, args[i+1:]...)
+			break
+		}
+		if arg == "-h" || arg == "--help" {
+			fmt.Print(strings.TrimPrefix(mutateHelp, "\n"))
+			return nil, nil
+		}
+		var ptr *string
+		var value string
+		switch arg {
+		case "--operators":
+			ptr = &ops
+		case "--base":
+			ptr = &opts.base
+		case "--run":
+			ptr = &opts.run
+		case "--project-dir":
+			ptr = &opts.projectDir
+		case "--format":
+			ptr = &opts.format
+		case "-o":
+			ptr = &opts.outFile
+		case "--timeout", "--parallel", "--min-score":
+			ptr = &value
+		default:
+			remainArgs = append(remainArgs, arg)
+			continue
+		}
+		if i+1 >= n {
+			return nil, fmt.Errorf("%s requires argument", arg)
+		}
+		*ptr = args[i+1]
+		i++
+
+		var err error
+		switch arg {
+		case "--timeout":
+			opts.timeout, err = time.ParseDuration(value)
+		case "--parallel":
+			opts.parallel, err = strconv.Atoi(value)
+			if err == nil && opts.parallel <= 0 {
+				err = fmt.Errorf("requires positive number")
+			}
+		case "--min-score":
+			opts.minScore, err = strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", arg, err)
+		}
+	}
+	if opts.format != "text" && opts.format != "json" {
+		return nil, fmt.Errorf("unrecognized format: %s, expect text or json", opts.format)
+	}
+	opts.ops = make(map[string]bool)
+	if ops == "" {
+		ops = strings.Join(mutateOps, ",")
+	}
+	for _, op := range strings.Split(ops, ",") {
+		op = strings.TrimSpace(op)
+		if op == "" {
+			continue
+		}
+		if !containsStr(mutateOps, op) {
+			return nil, fmt.Errorf("unrecognized operator: %s, expect one of %s", op, strings.Join(mutateOps, ","))
+		}
+		opts.ops[op] = true
+	}
+	opts.testFlags, opts.pkgArgs = splitPkgArgs(remainArgs)
+	return opts, nil
+}
+
+func runMutate(opts *mutateOptions) (*mutateReport, error) {
+	dir := opts.projectDir
+	if dir == "" {
+		dir = "."
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	goCommand := opts.goCommand
+	if goCommand == "" {
+		// mutants are tested by xgo itself
+		goCommand, err = os.Executable()
+		if err != nil {
+			return nil, err
+		}
+	}
+	var changes map[string]*git.FileChange
+	if opts.base != "" {
+		base, err := git.ResolveBaseRef(dir, opts.base)
+		if err != nil {
+			return nil, err
+		}
+		topLevel, err := git.ShowTopLevel(dir)
+		if err != nil {
+			return nil, err
+		}
+		diffs, err := git.DiffLines(dir, base)
+		if err != nil {
+			return nil, err
+		}
+		changes = make(map[string]*git.FileChange, len(diffs))
+		for _, diff := range diffs {
+			changes[filepath.Join(topLevel, filepath.FromSlash(diff.File))] = diff
+		}
+	}
+
+	var index *coverage.TestIndex
+	if opts.run == "" {
+		root, err := resolveProjectRoot(dir)
+		if err != nil {
+			return nil, err
+		}
+		index, err = coverage.LoadTestIndex(filepath.Join(root, filepath.FromSlash(coverage.TestIndexFile)))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pkgs, err := listMutatePkgs(dir, opts.pkgArgs)
+	if err != nil {
+		return nil, err
+	}
+	tmpDir, err := os.MkdirTemp("", "xgo-mutate")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	report := &mutateReport{Mutants: []*mutant{}, Skipped: []*mutateSkipped{}}
+	runner := &mutantRunner{
+		goCommand: goCommand,
+		dir:       dir,
+		tmpDir:    tmpDir,
+		testFlags: opts.testFlags,
+	}
+	for _, pkg := range pkgs {
+		if len(pkg.TestGoFiles) == 0 && len(pkg.XTestGoFiles) == 0 {
+			report.Skipped = append(report.Skipped, &mutateSkipped{Pkg: pkg.ImportPath, Reason: "no test files"})
+			continue
+		}
+		var pkgMutants []*mutant
+		sources := make(map[string][]byte)
+		for _, file := range pkg.GoFiles {
+			absFile := filepath.Join(pkg.Dir, file)
+			var change *git.FileChange
+			if changes != nil {
+				change = changes[absFile]
+				if change == nil {
+					continue
+				}
+			}
+			code, err := os.ReadFile(absFile)
+			if err != nil {
+				return nil, err
+			}
+			mutants, err := findMutants(absFile, code, opts.ops)
+			if err != nil {
+				return nil, err
+			}
+			relFile := absFile
+			if rel, err := filepath.Rel(dir, absFile); err == nil && !strings.HasPrefix(rel, "..") {
+				relFile = rel
+			}
+			for _, m := range mutants {
+				if change != nil && !change.HasLine(m.Line) {
+					continue
+				}
+				m.Pkg = pkg.ImportPath
+				m.File = filepath.ToSlash(relFile)
+				pkgMutants = append(pkgMutants, m)
+			}
+			sources[absFile] = code
+		}
+		if len(pkgMutants) == 0 {
+			continue
+		}
+
+		// tests must pass before mutation
+		begin := time.Now()
+		output, err := runner.test(context.Background(), pkg.ImportPath, "", opts.run)
+		if err != nil {
+			reason := "tests fail without mutation"
+			if isBuildFailure(output) {
+				reason = "build fails without mutation"
+			}
+			report.Skipped = append(report.Skipped, &mutateSkipped{Pkg: pkg.ImportPath, Reason: reason})
+			continue
+		}
+		timeout := opts.timeout
+		if timeout == 0 {
+			timeout = 3*time.Since(begin) + 10*time.Second
+		}
+
+		for _, m := range pkgMutants {
+			m.ID = len(report.Mutants) + 1
+			report.Mutants = append(report.Mutants, m)
+		}
+		runner.runAll(pkgMutants, sources, opts.parallel, func(m *mutant) (string, bool) {
+			if opts.run != "" {
+				return opts.run, true
+			}
+			return mutantRunPattern(index, m)
+		}, timeout, len(report.Mutants))
+	}
+	summarizeMutants(report)
+	return report, nil
+}
+
+// listMutatePkgs lists packages matched by pkgArgs
+func listMutatePkgs(dir string, pkgArgs []string) ([]*goListPkg, error) {
+	args := []string{"list", "-json"}
+	if len(pkgArgs) == 0 {
+		pkgArgs = []string{"."}
+	}
+	args = append(args, pkgArgs...)
+	output, err := cmd.Dir(dir).Output("go", args...)
+	if err != nil {
+		return nil, err
+	}
+	var pkgs []*goListPkg
+	dec := json.NewDecoder(strings.NewReader(output))
+	for dec.More() {
+		var pkg *goListPkg
+		err := dec.Decode(&pkg)
+		if err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Slice(pkgs, func(i, j int) bool {
+		return pkgs[i].ImportPath < pkgs[j].ImportPath
+	})
+	return pkgs, nil
+}
+
+// mutantRunPattern returns -run pattern of tests calling the
+// mutated function according to the index, false if no test
+// calls it. Empty pattern means all tests of the package.
+func mutantRunPattern(index *coverage.TestIndex, m *mutant) (string, bool) {
+	if index == nil || !m.indexable {
+		return "", true
+	}
+	var indexed bool
+	for _, entry := range index.Tests {
+		if entry.Pkg == m.Pkg {
+			indexed = true
+			break
+		}
+	}
+	if !indexed {
+		return "", true
+	}
+	var names []string
+	for _, entry := range index.TestsOfFunc(m.Pkg, m.Func) {
+		if entry.Pkg == m.Pkg {
+			names = append(names, entry.Name)
+		}
+	}
+	if len(names) == 0 {
+		return "", false
+	}
+	m.Tests = dedupSorted(names)
+	return "^(" + strings.Join(m.Tests, "|") + ")$", true
+}
+
+type mutantRunner struct {
+	goCommand string
+	dir       string
+	tmpDir    string
+	testFlags []string
+
+	mutex sync.Mutex
+	done  int
+}
+
+func (c *mutantRunner) runAll(mutants []*mutant, sources map[string][]byte, parallel int, pattern func(m *mutant) (string, bool), timeout time.Duration, total int) {
+	ch := make(chan *mutant)
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range ch {
+				runPattern, covered := pattern(m)
+				if !covered {
+					m.Status = mutantNoCoverage
+				} else {
+					m.Status = c.runMutant(m, sources[m.absFile], runPattern, timeout)
+				}
+				c.mutex.Lock()
+				c.done++
+				fmt.Fprintf(os.Stderr, "mutate: [%d/%d] %s %s:%d %s\n", c.done, total, m.Status, m.File, m.Line, m.Operator)
+				c.mutex.Unlock()
+			}
+		}()
+	}
+	for _, m := range mutants {
+		ch <- m
+	}
+	close(ch)
+	wg.Wait()
+}
+
+func (c *mutantRunner) runMutant(m *mutant, code []byte, runPattern string, timeout time.Duration) string {
+	mutantDir := filepath.Join(c.tmpDir, strconv.Itoa(m.ID))
+	overlay, err := writeMutantOverlay(mutantDir, m, code)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "mutate: %v\n", err)
+		return mutantCompileError
+	}
+	defer os.RemoveAll(mutantDir)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	output, err := c.test(ctx, m.Pkg, overlay, runPattern)
+	if err == nil {
+		return mutantSurvived
+	}
+	if ctx.Err() != nil || strings.Contains(output, "panic: test timed out after") {
+		return mutantTimeout
+	}
+	if isBuildFailure(output) {
+		return mutantCompileError
+	}
+	return mutantKilled
+}
+
+func (c *mutantRunner) test(ctx context.Context, pkg string, overlay string, runPattern string) (string, error) {
+	args := []string{"test", "-count=1"}
+	if overlay != "" {
+		args = append(args, "-overlay", overlay)
+	}
+	if runPattern != "" {
+		args = append(args, "-run", runPattern)
+	}
+	args = append(args, c.testFlags...)
+	if deadline, ok := ctx.Deadline(); ok {
+		// the test binary stops itself even if
+		// it survives killing the process group
+		args = append(args, "-timeout="+time.Until(deadline).String())
+	}
+	args = append(args, pkg)
+
+	var buf bytes.Buffer
+	execCmd := exec.Command(c.goCommand, args...)
+	execCmd.Dir = c.dir
+	execCmd.Stdout = &buf
+	execCmd.Stderr = &buf
+	// killing only the command leaves go test and the
+	// test binary running with the output pipe open
+	setProcessGroup(execCmd)
+	err := execCmd.Start()
+	if err != nil {
+		return "", err
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcessGroup(execCmd)
+		case <-done:
+		}
+	}()
+	err = execCmd.Wait()
+	close(done)
+	return buf.String(), err
+}
+
+// writeMutantOverlay writes the mutated file and an overlay
+// replacing the original file with it
+func writeMutantOverlay(dir string, m *mutant, code []byte) (string, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+	mutatedFile := filepath.Join(dir, filepath.Base(m.absFile))
+	err = os.WriteFile(mutatedFile, m.apply(code), 0644)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"Replace": map[string]string{
+			m.absFile: mutatedFile,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	overlayFile := filepath.Join(dir, "overlay.json")
+	err = os.WriteFile(overlayFile, data, 0644)
+	if err != nil {
+		return "", err
+	}
+	return overlayFile, nil
+}
+
+func isBuildFailure(output string) bool {
+	return strings.Contains(output, "[build failed]") || strings.Contains(output, "[setup failed]")
+}
+
+func summarizeMutants(report *mutateReport) {
+	for _, m := range report.Mutants {
+		switch m.Status {
+		case mutantKilled:
+			report.Killed++
+		case mutantSurvived:
+			report.Survived++
+		case mutantTimeout:
+			report.Timeout++
+		case mutantNoCoverage:
+			report.NoCoverage++
+		case mutantCompileError:
+			report.CompileError++
+		}
+	}
+	detected := report.Killed + report.Timeout
+	total := detected + report.Survived + report.NoCoverage
+	report.Score = 100
+	if total > 0 {
+		report.Score = float64(detected) * 100 / float64(total)
+	}
+}
+
+func writeMutateReport(w io.Writer, report *mutateReport) {
+	var undetected []*mutant
+	for _, m := range report.Mutants {
+		if m.Status == mutantSurvived || m.Status == mutantNoCoverage {
+			undetected = append(undetected, m)
+		}
+	}
+	if len(undetected) > 0 {
+		fmt.Fprintf(w, "Surviving mutants:\n")
+		for _, m := range undetected {
+			note := ""
+			if m.Status == mutantNoCoverage {
+				note = " (no test calls " + m.Func + ")"
+			}
+			fmt.Fprintf(w, "  %s:%d:%d %s: %s -> %s%s\n", m.File, m.Line, m.Col, m.Operator, m.Original, m.Mutated, note)
+		}
+		fmt.Fprintln(w)
+	}
+	for _, skipped := range report.Skipped {
+		fmt.Fprintf(w, "skipped %s: %s\n", skipped.Pkg, skipped.Reason)
+	}
+	fmt.Fprintf(w, "mutants: %d, killed: %d, timeout: %d, survived: %d, no coverage: %d, compile error: %d\n",
+		len(report.Mutants), report.Killed, report.Timeout, report.Survived, report.NoCoverage, report.CompileError)
+	fmt.Fprintf(w, "mutation score: %s\n", formatMutateScore(report.Score))
+}
+
+func formatMutateScore(score float64) string {
+	return strconv.FormatFloat(score, 'f', 1, 64) + "%"
+}
+
+func containsStr(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,17 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "os/exec"
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills cmd only, descendants
+// stop by the -timeout passed to go test
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+	cmd.Process.Kill()
+}
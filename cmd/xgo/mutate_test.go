@@ -0,0 +1,160 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// go test -run TestFindMutants -v ./cmd/xgo
+func TestFindMutants(t *testing.T) {
+	code := `package a
+
+import "errors"
+
+func Max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func Check(ok bool, n int) (bool, error) {
+	if !ok {
+		return false, errors.New("not ok")
+	}
+	n++
+	return n == 0, nil
+}
+`
+	mutants, err := findMutants("a.go", []byte(code), map[string]bool{
+		mutateOpFlip:     true,
+		mutateOpBoundary: true,
+		mutateOpDrop:     true,
+		mutateOpReturn:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var descs []string
+	for _, m := range mutants {
+		descs = append(descs, m.Func+":"+m.Operator+": "+m.Original+" -> "+m.Mutated)
+	}
+	expect := []string{
+		"Max:flip: a > b -> a <= b",
+		"Max:boundary: a > b -> a >= b",
+		"Check:flip: !ok -> ok",
+		"Check:return: return false, errors.New(\"not ok\") -> return true, errors.New(\"not ok\")",
+		"Check:drop: n++ -> ",
+		"Check:return: return n == 0, nil -> return n == 0, xgoMutantError{}",
+		"Check:flip: n == 0 -> n != 0",
+	}
+	if strings.Join(descs, "\n") != strings.Join(expect, "\n") {
+		t.Fatalf("unexpected mutants:\n%s", strings.Join(descs, "\n"))
+	}
+
+	// nil error is replaced with a declared type
+	mutated := string(mutants[5].apply([]byte(code)))
+	if !strings.Contains(mutated, "return n == 0, xgoMutantError{}\n") || !strings.Contains(mutated, "func (xgoMutantError) Error() string") {
+		t.Fatalf("unexpected mutated code:\n%s", mutated)
+	}
+}
+
+func TestFindMutantsSkipGenerated(t *testing.T) {
+	code := "// Code generated by x. DO NOT EDIT.\n\npackage a\n\nfunc A(a int) bool { return a > 0 }\n"
+	mutants, err := findMutants("a.go", []byte(code), map[string]bool{mutateOpFlip: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mutants) != 0 {
+		t.Fatalf("expect no mutants of generated file, actual: %d", len(mutants))
+	}
+}
+
+// go test -run TestRunMutate -v ./cmd/xgo
+func TestRunMutate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs go test for each mutant")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("requires go")
+	}
+	dir := t.TempDir()
+	aCode := "package a\n\nfunc Max(a, b int) int {\n\tif a > b {\n\t\treturn a\n\t}\n\treturn b\n}\n"
+	writeFiles(t, dir, map[string]string{
+		"go.mod":      "module example.com/m\n\ngo 1.18\n",
+		"a/a.go":      aCode,
+		"a/a_test.go": "package a\n\nimport \"testing\"\n\nfunc TestMax(t *testing.T) {\n\tif Max(2, 1) != 2 || Max(1, 2) != 2 {\n\t\tt.Fatal(\"wrong max\")\n\t}\n}\n",
+		"b/b.go":      "package b\n\nfunc B() bool { return true }\n",
+	})
+	report, err := runMutate(&mutateOptions{
+		goCommand:  "go",
+		projectDir: dir,
+		ops:        map[string]bool{mutateOpFlip: true, mutateOpBoundary: true},
+		parallel:   2,
+		pkgArgs:    []string{"./..."},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a > b -> a <= b is killed, a > b -> a >= b survives
+	// because Max(a, a) is the same either way
+	if len(report.Mutants) != 2 || report.Killed != 1 || report.Survived != 1 || report.Score != 50 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if m := report.Mutants[1]; m.Status != mutantSurvived || m.File != "a/a.go" || m.Line != 4 || m.Col != 7 {
+		t.Fatalf("unexpected surviving mutant: %+v", m)
+	}
+	if len(report.Skipped) != 1 || report.Skipped[0].Pkg != "example.com/m/b" {
+		t.Fatalf("expect b skipped, actual: %+v", report.Skipped)
+	}
+
+	// source on disk is untouched
+	content, err := os.ReadFile(filepath.Join(dir, "a", "a.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != aCode {
+		t.Fatalf("source modified: %s", content)
+	}
+}
+
+// go test -run TestRunMutantTimeout -v ./cmd/xgo
+func TestRunMutantTimeout(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs go test")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("requires go")
+	}
+	dir := t.TempDir()
+	code := "package a\n\nfunc Count(n int) int {\n\ti := 0\n\tfor i < n {\n\t\ti++\n\t}\n\treturn i\n}\n"
+	writeFiles(t, dir, map[string]string{
+		"go.mod":      "module example.com/m\n\ngo 1.18\n",
+		"a/a.go":      code,
+		"a/a_test.go": "package a\n\nimport \"testing\"\n\nfunc TestCount(t *testing.T) {\n\tif Count(3) != 3 {\n\t\tt.Fatal(\"wrong count\")\n\t}\n}\n",
+	})
+	// i++ -> i--, loops forever
+	start := strings.Index(code, "i++")
+	m := &mutant{
+		ID:      1,
+		Pkg:     "example.com/m/a",
+		absFile: filepath.Join(dir, "a", "a.go"),
+		start:   start,
+		end:     start + len("i++"),
+		replace: "i--",
+	}
+	runner := &mutantRunner{goCommand: "go", dir: dir, tmpDir: t.TempDir()}
+	timeout := 5 * time.Second
+	begin := time.Now()
+	status := runner.runMutant(m, []byte(code), "", timeout)
+	if status != mutantTimeout {
+		t.Fatalf("expect %s, actual: %s", mutantTimeout, status)
+	}
+	if cost := time.Since(begin); cost > timeout+3*time.Second {
+		t.Fatalf("expect stopped at the deadline, cost: %v", cost)
+	}
+}
@@ -78,6 +78,16 @@ type options struct {
 	// --strace-dir
 	stackTraceDir string
 
+	// --cover-per-test
+	coverPerTest bool
+
+	// --affected, --base
+	affected     bool
+	affectedBase string
+
+	// --call-coverage
+	callCoverage string
+
 	remainArgs []string
 
 	testArgs []string
@@ -128,6 +138,10 @@ func parseOptions(cmd string, args []string) (*options, error) {
 	var stackTrace string
 	var stackTraceDir string
 	var trapStdlib bool
+	var coverPerTest bool
+	var affected bool
+	var affectedBase string
+	var callCoverage string
 
 	var remainArgs []string
 	var testArgs []string
@@ -231,6 +245,13 @@ func parseOptions(cmd string, args []string) (*options, error) {
 
 	if cmd == "test" {
 		trapStdlib = true
+		flagValues = append(flagValues, FlagValue{
+			Flags: []string{"--base"},
+			Value: &affectedBase,
+		}, FlagValue{
+			Flags: []string{"--call-coverage"},
+			Value: &callCoverage,
+		})
 	}
 
 	for i := 0; i < nArg; i++ {
@@ -309,6 +330,14 @@ func parseOptions(cmd string, args []string) (*options, error) {
 			noSetup = true
 			continue
 		}
+		if cmd == "test" && arg == "--cover-per-test" {
+			coverPerTest = true
+			continue
+		}
+		if cmd == "test" && arg == "--affected" {
+			affected = true
+			continue
+		}
 
 		debugCompileVal, ok := tryParseOption("--debug-compile", args, &i)
 		if ok {
@@ -449,6 +478,10 @@ func parseOptions(cmd string, args []string) (*options, error) {
 		stackTrace:    stackTrace,
 		stackTraceDir: stackTraceDir,
 		trapStdlib:    trapStdlib,
+		coverPerTest:  coverPerTest,
+		affected:      affected,
+		affectedBase:  affectedBase,
+		callCoverage:  callCoverage,
 
 		remainArgs: remainArgs,
 		testArgs:   testArgs,
@@ -570,3 +603,35 @@ func getPkgArgs(args []string) []string {
 	}
 	return newArgs
 }
+
+// splitPkgArgs separates packages from go flags in args,
+// flags may appear before or after packages
+func splitPkgArgs(args []string) (flags []string, pkgs []string) {
+	n := len(args)
+	for i := 0; i < n; i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			pkgs = append(pkgs, arg)
+			continue
+		}
+		flags = append(flags, arg)
+		if strings.Contains(arg, "=") {
+			continue
+		}
+		// make --opt equivalent with -opt
+		if strings.HasPrefix(arg, "--") {
+			arg = arg[1:]
+		}
+		switch arg {
+		case "-a", "-n", "-race", "-msan", "-asan", "-cover", "-v", "-work", "-x", "-linkshared", "-buildvcs", "-trimpath",
+			"-c", "-json", "-short", "-failfast", "-benchmem":
+			// zero arg
+		default:
+			if i+1 < n {
+				i++
+				flags = append(flags, args[i])
+			}
+		}
+	}
+	return flags, pkgs
+}
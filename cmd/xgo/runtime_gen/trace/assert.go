@@ -0,0 +1,509 @@
+package trace
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// TestingT is the subset of testing.TB used by call assertions
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// CallMatcher matches recorded calls by function name
+// and optional predicates, create one with Call
+type CallMatcher struct {
+	name  string
+	preds []callPred
+}
+
+type callPred struct {
+	desc  string
+	match func(stack *Stack) bool
+}
+
+// Call matches calls by name, which is compared against the
+// method name, IdentityName, or IdentityName qualified by the
+// package name or path. `*` matches any sequence, e.g.
+//
+//	Call("Save"), Call("(*Service).Save"), Call("dao.*")
+func Call(name string) *CallMatcher {
+	return &CallMatcher{name: name}
+}
+
+func (c *CallMatcher) with(desc string, match func(stack *Stack) bool) *CallMatcher {
+	preds := make([]callPred, len(c.preds), len(c.preds)+1)
+	copy(preds, c.preds)
+	return &CallMatcher{
+		name:  c.name,
+		preds: append(preds, callPred{desc: desc, match: match}),
+	}
+}
+
+// Arg requires argument `name` to satisfy pred,
+// the receiver can be referred by its name
+func (c *CallMatcher) Arg(name string, pred func(v interface{}) bool) *CallMatcher {
+	return c.with(name+" matches", func(stack *Stack) bool {
+		v, ok := getArg(stack, name)
+		return ok && pred(v)
+	})
+}
+
+// ArgEquals requires argument `name` to equal value, basic
+// values are converted to the argument type before comparing,
+// so ArgEquals("id", 1) matches an int64 id
+func (c *CallMatcher) ArgEquals(name string, value interface{}) *CallMatcher {
+	return c.with(fmt.Sprintf("%s=%v", name, value), func(stack *Stack) bool {
+		v, ok := getArg(stack, name)
+		return ok && valueEquals(v, value)
+	})
+}
+
+// Failed requires the call to return an error or panic
+func (c *CallMatcher) Failed() *CallMatcher {
+	return c.with("failed", func(stack *Stack) bool {
+		return stack.Error != nil || stack.Panic
+	})
+}
+
+// Succeeded requires the call to return without error or panic
+func (c *CallMatcher) Succeeded() *CallMatcher {
+	return c.with("succeeded", func(stack *Stack) bool {
+		return stack.Error == nil && !stack.Panic
+	})
+}
+
+// Where adds a custom predicate, desc is shown in failure messages
+func (c *CallMatcher) Where(desc string, pred func(stack *Stack) bool) *CallMatcher {
+	return c.with(desc, pred)
+}
+
+// Match reports whether stack is a matched call
+func (c *CallMatcher) Match(stack *Stack) bool {
+	if stack == nil || stack.FuncInfo == nil {
+		return false
+	}
+	f := stack.FuncInfo
+	if !matchFuncName(c.name, f.Pkg, f.IdentityName, f.Name, f.RecvType) {
+		return false
+	}
+	for _, p := range c.preds {
+		if !p.match(stack) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *CallMatcher) String() string {
+	if len(c.preds) == 0 {
+		return c.name
+	}
+	descs := make([]string, 0, len(c.preds))
+	for _, p := range c.preds {
+		descs = append(descs, p.desc)
+	}
+	return c.name + "(" + strings.Join(descs, ", ") + ")"
+}
+
+func getArg(stack *Stack, name string) (v interface{}, ok bool) {
+	if stack.Args == nil {
+		return nil, false
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			v, ok = nil, false
+		}
+	}()
+	field := stack.Args.GetField(name)
+	if field == nil {
+		return nil, false
+	}
+	return field.Value(), true
+}
+
+func valueEquals(actual interface{}, expect interface{}) bool {
+	if reflect.DeepEqual(actual, expect) {
+		return true
+	}
+	if actual == nil || expect == nil {
+		return false
+	}
+	av := reflect.ValueOf(actual)
+	ev := reflect.ValueOf(expect)
+	if !isBasicKind(av.Kind()) || !isBasicKind(ev.Kind()) {
+		return false
+	}
+	if av.Kind() == reflect.String != (ev.Kind() == reflect.String) {
+		return false
+	}
+	if !ev.Type().ConvertibleTo(av.Type()) {
+		return false
+	}
+	// converting back detects overflow, e.g. 256 to uint8
+	cv := ev.Convert(av.Type())
+	return cv.Interface() == av.Interface() && cv.Convert(ev.Type()).Interface() == expect
+}
+
+func isBasicKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// CallAssert asserts on the flow of collected calls, typically
+// used inside OnComplete:
+//
+//	trace.Options().OnComplete(func(root *trace.Root) {
+//		a := trace.AssertCalls(t, root)
+//		a.InOrder(trace.Call("Validate"), trace.Call("Save"))
+//		a.After(trace.Call("Save").Failed()).Never(trace.Call("Notify"))
+//	}).Collect(func() {
+//		...
+//	})
+//
+// Assertions report failures with t.Errorf and the relevant
+// part of the actual call tree, and return whether they passed.
+type CallAssert struct {
+	t      TestingT
+	parent map[*Stack]*Stack
+	// description of the scope, empty for all calls
+	scope string
+	// calls in scope, in call order
+	calls []*Stack
+}
+
+// AssertCalls creates assertions on all calls of root
+func AssertCalls(t TestingT, root *Root) *CallAssert {
+	c := &CallAssert{
+		t:      t,
+		parent: make(map[*Stack]*Stack),
+	}
+	if root == nil {
+		return c
+	}
+	var walk func(stack *Stack)
+	walk = func(stack *Stack) {
+		c.calls = append(c.calls, stack)
+		for _, child := range stack.Children {
+			c.parent[child] = stack
+			walk(child)
+		}
+	}
+	for _, stack := range root.Children {
+		walk(stack)
+	}
+	return c
+}
+
+// Calls returns calls in scope, in call order
+func (c *CallAssert) Calls() []*Stack {
+	return c.calls
+}
+
+func (c *CallAssert) sub(scope string, calls []*Stack) *CallAssert {
+	if c.scope != "" {
+		scope = c.scope + ", " + scope
+	}
+	return &CallAssert{
+		t:      c.t,
+		parent: c.parent,
+		scope:  scope,
+		calls:  calls,
+	}
+}
+
+func (c *CallAssert) first(m *CallMatcher) (int, bool) {
+	for i, stack := range c.calls {
+		if m.Match(stack) {
+			return i, true
+		}
+	}
+	c.t.Helper()
+	c.fail(fmt.Sprintf("expect %s to be called", m), nil)
+	return 0, false
+}
+
+// Within narrows the scope to calls made inside calls matching m
+func (c *CallAssert) Within(m *CallMatcher) *CallAssert {
+	c.t.Helper()
+	matched := make(map[*Stack]bool)
+	for _, stack := range c.calls {
+		if m.Match(stack) {
+			matched[stack] = true
+		}
+	}
+	var calls []*Stack
+	for _, stack := range c.calls {
+		if c.hasAncestor(stack, matched) {
+			calls = append(calls, stack)
+		}
+	}
+	if len(matched) == 0 {
+		c.fail(fmt.Sprintf("expect %s to be called", m), nil)
+	}
+	return c.sub("within "+m.String(), calls)
+}
+
+// After narrows the scope to calls made after the
+// first call matching m returned
+func (c *CallAssert) After(m *CallMatcher) *CallAssert {
+	c.t.Helper()
+	idx, ok := c.first(m)
+	if !ok {
+		return c.sub("after "+m.String(), nil)
+	}
+	target := c.calls[idx]
+	var calls []*Stack
+	for _, stack := range c.calls[idx+1:] {
+		if !c.isDescendant(stack, target) {
+			calls = append(calls, stack)
+		}
+	}
+	return c.sub("after "+m.String(), calls)
+}
+
+// Before narrows the scope to calls made before
+// the first call matching m
+func (c *CallAssert) Before(m *CallMatcher) *CallAssert {
+	c.t.Helper()
+	idx, ok := c.first(m)
+	if !ok {
+		return c.sub("before "+m.String(), nil)
+	}
+	target := c.calls[idx]
+	var calls []*Stack
+	for _, stack := range c.calls[:idx] {
+		// callers of the target have not returned yet
+		if !c.isDescendant(target, stack) {
+			calls = append(calls, stack)
+		}
+	}
+	return c.sub("before "+m.String(), calls)
+}
+
+func (c *CallAssert) hasAncestor(stack *Stack, set map[*Stack]bool) bool {
+	for p := c.parent[stack]; p != nil; p = c.parent[p] {
+		if set[p] {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CallAssert) isDescendant(stack *Stack, ancestor *Stack) bool {
+	for p := c.parent[stack]; p != nil; p = c.parent[p] {
+		if p == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CallAssert) matches(m *CallMatcher) []*Stack {
+	var list []*Stack
+	for _, stack := range c.calls {
+		if m.Match(stack) {
+			list = append(list, stack)
+		}
+	}
+	return list
+}
+
+// Called asserts m is called at least once
+func (c *CallAssert) Called(m *CallMatcher) bool {
+	c.t.Helper()
+	if len(c.matches(m)) > 0 {
+		return true
+	}
+	c.fail(fmt.Sprintf("expect %s to be called", m), nil)
+	return false
+}
+
+// CalledTimes asserts m is called exactly n times
+func (c *CallAssert) CalledTimes(m *CallMatcher, n int) bool {
+	c.t.Helper()
+	list := c.matches(m)
+	if len(list) == n {
+		return true
+	}
+	c.fail(fmt.Sprintf("expect %s to be called %d times, actual %d", m, n, len(list)), list)
+	return false
+}
+
+// Never asserts m is not called
+func (c *CallAssert) Never(m *CallMatcher) bool {
+	c.t.Helper()
+	list := c.matches(m)
+	if len(list) == 0 {
+		return true
+	}
+	c.fail(fmt.Sprintf("expect %s not to be called, actual %d times", m, len(list)), list)
+	return false
+}
+
+// InOrder asserts matchers are called in the given order,
+// other calls may happen in between
+func (c *CallAssert) InOrder(ms ...*CallMatcher) bool {
+	c.t.Helper()
+	var matched []*Stack
+	i := 0
+	for _, stack := range c.calls {
+		if i < len(ms) && ms[i].Match(stack) {
+			matched = append(matched, stack)
+			i++
+		}
+	}
+	if i == len(ms) {
+		return true
+	}
+	var msg string
+	if i == 0 {
+		msg = fmt.Sprintf("expect %s to be called", ms[0])
+	} else {
+		msg = fmt.Sprintf("expect %s to be called after %s", ms[i], ms[i-1])
+		if len(c.matches(ms[i])) > 0 {
+			msg += ", actually called before"
+		}
+	}
+	c.fail(msg, matched)
+	return false
+}
+
+// Unordered asserts each matcher is matched by a distinct call,
+// in any order
+func (c *CallAssert) Unordered(ms ...*CallMatcher) bool {
+	c.t.Helper()
+	// bipartite matching of matchers to calls
+	assigned := make(map[*Stack]int)
+	var try func(i int, seen map[*Stack]bool) bool
+	try = func(i int, seen map[*Stack]bool) bool {
+		for _, stack := range c.calls {
+			if seen[stack] || !ms[i].Match(stack) {
+				continue
+			}
+			seen[stack] = true
+			j, ok := assigned[stack]
+			if !ok || try(j, seen) {
+				assigned[stack] = i
+				return true
+			}
+		}
+		return false
+	}
+	var missing []string
+	for i := range ms {
+		if !try(i, make(map[*Stack]bool)) {
+			missing = append(missing, ms[i].String())
+		}
+	}
+	if len(missing) == 0 {
+		return true
+	}
+	var matched []*Stack
+	for _, stack := range c.calls {
+		if _, ok := assigned[stack]; ok {
+			matched = append(matched, stack)
+		}
+	}
+	c.fail(fmt.Sprintf("expect %s to be called", strings.Join(missing, ", ")), matched)
+	return false
+}
+
+// max lines of call tree printed on failure
+const assertTreeLimit = 60
+
+// fail reports msg with calls in scope, marked calls are highlighted
+func (c *CallAssert) fail(msg string, marked []*Stack) {
+	c.t.Helper()
+	var b bytes.Buffer
+	b.WriteString(msg)
+	b.WriteString("\nactual calls")
+	if c.scope != "" {
+		b.WriteString(" ")
+		b.WriteString(c.scope)
+	}
+	if len(c.calls) == 0 {
+		b.WriteString(": none")
+		c.t.Errorf("%s", b.String())
+		return
+	}
+	b.WriteString(":\n")
+	marks := make(map[*Stack]bool, len(marked))
+	for _, stack := range marked {
+		marks[stack] = true
+	}
+	inScope := make(map[*Stack]bool, len(c.calls))
+	for _, stack := range c.calls {
+		inScope[stack] = true
+	}
+	var lines int
+	var write func(stack *Stack, indent string)
+	write = func(stack *Stack, indent string) {
+		if lines >= assertTreeLimit {
+			lines++
+			return
+		}
+		lines++
+		b.WriteString(indent)
+		if marks[stack] {
+			b.WriteString("> ")
+		} else {
+			b.WriteString("  ")
+		}
+		b.WriteString(describeCall(stack))
+		b.WriteString("\n")
+		for _, child := range stack.Children {
+			if inScope[child] {
+				write(child, indent+"  ")
+			}
+		}
+	}
+	for _, stack := range c.calls {
+		if p := c.parent[stack]; p == nil || !inScope[p] {
+			write(stack, "  ")
+		}
+	}
+	if lines > assertTreeLimit {
+		fmt.Fprintf(&b, "  ... %d more calls\n", lines-assertTreeLimit)
+	}
+	c.t.Errorf("%s", strings.TrimSuffix(b.String(), "\n"))
+}
+
+// max length of args printed on failure
+const assertArgsLimit = 80
+
+func describeCall(stack *Stack) string {
+	var b strings.Builder
+	if stack.FuncInfo != nil {
+		b.WriteString(stack.FuncInfo.IdentityName)
+	} else {
+		b.WriteString("<unknown>")
+	}
+	if stack.Args != nil && stack.Args.NumField() > 0 {
+		data, err := MarshalAnyJSON(redactObject(stack.FuncInfo, stack.Args))
+		s := string(data)
+		if err != nil {
+			s = "<" + err.Error() + ">"
+		}
+		if len(s) > assertArgsLimit {
+			s = truncateUTF8(s, assertArgsLimit) + "..."
+		}
+		b.WriteString(" ")
+		b.WriteString(s)
+	}
+	if stack.Panic {
+		fmt.Fprintf(&b, " PANIC: %v", stack.Error)
+	} else if stack.Error != nil {
+		fmt.Fprintf(&b, " ERROR: %v", stack.Error)
+	}
+	return b.String()
+}
@@ -0,0 +1,418 @@
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/xhd2015/xgo/runtime/core"
+	"github.com/xhd2015/xgo/runtime/trap"
+	"github.com/xhd2015/xgo/runtime/trap/flags"
+)
+
+// env: XGO_TRACE_CONTROL
+// description:
+//
+//	enable runtime control of trace collection, so that
+//	an operator can start and stop collecting traces
+//	on a running binary without rebuilding it.
+//	the binary must import this package, or be built
+//	with --strace which imports it automatically.
+//
+// values(comma separated):
+//
+//	signal       - SIGUSR1 toggles collection on and off
+//	http         - serve control endpoints at localhost:7070
+//	http=<addr>  - serve control endpoints at <addr>
+//
+// example:
+//
+//	XGO_TRACE_CONTROL=signal,http=localhost:7070 ./server
+const XGO_TRACE_CONTROL = "XGO_TRACE_CONTROL"
+
+const defaultControlAddr = "localhost:7070"
+
+// ControlOptions describes a trace collection started
+// at runtime, see StartControl
+type ControlOptions struct {
+	// Output overrides XGO_TRACE_OUTPUT for traces
+	// collected in this session, empty means unchanged
+	Output string
+
+	// Limit stops the session after Limit traces
+	// have been emitted, 0 means no limit
+	Limit int
+
+	// Match restricts which calls start a new trace,
+	// comma separated patterns matched against
+	// `pkg.IdentityName`, `pkgName.IdentityName`,
+	// `IdentityName` or the method name, `*`
+	// matches any characters
+	// examples:
+	//   main.handleRequest
+	//   *Handler.ServeHTTP
+	//   github.com/org/svc/api.*
+	Match string
+}
+
+type ControlStatus struct {
+	Active  bool   `json:"active"`
+	Output  string `json:"output"`
+	Limit   int    `json:"limit"`
+	Match   string `json:"match"`
+	Started int64  `json:"started"`
+	Emitted int64  `json:"emitted"`
+}
+
+type controlState struct {
+	opts     ControlOptions
+	patterns []string
+
+	started int64 // atomic
+	emitted int64 // atomic
+}
+
+// set only in init
+var controlInstalled bool
+
+var controlActive int32 // atomic
+var controlMutex sync.Mutex
+var currentControl *controlState
+
+func init() {
+	env := os.Getenv(XGO_TRACE_CONTROL)
+	if env == "" {
+		return
+	}
+	err := setupControl(env)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "WARNING: %s=%s: %v\n", XGO_TRACE_CONTROL, env, err)
+	}
+}
+
+func setupControl(env string) error {
+	var useSignal bool
+	var httpAddr string
+	for _, item := range strings.Split(env, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if item == "signal" {
+			useSignal = true
+			continue
+		}
+		if item == "http" {
+			httpAddr = defaultControlAddr
+			continue
+		}
+		if strings.HasPrefix(item, "http=") {
+			httpAddr = strings.TrimPrefix(item, "http=")
+			if httpAddr == "" {
+				return fmt.Errorf("http requires address")
+			}
+			continue
+		}
+		return fmt.Errorf("unrecognized control: %s", item)
+	}
+	if !useSignal && httpAddr == "" {
+		return nil
+	}
+	// interceptors can only be installed globally
+	// during init, afterwards they are bound to
+	// goroutines
+	controlInstalled = true
+	setupGlobalInterceptor()
+
+	if useSignal {
+		err := setupControlSignal()
+		if err != nil {
+			return err
+		}
+	}
+	if httpAddr != "" {
+		err := serveControlHTTP(httpAddr)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartControl starts collecting traces of all goroutines,
+// each top-level call that matches opts.Match produces a
+// trace file.
+// It requires XGO_TRACE_CONTROL to be set when the program
+// starts.
+// If a session is already active, it will be replaced.
+func StartControl(opts *ControlOptions) error {
+	if !controlInstalled {
+		return fmt.Errorf("trace control not enabled, requires env %s", XGO_TRACE_CONTROL)
+	}
+	state := &controlState{}
+	if opts != nil {
+		if opts.Limit < 0 {
+			return fmt.Errorf("invalid limit: %d", opts.Limit)
+		}
+		state.opts = *opts
+		state.patterns = parseMatchPatterns(opts.Match)
+	}
+	controlMutex.Lock()
+	currentControl = state
+	atomic.StoreInt32(&controlActive, 1)
+	controlMutex.Unlock()
+	return nil
+}
+
+// StopControl stops the active session, traces in
+// progress are still emitted when they finish.
+func StopControl() {
+	controlMutex.Lock()
+	defer controlMutex.Unlock()
+	stopControlLocked(currentControl)
+}
+
+func GetControlStatus() *ControlStatus {
+	controlMutex.Lock()
+	defer controlMutex.Unlock()
+	status := &ControlStatus{
+		Active: atomic.LoadInt32(&controlActive) == 1,
+	}
+	if currentControl != nil {
+		status.Output = currentControl.opts.Output
+		status.Limit = currentControl.opts.Limit
+		status.Match = currentControl.opts.Match
+		status.Started = atomic.LoadInt64(&currentControl.started)
+		status.Emitted = atomic.LoadInt64(&currentControl.emitted)
+	}
+	return status
+}
+
+func toggleControl() {
+	if atomic.LoadInt32(&controlActive) == 1 {
+		StopControl()
+		fmt.Fprintf(os.Stderr, "xgo trace: stopped\n")
+		return
+	}
+	err := StartControl(nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "xgo trace: %v\n", err)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "xgo trace: started\n")
+}
+
+// must be called with controlMutex held
+func stopControlLocked(state *controlState) {
+	if state == nil || state != currentControl {
+		return
+	}
+	atomic.StoreInt32(&controlActive, 0)
+}
+
+func isControlActive() bool {
+	return atomic.LoadInt32(&controlActive) == 1
+}
+
+// acquireControl checks whether f should start a new
+// trace, returning the session the trace belongs to
+func acquireControl(f *core.FuncInfo) *controlState {
+	if !isControlActive() {
+		return nil
+	}
+	controlMutex.Lock()
+	state := currentControl
+	controlMutex.Unlock()
+	if state == nil {
+		return nil
+	}
+	if len(state.patterns) > 0 && !matchFunc(state.patterns, f) {
+		return nil
+	}
+	started := atomic.AddInt64(&state.started, 1)
+	if state.opts.Limit > 0 && started > int64(state.opts.Limit) {
+		return nil
+	}
+	return state
+}
+
+func (c *controlState) onEmitted() {
+	emitted := atomic.AddInt64(&c.emitted, 1)
+	if c.opts.Limit > 0 && emitted >= int64(c.opts.Limit) {
+		controlMutex.Lock()
+		stopControlLocked(c)
+		controlMutex.Unlock()
+	}
+}
+
+func parseMatchPatterns(match string) []string {
+	var patterns []string
+	for _, p := range strings.Split(match, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+func matchFunc(patterns []string, f *core.FuncInfo) bool {
+	if f == nil {
+		return false
+	}
+	for _, p := range patterns {
+		if matchFuncName(p, f.Pkg, f.IdentityName, f.Name, f.RecvType) {
+			return true
+		}
+	}
+	return false
+}
+
+// endpoints, start and stop require POST:
+//
+//	/trace/start?output=<dir>&limit=<n>&match=<pattern>
+//	/trace/stop
+//	/trace/status
+func serveControlHTTP(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := newControlMux()
+	fmt.Fprintf(os.Stderr, "xgo trace control: http://%s/trace/status\n", l.Addr().String())
+	go trap.Direct(func() {
+		err := http.Serve(l, mux)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "xgo trace control: %v\n", err)
+		}
+	})
+	return nil
+}
+
+func newControlMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/trace/start", controlHandler(http.MethodPost, func(w http.ResponseWriter, r *http.Request) error {
+		output, err := resolveControlOutput(r.FormValue("output"))
+		if err != nil {
+			return err
+		}
+		opts := &ControlOptions{
+			Output: output,
+			Match:  r.FormValue("match"),
+		}
+		if limit := r.FormValue("limit"); limit != "" {
+			n, err := strconv.Atoi(limit)
+			if err != nil {
+				return fmt.Errorf("invalid limit: %s", limit)
+			}
+			opts.Limit = n
+		}
+		return StartControl(opts)
+	}))
+	mux.HandleFunc("/trace/stop", controlHandler(http.MethodPost, func(w http.ResponseWriter, r *http.Request) error {
+		StopControl()
+		return nil
+	}))
+	mux.HandleFunc("/trace/status", controlHandler("", func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	}))
+	return mux
+}
+
+// resolveControlOutput restricts output requested over
+// http to the trace directory, so that a request cannot
+// make the program write files elsewhere
+func resolveControlOutput(output string) (string, error) {
+	if output == "" || isSpecialTraceOutput(output) {
+		return output, nil
+	}
+	baseDir := getTraceOutput()
+	if baseDir == "" || isSpecialTraceOutput(baseDir) {
+		baseDir = flags.STRACE_DIR
+	}
+	if baseDir == "" {
+		baseDir = "."
+	}
+	baseDir, err := filepath.Abs(baseDir)
+	if err != nil {
+		return "", err
+	}
+	dir := output
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(baseDir, dir)
+	}
+	dir = filepath.Clean(dir)
+	rel, err := filepath.Rel(baseDir, dir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("output must be inside %s: %s", baseDir, output)
+	}
+	return dir, nil
+}
+
+// outputs other than a directory
+func isSpecialTraceOutput(output string) bool {
+	switch output {
+	case "off", "stdout", "text", "markdown":
+		return true
+	}
+	return false
+}
+
+// controlHandler responds with the latest status,
+// the handler itself is never traced.
+// If method is not empty, other methods are rejected,
+// as well as requests from pages of other origins.
+func controlHandler(method string, handle func(w http.ResponseWriter, r *http.Request) error) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		trap.Direct(func() {
+			w.Header().Set("Content-Type", "application/json")
+			if method != "" {
+				if r.Method != method {
+					w.Header().Set("Allow", method)
+					writeControlError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s requires %s", r.URL.Path, method))
+					return
+				}
+				if !isSameOrigin(r) {
+					writeControlError(w, http.StatusForbidden, fmt.Errorf("cross origin request rejected"))
+					return
+				}
+			}
+			err := handle(w, r)
+			if err != nil {
+				writeControlError(w, http.StatusBadRequest, err)
+				return
+			}
+			data, err := json.Marshal(GetControlStatus())
+			if err != nil {
+				writeControlError(w, http.StatusInternalServerError, err)
+				return
+			}
+			w.Write(data)
+		})
+	}
+}
+
+// browsers send Origin with cross origin POST
+func isSameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+func writeControlError(w http.ResponseWriter, code int, err error) {
+	data, _ := json.Marshal(map[string]string{"error": err.Error()})
+	w.WriteHeader(code)
+	w.Write(data)
+}
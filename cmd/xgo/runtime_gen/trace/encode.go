@@ -0,0 +1,689 @@
+package trace
+
+import (
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	DefaultMarshalMaxDepth     = 10
+	DefaultMarshalMaxElements  = 100
+	DefaultMarshalMaxStringLen = 4096
+)
+
+// MarshalOptions configures the marshaling engine for args
+// and results, selected by ExportOptions.Marshal. Unlike
+// MarshalAnyJSON, it never fails: values too deep, too large,
+// cyclic or panicking while marshaled are replaced by markers
+// like "[max depth]" or {"$ref":"$.a.b"}.
+//
+// Zero limits mean defaults, negative limits mean unlimited.
+type MarshalOptions struct {
+	// max nesting of structs, maps, slices and arrays
+	MaxDepth int
+	// max elements of a map, slice or array
+	MaxElements int
+	// max bytes of a string or []byte
+	MaxStringLen int
+
+	// SkipMarshalers encodes the underlying value instead of
+	// calling MarshalJSON or MarshalText, for types whose
+	// methods are expensive or have side effects
+	SkipMarshalers bool
+
+	// Formatters take precedence over those added by
+	// RegisterFormatter, keys can be interface types
+	Formatters map[reflect.Type]Formatter
+}
+
+// Formatter returns a replacement of v to be marshaled
+type Formatter func(v interface{}) interface{}
+
+type formatterRegistry struct {
+	byType map[reflect.Type]Formatter
+	// interface types in registration order
+	ifaces []reflect.Type
+}
+
+var (
+	formatterMutex sync.Mutex
+	// *formatterRegistry, replaced on each registration
+	formatters atomic.Value
+)
+
+func init() {
+	formatters.Store(&formatterRegistry{})
+	RegisterFormatter(time.Time{}, func(v interface{}) interface{} {
+		return v.(time.Time).Format(time.RFC3339Nano)
+	})
+	RegisterFormatter(time.Duration(0), func(v interface{}) interface{} {
+		return v.(time.Duration).String()
+	})
+	RegisterFormatter((*error)(nil), func(v interface{}) interface{} {
+		return v.(error).Error()
+	})
+}
+
+// RegisterFormatter makes the marshaling engine encode values of
+// the type of example with f. To match all implementations of an
+// interface, pass a nil pointer to it, e.g.
+//
+//	trace.RegisterFormatter(decimal.Decimal{}, func(v interface{}) interface{} {
+//		return v.(decimal.Decimal).String()
+//	})
+//	trace.RegisterFormatter((*proto.Message)(nil), func(v interface{}) interface{} {
+//		data, _ := protojson.Marshal(v.(proto.Message))
+//		return json.RawMessage(data)
+//	})
+//
+// Time, duration and error values are formatted as strings by default.
+func RegisterFormatter(example interface{}, f Formatter) {
+	t := reflect.TypeOf(example)
+	if t == nil {
+		panic(fmt.Errorf("RegisterFormatter: example must not be nil"))
+	}
+	if t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Interface {
+		t = t.Elem()
+	}
+	formatterMutex.Lock()
+	defer formatterMutex.Unlock()
+	old := formatters.Load().(*formatterRegistry)
+	r := &formatterRegistry{
+		byType: make(map[reflect.Type]Formatter, len(old.byType)+1),
+		ifaces: old.ifaces,
+	}
+	for k, v := range old.byType {
+		r.byType[k] = v
+	}
+	if _, ok := r.byType[t]; !ok && t.Kind() == reflect.Interface {
+		r.ifaces = append(r.ifaces[:len(r.ifaces):len(r.ifaces)], t)
+	}
+	r.byType[t] = f
+	formatters.Store(r)
+}
+
+func lookupFormatter(m map[reflect.Type]Formatter, ifaces []reflect.Type, t reflect.Type) Formatter {
+	if f, ok := m[t]; ok {
+		return f
+	}
+	for _, it := range ifaces {
+		if t.Implements(it) {
+			return m[it]
+		}
+	}
+	return nil
+}
+
+// Marshal encodes v as JSON, nil options use defaults
+func (c *MarshalOptions) Marshal(v interface{}) ([]byte, error) {
+	e := newEncoder(c)
+	return e.marshal(v), nil
+}
+
+// marshalValue marshals args or results with the engine
+type marshalValue struct {
+	data interface{}
+	opts *MarshalOptions
+}
+
+func (c *marshalValue) MarshalJSON() ([]byte, error) {
+	return c.opts.Marshal(c.data)
+}
+
+type encoder struct {
+	maxDepth     int
+	maxElements  int
+	maxStringLen int
+	marshalers   bool
+
+	formatters *formatterRegistry
+	// interface keys of opts formatters
+	optFormatters map[reflect.Type]Formatter
+	optIfaces     []reflect.Type
+
+	redactor *redactor
+
+	buf  []byte
+	path []string
+	// pointers, maps and slices being encoded -> their path
+	visiting map[visitKey]string
+}
+
+type visitKey struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+func limitOrDefault(limit int, def int) int {
+	if limit == 0 {
+		return def
+	}
+	if limit < 0 {
+		return math.MaxInt32
+	}
+	return limit
+}
+
+func newEncoder(opts *MarshalOptions) *encoder {
+	if opts == nil {
+		opts = &MarshalOptions{}
+	}
+	e := &encoder{
+		maxDepth:      limitOrDefault(opts.MaxDepth, DefaultMarshalMaxDepth),
+		maxElements:   limitOrDefault(opts.MaxElements, DefaultMarshalMaxElements),
+		maxStringLen:  limitOrDefault(opts.MaxStringLen, DefaultMarshalMaxStringLen),
+		marshalers:    !opts.SkipMarshalers,
+		formatters:    formatters.Load().(*formatterRegistry),
+		optFormatters: opts.Formatters,
+		redactor:      getRedactor(),
+		visiting:      make(map[visitKey]string),
+	}
+	for t := range opts.Formatters {
+		if t.Kind() == reflect.Interface {
+			e.optIfaces = append(e.optIfaces, t)
+		}
+	}
+	// stable precedence among interfaces
+	sort.Slice(e.optIfaces, func(i, j int) bool {
+		return e.optIfaces[i].String() < e.optIfaces[j].String()
+	})
+	return e
+}
+
+func (c *encoder) marshal(v interface{}) (data []byte) {
+	defer func() {
+		if e := recover(); e != nil {
+			data = appendJSONString(nil, fmt.Sprintf("[panic: %v]", e))
+		}
+	}()
+	c.path = append(c.path[:0], "$")
+	c.value(reflect.ValueOf(v), 0)
+	return c.buf
+}
+
+func (c *encoder) formatter(t reflect.Type) Formatter {
+	if f := lookupFormatter(c.optFormatters, c.optIfaces, t); f != nil {
+		return f
+	}
+	return lookupFormatter(c.formatters.byType, c.formatters.ifaces, t)
+}
+
+func (c *encoder) marker(format string, args ...interface{}) {
+	c.buf = appendJSONString(c.buf, "["+fmt.Sprintf(format, args...)+"]")
+}
+
+func (c *encoder) pathString() string {
+	return strings.Join(c.path, "")
+}
+
+// enter records v as being encoded, returns false
+// with a back reference written if it already is
+func (c *encoder) enter(key visitKey) bool {
+	if ref, ok := c.visiting[key]; ok {
+		c.buf = append(c.buf, `{"$ref":`...)
+		c.buf = appendJSONString(c.buf, ref)
+		c.buf = append(c.buf, '}')
+		return false
+	}
+	c.visiting[key] = c.pathString()
+	return true
+}
+
+func (c *encoder) value(v reflect.Value, depth int) {
+	c.valueFormatted(v, depth, true)
+}
+
+func (c *encoder) valueFormatted(v reflect.Value, depth int, format bool) {
+	for v.IsValid() && v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			break
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() || (v.Kind() == reflect.Interface && v.IsNil()) {
+		c.buf = append(c.buf, "null"...)
+		return
+	}
+	t := v.Type()
+	if t.Kind() == reflect.Ptr && v.IsNil() {
+		c.buf = append(c.buf, "null"...)
+		return
+	}
+	if c.redactor.matchType(t) {
+		c.buf = appendJSONString(c.buf, c.redactor.placeholder)
+		return
+	}
+	if v.CanInterface() {
+		if obj, ok := v.Interface().(*redactedObject); ok {
+			c.object(obj, depth)
+			return
+		}
+		if format {
+			if f := c.formatter(t); f != nil {
+				c.formatted(f, v, depth)
+				return
+			}
+		}
+		if c.marshalers && c.marshaler(v) {
+			return
+		}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		c.buf = strconv.AppendBool(c.buf, v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		c.buf = strconv.AppendInt(c.buf, v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		c.buf = strconv.AppendUint(c.buf, v.Uint(), 10)
+	case reflect.Float32:
+		c.float(v.Float(), 32)
+	case reflect.Float64:
+		c.float(v.Float(), 64)
+	case reflect.Complex64, reflect.Complex128:
+		c.buf = appendJSONString(c.buf, fmt.Sprint(v.Complex()))
+	case reflect.String:
+		c.string(v.String())
+	case reflect.Chan:
+		c.marker("%s", t.String())
+	case reflect.Func:
+		c.marker("func")
+	case reflect.UnsafePointer:
+		c.marker("unsafe.Pointer 0x%x", v.Pointer())
+	case reflect.Ptr:
+		key := visitKey{ptr: v.Pointer(), typ: t}
+		if !c.enter(key) {
+			return
+		}
+		c.value(v.Elem(), depth)
+		delete(c.visiting, key)
+	case reflect.Map:
+		c.mapValue(v, depth)
+	case reflect.Slice:
+		if v.IsNil() {
+			c.buf = append(c.buf, "null"...)
+			return
+		}
+		key := visitKey{ptr: v.Pointer(), typ: t, len: v.Len()}
+		if !c.enter(key) {
+			return
+		}
+		if t.Elem().Kind() == reflect.Uint8 && !t.Elem().Implements(jsonMarshalerType) {
+			c.bytes(v)
+		} else {
+			c.list(v, depth)
+		}
+		delete(c.visiting, key)
+	case reflect.Array:
+		c.list(v, depth)
+	case reflect.Struct:
+		c.structValue(v, depth)
+	default:
+		c.marker("unsupported %s", t.String())
+	}
+}
+
+func (c *encoder) formatted(f Formatter, v reflect.Value, depth int) {
+	var res interface{}
+	var panicVal interface{}
+	func() {
+		defer func() {
+			panicVal = recover()
+		}()
+		res = f(v.Interface())
+	}()
+	if panicVal != nil {
+		c.marker("panic: %v", panicVal)
+		return
+	}
+	if raw, ok := res.(json.RawMessage); ok {
+		c.raw(raw, "formatter")
+		return
+	}
+	rv := reflect.ValueOf(res)
+	// a formatter returning the same type would recurse forever
+	c.valueFormatted(rv, depth, !rv.IsValid() || rv.Type() != v.Type())
+}
+
+// marshaler encodes v with its MarshalJSON or MarshalText,
+// returns false if v has neither
+func (c *encoder) marshaler(v reflect.Value) bool {
+	t := v.Type()
+	// methods with pointer receiver are used
+	// only if addressable, as encoding/json does
+	addressable := t.Kind() != reflect.Ptr && v.CanAddr()
+	var isJSON bool
+	switch {
+	case t.Implements(jsonMarshalerType):
+		isJSON = true
+	case addressable && reflect.PtrTo(t).Implements(jsonMarshalerType):
+		v = v.Addr()
+		isJSON = true
+	case t.Implements(textMarshalerType):
+	case addressable && reflect.PtrTo(t).Implements(textMarshalerType):
+		v = v.Addr()
+	default:
+		return false
+	}
+	var data []byte
+	var err error
+	var panicVal interface{}
+	func() {
+		defer func() {
+			panicVal = recover()
+		}()
+		if isJSON {
+			data, err = v.Interface().(json.Marshaler).MarshalJSON()
+		} else {
+			data, err = v.Interface().(encoding.TextMarshaler).MarshalText()
+		}
+	}()
+	switch {
+	case panicVal != nil:
+		c.marker("panic: %v", panicVal)
+	case err != nil:
+		c.marker("error: %v", err)
+	case isJSON:
+		c.raw(data, "MarshalJSON")
+	default:
+		c.string(string(data))
+	}
+	return true
+}
+
+func (c *encoder) raw(data []byte, source string) {
+	if !json.Valid(data) {
+		c.marker("invalid JSON from %s", source)
+		return
+	}
+	c.buf = append(c.buf, data...)
+}
+
+// object encodes args or results as a JSON object
+func (c *encoder) object(obj *redactedObject, depth int) {
+	if isNilObject(obj.Object) {
+		c.buf = append(c.buf, "null"...)
+		return
+	}
+	redactAll := c.redactor.matchFunc(obj.funcInfo)
+	n := obj.NumField()
+	c.buf = append(c.buf, '{')
+	for i := 0; i < n; i++ {
+		field := obj.GetFieldIndex(i)
+		name := field.Name()
+		if name == "" {
+			name = "field_" + strconv.FormatInt(int64(i), 10)
+		}
+		if i > 0 {
+			c.buf = append(c.buf, ',')
+		}
+		c.buf = appendJSONString(c.buf, name)
+		c.buf = append(c.buf, ':')
+		if redactAll || c.redactor.matchField(field.Name()) {
+			c.buf = appendJSONString(c.buf, c.redactor.placeholder)
+			continue
+		}
+		c.path = append(c.path, "."+name)
+		// pointer keeps methods with pointer receiver
+		c.value(reflect.ValueOf(field.Ptr()), depth)
+		c.path = c.path[:len(c.path)-1]
+	}
+	c.buf = append(c.buf, '}')
+}
+
+func (c *encoder) float(f float64, bits int) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		c.buf = appendJSONString(c.buf, strconv.FormatFloat(f, 'g', -1, bits))
+		return
+	}
+	// same format as encoding/json
+	abs := math.Abs(f)
+	format := byte('f')
+	if abs != 0 {
+		if bits == 64 && (abs < 1e-6 || abs >= 1e21) || bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			format = 'e'
+		}
+	}
+	c.buf = strconv.AppendFloat(c.buf, f, format, -1, bits)
+}
+
+func (c *encoder) string(s string) {
+	if len(s) <= c.maxStringLen {
+		c.buf = appendJSONString(c.buf, s)
+		return
+	}
+	c.buf = appendJSONString(c.buf, truncateUTF8(s, c.maxStringLen)+fmt.Sprintf("...(%d more bytes)", len(s)-len(truncateUTF8(s, c.maxStringLen))))
+}
+
+func (c *encoder) bytes(v reflect.Value) {
+	b := v.Bytes()
+	if len(b) <= c.maxStringLen {
+		c.buf = appendJSONString(c.buf, base64.StdEncoding.EncodeToString(b))
+		return
+	}
+	c.buf = appendJSONString(c.buf, base64.StdEncoding.EncodeToString(b[:c.maxStringLen])+fmt.Sprintf("...(%d more bytes)", len(b)-c.maxStringLen))
+}
+
+func (c *encoder) list(v reflect.Value, depth int) {
+	if depth >= c.maxDepth {
+		c.marker("max depth")
+		return
+	}
+	n := v.Len()
+	limit := n
+	if limit > c.maxElements {
+		limit = c.maxElements
+	}
+	c.buf = append(c.buf, '[')
+	for i := 0; i < limit; i++ {
+		if i > 0 {
+			c.buf = append(c.buf, ',')
+		}
+		c.path = append(c.path, "["+strconv.Itoa(i)+"]")
+		c.value(v.Index(i), depth+1)
+		c.path = c.path[:len(c.path)-1]
+	}
+	if limit < n {
+		if limit > 0 {
+			c.buf = append(c.buf, ',')
+		}
+		c.marker("%d more", n-limit)
+	}
+	c.buf = append(c.buf, ']')
+}
+
+type mapEntry struct {
+	key   string
+	value reflect.Value
+}
+
+func (c *encoder) mapValue(v reflect.Value, depth int) {
+	if v.IsNil() {
+		c.buf = append(c.buf, "null"...)
+		return
+	}
+	if depth >= c.maxDepth {
+		c.marker("max depth")
+		return
+	}
+	key := visitKey{ptr: v.Pointer(), typ: v.Type()}
+	if !c.enter(key) {
+		return
+	}
+	defer delete(c.visiting, key)
+
+	n := v.Len()
+	entries := make([]mapEntry, 0, n)
+	iter := v.MapRange()
+	// huge maps are cut before sorting, so which
+	// entries are kept is not deterministic
+	for len(entries) < c.maxElements && iter.Next() {
+		k := iter.Key()
+		name, ok := jsonMapKey(exposeField(k))
+		if !ok {
+			name = fmt.Sprint(k)
+		}
+		entries = append(entries, mapEntry{key: name, value: iter.Value()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+	redactKeys := v.Type().Key().Kind() == reflect.String
+	c.buf = append(c.buf, '{')
+	for i, entry := range entries {
+		if i > 0 {
+			c.buf = append(c.buf, ',')
+		}
+		c.buf = appendJSONString(c.buf, entry.key)
+		c.buf = append(c.buf, ':')
+		if redactKeys && c.redactor.matchField(entry.key) {
+			c.buf = appendJSONString(c.buf, c.redactor.placeholder)
+			continue
+		}
+		c.path = append(c.path, "["+strconv.Quote(entry.key)+"]")
+		c.value(entry.value, depth+1)
+		c.path = c.path[:len(c.path)-1]
+	}
+	if len(entries) < n {
+		if len(entries) > 0 {
+			c.buf = append(c.buf, ',')
+		}
+		c.buf = appendJSONString(c.buf, "[more]")
+		c.buf = append(c.buf, ':')
+		c.buf = strconv.AppendInt(c.buf, int64(n-len(entries)), 10)
+	}
+	c.buf = append(c.buf, '}')
+}
+
+func (c *encoder) structValue(v reflect.Value, depth int) {
+	if depth >= c.maxDepth {
+		c.marker("max depth")
+		return
+	}
+	if !v.CanAddr() {
+		// addressable, so fields promoted from
+		// unexported embedded structs can be read
+		nv := reflect.New(v.Type()).Elem()
+		nv.Set(v)
+		v = nv
+	}
+	c.buf = append(c.buf, '{')
+	c.fields(v, depth, make(map[string]bool), true)
+	c.buf = append(c.buf, '}')
+}
+
+// fields writes fields of v, fields of embedded structs
+// without json name are promoted, like in redactor.collectFields
+func (c *encoder) fields(v reflect.Value, depth int, seen map[string]bool, first bool) bool {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		fv := exposeField(v.Field(i))
+		name, opts := parseJSONTag(tag)
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct && !marshalsItself(field.Type) && c.formatter(field.Type) == nil {
+				if fv.Kind() == reflect.Ptr {
+					if fv.IsNil() {
+						continue
+					}
+					fv = fv.Elem()
+				}
+				first = c.fields(fv, depth, seen, first)
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			// unexported
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if strings.Contains(opts, "omitempty") && isEmptyValue(fv) {
+			continue
+		}
+		if !first {
+			c.buf = append(c.buf, ',')
+		}
+		first = false
+		c.buf = appendJSONString(c.buf, name)
+		c.buf = append(c.buf, ':')
+		if isRedactTag(field.Tag) || c.redactor.matchField(field.Name) || c.redactor.matchField(name) {
+			c.buf = appendJSONString(c.buf, c.redactor.placeholder)
+			continue
+		}
+		c.path = append(c.path, "."+name)
+		c.value(fv, depth+1)
+		c.path = c.path[:len(c.path)-1]
+	}
+	return first
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString quotes s as a JSON string,
+// invalid UTF-8 is replaced by U+FFFD
+func appendJSONString(buf []byte, s string) []byte {
+	buf = append(buf, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		b := s[i]
+		if b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' {
+				i++
+				continue
+			}
+			buf = append(buf, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				buf = append(buf, '\\', b)
+			case '\n':
+				buf = append(buf, '\\', 'n')
+			case '\r':
+				buf = append(buf, '\\', 'r')
+			case '\t':
+				buf = append(buf, '\\', 't')
+			default:
+				buf = append(buf, '\\', 'u', '0', '0', hexDigits[b>>4], hexDigits[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	buf = append(buf, s[start:]...)
+	buf = append(buf, '"')
+	return buf
+}
@@ -0,0 +1,47 @@
+package trace
+
+import "strings"
+
+// matchFuncName matches a function against pattern in
+// any of the forms below, `*` matches any characters:
+//
+//	github.com/org/svc/api.(*Handler).ServeHTTP
+//	api.(*Handler).ServeHTTP
+//	(*Handler).ServeHTTP
+//	ServeHTTP, only for methods
+func matchFuncName(pattern string, pkg string, identityName string, name string, recvType string) bool {
+	if recvType != "" && matchWildcard(pattern, name) {
+		return true
+	}
+	if matchWildcard(pattern, identityName) || matchWildcard(pattern, pkg+"."+identityName) {
+		return true
+	}
+	pkgName := pkg
+	if idx := strings.LastIndex(pkgName, "/"); idx >= 0 {
+		pkgName = pkgName[idx+1:]
+		return matchWildcard(pattern, pkgName+"."+identityName)
+	}
+	return false
+}
+
+// matchWildcard matches s against pattern,
+// where `*` matches any sequence of characters
+func matchWildcard(pattern string, s string) bool {
+	if !strings.Contains(pattern, "*") {
+		return pattern == s
+	}
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	last := len(parts) - 1
+	for i := 1; i < last; i++ {
+		idx := strings.Index(s, parts[i])
+		if idx < 0 {
+			return false
+		}
+		s = s[idx+len(parts[i]):]
+	}
+	return strings.HasSuffix(s, parts[last])
+}
@@ -0,0 +1,418 @@
+package trace
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"sort"
+	"time"
+)
+
+// ProfileNode is a node of the call tree aggregated
+// from traces, calls to the same function under the
+// same call path are merged into one node.
+// All durations are in nanoseconds.
+type ProfileNode struct {
+	Name string // pkg.IdentityName, empty for root
+	Pkg  string
+	File string
+	Line int
+
+	Calls  int64
+	Errors int64 // calls returned error or panicked
+
+	// wall time including children
+	Inclusive int64
+	// wall time excluding children
+	Exclusive int64
+
+	Children []*ProfileNode
+}
+
+// AggregateProfile merges call trees of all roots into
+// one tree, the returned node represents the root and
+// has no name.
+func AggregateProfile(roots ...*RootExport) *ProfileNode {
+	top := &ProfileNode{}
+	for _, root := range roots {
+		if root == nil {
+			continue
+		}
+		for _, stack := range root.Children {
+			top.add(stack)
+		}
+	}
+	for _, child := range top.Children {
+		top.Inclusive += child.Inclusive
+	}
+	return top
+}
+
+func (c *ProfileNode) add(stack *StackExport) {
+	if stack == nil {
+		return
+	}
+	name := "<unknown>"
+	var pkg, file string
+	var line int
+	if stack.FuncInfo != nil {
+		pkg = stack.FuncInfo.Pkg
+		file = stack.FuncInfo.File
+		line = stack.FuncInfo.Line
+		name = stack.FuncInfo.IdentityName
+		if pkg != "" {
+			name = pkg + "." + name
+		}
+	}
+	var node *ProfileNode
+	for _, child := range c.Children {
+		if child.Name == name {
+			node = child
+			break
+		}
+	}
+	if node == nil {
+		node = &ProfileNode{
+			Name: name,
+			Pkg:  pkg,
+			File: file,
+			Line: line,
+		}
+		c.Children = append(c.Children, node)
+	}
+	cost := stack.End - stack.Begin
+	if cost < 0 {
+		cost = 0
+	}
+	var childCost int64
+	for _, child := range stack.Children {
+		if child == nil {
+			continue
+		}
+		if d := child.End - child.Begin; d > 0 {
+			childCost += d
+		}
+		node.add(child)
+	}
+	self := cost - childCost
+	if self < 0 {
+		self = 0
+	}
+	node.Calls++
+	if stack.Error != "" || stack.Panic {
+		node.Errors++
+	}
+	node.Inclusive += cost
+	node.Exclusive += self
+}
+
+// Walk visits all nodes in depth-first order, path
+// contains names from the outermost call to the node.
+func (c *ProfileNode) Walk(f func(path []string, node *ProfileNode)) {
+	var walk func(path []string, node *ProfileNode)
+	walk = func(path []string, node *ProfileNode) {
+		for _, child := range node.Children {
+			childPath := append(path[:len(path):len(path)], child.Name)
+			f(childPath, child)
+			walk(childPath, child)
+		}
+	}
+	walk(nil, c)
+}
+
+// ProfileFunc summarizes a function across all call paths
+type ProfileFunc struct {
+	Name      string
+	Pkg       string
+	File      string
+	Line      int
+	Calls     int64
+	Errors    int64
+	Inclusive int64
+	Exclusive int64
+}
+
+// Funcs returns per-function summaries sorted by exclusive
+// time descending. For recursive calls, inclusive time
+// is only counted on the outermost call.
+func (c *ProfileNode) Funcs() []*ProfileFunc {
+	mapping := make(map[string]*ProfileFunc)
+	var list []*ProfileFunc
+	c.Walk(func(path []string, node *ProfileNode) {
+		fn := mapping[node.Name]
+		if fn == nil {
+			fn = &ProfileFunc{
+				Name: node.Name,
+				Pkg:  node.Pkg,
+				File: node.File,
+				Line: node.Line,
+			}
+			mapping[node.Name] = fn
+			list = append(list, fn)
+		}
+		fn.Calls += node.Calls
+		fn.Errors += node.Errors
+		fn.Exclusive += node.Exclusive
+		recursive := false
+		for _, name := range path[:len(path)-1] {
+			if name == node.Name {
+				recursive = true
+				break
+			}
+		}
+		if !recursive {
+			fn.Inclusive += node.Inclusive
+		}
+	})
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Exclusive > list[j].Exclusive
+	})
+	return list
+}
+
+// MarshalProfile encodes aggregated traces into the
+// gzipped protobuf format understood by `go tool pprof`.
+// Each call path becomes a sample with two values:
+// the number of calls and the exclusive wall time, so
+// pprof's flat and cum show exclusive and inclusive time.
+func MarshalProfile(roots ...*RootExport) ([]byte, error) {
+	var buf bytes.Buffer
+	err := WriteProfile(&buf, roots...)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func WriteProfile(w io.Writer, roots ...*RootExport) error {
+	begin, duration := profileTimeRange(roots)
+	data := encodeProfile(AggregateProfile(roots...), begin, duration)
+	gz := gzip.NewWriter(w)
+	_, err := gz.Write(data)
+	if err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func profileTimeRange(roots []*RootExport) (begin time.Time, duration int64) {
+	var end time.Time
+	for _, root := range roots {
+		if root == nil || root.Begin.IsZero() {
+			continue
+		}
+		if begin.IsZero() || root.Begin.Before(begin) {
+			begin = root.Begin
+		}
+		for _, stack := range root.Children {
+			if stack == nil {
+				continue
+			}
+			e := root.Begin.Add(time.Duration(stack.End))
+			if e.After(end) {
+				end = e
+			}
+		}
+	}
+	if !begin.IsZero() && end.After(begin) {
+		duration = int64(end.Sub(begin))
+	}
+	return begin, duration
+}
+
+// see https://github.com/google/pprof/blob/main/proto/profile.proto
+const (
+	profileSampleType    = 1
+	profileSample        = 2
+	profileLocation      = 4
+	profileFunction      = 5
+	profileStringTable   = 6
+	profileTimeNanos     = 9
+	profileDurationNanos = 10
+	profilePeriodType    = 11
+	profilePeriod        = 12
+	profileDefaultSample = 14
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	locationID   = 1
+	locationLine = 4
+
+	lineFunctionID = 1
+	lineLine       = 2
+
+	functionID         = 1
+	functionName       = 2
+	functionSystemName = 3
+	functionFilename   = 4
+	functionStartLine  = 5
+)
+
+type profileEncoder struct {
+	strings   []string
+	stringIdx map[string]int64
+	funcIDs   map[string]uint64
+	functions protoBuffer
+	locations protoBuffer
+	samples   protoBuffer
+}
+
+func encodeProfile(top *ProfileNode, begin time.Time, duration int64) []byte {
+	e := &profileEncoder{
+		stringIdx: make(map[string]int64),
+		funcIDs:   make(map[string]uint64),
+	}
+	e.str("")
+
+	var stack []uint64
+	var walk func(node *ProfileNode)
+	walk = func(node *ProfileNode) {
+		for _, child := range node.Children {
+			stack = append(stack, e.location(child))
+			e.sample(stack, child.Calls, child.Exclusive)
+			walk(child)
+			stack = stack[:len(stack)-1]
+		}
+	}
+	walk(top)
+
+	var p protoBuffer
+	p.message(profileSampleType, e.valueType("calls", "count"))
+	p.message(profileSampleType, e.valueType("wall", "nanoseconds"))
+	p.raw(e.samples.data)
+	p.raw(e.locations.data)
+	p.raw(e.functions.data)
+	if !begin.IsZero() {
+		p.int64(profileTimeNanos, begin.UnixNano())
+	}
+	p.int64(profileDurationNanos, duration)
+	p.message(profilePeriodType, e.valueType("wall", "nanoseconds"))
+	p.int64(profilePeriod, 1)
+	p.int64(profileDefaultSample, e.str("wall"))
+
+	// string table must be encoded after all strings collected
+	for _, s := range e.strings {
+		p.stringAlways(profileStringTable, s)
+	}
+	return p.data
+}
+
+func (c *profileEncoder) str(s string) int64 {
+	idx, ok := c.stringIdx[s]
+	if ok {
+		return idx
+	}
+	idx = int64(len(c.strings))
+	c.strings = append(c.strings, s)
+	c.stringIdx[s] = idx
+	return idx
+}
+
+func (c *profileEncoder) valueType(typ string, unit string) []byte {
+	var b protoBuffer
+	b.int64(valueTypeType, c.str(typ))
+	b.int64(valueTypeUnit, c.str(unit))
+	return b.data
+}
+
+// each function has exactly one location
+// with the same id
+func (c *profileEncoder) location(node *ProfileNode) uint64 {
+	id, ok := c.funcIDs[node.Name]
+	if ok {
+		return id
+	}
+	id = uint64(len(c.funcIDs) + 1)
+	c.funcIDs[node.Name] = id
+
+	var fn protoBuffer
+	fn.uint64(functionID, id)
+	fn.int64(functionName, c.str(node.Name))
+	fn.int64(functionSystemName, c.str(node.Name))
+	fn.int64(functionFilename, c.str(node.File))
+	fn.int64(functionStartLine, int64(node.Line))
+	c.functions.message(profileFunction, fn.data)
+
+	var line protoBuffer
+	line.uint64(lineFunctionID, id)
+	line.int64(lineLine, int64(node.Line))
+
+	var loc protoBuffer
+	loc.uint64(locationID, id)
+	loc.message(locationLine, line.data)
+	c.locations.message(profileLocation, loc.data)
+	return id
+}
+
+// stack is ordered from outermost to innermost,
+// pprof expects the reverse
+func (c *profileEncoder) sample(stack []uint64, calls int64, exclusive int64) {
+	ids := make([]uint64, len(stack))
+	for i, id := range stack {
+		ids[len(stack)-1-i] = id
+	}
+	var s protoBuffer
+	s.packedUint64(sampleLocationID, ids)
+	s.packedUint64(sampleValue, []uint64{uint64(calls), uint64(exclusive)})
+	c.samples.message(profileSample, s.data)
+}
+
+// protoBuffer is a minimal protobuf encoder
+type protoBuffer struct {
+	data []byte
+}
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+func (b *protoBuffer) key(tag int, wireType int) {
+	b.varint(uint64(tag)<<3 | uint64(wireType))
+}
+
+func (b *protoBuffer) uint64(tag int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.key(tag, 0)
+	b.varint(x)
+}
+
+func (b *protoBuffer) int64(tag int, x int64) {
+	b.uint64(tag, uint64(x))
+}
+
+func (b *protoBuffer) stringAlways(tag int, s string) {
+	b.key(tag, 2)
+	b.varint(uint64(len(s)))
+	b.data = append(b.data, s...)
+}
+
+func (b *protoBuffer) message(tag int, data []byte) {
+	b.key(tag, 2)
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+func (b *protoBuffer) packedUint64(tag int, list []uint64) {
+	if len(list) == 0 {
+		return
+	}
+	var p protoBuffer
+	for _, x := range list {
+		p.varint(x)
+	}
+	b.message(tag, p.data)
+}
+
+func (b *protoBuffer) raw(data []byte) {
+	b.data = append(b.data, data...)
+}
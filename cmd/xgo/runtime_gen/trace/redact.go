@@ -0,0 +1,562 @@
+package trace
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/xhd2015/xgo/runtime/core"
+)
+
+// DefaultRedactPlaceholder replaces redacted values
+const DefaultRedactPlaceholder = "[redacted]"
+
+// RedactOptions configures which values are replaced by a placeholder
+// before args and results are marshaled into traces.
+// Fields tagged with `xgo:"redact"` are always redacted.
+// Patterns support `*` as wildcard.
+type RedactOptions struct {
+	// Fields matches struct field names, json names, map keys,
+	// argument and result names, case insensitive.
+	// e.g. "password", "*token*"
+	Fields []string
+
+	// Types matches type names like "net/http.Header"
+	// or "example.com/pkg.Secret", pointers are matched
+	// by their element type.
+	Types []string
+
+	// Funcs matches functions as "pkg.Func" or "Func",
+	// all args and results of them are redacted.
+	Funcs []string
+
+	// Placeholder defaults to DefaultRedactPlaceholder
+	Placeholder string
+}
+
+// configured by XGO_TRACE_REDACT_FIELDS, XGO_TRACE_REDACT_TYPES
+// and XGO_TRACE_REDACT_FUNCS, comma separated
+var redactorValue atomic.Value // *redactor
+
+func init() {
+	SetRedactOptions(&RedactOptions{
+		Fields: parseMatchPatterns(os.Getenv("XGO_TRACE_REDACT_FIELDS")),
+		Types:  parseMatchPatterns(os.Getenv("XGO_TRACE_REDACT_TYPES")),
+		Funcs:  parseMatchPatterns(os.Getenv("XGO_TRACE_REDACT_FUNCS")),
+	})
+}
+
+// SetRedactOptions replaces the redaction config, nil
+// leaves only fields tagged with `xgo:"redact"` redacted
+func SetRedactOptions(opts *RedactOptions) {
+	if opts == nil {
+		opts = &RedactOptions{}
+	}
+	r := &redactor{
+		opts:        opts,
+		placeholder: opts.Placeholder,
+	}
+	if r.placeholder == "" {
+		r.placeholder = DefaultRedactPlaceholder
+	}
+	for _, p := range opts.Fields {
+		r.fields = append(r.fields, strings.ToLower(p))
+	}
+	redactorValue.Store(r)
+}
+
+// GetRedactOptions returns the current redaction config
+func GetRedactOptions() *RedactOptions {
+	return getRedactor().opts
+}
+
+// Redact returns v with sensitive values replaced by
+// the placeholder, the result is meant to be marshaled
+// as JSON. v itself is returned if nothing is redacted.
+func Redact(v interface{}) interface{} {
+	res, _ := getRedactor().redact(v)
+	return res
+}
+
+func getRedactor() *redactor {
+	return redactorValue.Load().(*redactor)
+}
+
+type redactor struct {
+	opts        *RedactOptions
+	placeholder string
+	// lower cased
+	fields []string
+
+	// reflect.Type -> bool, whether values of
+	// the type may contain redacted values
+	mayRedactCache sync.Map
+}
+
+// prevents stack overflow on cyclic values,
+// json reports such values as error anyway
+const maxRedactDepth = 100
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+func (c *redactor) redact(v interface{}) (interface{}, bool) {
+	if v == nil {
+		return nil, false
+	}
+	rv := reflect.ValueOf(v)
+	if !c.mayRedact(rv.Type()) {
+		return v, false
+	}
+	res, changed := c.value(rv, 0)
+	if !changed {
+		return v, false
+	}
+	return res, true
+}
+
+func (c *redactor) matchField(name string) bool {
+	if name == "" || len(c.fields) == 0 {
+		return false
+	}
+	name = strings.ToLower(name)
+	for _, p := range c.fields {
+		if matchWildcard(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *redactor) matchType(t reflect.Type) bool {
+	if len(c.opts.Types) == 0 {
+		return false
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Name() == "" {
+		return false
+	}
+	name := t.PkgPath() + "." + t.Name()
+	if t.PkgPath() == "" {
+		name = t.Name()
+	}
+	for _, p := range c.opts.Types {
+		if matchWildcard(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *redactor) matchFunc(f *core.FuncInfo) bool {
+	return len(c.opts.Funcs) > 0 && matchFunc(c.opts.Funcs, f)
+}
+
+func isRedactTag(tag reflect.StructTag) bool {
+	xgoTag, ok := tag.Lookup("xgo")
+	if !ok {
+		return false
+	}
+	for _, opt := range strings.Split(xgoTag, ",") {
+		if strings.TrimSpace(opt) == "redact" {
+			return true
+		}
+	}
+	return false
+}
+
+// marshalsItself tells whether json encodes t via
+// its own MarshalJSON or MarshalText, such values
+// are either redacted as a whole or kept as is
+func marshalsItself(t reflect.Type) bool {
+	if t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType) {
+		return true
+	}
+	if t.Kind() != reflect.Ptr {
+		pt := reflect.PtrTo(t)
+		return pt.Implements(jsonMarshalerType) || pt.Implements(textMarshalerType)
+	}
+	return false
+}
+
+// mayRedact reports whether any value of t could contain
+// a redacted value, so that the common case skips the copy
+func (c *redactor) mayRedact(t reflect.Type) bool {
+	if v, ok := c.mayRedactCache.Load(t); ok {
+		return v.(bool)
+	}
+	res := c.computeMayRedact(t, make(map[reflect.Type]bool))
+	c.mayRedactCache.Store(t, res)
+	return res
+}
+
+// typeMayRedact is mayRedact while visiting t's parents, results
+// are not cached as they assume visiting types add nothing
+func (c *redactor) typeMayRedact(t reflect.Type, visiting map[reflect.Type]bool) bool {
+	if v, ok := c.mayRedactCache.Load(t); ok {
+		return v.(bool)
+	}
+	if visiting[t] {
+		return false
+	}
+	return c.computeMayRedact(t, visiting)
+}
+
+func (c *redactor) computeMayRedact(t reflect.Type, visiting map[reflect.Type]bool) bool {
+	visiting[t] = true
+	if c.matchType(t) {
+		return true
+	}
+	if marshalsItself(t) {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Interface:
+		// decided by the dynamic type
+		return true
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return c.typeMayRedact(t.Elem(), visiting)
+	case reflect.Map:
+		if len(c.fields) > 0 && t.Key().Kind() == reflect.String {
+			return true
+		}
+		return c.typeMayRedact(t.Elem(), visiting)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" && !field.Anonymous {
+				// unexported, not marshaled
+				continue
+			}
+			if isRedactTag(field.Tag) || c.matchField(field.Name) || c.matchField(jsonFieldName(field)) {
+				return true
+			}
+			if c.typeMayRedact(field.Type, visiting) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// value returns a copy of v with redacted values replaced,
+// changed is false if nothing was redacted
+func (c *redactor) value(v reflect.Value, depth int) (res interface{}, changed bool) {
+	if !v.IsValid() {
+		return nil, false
+	}
+	t := v.Type()
+	if c.matchType(t) {
+		if t.Kind() == reflect.Ptr && v.IsNil() {
+			return nil, false
+		}
+		return c.placeholder, true
+	}
+	if depth > maxRedactDepth || !c.mayRedact(t) || !v.CanInterface() {
+		return nil, false
+	}
+	switch t.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return nil, false
+		}
+		return c.value(v.Elem(), depth+1)
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && v.IsNil() {
+			return nil, false
+		}
+		n := v.Len()
+		list := make([]interface{}, n)
+		for i := 0; i < n; i++ {
+			elem := v.Index(i)
+			r, ok := c.value(elem, depth+1)
+			if ok {
+				changed = true
+				list[i] = r
+			} else {
+				list[i] = elem.Interface()
+			}
+		}
+		return list, changed
+	case reflect.Map:
+		if v.IsNil() {
+			return nil, false
+		}
+		m := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, ok := jsonMapKey(iter.Key())
+			if !ok {
+				// keys json cannot encode, leave it to json to report
+				return nil, false
+			}
+			if t.Key().Kind() == reflect.String && c.matchField(key) {
+				m[key] = c.placeholder
+				changed = true
+				continue
+			}
+			elem := iter.Value()
+			r, ok := c.value(elem, depth+1)
+			if ok {
+				changed = true
+				m[key] = r
+			} else {
+				m[key] = elem.Interface()
+			}
+		}
+		return m, changed
+	case reflect.Struct:
+		return c.structValue(v, depth)
+	}
+	return nil, false
+}
+
+// redactedStruct marshals fields in declaration order
+// like encoding/json does for structs
+type redactedStruct []redactedField
+
+type redactedField struct {
+	name      string
+	value     interface{}
+	omitEmpty bool
+	empty     bool
+}
+
+func (c redactedStruct) MarshalJSON() ([]byte, error) {
+	buf := []byte{'{'}
+	first := true
+	for _, field := range c {
+		if field.omitEmpty && field.empty {
+			continue
+		}
+		if !first {
+			buf = append(buf, ',')
+		}
+		first = false
+		buf = append(buf, strconv.Quote(field.name)...)
+		buf = append(buf, ':')
+		data, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, data...)
+	}
+	buf = append(buf, '}')
+	return buf, nil
+}
+
+func (c *redactor) structValue(v reflect.Value, depth int) (interface{}, bool) {
+	if !v.CanAddr() {
+		// addressable, so fields promoted from
+		// unexported embedded structs can be read
+		nv := reflect.New(v.Type()).Elem()
+		nv.Set(v)
+		v = nv
+	}
+	var fields redactedStruct
+	seen := make(map[string]bool)
+	changed := c.collectFields(v, depth, seen, &fields)
+	return fields, changed
+}
+
+// collectFields appends marshaled fields of v, fields of
+// embedded structs without json name are promoted
+func (c *redactor) collectFields(v reflect.Value, depth int, seen map[string]bool, fields *redactedStruct) (changed bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		fv := exposeField(v.Field(i))
+		name, opts := parseJSONTag(tag)
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct && !marshalsItself(field.Type) {
+				if fv.Kind() == reflect.Ptr {
+					if fv.IsNil() {
+						continue
+					}
+					fv = fv.Elem()
+				}
+				if c.collectFields(fv, depth+1, seen, fields) {
+					changed = true
+				}
+				continue
+			}
+		}
+		if field.PkgPath != "" {
+			// unexported
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		rf := redactedField{
+			name:      name,
+			omitEmpty: strings.Contains(opts, "omitempty"),
+			empty:     isEmptyValue(fv),
+		}
+		if isRedactTag(field.Tag) || c.matchField(field.Name) || c.matchField(name) {
+			rf.value = c.placeholder
+			changed = true
+		} else if r, ok := c.value(fv, depth+1); ok {
+			rf.value = r
+			changed = true
+		} else {
+			rf.value = fv.Interface()
+		}
+		*fields = append(*fields, rf)
+	}
+	return changed
+}
+
+// exposeField makes fields reached through unexported
+// embedded structs readable, as encoding/json does
+func exposeField(v reflect.Value) reflect.Value {
+	if v.CanInterface() || !v.CanAddr() {
+		return v
+	}
+	return reflect.NewAt(v.Type(), unsafe.Pointer(v.UnsafeAddr())).Elem()
+}
+
+func jsonFieldName(field reflect.StructField) string {
+	name, _ := parseJSONTag(field.Tag.Get("json"))
+	return name
+}
+
+func parseJSONTag(tag string) (name string, opts string) {
+	idx := strings.Index(tag, ",")
+	if idx < 0 {
+		return tag, ""
+	}
+	return tag[:idx], tag[idx+1:]
+}
+
+func jsonMapKey(k reflect.Value) (string, bool) {
+	if k.Kind() == reflect.String {
+		return k.String(), true
+	}
+	if k.CanInterface() {
+		if tm, ok := k.Interface().(encoding.TextMarshaler); ok {
+			data, err := tm.MarshalText()
+			if err != nil {
+				return "", false
+			}
+			return string(data), true
+		}
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), true
+	}
+	return "", false
+}
+
+// same as encoding/json
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+func isNilObject(obj core.Object) bool {
+	v := reflect.ValueOf(obj)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// redactedObject redacts fields of args or results
+type redactedObject struct {
+	core.Object
+	funcInfo *core.FuncInfo
+}
+
+// redactObject wraps args or results of f so that
+// they are redacted when marshaled
+func redactObject(f *core.FuncInfo, obj core.Object) core.Object {
+	if obj == nil {
+		return nil
+	}
+	if _, ok := obj.(*premarshaled); ok {
+		// already redacted when taking snapshot
+		return obj
+	}
+	return &redactedObject{Object: obj, funcInfo: f}
+}
+
+func (c *redactedObject) MarshalJSON() ([]byte, error) {
+	if isNilObject(c.Object) {
+		return []byte("null"), nil
+	}
+	r := getRedactor()
+	redactAll := r.matchFunc(c.funcInfo)
+	n := c.NumField()
+	buf := []byte{'{'}
+	for i := 0; i < n; i++ {
+		field := c.GetFieldIndex(i)
+		name := field.Name()
+		if name == "" {
+			name = "field_" + strconv.FormatInt(int64(i), 10)
+		}
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = append(buf, strconv.Quote(name)...)
+		buf = append(buf, ':')
+
+		var val interface{}
+		if redactAll || r.matchField(field.Name()) {
+			val = r.placeholder
+		} else if redacted, ok := r.redact(field.Value()); ok {
+			val = redacted
+		} else {
+			// pointer keeps methods with pointer receiver
+			val = field.Ptr()
+		}
+		data, err := json.Marshal(val)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", name, err)
+		}
+		buf = append(buf, data...)
+	}
+	buf = append(buf, '}')
+	return buf, nil
+}
@@ -0,0 +1,350 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// TextOptions controls rendering of a trace as plain
+// text or markdown, zero limits mean unlimited
+type TextOptions struct {
+	// title printed before the tree, usually the test name
+	Title string
+
+	// render a markdown list instead of a text tree
+	Markdown bool
+	// highlight errors and panics with ANSI colors,
+	// ignored by markdown
+	Color bool
+
+	// max depth of the call tree
+	MaxDepth int
+	// max children listed under one call
+	MaxChildren int
+	// max length of rendered args and results
+	MaxValueLen int
+
+	// Marshal encodes args and results, defaults to json.Marshal
+	Marshal func(v interface{}) ([]byte, error)
+}
+
+// DefaultTextOptions returns limits suitable for CI logs
+func DefaultTextOptions() *TextOptions {
+	return &TextOptions{
+		MaxChildren: 50,
+		MaxValueLen: 120,
+	}
+}
+
+const (
+	ansiRed    = "\033[31m"
+	ansiYellow = "\033[33m"
+	ansiGrey   = "\033[90m"
+	ansiReset  = "\033[0m"
+)
+
+// WriteText renders the call tree of root, one call per line with
+// duration, truncated args and results, errors and panics
+func WriteText(w io.Writer, root *RootExport, opts *TextOptions) error {
+	if opts == nil {
+		opts = DefaultTextOptions()
+	}
+	bw := bufio.NewWriter(w)
+	r := &textRenderer{w: bw, opts: opts}
+	if opts.Markdown {
+		r.writeMarkdown(root)
+	} else {
+		r.writeText(root)
+	}
+	return bw.Flush()
+}
+
+type textRenderer struct {
+	w    *bufio.Writer
+	opts *TextOptions
+}
+
+func (c *textRenderer) writeText(root *RootExport) {
+	if c.opts.Title != "" {
+		fmt.Fprintf(c.w, "=== TRACE %s\n", c.opts.Title)
+	}
+	var children []*StackExport
+	if root != nil {
+		children = root.Children
+	}
+	for _, stack := range children {
+		c.writeTextStack(stack, "", "", 1)
+	}
+}
+
+// prefix is used for the line of stack itself,
+// childPrefix for lines of its children
+func (c *textRenderer) writeTextStack(stack *StackExport, prefix string, childPrefix string, depth int) {
+	c.w.WriteString(prefix)
+	c.w.WriteString(c.describe(stack))
+	c.w.WriteString("\n")
+
+	if len(stack.Children) == 0 {
+		return
+	}
+	if c.opts.MaxDepth > 0 && depth >= c.opts.MaxDepth {
+		c.writeOmitted(childPrefix+"└── ", stack.Children)
+		return
+	}
+	children, omitted := c.limitChildren(stack.Children)
+	for i, child := range children {
+		last := i == len(children)-1 && len(omitted) == 0
+		if last {
+			c.writeTextStack(child, childPrefix+"└── ", childPrefix+"    ", depth+1)
+		} else {
+			c.writeTextStack(child, childPrefix+"├── ", childPrefix+"│   ", depth+1)
+		}
+	}
+	if len(omitted) > 0 {
+		c.writeOmitted(childPrefix+"└── ", omitted)
+	}
+}
+
+func (c *textRenderer) writeOmitted(prefix string, stacks []*StackExport) {
+	c.w.WriteString(prefix)
+	note := omittedNote(stacks)
+	if countErrorStacks(stacks) > 0 {
+		note = c.colored(ansiRed, note)
+	} else {
+		note = c.colored(ansiGrey, note)
+	}
+	c.w.WriteString(note)
+	c.w.WriteString("\n")
+}
+
+func (c *textRenderer) describe(stack *StackExport) string {
+	var b strings.Builder
+	b.WriteString(stackTextName(stack))
+	if stack.Mocked {
+		b.WriteString(" [mock]")
+	}
+	if cost := formatTextCost(stack.End - stack.Begin); cost != "" {
+		b.WriteString(" ")
+		b.WriteString(c.colored(ansiGrey, cost))
+	}
+	if args := c.value(stack.Args); args != "" {
+		b.WriteString(" args=")
+		b.WriteString(args)
+	}
+	if stack.Panic {
+		b.WriteString(" ")
+		b.WriteString(c.colored(ansiYellow, "PANIC: "+stack.Error))
+	} else if stack.Error != "" {
+		b.WriteString(" ")
+		b.WriteString(c.colored(ansiRed, "ERROR: "+stack.Error))
+	} else if results := c.value(stack.Results); results != "" {
+		b.WriteString(" results=")
+		b.WriteString(results)
+	}
+	return b.String()
+}
+
+func (c *textRenderer) writeMarkdown(root *RootExport) {
+	var children []*StackExport
+	if root != nil {
+		children = root.Children
+	}
+	if c.opts.Title != "" {
+		mark := "✅"
+		if countErrorStacks(children) > 0 {
+			mark = "❌"
+		}
+		fmt.Fprintf(c.w, "### %s Trace of %s\n\n", mark, markdownCode(c.opts.Title))
+	}
+	for _, stack := range children {
+		c.writeMarkdownStack(stack, "", 1)
+	}
+	c.w.WriteString("\n")
+}
+
+func (c *textRenderer) writeMarkdownStack(stack *StackExport, indent string, depth int) {
+	var b strings.Builder
+	b.WriteString(indent)
+	b.WriteString("- ")
+	if stack.Panic {
+		b.WriteString("💥 ")
+	} else if stack.Error != "" {
+		b.WriteString("❌ ")
+	}
+	b.WriteString("**")
+	b.WriteString(markdownEscape(stackTextName(stack)))
+	b.WriteString("**")
+	if stack.Mocked {
+		b.WriteString(" *mock*")
+	}
+	if cost := formatTextCost(stack.End - stack.Begin); cost != "" {
+		b.WriteString(" ")
+		b.WriteString(cost)
+	}
+	if args := c.value(stack.Args); args != "" {
+		b.WriteString(" args: ")
+		b.WriteString(markdownCode(args))
+	}
+	if stack.Panic {
+		b.WriteString(" panic: ")
+		b.WriteString(markdownCode(stack.Error))
+	} else if stack.Error != "" {
+		b.WriteString(" error: ")
+		b.WriteString(markdownCode(stack.Error))
+	} else if results := c.value(stack.Results); results != "" {
+		b.WriteString(" results: ")
+		b.WriteString(markdownCode(results))
+	}
+	b.WriteString("\n")
+	c.w.WriteString(b.String())
+
+	if len(stack.Children) == 0 {
+		return
+	}
+	childIndent := indent + "  "
+	if c.opts.MaxDepth > 0 && depth >= c.opts.MaxDepth {
+		fmt.Fprintf(c.w, "%s- *%s*\n", childIndent, omittedNote(stack.Children))
+		return
+	}
+	children, omitted := c.limitChildren(stack.Children)
+	for _, child := range children {
+		c.writeMarkdownStack(child, childIndent, depth+1)
+	}
+	if len(omitted) > 0 {
+		fmt.Fprintf(c.w, "%s- *%s*\n", childIndent, omittedNote(omitted))
+	}
+}
+
+// limitChildren splits children into rendered and omitted ones
+func (c *textRenderer) limitChildren(children []*StackExport) ([]*StackExport, []*StackExport) {
+	if c.opts.MaxChildren <= 0 || len(children) <= c.opts.MaxChildren {
+		return children, nil
+	}
+	return children[:c.opts.MaxChildren], children[c.opts.MaxChildren:]
+}
+
+// omittedNote counts omitted calls including their descendants,
+// errors among them are mentioned so they are not missed
+func omittedNote(stacks []*StackExport) string {
+	note := fmt.Sprintf("... %d calls omitted", countStacks(stacks))
+	if n := countErrorStacks(stacks); n > 0 {
+		note += fmt.Sprintf(", %d with error", n)
+	}
+	return note
+}
+
+// value renders args or results, empty ones are omitted
+func (c *textRenderer) value(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	marshal := c.opts.Marshal
+	if marshal == nil {
+		marshal = json.Marshal
+	}
+	data, err := marshal(v)
+	var s string
+	if err != nil {
+		s = "<" + err.Error() + ">"
+	} else {
+		s = string(data)
+	}
+	if s == "{}" || s == "null" || s == "[]" {
+		return ""
+	}
+	if c.opts.MaxValueLen > 0 && len(s) > c.opts.MaxValueLen {
+		s = truncateUTF8(s, c.opts.MaxValueLen) + "..."
+	}
+	return s
+}
+
+func (c *textRenderer) colored(color string, s string) string {
+	if !c.opts.Color {
+		return s
+	}
+	return color + s + ansiReset
+}
+
+func stackTextName(stack *StackExport) string {
+	if stack.FuncInfo == nil || stack.FuncInfo.IdentityName == "" {
+		return "<unknown>"
+	}
+	return stack.FuncInfo.IdentityName
+}
+
+func countErrorStacks(stacks []*StackExport) int {
+	var n int
+	for _, stack := range stacks {
+		if stack.Error != "" || stack.Panic {
+			n++
+		}
+		n += countErrorStacks(stack.Children)
+	}
+	return n
+}
+
+func countStacks(stacks []*StackExport) int {
+	n := len(stacks)
+	for _, stack := range stacks {
+		n += countStacks(stack.Children)
+	}
+	return n
+}
+
+// truncateUTF8 cuts s to at most n bytes
+// without splitting a multi-byte rune
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && s[n]&0xC0 == 0x80 {
+		n--
+	}
+	return s[:n]
+}
+
+func formatTextCost(cost int64) string {
+	if cost <= 0 {
+		return ""
+	}
+	units := []struct {
+		name  string
+		scale int64
+	}{
+		{"ns", 1},
+		{"μs", 1000},
+		{"ms", 1000},
+		{"s", 1000},
+	}
+	name := units[0].name
+	f := float64(cost)
+	for i := 1; i < len(units); i++ {
+		if f < float64(units[i].scale) {
+			break
+		}
+		f = f / float64(units[i].scale)
+		name = units[i].name
+	}
+	return fmt.Sprintf("%d%s", int64(f), name)
+}
+
+func markdownEscape(s string) string {
+	return strings.NewReplacer("*", "\\*", "_", "\\_", "`", "\\`", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// markdownCode wraps s in a code span, using a longer
+// fence when s itself contains backticks
+func markdownCode(s string) string {
+	s = strings.Replace(s, "\n", " ", -1)
+	fence := "`"
+	for strings.Contains(s, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(s, "`") || strings.HasSuffix(s, "`") || fence != "`" {
+		return fence + " " + s + " " + fence
+	}
+	return fence + s + fence
+}
@@ -0,0 +1,155 @@
+package test_explorer
+
+import (
+	"go/ast"
+	"strconv"
+	"strings"
+)
+
+// TestFuncKind tells how a case is run by go test
+type TestFuncKind string
+
+const (
+	TestFuncKind_Test      TestFuncKind = "test"
+	TestFuncKind_Benchmark TestFuncKind = "benchmark"
+	TestFuncKind_Fuzz      TestFuncKind = "fuzz"
+	TestFuncKind_Example   TestFuncKind = "example"
+)
+
+const defaultFuzzTime = "10s"
+
+// BenchResult is a result line of go test -bench -benchmem, e.g.
+//
+//	BenchmarkAdd-8   1000000   1035 ns/op   128 B/op   2 allocs/op
+type BenchResult struct {
+	// without the -GOMAXPROCS suffix
+	Name        string  `json:"name"`
+	Iterations  int64   `json:"iterations"`
+	NsPerOp     float64 `json:"nsPerOp"`
+	BytesPerOp  int64   `json:"bytesPerOp"`
+	AllocsPerOp int64   `json:"allocsPerOp"`
+}
+
+// getTestFuncKind returns kind of the top level function
+// by its name, same as go test: TestXxx, but not Testxxx
+func getTestFuncKind(name string) TestFuncKind {
+	for _, e := range []struct {
+		prefix string
+		kind   TestFuncKind
+	}{
+		{"Test", TestFuncKind_Test},
+		{"Benchmark", TestFuncKind_Benchmark},
+		{"Fuzz", TestFuncKind_Fuzz},
+		{"Example", TestFuncKind_Example},
+	} {
+		if !strings.HasPrefix(name, e.prefix) {
+			continue
+		}
+		if len(name) > len(e.prefix) {
+			c := name[len(e.prefix)]
+			if c >= 'a' && c <= 'z' {
+				return ""
+			}
+		}
+		return e.kind
+	}
+	return ""
+}
+
+// isTestFunc checks the signature against the kind:
+// func(*testing.T), func(*testing.B), func(*testing.F), func().
+// Examples without output comments are compiled but not run by
+// go test, so they are excluded.
+func isTestFunc(fnDecl *ast.FuncDecl, kind TestFuncKind, comments []*ast.CommentGroup) bool {
+	if fnDecl.Recv != nil || fnDecl.Body == nil {
+		return false
+	}
+	params := fnDecl.Type.Params
+	switch kind {
+	case TestFuncKind_Test, TestFuncKind_Benchmark, TestFuncKind_Fuzz:
+		return params != nil && len(params.List) == 1
+	case TestFuncKind_Example:
+		if (params != nil && len(params.List) > 0) || fnDecl.Type.Results != nil {
+			return false
+		}
+		return hasExampleOutput(fnDecl, comments)
+	}
+	return false
+}
+
+func hasExampleOutput(fnDecl *ast.FuncDecl, comments []*ast.CommentGroup) bool {
+	for _, group := range comments {
+		if group.Pos() < fnDecl.Body.Lbrace || group.End() > fnDecl.Body.Rbrace {
+			continue
+		}
+		text := strings.ToLower(strings.TrimSpace(group.Text()))
+		if strings.HasPrefix(text, "output:") || strings.HasPrefix(text, "unordered output:") {
+			return true
+		}
+	}
+	return false
+}
+
+// getRunFlags returns flags selecting names of the given kind,
+// benchmarks and fuzz targets skip tests by -run ^$
+func getRunFlags(kind TestFuncKind, runNames string, fuzzTime string) []string {
+	if runNames == "" {
+		return nil
+	}
+	switch kind {
+	case TestFuncKind_Benchmark:
+		return []string{"-run", "^$", "-bench", runNames, "-benchmem"}
+	case TestFuncKind_Fuzz:
+		if fuzzTime == "" {
+			fuzzTime = defaultFuzzTime
+		}
+		return []string{"-run", "^$", "-fuzz", runNames, "-fuzztime", fuzzTime}
+	}
+	return []string{"-run", runNames}
+}
+
+// parseBenchLine parses a result line of go test -bench
+func parseBenchLine(line string) (*BenchResult, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || getTestFuncKind(fields[0]) != TestFuncKind_Benchmark {
+		return nil, false
+	}
+	iterations, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	name := fields[0]
+	if idx := strings.LastIndex(name, "-"); idx > 0 {
+		if _, err := strconv.Atoi(name[idx+1:]); err == nil {
+			name = name[:idx]
+		}
+	}
+	res := &BenchResult{
+		Name:       name,
+		Iterations: iterations,
+	}
+	var hasNs bool
+	for i := 2; i+1 < len(fields); i += 2 {
+		value, unit := fields[i], fields[i+1]
+		switch unit {
+		case "ns/op":
+			res.NsPerOp, err = strconv.ParseFloat(value, 64)
+			hasNs = err == nil
+		case "B/op":
+			res.BytesPerOp, _ = strconv.ParseInt(value, 10, 64)
+		case "allocs/op":
+			res.AllocsPerOp, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+	if !hasNs {
+		return nil, false
+	}
+	return res, true
+}
+
+// String formats the result for the output panel
+func (c *BenchResult) String() string {
+	return c.Name + ": " + strconv.FormatFloat(c.NsPerOp, 'f', -1, 64) + " ns/op, " +
+		strconv.FormatInt(c.BytesPerOp, 10) + " B/op, " +
+		strconv.FormatInt(c.AllocsPerOp, 10) + " allocs/op"
+}
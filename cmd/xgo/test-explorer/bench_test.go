@@ -0,0 +1,70 @@
+package test_explorer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTestFuncKinds(t *testing.T) {
+	code := `package test
+import "testing"
+
+func TestA(t *testing.T) {}
+func Testlower(t *testing.T) {}
+func BenchmarkB(b *testing.B) {}
+func FuzzC(f *testing.F) {}
+func ExampleD() {
+	// Output: d
+}
+func ExampleNoOutput() {}
+func helper() {}
+`
+	_, funcDecls, err := parseTestFuncsCode("test.go", strings.NewReader(code))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []string
+	for _, fn := range funcDecls {
+		kinds = append(kinds, fn.Name.Name+":"+string(getTestFuncKind(fn.Name.Name)))
+	}
+	expect := "TestA:test BenchmarkB:benchmark FuzzC:fuzz ExampleD:example"
+	if strings.Join(kinds, " ") != expect {
+		t.Fatalf("expect %s, actual: %s", expect, strings.Join(kinds, " "))
+	}
+}
+
+func TestGetRunFlags(t *testing.T) {
+	tests := []struct {
+		kind   TestFuncKind
+		expect string
+	}{
+		{TestFuncKind_Test, "-run ^TestA$"},
+		{TestFuncKind_Example, "-run ^TestA$"},
+		{TestFuncKind_Benchmark, "-run ^$ -bench ^TestA$ -benchmem"},
+		{TestFuncKind_Fuzz, "-run ^$ -fuzz ^TestA$ -fuzztime 10s"},
+	}
+	for _, tt := range tests {
+		flags := strings.Join(getRunFlags(tt.kind, "^TestA$", ""), " ")
+		if flags != tt.expect {
+			t.Errorf("%s: expect %s, actual: %s", tt.kind, tt.expect, flags)
+		}
+	}
+}
+
+func TestParseBenchLine(t *testing.T) {
+	res, ok := parseBenchLine("BenchmarkAdd/small-8   \t 1000000\t      1035 ns/op\t     128 B/op\t       2 allocs/op")
+	if !ok {
+		t.Fatalf("expect parsed")
+	}
+	if res.Name != "BenchmarkAdd/small" || res.Iterations != 1000000 || res.NsPerOp != 1035 || res.BytesPerOp != 128 || res.AllocsPerOp != 2 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+	if res.String() != "BenchmarkAdd/small: 1035 ns/op, 128 B/op, 2 allocs/op" {
+		t.Fatalf("unexpected string: %s", res.String())
+	}
+	for _, line := range []string{"BenchmarkAdd", "=== RUN   BenchmarkAdd", "PASS", "ok  \texample.com/a\t1.2s"} {
+		if _, ok := parseBenchLine(line); ok {
+			t.Errorf("expect %q not parsed", line)
+		}
+	}
+}
@@ -27,6 +27,10 @@ type TestConfig struct {
 	Flags []string `json:"flags"`
 	Args  []string `json:"args"`
 
+	// time budget of running a fuzz target
+	// with -fuzz, default: 10s
+	FuzzTime string `json:"fuzz_time"`
+
 	MockRules []string   `json:"mock_rules"`
 	Xgo       *XgoConfig `json:"xgo,omitempty"`
 }
@@ -53,6 +57,13 @@ func (c *TestConfig) GetGoCmd() string {
 	return "go"
 }
 
+func (c *TestConfig) GetFuzzTime() string {
+	if c.FuzzTime != "" {
+		return c.FuzzTime
+	}
+	return defaultFuzzTime
+}
+
 type GoConfig struct {
 	Min string `json:"min"`
 	Max string `json:"max"`
@@ -138,6 +149,14 @@ func parseTestConfig(config string) (*TestConfig, error) {
 		conf.Args = list
 	}
 
+	e, ok = m["fuzz_time"]
+	if ok {
+		if s, ok := e.(string); ok {
+			conf.FuzzTime = s
+		} else {
+			return nil, fmt.Errorf("fuzz_time requires string, actual: %T", e)
+		}
+	}
 	e, ok = m["mock_rules"]
 	if ok {
 		list, err := toMarshaledStrings(e)
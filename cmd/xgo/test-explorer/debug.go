@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/xhd2015/xgo/support/cmd"
@@ -50,14 +51,15 @@ func debug(ctx *RunContext) error {
 	args := ctx.Args
 	env := ctx.Env
 
-	err := debugTest(goCmd, projectDir, file, buildFlags, []string{"./" + filepath.Dir(relPath)}, fmt.Sprintf("^%s$", name), stdout, stderr, args, env)
+	kind := getTestFuncKind(strings.SplitN(name, "/", 2)[0])
+	err := debugTest(goCmd, projectDir, file, buildFlags, []string{"./" + filepath.Dir(relPath)}, kind, fmt.Sprintf("^%s$", name), stdout, stderr, args, env)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func debugTest(goCmd string, dir string, file string, buildFlags []string, buildArgs []string, runNames string, stdout io.Writer, stderr io.Writer, args []string, env []string) error {
+func debugTest(goCmd string, dir string, file string, buildFlags []string, buildArgs []string, kind TestFuncKind, runNames string, stdout io.Writer, stderr io.Writer, args []string, env []string) error {
 	if goCmd == "" {
 		goCmd = "go"
 	}
@@ -91,7 +93,14 @@ func debugTest(goCmd string, dir string, file string, buildFlags []string, build
 		fmt.Fprintln(stderr, debug_util.FormatDlvPrompt(port))
 	}, func(port int) error {
 		// dlv exec --api-version=2 --listen=localhost:2345 --accept-multiclient --headless ./debug.bin
-		runArgs := append([]string{"-test.v", "-test.run", runNames}, args...)
+		runArgs := []string{"-test.v"}
+		if kind == TestFuncKind_Benchmark {
+			runArgs = append(runArgs, "-test.run", "^$", "-test.bench", runNames, "-test.benchmem")
+		} else {
+			// fuzz targets only run the seed corpus
+			runArgs = append(runArgs, "-test.run", runNames)
+		}
+		runArgs = append(runArgs, args...)
 		return cmd.Dir(filepath.Dir(file)).Debug().Stderr(stderr).Stdout(stdout).
 			Env(env).
 			Run("dlv", debug_util.FormatDlvArgs(tmpBin, port, runArgs)...)
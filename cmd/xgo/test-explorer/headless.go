@@ -0,0 +1,186 @@
+package test_explorer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// failedTest is a top level test failed in the
+// first run of xgo e test, classified by reruns
+type failedTest struct {
+	pkg    string
+	name   string
+	reruns int
+	passes int
+}
+
+func (c *failedTest) flaky() bool {
+	return c.passes > 0
+}
+
+// runHeadless implements xgo e test. Tests are run with -json
+// so outcomes can be recorded, the output is converted back
+// to what go test -v prints. If rerunFailures > 0, failed top
+// level tests are rerun that many times, those passing in any
+// rerun are flaky and do not fail the command.
+func runHeadless(conf *TestConfig, projectDir string, historyFile string, testArgs []string, rerunFailures int, stdout io.Writer) error {
+	recorder := newHistoryRecorder(projectDir)
+	runErr := runJSONTest(conf, projectDir, nil, testArgs, recorder, stdout)
+
+	var failed []*failedTest
+	if runErr != nil && rerunFailures > 0 {
+		failed = getFailedTests(recorder.records())
+	}
+	var rerunRecords []*TestRecord
+	if len(failed) > 0 {
+		rerunRecords = rerunFailedTests(conf, projectDir, failed, rerunFailures, recorder, stdout)
+	}
+	if historyFile != "" {
+		err := appendHistory(historyFile, append(recorder.records(), rerunRecords...))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "WARNING: save test history: %v\n", err)
+		}
+	}
+	if runErr == nil || len(failed) == 0 {
+		return runErr
+	}
+	var consistent int
+	fmt.Fprintf(stdout, "\nrerun %d failed test(s) %d time(s):\n", len(failed), rerunFailures)
+	for _, test := range failed {
+		class := "flaky"
+		if !test.flaky() {
+			class = "consistent"
+			consistent++
+		}
+		fmt.Fprintf(stdout, "  %-10s  %s %s: passed %d/%d reruns\n", class, test.pkg, test.name, test.passes, test.reruns)
+	}
+	if consistent > 0 {
+		return fmt.Errorf("%d test(s) failed consistently", consistent)
+	}
+	// only flaky failures, unless failed otherwise,
+	// e.g. package level failures like build errors
+	if packageFailed(recorder, failed) {
+		return runErr
+	}
+	return nil
+}
+
+// getFailedTests returns failed top level tests
+func getFailedTests(records []*TestRecord) []*failedTest {
+	var failed []*failedTest
+	for _, record := range records {
+		if record.Status != RunStatus_Fail || strings.Contains(record.Name, "/") {
+			continue
+		}
+		failed = append(failed, &failedTest{pkg: record.Pkg, name: record.Name})
+	}
+	sort.Slice(failed, func(i, j int) bool {
+		if failed[i].pkg != failed[j].pkg {
+			return failed[i].pkg < failed[j].pkg
+		}
+		return failed[i].name < failed[j].name
+	})
+	return failed
+}
+
+// packageFailed tells if any package failed
+// without a failing test, e.g. build failed
+func packageFailed(recorder *historyRecorder, failed []*failedTest) bool {
+	failedPkgs := make(map[string]bool, len(failed))
+	for _, test := range failed {
+		failedPkgs[test.pkg] = true
+	}
+	for _, pkg := range recorder.failedPkgs() {
+		if !failedPkgs[pkg] {
+			return true
+		}
+	}
+	return false
+}
+
+// rerunFailedTests reruns failed tests of each package
+// with -count, each run reports its own outcome
+func rerunFailedTests(conf *TestConfig, projectDir string, failed []*failedTest, count int, base *historyRecorder, stdout io.Writer) []*TestRecord {
+	var pkgs []string
+	byPkg := make(map[string][]*failedTest)
+	for _, test := range failed {
+		if _, ok := byPkg[test.pkg]; !ok {
+			pkgs = append(pkgs, test.pkg)
+		}
+		byPkg[test.pkg] = append(byPkg[test.pkg], test)
+	}
+	var records []*TestRecord
+	for _, pkg := range pkgs {
+		tests := byPkg[pkg]
+		names := make([]string, 0, len(tests))
+		for _, test := range tests {
+			names = append(names, test.name)
+		}
+		recorder := base.newRerun()
+		fmt.Fprintf(stdout, "\nrerun %s %s\n", pkg, strings.Join(names, ","))
+		runNames := "^(" + strings.Join(escapeRegexNames(names), "|") + ")$"
+		flags := []string{"-count=" + strconv.Itoa(count)}
+		// errors are expected
+		runJSONTest(conf, projectDir, flags, []string{"-run", runNames, pkg}, recorder, stdout)
+
+		pkgRecords := recorder.records()
+		for _, test := range tests {
+			for _, record := range pkgRecords {
+				if record.Pkg != test.pkg || record.Name != test.name {
+					continue
+				}
+				test.reruns++
+				if record.Status == RunStatus_Success {
+					test.passes++
+				}
+			}
+		}
+		records = append(records, pkgRecords...)
+	}
+	return records
+}
+
+// runJSONTest runs go test -json, writes output of events
+// to stdout and records outcomes
+func runJSONTest(conf *TestConfig, projectDir string, extraFlags []string, testArgs []string, recorder *historyRecorder, stdout io.Writer) error {
+	r, w := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, 1024*1024)
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			if !bytes.HasPrefix(line, []byte{'{'}) {
+				// e.g. FAIL pkg [build failed]
+				fmt.Fprintf(stdout, "%s\n", line)
+				continue
+			}
+			var event *TestEvent
+			err := json.Unmarshal(line, &event)
+			if err != nil || event == nil {
+				fmt.Fprintf(stdout, "%s\n", line)
+				continue
+			}
+			io.WriteString(stdout, event.Output)
+			recorder.observe(event)
+			if event.Test == "" && event.Action == TestEventAction_Fail {
+				recorder.addFailedPkg(event.Package)
+			}
+		}
+		// drain
+		io.Copy(io.Discard, r)
+	}()
+	flags := append(append([]string{"-json"}, conf.Flags...), extraFlags...)
+	err := runTest(conf.GoCmd, projectDir, flags, testArgs, conf.Args, conf.CmdEnv(), w, os.Stderr)
+	w.Close()
+	<-done
+	return err
+}
@@ -6,6 +6,7 @@ xgo tool test-explorer is a tool used to test and debug go code easily.
 Usage:
    xgo e [options]       
    xgo e [options] test
+   xgo e history [--limit N] [--format text|json]
 
 Alias:
    xgo e
@@ -16,12 +17,17 @@ By default, test-explorer opens a web UI to show all tests to be run.
 
 If invoked with 'xgo e test', all tests are automatically executed without opening the web UI.
 
+Outcomes of each run are recorded into .xgo/test-history.jsonl under the project root,
+'xgo e history' shows the slowest tests and flaky tests, which passed and failed on the same revision without uncommitted changes in between.
+
 Options:
      --project-dir DIR         directory to project dir
      --go-command CMD          the command to execute test, default is 'xgo' when invoked via xgo, and 'go' otherwise
      --flag FLAG
      --flags FLAG              flags passed to test
      --exclude DIR             exclude a sub path from showing in the explorer UI
+     --rerun-failures N        for 'xgo e test', rerun failed tests N times, tests passing
+                               in any rerun are flaky and do not fail the command
      --config FILE             test config file used to add persistent options, default: test.config.json.
                                if FILE is 'none', test config file is not read.
   -h,--help                    show help
@@ -29,6 +35,9 @@ Options:
 Examples:
   xgo e                  open the test explorer in browser
   xgo e test             run all tests without opening the test explorer(used in CI)
+  xgo e test --rerun-failures 3
+                         run all tests, rerun failed tests 3 times to tell flaky ones
+  xgo e history          show the slowest tests and flaky tests
 
 See https://github.com/xhd2015/xgo/blob/master/doc/test-explorer/README.md for documentation.
 
@@ -0,0 +1,541 @@
+package test_explorer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/xhd2015/xgo/support/cmd"
+	"github.com/xhd2015/xgo/support/goinfo"
+	"github.com/xhd2015/xgo/support/netutil"
+)
+
+// historyFileName is where outcomes of test runs are
+// persisted, relative to the project root
+const historyFileName = ".xgo/test-history.jsonl"
+
+// oldest records are dropped beyond this
+const maxHistoryRecords = 20000
+
+// failure output is truncated to this
+const maxHistoryOutput = 8 * 1024
+
+// only the latest runs of each test are analyzed
+const historyWindow = 20
+
+// TestRecord is the outcome of a test in one run
+type TestRecord struct {
+	RunID string    `json:"runID"`
+	Time  time.Time `json:"time"`
+	// short git revision, empty if not a git repo
+	Revision string `json:"revision,omitempty"`
+	// hash of uncommitted changes, empty if clean
+	Tree string `json:"tree,omitempty"`
+	Pkg  string `json:"pkg"`
+	// full name, e.g. TestSomething/subcase
+	Name    string    `json:"name"`
+	Status  RunStatus `json:"status"`
+	Elapsed float64   `json:"elapsed"` // seconds
+	// only if failed
+	Output string `json:"output,omitempty"`
+	// a rerun of a failed test
+	Rerun bool `json:"rerun,omitempty"`
+}
+
+type HistorySummary struct {
+	Tests []*TestHistory `json:"tests"`
+	// top level tests sorted by average duration
+	Slowest []*TestHistory `json:"slowest"`
+	Flaky   []*TestHistory `json:"flaky"`
+}
+
+// TestHistory summarizes the latest runs of a test
+type TestHistory struct {
+	Pkg    string `json:"pkg"`
+	Name   string `json:"name"`
+	Runs   int    `json:"runs"`
+	Passes int    `json:"passes"`
+	Fails  int    `json:"fails"`
+	// statuses of the latest runs, oldest first,
+	// P for pass, F for fail, S for skip
+	Trend       string  `json:"trend"`
+	AvgElapsed  float64 `json:"avgElapsed"`
+	LastElapsed float64 `json:"lastElapsed"`
+	// times the status changed between pass and fail
+	Flips int `json:"flips"`
+	// both passed and failed on the same revision
+	// without changes in between
+	Flaky        bool      `json:"flaky"`
+	LastStatus   RunStatus `json:"lastStatus"`
+	LastRevision string    `json:"lastRevision,omitempty"`
+	LastFailure  string    `json:"lastFailure,omitempty"`
+}
+
+func (c *TestHistory) Key() string {
+	return c.Pkg + "." + c.Name
+}
+
+var historyMutex sync.Mutex
+
+func loadHistory(file string) ([]*TestRecord, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var records []*TestRecord
+	// lines are not bounded by maxHistoryOutput: json
+	// escapes characters like < into \u003c
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		var record *TestRecord
+		if len(bytes.TrimSpace(line)) > 0 && json.Unmarshal(line, &record) == nil && record != nil {
+			records = append(records, record)
+		}
+		// otherwise skip broken lines, e.g. partially written
+		if err == io.EOF {
+			return records, nil
+		}
+	}
+}
+
+// appendHistory appends records to file, keeping
+// at most maxHistoryRecords latest records
+func appendHistory(file string, records []*TestRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	historyMutex.Lock()
+	defer historyMutex.Unlock()
+	existing, err := loadHistory(file)
+	if err != nil {
+		return err
+	}
+	all := append(existing, records...)
+	if len(all) > maxHistoryRecords {
+		all = all[len(all)-maxHistoryRecords:]
+	}
+	var b strings.Builder
+	for _, record := range all {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		b.Write(data)
+		b.WriteByte('\n')
+	}
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+	tmpFile := file + ".tmp"
+	err = os.WriteFile(tmpFile, []byte(b.String()), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, file)
+}
+
+// getGitState returns the short revision of HEAD and a hash
+// of uncommitted changes, so fixing a failed test without
+// committing is not taken as flaky
+func getGitState(dir string) (revision string, tree string) {
+	rev, err := cmd.Dir(dir).Stderr(io.Discard).Output("git", "rev-parse", "--short", "HEAD")
+	if err != nil {
+		return "", ""
+	}
+	revision = strings.TrimSpace(rev)
+	diff, err := cmd.Dir(dir).Stderr(io.Discard).Output("git", "diff", "HEAD")
+	if err != nil {
+		return revision, ""
+	}
+	untracked, err := cmd.Dir(dir).Stderr(io.Discard).Output("git", "ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return revision, ""
+	}
+	h := sha256.New()
+	io.WriteString(h, diff)
+	dirty := diff != ""
+	for _, name := range strings.Split(untracked, "\n") {
+		name = strings.TrimSpace(name)
+		// the history itself changes on each run
+		if name == "" || strings.HasPrefix(name, filepath.Dir(historyFileName)+"/") {
+			continue
+		}
+		stat, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		// untracked files are not in the diff
+		fmt.Fprintf(h, "%s %d %d\n", name, stat.Size(), stat.ModTime().UnixNano())
+		dirty = true
+	}
+	if !dirty {
+		return revision, ""
+	}
+	return revision, hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+func newRunID() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// historyRecorder collects outcomes of a run from test events
+type historyRecorder struct {
+	runID    string
+	revision string
+	tree     string
+	rerun    bool
+
+	mutex   sync.Mutex
+	outputs map[string]*strings.Builder
+	results []*TestRecord
+	// packages failed as a whole
+	pkgFails []string
+}
+
+func newHistoryRecorder(dir string) *historyRecorder {
+	revision, tree := getGitState(dir)
+	return &historyRecorder{
+		runID:    newRunID(),
+		revision: revision,
+		tree:     tree,
+		outputs:  make(map[string]*strings.Builder),
+	}
+}
+
+// newRerun returns a recorder for a rerun on the same state
+func (c *historyRecorder) newRerun() *historyRecorder {
+	return &historyRecorder{
+		runID:    newRunID(),
+		revision: c.revision,
+		tree:     c.tree,
+		rerun:    true,
+		outputs:  make(map[string]*strings.Builder),
+	}
+}
+
+func (c *historyRecorder) observe(event *TestEvent) {
+	if c == nil || event == nil || event.Test == "" {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	key := event.Package + "\x00" + event.Test
+	var status RunStatus
+	switch event.Action {
+	case TestEventAction_Output:
+		out := c.outputs[key]
+		if out == nil {
+			out = &strings.Builder{}
+			c.outputs[key] = out
+		}
+		if out.Len() < maxHistoryOutput {
+			out.WriteString(event.Output)
+		}
+		return
+	case TestEventAction_Pass:
+		status = RunStatus_Success
+	case TestEventAction_Fail:
+		status = RunStatus_Fail
+	case TestEventAction_Skip:
+		status = RunStatus_Skip
+	default:
+		return
+	}
+	record := &TestRecord{
+		RunID:    c.runID,
+		Time:     event.Time,
+		Revision: c.revision,
+		Tree:     c.tree,
+		Pkg:      event.Package,
+		Name:     event.Test,
+		Status:   status,
+		Elapsed:  event.Elapsed,
+		Rerun:    c.rerun,
+	}
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	if status == RunStatus_Fail {
+		if out := c.outputs[key]; out != nil {
+			record.Output = truncateOutput(out.String())
+		}
+	}
+	// with -count > 1, each run has its own output
+	delete(c.outputs, key)
+	c.results = append(c.results, record)
+}
+
+var plainResultRegex = regexp.MustCompile(`^\s*--- (PASS|FAIL|SKIP): (\S+) \(([0-9.]+)s\)`)
+
+// observeLine converts a line of go test -v output into
+// events, lines before a result are output of test
+func (c *historyRecorder) observeLine(pkg string, test string, line string) {
+	m := plainResultRegex.FindStringSubmatch(line)
+	if m == nil {
+		c.observe(&TestEvent{Package: pkg, Test: test, Action: TestEventAction_Output, Output: line + "\n"})
+		return
+	}
+	action := TestEventAction_Pass
+	switch m[1] {
+	case "FAIL":
+		action = TestEventAction_Fail
+	case "SKIP":
+		action = TestEventAction_Skip
+	}
+	elapsed, _ := strconv.ParseFloat(m[3], 64)
+	// m[2] can be a sub test of test
+	c.observe(&TestEvent{Package: pkg, Test: m[2], Action: action, Elapsed: elapsed})
+}
+
+func (c *historyRecorder) addFailedPkg(pkg string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.pkgFails = append(c.pkgFails, pkg)
+}
+
+func (c *historyRecorder) failedPkgs() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]string(nil), c.pkgFails...)
+}
+
+func (c *historyRecorder) records() []*TestRecord {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]*TestRecord(nil), c.results...)
+}
+
+func truncateOutput(s string) string {
+	if len(s) <= maxHistoryOutput {
+		return s
+	}
+	// do not split a multi-byte rune
+	n := maxHistoryOutput
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "\n...truncated"
+}
+
+// summarizeHistory analyzes the latest historyWindow
+// runs of each test, a test is flaky if it both passed
+// and failed on the same revision and uncommitted changes.
+// Reruns are included so a test passing on rerun is flaky
+func summarizeHistory(records []*TestRecord, limit int) *HistorySummary {
+	byKey := make(map[string][]*TestRecord)
+	var keys []string
+	for _, record := range records {
+		key := record.Pkg + "." + record.Name
+		if _, ok := byKey[key]; !ok {
+			keys = append(keys, key)
+		}
+		byKey[key] = append(byKey[key], record)
+	}
+	sort.Strings(keys)
+
+	summary := &HistorySummary{
+		Tests:   []*TestHistory{},
+		Slowest: []*TestHistory{},
+		Flaky:   []*TestHistory{},
+	}
+	for _, key := range keys {
+		runs := byKey[key]
+		if len(runs) > historyWindow {
+			runs = runs[len(runs)-historyWindow:]
+		}
+		last := runs[len(runs)-1]
+		h := &TestHistory{
+			Pkg:          last.Pkg,
+			Name:         last.Name,
+			Runs:         len(runs),
+			LastElapsed:  last.Elapsed,
+			LastStatus:   last.Status,
+			LastRevision: last.Revision,
+		}
+		var trend strings.Builder
+		var totalElapsed float64
+		var prev RunStatus
+		// by revision and uncommitted changes
+		stateStatus := make(map[string]RunStatus)
+		for _, run := range runs {
+			totalElapsed += run.Elapsed
+			switch run.Status {
+			case RunStatus_Success:
+				h.Passes++
+				trend.WriteByte('P')
+			case RunStatus_Fail:
+				h.Fails++
+				trend.WriteByte('F')
+				h.LastFailure = run.Output
+			default:
+				trend.WriteByte('S')
+				continue
+			}
+			if prev != "" && prev != run.Status {
+				h.Flips++
+			}
+			prev = run.Status
+			state := run.Revision + "+" + run.Tree
+			if s, ok := stateStatus[state]; ok && s != run.Status {
+				h.Flaky = true
+			}
+			stateStatus[state] = run.Status
+		}
+		h.Trend = trend.String()
+		h.AvgElapsed = totalElapsed / float64(len(runs))
+		summary.Tests = append(summary.Tests, h)
+		if h.Flaky {
+			summary.Flaky = append(summary.Flaky, h)
+		}
+		if !strings.Contains(h.Name, "/") {
+			summary.Slowest = append(summary.Slowest, h)
+		}
+	}
+	sort.SliceStable(summary.Slowest, func(i, j int) bool {
+		return summary.Slowest[i].AvgElapsed > summary.Slowest[j].AvgElapsed
+	})
+	sort.SliceStable(summary.Flaky, func(i, j int) bool {
+		return summary.Flaky[i].Flips > summary.Flaky[j].Flips
+	})
+	if limit > 0 {
+		if len(summary.Slowest) > limit {
+			summary.Slowest = summary.Slowest[:limit]
+		}
+		if len(summary.Flaky) > limit {
+			summary.Flaky = summary.Flaky[:limit]
+		}
+	}
+	return summary
+}
+
+func setupHistoryHandler(server *http.ServeMux, historyFile string) {
+	server.HandleFunc("/history", func(w http.ResponseWriter, r *http.Request) {
+		netutil.SetCORSHeaders(w)
+		netutil.HandleJSON(w, r, func(ctx context.Context, r *http.Request) (interface{}, error) {
+			limit := 10
+			if s := r.URL.Query().Get("limit"); s != "" {
+				var err error
+				limit, err = strconv.Atoi(s)
+				if err != nil {
+					return nil, netutil.ParamErrorf("limit: %v", err)
+				}
+			}
+			records, err := loadHistory(historyFile)
+			if err != nil {
+				return nil, err
+			}
+			return summarizeHistory(records, limit), nil
+		})
+	})
+}
+
+// handleHistory implements xgo e history
+func handleHistory(args []string) error {
+	var projectDir string
+	var format string
+	limit := 10
+	n := len(args)
+	for i := 0; i < n; i++ {
+		arg := args[i]
+		switch arg {
+		case "--project-dir", "--format", "--limit":
+		default:
+			return fmt.Errorf("unrecognized flag: %s", arg)
+		}
+		if i+1 >= n {
+			return fmt.Errorf("%s requires value", arg)
+		}
+		value := args[i+1]
+		i++
+		switch arg {
+		case "--project-dir":
+			projectDir = value
+		case "--format":
+			format = value
+		case "--limit":
+			var err error
+			limit, err = strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s: %v", arg, err)
+			}
+		}
+	}
+	if format != "" && format != "text" && format != "json" {
+		return fmt.Errorf("unrecognized format: %s, expect text or json", format)
+	}
+	_, projectRoot, err := goinfo.FindGoModDirSubPath(projectDir)
+	if err != nil {
+		return err
+	}
+	records, err := loadHistory(filepath.Join(projectRoot, filepath.FromSlash(historyFileName)))
+	if err != nil {
+		return err
+	}
+	summary := summarizeHistory(records, limit)
+	if format == "json" {
+		data, err := json.MarshalIndent(summary, "", "    ")
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(append(data, '\n'))
+		return err
+	}
+	return writeHistorySummary(os.Stdout, summary)
+}
+
+func writeHistorySummary(w io.Writer, summary *HistorySummary) error {
+	if len(summary.Tests) == 0 {
+		fmt.Fprintf(w, "no test history, run tests via xgo e or xgo e test first\n")
+		return nil
+	}
+	fmt.Fprintf(w, "Slowest tests (average of latest %d runs):\n", historyWindow)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "TEST\tAVG\tLAST\tRUNS\tTREND\n")
+	for _, h := range summary.Slowest {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", h.Key(), formatSeconds(h.AvgElapsed), formatSeconds(h.LastElapsed), h.Runs, h.Trend)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(summary.Flaky) == 0 {
+		fmt.Fprintf(w, "\nNo flaky tests.\n")
+		return nil
+	}
+	fmt.Fprintf(w, "\nFlaky tests (passed and failed without code changes):\n")
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "TEST\tPASS\tFAIL\tFLIPS\tTREND\n")
+	for _, h := range summary.Flaky {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\n", h.Key(), h.Passes, h.Fails, h.Flips, h.Trend)
+	}
+	return tw.Flush()
+}
+
+func formatSeconds(s float64) string {
+	return strconv.FormatFloat(s, 'f', 2, 64) + "s"
+}
@@ -0,0 +1,216 @@
+package test_explorer
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSummarizeHistory(t *testing.T) {
+	var records []*TestRecord
+	add := func(rev string, tree string, name string, status RunStatus, elapsed float64) {
+		records = append(records, &TestRecord{Revision: rev, Tree: tree, Pkg: "a", Name: name, Status: status, Elapsed: elapsed})
+	}
+	// fixed by a new revision, not flaky
+	add("r1", "", "TestFixed", RunStatus_Fail, 1)
+	add("r2", "", "TestFixed", RunStatus_Success, 1)
+	// fixed by uncommitted changes, not flaky
+	add("r2", "t1", "TestFixedLocally", RunStatus_Fail, 0.5)
+	add("r2", "t2", "TestFixedLocally", RunStatus_Success, 0.5)
+	// flips on the same revision
+	add("r2", "", "TestFlaky", RunStatus_Success, 3)
+	add("r2", "", "TestFlaky", RunStatus_Fail, 3)
+	add("r2", "", "TestFlaky", RunStatus_Success, 6)
+	add("r2", "", "TestFlaky/sub", RunStatus_Success, 10)
+
+	summary := summarizeHistory(records, 10)
+	if len(summary.Tests) != 4 {
+		t.Fatalf("expect 4 tests, actual: %d", len(summary.Tests))
+	}
+	if len(summary.Flaky) != 1 || summary.Flaky[0].Name != "TestFlaky" {
+		t.Fatalf("expect only TestFlaky flaky, actual: %+v", summary.Flaky)
+	}
+	flaky := summary.Flaky[0]
+	if flaky.Trend != "PFP" || flaky.Flips != 2 || flaky.Passes != 2 || flaky.Fails != 1 || flaky.AvgElapsed != 4 || flaky.LastElapsed != 6 {
+		t.Fatalf("unexpected history: %+v", flaky)
+	}
+	// sub tests are not listed as slowest
+	if len(summary.Slowest) != 3 || summary.Slowest[0].Name != "TestFlaky" || summary.Slowest[1].Name != "TestFixed" {
+		t.Fatalf("unexpected slowest: %+v", summary.Slowest)
+	}
+}
+
+func TestHistoryEscapedOutput(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history.jsonl")
+	// each < is escaped into 6 bytes
+	output := strings.Repeat("<", 10000)
+	for i := 0; i < 2; i++ {
+		err := appendHistory(file, []*TestRecord{{Pkg: "a", Name: "TestHTML", Status: RunStatus_Fail, Output: output}})
+		if err != nil {
+			t.Fatalf("append %d: %v", i, err)
+		}
+	}
+	records, err := loadHistory(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1].Output != output {
+		t.Fatalf("expect 2 records, actual: %d", len(records))
+	}
+}
+
+func TestGetGitState(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("requires git")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) {
+		c := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		c.Dir = dir
+		if out, err := c.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	write := func(name string, content string) {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a_test.go", "package a\n")
+	git("init", "-q")
+	git("add", "-A")
+	git("commit", "-q", "-m", "init")
+
+	rev, tree := getGitState(dir)
+	if rev == "" || tree != "" {
+		t.Fatalf("expect clean revision, actual: %q %q", rev, tree)
+	}
+	// the history does not make it dirty
+	write(historyFileName, "{}\n")
+	if _, tree := getGitState(dir); tree != "" {
+		t.Fatalf("expect clean with history, actual: %q", tree)
+	}
+	write("a_test.go", "package a\n\n// fix\n")
+	_, tree1 := getGitState(dir)
+	write("b_test.go", "package a\n")
+	_, tree2 := getGitState(dir)
+	if tree1 == "" || tree2 == "" || tree1 == tree2 {
+		t.Fatalf("expect different changes, actual: %q %q", tree1, tree2)
+	}
+	if _, tree := getGitState(dir); tree != tree2 {
+		t.Fatalf("expect stable hash %q, actual: %q", tree2, tree)
+	}
+}
+
+func TestTruncateOutputRune(t *testing.T) {
+	// "中" is 3 bytes, the limit falls inside the last one
+	s := strings.Repeat("a", maxHistoryOutput-1) + "中"
+	out := truncateOutput(s)
+	if !utf8.ValidString(out) || out != strings.Repeat("a", maxHistoryOutput-1)+"\n...truncated" {
+		t.Fatalf("expect cut before the rune, actual suffix: %q", out[len(out)-20:])
+	}
+}
+
+func TestObserveLine(t *testing.T) {
+	recorder := &historyRecorder{runID: "1", outputs: make(map[string]*strings.Builder)}
+	for _, line := range []string{
+		"=== RUN   TestA",
+		"=== RUN   TestA/sub",
+		"    a_test.go:10: bad",
+		"    --- FAIL: TestA/sub (0.10s)",
+		"--- FAIL: TestA (0.20s)",
+		"FAIL",
+	} {
+		recorder.observeLine("a", "TestA", line)
+	}
+	records := recorder.records()
+	if len(records) != 2 || records[0].Name != "TestA/sub" || records[1].Name != "TestA" || records[1].Elapsed != 0.2 {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+	if !strings.Contains(records[1].Output, "a_test.go:10: bad") {
+		t.Fatalf("expect failure output, actual: %q", records[1].Output)
+	}
+}
+
+// go test -run TestRunHeadlessRerunFailures -v ./cmd/xgo/test-explorer
+func TestRunHeadlessRerunFailures(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs go test")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("requires go")
+	}
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod": "module example.com/m\n\ngo 1.18\n",
+		// fails only in the first run
+		"a/a_test.go": `package a
+
+import (
+	"os"
+	"testing"
+)
+
+func TestFlaky(t *testing.T) {
+	file := os.Getenv("FLAKY_MARK")
+	if _, err := os.Stat(file); err != nil {
+		os.WriteFile(file, nil, 0644)
+		t.Fatal("first run")
+	}
+}
+
+func TestBroken(t *testing.T) {
+	t.Fatal("always")
+}
+
+func TestOK(t *testing.T) {}
+`,
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conf := &TestConfig{
+		GoCmd: "go",
+		Env:   map[string]interface{}{"FLAKY_MARK": filepath.Join(dir, "flaky.mark")},
+	}
+	historyFile := filepath.Join(dir, filepath.FromSlash(historyFileName))
+	var out bytes.Buffer
+	err := runHeadless(conf, dir, historyFile, []string{"./..."}, 2, &out)
+	if err == nil || err.Error() != "1 test(s) failed consistently" {
+		t.Fatalf("expect TestBroken failed consistently, actual: %v\n%s", err, out.String())
+	}
+	output := out.String()
+	if !strings.Contains(output, "--- FAIL: TestFlaky") || !strings.Contains(output, "--- PASS: TestOK") {
+		t.Fatalf("expect go test -v output, actual:\n%s", output)
+	}
+	if !strings.Contains(output, "flaky       example.com/m/a TestFlaky: passed 2/2 reruns") ||
+		!strings.Contains(output, "consistent  example.com/m/a TestBroken: passed 0/2 reruns") {
+		t.Fatalf("unexpected classification:\n%s", output)
+	}
+
+	records, err := loadHistory(historyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 3 tests, then 2 failed tests rerun twice
+	if len(records) != 7 {
+		t.Fatalf("expect 7 records, actual: %d", len(records))
+	}
+	summary := summarizeHistory(records, 10)
+	if len(summary.Flaky) != 1 || summary.Flaky[0].Name != "TestFlaky" || summary.Flaky[0].Trend != "FPP" {
+		t.Fatalf("expect TestFlaky flaky, actual: %+v", summary.Flaky)
+	}
+}
@@ -41,12 +41,19 @@ type Options struct {
 	Bind   string
 
 	LogConsole bool
+
+	// for xgo e test, rerun failed tests to tell
+	// flaky ones from consistent ones
+	RerunFailures int
 }
 
 func Main(args []string, opts *Options) error {
 	if opts == nil {
 		opts = &Options{}
 	}
+	if len(args) > 0 && args[0] == "history" {
+		return handleHistory(args[1:])
+	}
 	var flagHelp bool
 	n := len(args)
 	var remainArgs []string
@@ -106,6 +113,18 @@ func Main(args []string, opts *Options) error {
 			opts.LogConsole = true
 			continue
 		}
+		if arg == "--rerun-failures" {
+			if i+1 >= n {
+				return fmt.Errorf("%s requires value", arg)
+			}
+			count, err := strconv.Atoi(args[i+1])
+			if err != nil || count < 0 {
+				return fmt.Errorf("%s requires non negative number: %s", arg, args[i+1])
+			}
+			opts.RerunFailures = count
+			i++
+			continue
+		}
 
 		ok, err := flag.TryParseFlagValue("--config", &opts.Config, nil, &i, args)
 		if err != nil {
@@ -195,6 +214,9 @@ type TestingItem struct {
 	Kind         TestingItemKind `json:"kind"`
 	Error        string          `json:"error"`
 
+	// only if Kind==case
+	TestKind TestFuncKind `json:"testKind,omitempty"`
+
 	// only if Kind==dir
 	// indicating any file ends with _test.go
 	// go only
@@ -224,6 +246,7 @@ func (c *TestingItem) CloneSelf() *TestingItem {
 		Line:           c.Line,
 		Kind:           c.Kind,
 		Error:          c.Error,
+		TestKind:       c.TestKind,
 		HasTestGoFiles: c.HasTestGoFiles,
 		HasTestCases:   c.HasTestCases,
 		State:          c.State.Clone(),
@@ -344,6 +367,7 @@ func handle(opts *Options, args []string) error {
 	if conf.Xgo != nil && conf.Xgo.AutoUpdate && os.Getenv("XGO_AUTO_UPDATE") != "never" {
 		autoUpdateXgo()
 	}
+	historyFile := filepath.Join(projectRoot, filepath.FromSlash(historyFileName))
 	if len(args) > 0 && args[0] == "test" {
 		root, err := scanTests(projectRoot, subPath, true, conf.Exclude)
 		if err != nil {
@@ -353,8 +377,8 @@ func handle(opts *Options, args []string) error {
 		paths, _, names := getTestPaths(root, nil)
 		pathArgs := formatPathArgs(paths)
 		runNames := formatRunNames(names)
-		testArgs := joinTestArgs(pathArgs, runNames)
-		return runTest(conf.GoCmd, projectDir, conf.Flags, testArgs, conf.Args, conf.CmdEnv(), nil, nil)
+		testArgs := joinTestArgs(pathArgs, getRunFlags(TestFuncKind_Test, runNames, ""))
+		return runHeadless(conf, projectDir, historyFile, testArgs, opts.RerunFailures, os.Stdout)
 	}
 
 	server := &http.ServeMux{}
@@ -419,10 +443,11 @@ func handle(opts *Options, args []string) error {
 		})
 	})
 
-	setupRunHandler(server, projectDir, opts.LogConsole, getTestConfig)
+	setupRunHandler(server, projectDir, historyFile, opts.LogConsole, getTestConfig)
 	setupDebugHandler(server, projectDir, getTestConfig)
 	setupTestHandler(server, projectDir, getTestConfig)
 	setupOpenHandler(server)
+	setupHistoryHandler(server, historyFile)
 
 	host, port := netutil.GetHostAndIP(opts.Bind, opts.Port)
 	autoIncrPort := true
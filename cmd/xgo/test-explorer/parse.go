@@ -85,6 +85,7 @@ func parseTests(absDir string, absFile string) ([]*TestingItem, error) {
 			File:         absFile,
 			Line:         fset.Position(fnDecl.Pos()).Line,
 			Kind:         TestingItemKind_Case,
+			TestKind:     getTestFuncKind(name),
 		})
 	}
 	return items, nil
@@ -109,13 +110,8 @@ func parseTestFuncsCode(file string, code io.Reader) (*token.FileSet, []*ast.Fun
 		if fnDecl.Name == nil {
 			continue
 		}
-		if !strings.HasPrefix(fnDecl.Name.Name, "Test") {
-			continue
-		}
-		if fnDecl.Body == nil {
-			continue
-		}
-		if fnDecl.Type.Params == nil || len(fnDecl.Type.Params.List) != 1 {
+		kind := getTestFuncKind(fnDecl.Name.Name)
+		if kind == "" || !isTestFunc(fnDecl, kind, astFile.Comments) {
 			continue
 		}
 		results = append(results, fnDecl)
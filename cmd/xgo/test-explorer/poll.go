@@ -137,6 +137,7 @@ func startRun(sess session.Session, projectDir string, absDir string, file strin
 			Stderr:        io.MultiWriter(os.Stderr, pw),
 
 			GoCmd:      config.GetGoCmd(),
+			FuzzTime:   config.GetFuzzTime(),
 			BuildFlags: append(config.Flags, testFlags...),
 			Env:        config.CmdEnv(),
 			Args:       testArgs,
@@ -173,6 +174,7 @@ type RunContext struct {
 
 	GoCmd      string
 	BuildFlags []string
+	FuzzTime   string
 
 	Env  []string
 	Args []string
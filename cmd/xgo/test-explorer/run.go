@@ -39,6 +39,7 @@ type TestingItemEvent struct {
 	Msg          string        `json:"msg"`
 	LogConsole   bool          `json:"logConsole"`
 	TraceRecords []*CallRecord `json:"traceRecords"`
+	Bench        *BenchResult  `json:"bench,omitempty"`
 }
 
 type PollSessionRequest struct {
@@ -59,6 +60,10 @@ type runSession struct {
 	env       []string
 	testFlags []string
 	progArgs  []string
+	fuzzTime  string
+
+	// empty if not recorded
+	historyFile string
 
 	pathPrefix []string
 
@@ -110,15 +115,29 @@ func escapeRegexName(name string) string {
 	return replacer.Replace(name)
 }
 
-func joinTestArgs(pathArgs []string, runNames string) []string {
-	args := make([]string, 0, len(pathArgs))
-	if runNames != "" {
-		args = append(args, "-run", runNames)
-	}
+func joinTestArgs(pathArgs []string, runFlags []string) []string {
+	args := make([]string, 0, len(runFlags)+len(pathArgs))
+	args = append(args, runFlags...)
 	args = append(args, pathArgs...)
 	return args
 }
 
+// getCaseTestKind returns kind of a case, sub cases
+// have the same kind as the base case
+func getCaseTestKind(item *TestingItem) TestFuncKind {
+	if item.TestKind != "" {
+		return item.TestKind
+	}
+	name := item.BaseCaseName
+	if name == "" {
+		name = item.Name
+	}
+	if kind := getTestFuncKind(name); kind != "" {
+		return kind
+	}
+	return TestFuncKind_Test
+}
+
 func getTestPaths(item *TestingItem, pathPrefix []string) (paths []string, itemPaths [][]string, names []string) {
 	switch item.Kind {
 	case TestingItemKind_Case:
@@ -133,6 +152,7 @@ func getTestPaths(item *TestingItem, pathPrefix []string) (paths []string, itemP
 		fileItemPath := getCaseItemPath(pathPrefix, item.RelPath, item.Name, item.NameUnderPkg)
 		itemPaths = [][]string{fileItemPath}
 	case TestingItemKind_File:
+		// benchmarks are only run when selected
 		relPath, cases := getFileSubCases(item)
 		paths = []string{relPath}
 
@@ -181,6 +201,9 @@ func getCaseItemPath(prefix []string, relPath string, name string, nameUnderPkg
 // emulate the ./pkg/... behavior
 func getAllSubRelPaths(t *TestingItem, pathPrefix []string) (relPaths []string, itemPaths [][]string) {
 	if t.Kind == TestingItemKind_Case {
+		if getCaseTestKind(t) == TestFuncKind_Benchmark {
+			return nil, nil
+		}
 		itemPaths = append(itemPaths, getCaseItemPath(pathPrefix, t.RelPath, t.Name, t.NameUnderPkg))
 	} else {
 		itemPaths = append(itemPaths, getFileItemPath(pathPrefix, t.RelPath))
@@ -200,7 +223,7 @@ func getAllSubRelPaths(t *TestingItem, pathPrefix []string) (relPaths []string,
 func getFileSubCases(t *TestingItem) (arg string, cases []string) {
 	arg = filepath.Dir(t.RelPath)
 	for _, child := range t.Children {
-		if child.Kind != TestingItemKind_Case {
+		if child.Kind != TestingItemKind_Case || getCaseTestKind(child) == TestFuncKind_Benchmark {
 			continue
 		}
 		cases = append(cases, child.Name)
@@ -256,6 +279,7 @@ func makeMergeTree(subPath []string, prefixName string, item *TestingItem) *Test
 		File:           item.File,
 		Line:           item.Line,
 		Kind:           TestingItemKind_Case,
+		TestKind:       item.TestKind,
 		HasTestGoFiles: item.HasTestGoFiles,
 		HasTestCases:   item.HasTestCases,
 		State: &TestingItemState{
@@ -289,7 +313,16 @@ func run(ctx *RunContext) error {
 	stdout := ctx.Stdout
 	verbose := true
 
-	args := []string{"test", "-run", fmt.Sprintf("^%s$", name)}
+	baseName := name
+	if idx := strings.Index(name, "/"); idx >= 0 {
+		baseName = name[:idx]
+	}
+	kind := getTestFuncKind(baseName)
+	if kind == "" {
+		kind = TestFuncKind_Test
+	}
+	args := []string{"test"}
+	args = append(args, getRunFlags(kind, fmt.Sprintf("^%s$", name), ctx.FuzzTime)...)
 	if verbose {
 		args = append(args, "-v")
 	}
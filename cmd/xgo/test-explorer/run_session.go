@@ -33,7 +33,7 @@ type StartSessionRequest struct {
 }
 
 // TODO: make FE call /session/destroy
-func setupRunHandler(server *http.ServeMux, projectDir string, logConsole bool, getTestConfig func() (*TestConfig, error)) {
+func setupRunHandler(server *http.ServeMux, projectDir string, historyFile string, logConsole bool, getTestConfig func() (*TestConfig, error)) {
 	sessionManager := session.NewSessionManager()
 
 	server.HandleFunc("/session/start", func(w http.ResponseWriter, r *http.Request) {
@@ -54,6 +54,9 @@ func setupRunHandler(server *http.ServeMux, projectDir string, logConsole bool,
 			if req.Trace && req.Item.Kind != TestingItemKind_Case {
 				return nil, netutil.ParamErrorf("trace not supported: %s", req.Item.Kind)
 			}
+			if req.Debug && getCaseTestKind(req.Item) == TestFuncKind_Fuzz {
+				return nil, netutil.ParamErrorf("debug not supported: %s", TestFuncKind_Fuzz)
+			}
 
 			config, err := getTestConfig()
 			if err != nil {
@@ -77,6 +80,9 @@ func setupRunHandler(server *http.ServeMux, projectDir string, logConsole bool,
 				env:       config.CmdEnv(),
 				testFlags: config.Flags,
 				progArgs:  config.Args,
+				fuzzTime:  config.GetFuzzTime(),
+
+				historyFile: historyFile,
 
 				pathPrefix: []string{getRootName(absDir)},
 
@@ -207,12 +213,12 @@ func (c *runSession) Start() error {
 	trace := c.trace
 
 	begin := time.Now()
-	_ = begin
 
 	dirPkgPath, err := resolveDirPkgPath(absDir)
 	if err != nil {
 		return err
 	}
+	recorder := newHistoryRecorder(absDir)
 
 	// record status
 	pm := &pathMapping{}
@@ -256,10 +262,30 @@ func (c *runSession) Start() error {
 	}
 
 	var singleCase bool
+	kind := TestFuncKind_Test
 	var eventBuilder func(line []byte) ([]*TestingItemEvent, error)
 	if item.Kind == TestingItemKind_Case {
 		singleCase = true
-		eventBuilder = plainMsgBuilder
+		kind = getCaseTestKind(item)
+		casePkg, caseName := getCasePkgAndName(dirPkgPath, item)
+		eventBuilder = func(line []byte) ([]*TestingItemEvent, error) {
+			events, err := plainMsgBuilder(line)
+			if err != nil {
+				return nil, err
+			}
+			recorder.observeLine(casePkg, caseName, string(line))
+			if kind == TestFuncKind_Benchmark {
+				if res, ok := parseBenchLine(string(line)); ok {
+					events = append(events, &TestingItemEvent{
+						Event: Event_ItemStatus,
+						Path:  rootPath,
+						Msg:   res.String(),
+						Bench: res,
+					})
+				}
+			}
+			return events, nil
+		}
 	} else {
 		tResolver := &testResolver{
 			absDir:     absDir,
@@ -270,6 +296,7 @@ func (c *runSession) Start() error {
 			dirPkgPath:   dirPkgPath,
 			testResolver: tResolver,
 			pm:           pm,
+			recorder:     recorder,
 		}
 		eventBuilder = jsonTestEventBuilder.build
 	}
@@ -337,10 +364,10 @@ func (c *runSession) Start() error {
 		}
 
 		if !debug {
-			testArgs := joinTestArgs(pathArgs, runNames)
+			testArgs := joinTestArgs(pathArgs, getRunFlags(kind, runNames, c.fuzzTime))
 			err = runTest(c.goCmd, c.dir, testFlags, testArgs, c.progArgs, c.env, stdout, stderr)
 		} else {
-			err = debugTest(c.goCmd, c.dir, item.File, testFlags, pathArgs, runNames, stdout, stderr, c.progArgs, c.env)
+			err = debugTest(c.goCmd, c.dir, item.File, testFlags, pathArgs, kind, runNames, stdout, stderr, c.progArgs, c.env)
 		}
 
 		if err != nil {
@@ -369,6 +396,17 @@ func (c *runSession) Start() error {
 			if err != nil {
 				sendEvent(&TestingItemEvent{Event: Event_ItemStatus, Path: rootPath, Msg: err.Error(), Status: RunStatus_Fail})
 			}
+			if !debug && c.historyFile != "" {
+				records := recorder.records()
+				if singleCase && len(records) == 0 {
+					// e.g. benchmarks
+					records = []*TestRecord{caseRecord(recorder, dirPkgPath, item, err, time.Since(begin))}
+				}
+				saveErr := appendHistory(c.historyFile, records)
+				if saveErr != nil {
+					fmt.Fprintf(os.Stderr, "save test history: %v\n", saveErr)
+				}
+			}
 
 			// set all sub cases as success
 			pm.Range(func(path []string, status RunStatus) bool {
@@ -580,7 +618,8 @@ type jsonTestEventBuilder struct {
 	dirPkgPath   string
 	testResolver *testResolver
 
-	pm *pathMapping
+	pm       *pathMapping
+	recorder *historyRecorder
 
 	// parser
 	prefix []string
@@ -591,6 +630,7 @@ func (c *jsonTestEventBuilder) build(line []byte) ([]*TestingItemEvent, error) {
 	if err != nil {
 		return nil, err
 	}
+	c.recorder.observe(event)
 	return buildEvent(event, c.pathPrefix, c.dirPkgPath, c.pm, c.testResolver)
 }
 
@@ -651,3 +691,34 @@ func getConsistentTraceDir(projectDir string, subDir string) (string, error) {
 
 	return fullPath, nil
 }
+
+// caseRecord records a single case without result lines
+func caseRecord(recorder *historyRecorder, dirPkgPath string, item *TestingItem, err error, elapsed time.Duration) *TestRecord {
+	pkg, name := getCasePkgAndName(dirPkgPath, item)
+	status := RunStatus_Success
+	if err != nil {
+		status = RunStatus_Fail
+	}
+	return &TestRecord{
+		RunID:    recorder.runID,
+		Time:     time.Now(),
+		Revision: recorder.revision,
+		Tree:     recorder.tree,
+		Pkg:      pkg,
+		Name:     name,
+		Status:   status,
+		Elapsed:  elapsed.Seconds(),
+	}
+}
+
+func getCasePkgAndName(dirPkgPath string, item *TestingItem) (pkg string, name string) {
+	pkg = dirPkgPath
+	if dir := filepath.Dir(item.RelPath); dir != "." {
+		pkg += "/" + filepath.ToSlash(dir)
+	}
+	name = item.NameUnderPkg
+	if name == "" {
+		name = item.Name
+	}
+	return pkg, name
+}
@@ -100,11 +100,40 @@ func (c *traceConverter) convertStack(stack *StackExport) *CallRecord {
 
 		Args: args,
 
-		Error: stack.Error,
-		Panic: stack.Panic,
+		MockStatus: getMockStatus(stack),
+		Error:      stack.Error,
+		Panic:      stack.Panic,
 
 		Result: results,
+		Log:    convertLogs(stack.Logs),
 
 		Children: c.convertStacks(stack.Children),
 	}
 }
+
+func getMockStatus(stack *StackExport) MockStatus {
+	failed := stack.Error != "" || stack.Panic
+	if stack.Mocked {
+		if failed {
+			return MockStatus_MockError
+		}
+		return MockStatus_MockResp
+	}
+	if failed {
+		return MockStatus_NormalError
+	}
+	return MockStatus_NormalResp
+}
+
+// convertLogs returns nil when there is no log,
+// so that the field is rendered as absent
+func convertLogs(logs []*LogEntryExport) interface{} {
+	if len(logs) == 0 {
+		return nil
+	}
+	msgs := make([]string, 0, len(logs))
+	for _, log := range logs {
+		msgs = append(msgs, log.Msg)
+	}
+	return msgs
+}
@@ -34,9 +34,19 @@ type StackExport struct {
 	Panic bool
 	Error string
 
+	// answered by a mock instead of the real function
+	Mocked bool
+	// lines logged via Log or LogWriter during the call
+	Logs []*LogEntryExport
+
 	Children []*StackExport
 }
 
+type LogEntryExport struct {
+	Time int64 // relative to root begin
+	Msg  string
+}
+
 type FuncInfoExport struct {
 	// FullName string
 	Kind         FuncKind
@@ -64,3 +74,40 @@ type FuncInfoExport struct {
 	// last last result error
 	LastResultErr bool
 }
+
+// LiveSpanExport is a completed call pushed to
+// a live server started by `xgo tool trace --live`
+type LiveSpanExport struct {
+	Trace int64 // id of the trace the call belongs to
+	// name of the trace, empty for traces
+	// named after the goroutine
+	Name   string
+	ID     int64
+	Parent int64 // 0 for top level calls
+	// Stack has no Children, they are pushed
+	// as separate spans before the call completes
+	Stack *StackExport
+}
+
+// TestCoverageExport lists functions called by a test,
+// written by `xgo test --cover-per-test`
+type TestCoverageExport struct {
+	Pkg   string
+	Test  string
+	Funcs []*FuncHitExport
+}
+
+// CallCoverageExport lists functions invoked since the previous
+// line of the same test binary, the first line also lists those
+// not invoked with Count 0, written by `xgo test --call-coverage`
+type CallCoverageExport struct {
+	Funcs []*FuncHitExport
+}
+
+type FuncHitExport struct {
+	Pkg          string
+	IdentityName string
+	File         string
+	Line         int
+	Count        int64
+}
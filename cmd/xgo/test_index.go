@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xhd2015/xgo/support/coverage"
+	"github.com/xhd2015/xgo/support/git"
+)
+
+// written by runtime/trace, one line per test
+type testCoverageExport struct {
+	Pkg   string
+	Test  string
+	Funcs []*funcHitExport
+}
+
+type funcHitExport struct {
+	Pkg          string
+	IdentityName string
+	File         string
+	Line         int
+	Count        int64
+}
+
+// saveTestIndex updates the test index with functions
+// called by tests of this run
+func saveTestIndex(rawFile string, projectDir string) error {
+	root, err := resolveProjectRoot(projectDir)
+	if err != nil {
+		return err
+	}
+	indexFile, n, err := updateTestIndex(rawFile, root)
+	if err != nil {
+		return err
+	}
+	if indexFile != "" {
+		fmt.Fprintf(os.Stderr, "test index: %d test(s) updated in %s\n", n, indexFile)
+	}
+	return nil
+}
+
+// resolveProjectRoot returns the git top level of
+// projectDir, or projectDir if not in a git repo
+func resolveProjectRoot(projectDir string) (string, error) {
+	dir, err := filepath.Abs(projectDir)
+	if err != nil {
+		return "", err
+	}
+	topLevel, err := git.ShowTopLevel(dir)
+	if err == nil && topLevel != "" {
+		return topLevel, nil
+	}
+	return dir, nil
+}
+
+// updateTestIndex merges functions called by each
+// test from rawFile into the index under root
+func updateTestIndex(rawFile string, root string) (string, int, error) {
+	file, err := os.Open(rawFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			// no test run
+			return "", 0, nil
+		}
+		return "", 0, err
+	}
+	defer file.Close()
+
+	var entries []*coverage.TestEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var export testCoverageExport
+		if json.Unmarshal(scanner.Bytes(), &export) != nil {
+			continue
+		}
+		entry := &coverage.TestEntry{
+			Pkg:   export.Pkg,
+			Name:  export.Test,
+			Funcs: make([]*coverage.FuncHit, 0, len(export.Funcs)),
+		}
+		for _, f := range export.Funcs {
+			entry.Funcs = append(entry.Funcs, &coverage.FuncHit{
+				Pkg:   f.Pkg,
+				Name:  f.IdentityName,
+				File:  relativeToRoot(root, f.File),
+				Line:  f.Line,
+				Count: f.Count,
+			})
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return "", 0, err
+	}
+
+	indexFile := filepath.Join(root, filepath.FromSlash(coverage.TestIndexFile))
+	index, err := coverage.LoadTestIndex(indexFile)
+	if err != nil {
+		return "", 0, err
+	}
+	index.Update(entries)
+	err = index.Save(indexFile)
+	if err != nil {
+		return "", 0, err
+	}
+	return indexFile, len(entries), nil
+}
+
+func relativeToRoot(root string, file string) string {
+	if file == "" || !filepath.IsAbs(file) {
+		return filepath.ToSlash(file)
+	}
+	rel, err := filepath.Rel(root, file)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return filepath.ToSlash(file)
+	}
+	return filepath.ToSlash(rel)
+}
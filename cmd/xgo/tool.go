@@ -29,6 +29,7 @@ The commands are:
 
 Examples:
     xgo tool trace TestSomething.json     visualize a generated trace
+    xgo tool trace profile -o out.pprof . aggregate traces into a pprof profile
     xgo tool test-explorer                open test explorer UI
     xgo tool coverage serve cover.out     visualize incremental coverage of cover.out
 
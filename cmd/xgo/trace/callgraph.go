@@ -0,0 +1,315 @@
+package trace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// CallGraph is the dynamic call graph aggregated from
+// traces, every caller-callee pair observed is an edge.
+// All durations are in nanoseconds.
+type CallGraph struct {
+	// ByPackage tells nodes are packages
+	ByPackage bool
+
+	Nodes []*CallGraphNode
+	Edges []*CallGraphEdge
+}
+
+// CallGraphNode is a function, or a package
+// when the graph is collapsed by package
+type CallGraphNode struct {
+	ID   string // pkg.IdentityName, or pkg when collapsed
+	Pkg  string
+	Name string // IdentityName, or pkg when collapsed
+	File string
+	Line int
+
+	Calls  int64
+	Errors int64 // calls returned error or panicked
+	// wall time, recursive calls are counted once
+	Total int64
+}
+
+type CallGraphEdge struct {
+	Caller string // node ID
+	Callee string // node ID
+
+	Calls  int64
+	Errors int64
+	// wall time of the callee, recursive calls are counted once
+	Total int64
+}
+
+// ErrorRate is the fraction of calls failed
+func (c *CallGraphEdge) ErrorRate() float64 {
+	if c.Calls == 0 {
+		return 0
+	}
+	return float64(c.Errors) / float64(c.Calls)
+}
+
+type CallGraphOptions struct {
+	// ByPackage collapses functions of the same package
+	// into one node, calls within a package are dropped
+	ByPackage bool
+}
+
+type callGraphBuilder struct {
+	opts  *CallGraphOptions
+	nodes map[string]*CallGraphNode
+	edges map[[2]string]*CallGraphEdge
+
+	// nodes and edges on the current call path,
+	// to count recursive calls once
+	activeNodes map[string]int
+	activeEdges map[[2]string]int
+}
+
+// BuildCallGraph aggregates the call trees of all roots
+func BuildCallGraph(roots []*RootExport, opts *CallGraphOptions) *CallGraph {
+	if opts == nil {
+		opts = &CallGraphOptions{}
+	}
+	b := &callGraphBuilder{
+		opts:        opts,
+		nodes:       make(map[string]*CallGraphNode),
+		edges:       make(map[[2]string]*CallGraphEdge),
+		activeNodes: make(map[string]int),
+		activeEdges: make(map[[2]string]int),
+	}
+	for _, root := range roots {
+		if root == nil {
+			continue
+		}
+		for _, stack := range root.Children {
+			b.add(stack, "")
+		}
+	}
+	graph := &CallGraph{ByPackage: opts.ByPackage}
+	for _, node := range b.nodes {
+		graph.Nodes = append(graph.Nodes, node)
+	}
+	for _, edge := range b.edges {
+		graph.Edges = append(graph.Edges, edge)
+	}
+	sort.Slice(graph.Nodes, func(i, j int) bool {
+		return graph.Nodes[i].ID < graph.Nodes[j].ID
+	})
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		a, b := graph.Edges[i], graph.Edges[j]
+		if a.Caller != b.Caller {
+			return a.Caller < b.Caller
+		}
+		return a.Callee < b.Callee
+	})
+	return graph
+}
+
+func (c *callGraphBuilder) nodeOf(stack *StackExport) *CallGraphNode {
+	name := "<unknown>"
+	var pkg, file string
+	var line int
+	if stack.FuncInfo != nil {
+		pkg = stack.FuncInfo.Pkg
+		file = stack.FuncInfo.File
+		line = stack.FuncInfo.Line
+		name = stack.FuncInfo.IdentityName
+	}
+	id := name
+	if c.opts.ByPackage {
+		if pkg == "" {
+			pkg = "<unknown>"
+		}
+		id, name, file, line = pkg, pkg, "", 0
+	} else if pkg != "" {
+		id = pkg + "." + name
+	}
+	node := c.nodes[id]
+	if node == nil {
+		node = &CallGraphNode{
+			ID:   id,
+			Pkg:  pkg,
+			Name: name,
+			File: file,
+			Line: line,
+		}
+		c.nodes[id] = node
+	}
+	return node
+}
+
+func (c *callGraphBuilder) add(stack *StackExport, caller string) {
+	if stack == nil {
+		return
+	}
+	node := c.nodeOf(stack)
+	cost := stack.End - stack.Begin
+	if cost < 0 {
+		cost = 0
+	}
+	failed := stack.Error != "" || stack.Panic
+
+	node.Calls++
+	if failed {
+		node.Errors++
+	}
+	if c.activeNodes[node.ID] == 0 {
+		node.Total += cost
+	}
+	c.activeNodes[node.ID]++
+	defer func() { c.activeNodes[node.ID]-- }()
+
+	// calls within the same node are not edges when
+	// collapsed, the callee continues as the caller
+	if caller != "" && !(c.opts.ByPackage && caller == node.ID) {
+		key := [2]string{caller, node.ID}
+		edge := c.edges[key]
+		if edge == nil {
+			edge = &CallGraphEdge{Caller: caller, Callee: node.ID}
+			c.edges[key] = edge
+		}
+		edge.Calls++
+		if failed {
+			edge.Errors++
+		}
+		if c.activeEdges[key] == 0 {
+			edge.Total += cost
+		}
+		c.activeEdges[key]++
+		defer func() { c.activeEdges[key]-- }()
+	}
+	for _, child := range stack.Children {
+		c.add(child, node.ID)
+	}
+}
+
+// edgeLabel describes an edge as: 3 calls, 12ms, 33% errors
+func edgeLabel(edge *CallGraphEdge) string {
+	label := fmt.Sprintf("%d calls, %s", edge.Calls, formatCost(0, edge.Total))
+	if edge.Calls == 1 {
+		label = fmt.Sprintf("1 call, %s", formatCost(0, edge.Total))
+	}
+	if edge.Errors > 0 {
+		label += fmt.Sprintf(", %.0f%% errors", edge.ErrorRate()*100)
+	}
+	return label
+}
+
+func nodeLabelStats(node *CallGraphNode) string {
+	if node.Calls == 1 {
+		return fmt.Sprintf("1 call, %s", formatCost(0, node.Total))
+	}
+	return fmt.Sprintf("%d calls, %s", node.Calls, formatCost(0, node.Total))
+}
+
+// packages groups node indexes by package, in order of first appearance
+func (c *CallGraph) packages() ([]string, map[string][]int) {
+	var pkgs []string
+	byPkg := make(map[string][]int)
+	for i, node := range c.Nodes {
+		if _, ok := byPkg[node.Pkg]; !ok {
+			pkgs = append(pkgs, node.Pkg)
+		}
+		byPkg[node.Pkg] = append(byPkg[node.Pkg], i)
+	}
+	return pkgs, byPkg
+}
+
+// WriteDOT renders the graph in graphviz DOT, functions are
+// clustered by package, edges with errors are drawn red
+func (c *CallGraph) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	q := func(s string) string {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+	}
+	fmt.Fprintf(bw, "digraph callgraph {\n")
+	fmt.Fprintf(bw, "\trankdir=LR;\n")
+	fmt.Fprintf(bw, "\tnode [shape=box, fontname=\"monospace\"];\n")
+	fmt.Fprintf(bw, "\tedge [fontname=\"monospace\", fontsize=10];\n")
+
+	writeNode := func(indent string, node *CallGraphNode, label string) {
+		attrs := "label=" + q(label+"\n"+nodeLabelStats(node))
+		if node.Errors > 0 {
+			attrs += ", color=red"
+		}
+		fmt.Fprintf(bw, "%s%s [%s];\n", indent, q(node.ID), attrs)
+	}
+	if c.ByPackage {
+		for _, node := range c.Nodes {
+			writeNode("\t", node, node.Name)
+		}
+	} else {
+		pkgs, byPkg := c.packages()
+		for i, pkg := range pkgs {
+			fmt.Fprintf(bw, "\tsubgraph cluster_%d {\n", i)
+			fmt.Fprintf(bw, "\t\tlabel=%s;\n", q(pkg))
+			for _, idx := range byPkg[pkg] {
+				writeNode("\t\t", c.Nodes[idx], c.Nodes[idx].Name)
+			}
+			fmt.Fprintf(bw, "\t}\n")
+		}
+	}
+	for _, edge := range c.Edges {
+		attrs := "label=" + q(edgeLabel(edge))
+		if edge.Errors > 0 {
+			attrs += ", color=red, fontcolor=red"
+		}
+		fmt.Fprintf(bw, "\t%s -> %s [%s];\n", q(edge.Caller), q(edge.Callee), attrs)
+	}
+	fmt.Fprintf(bw, "}\n")
+	return bw.Flush()
+}
+
+// WriteMermaid renders the graph as a mermaid flowchart,
+// which can be embedded in markdown
+func (c *CallGraph) WriteMermaid(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	// mermaid has no escaping inside quoted labels
+	// other than entity codes
+	esc := strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;").Replace
+	q := func(s string) string {
+		return `"` + esc(s) + `"`
+	}
+	ids := make(map[string]string, len(c.Nodes))
+	for i, node := range c.Nodes {
+		ids[node.ID] = fmt.Sprintf("n%d", i)
+	}
+	fmt.Fprintf(bw, "flowchart LR\n")
+	writeNode := func(indent string, node *CallGraphNode) {
+		fmt.Fprintf(bw, "%s%s[\"%s<br/>%s\"]\n", indent, ids[node.ID], esc(node.Name), esc(nodeLabelStats(node)))
+	}
+	if c.ByPackage {
+		for _, node := range c.Nodes {
+			writeNode("    ", node)
+		}
+	} else {
+		pkgs, byPkg := c.packages()
+		for i, pkg := range pkgs {
+			fmt.Fprintf(bw, "    subgraph p%d[%s]\n", i, q(pkg))
+			for _, idx := range byPkg[pkg] {
+				writeNode("        ", c.Nodes[idx])
+			}
+			fmt.Fprintf(bw, "    end\n")
+		}
+	}
+	var errorEdges []string
+	for i, edge := range c.Edges {
+		fmt.Fprintf(bw, "    %s -->|%s| %s\n", ids[edge.Caller], q(edgeLabel(edge)), ids[edge.Callee])
+		if edge.Errors > 0 {
+			errorEdges = append(errorEdges, fmt.Sprint(i))
+		}
+	}
+	if len(errorEdges) > 0 {
+		fmt.Fprintf(bw, "    linkStyle %s stroke:red\n", strings.Join(errorEdges, ","))
+	}
+	for _, node := range c.Nodes {
+		if node.Errors > 0 {
+			fmt.Fprintf(bw, "    style %s stroke:red\n", ids[node.ID])
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,253 @@
+// this script runs after graph is defined
+// graph: {ByPackage, Nodes: [{ID, Pkg, Name, File, Line, Calls, Errors, Total}], Edges: [{Caller, Callee, Calls, Errors, Total}]}
+
+const nodeWidth = 220
+const nodeHeight = 40
+const colGap = 120
+const rowGap = 16
+const svgNS = "http://www.w3.org/2000/svg"
+
+const container = document.getElementById("graph")
+const info = document.getElementById("graph-info")
+const graphStatus = document.getElementById("graph-status")
+
+const nodeByID = {}
+const outEdges = {}
+const inEdges = {}
+for (const node of graph.Nodes || []) {
+    nodeByID[node.ID] = node
+    outEdges[node.ID] = []
+    inEdges[node.ID] = []
+}
+for (const edge of graph.Edges || []) {
+    outEdges[edge.Caller].push(edge)
+    inEdges[edge.Callee].push(edge)
+}
+
+let selectedID = ""
+
+function formatNs(ns) {
+    const units = [["ns", 1], ["μs", 1000], ["ms", 1000], ["s", 1000]]
+    let v = ns
+    let name = units[0][0]
+    for (let i = 1; i < units.length; i++) {
+        if (v < units[i][1]) {
+            break
+        }
+        v = v / units[i][1]
+        name = units[i][0]
+    }
+    return (Math.round(v * 100) / 100) + name
+}
+
+function describe(stats) {
+    let s = `${stats.Calls} call${stats.Calls === 1 ? "" : "s"}, ${formatNs(stats.Total)}`
+    if (stats.Errors > 0) {
+        s += `, ${Math.round(stats.Errors * 100 / stats.Calls)}% errors`
+    }
+    return s
+}
+
+// layout ranks nodes by the longest path from callers,
+// edges closing a cycle are ignored
+function layout() {
+    const rank = {}
+    const state = {}
+    const back = new Set()
+    function visit(id) {
+        state[id] = "visiting"
+        for (const edge of outEdges[id]) {
+            if (state[edge.Callee] === "visiting") {
+                back.add(edge)
+            } else if (!state[edge.Callee]) {
+                visit(edge.Callee)
+            }
+        }
+        state[id] = "done"
+    }
+    // visit entries first so they get rank 0
+    for (const node of graph.Nodes || []) {
+        if (inEdges[node.ID].length === 0) {
+            visit(node.ID)
+        }
+    }
+    for (const node of graph.Nodes || []) {
+        if (!state[node.ID]) {
+            visit(node.ID)
+        }
+    }
+    // relax in topological order
+    const indegree = {}
+    for (const node of graph.Nodes || []) {
+        indegree[node.ID] = 0
+        rank[node.ID] = 0
+    }
+    for (const edge of graph.Edges || []) {
+        if (!back.has(edge)) {
+            indegree[edge.Callee]++
+        }
+    }
+    const queue = (graph.Nodes || []).filter(node => indegree[node.ID] === 0).map(node => node.ID)
+    while (queue.length > 0) {
+        const id = queue.shift()
+        for (const edge of outEdges[id]) {
+            if (back.has(edge)) {
+                continue
+            }
+            rank[edge.Callee] = Math.max(rank[edge.Callee], rank[id] + 1)
+            if (--indegree[edge.Callee] === 0) {
+                queue.push(edge.Callee)
+            }
+        }
+    }
+    const columns = []
+    for (const node of graph.Nodes || []) {
+        const r = rank[node.ID]
+        columns[r] = columns[r] || []
+        columns[r].push(node.ID)
+    }
+    const pos = {}
+    columns.forEach((ids, col) => {
+        ids.forEach((id, row) => {
+            pos[id] = {
+                x: 10 + col * (nodeWidth + colGap),
+                y: 10 + row * (nodeHeight + rowGap),
+            }
+        })
+    })
+    const width = 20 + columns.length * (nodeWidth + colGap)
+    const height = 20 + Math.max(0, ...columns.map(ids => ids.length)) * (nodeHeight + rowGap)
+    return { pos, width, height }
+}
+
+function el(tag, attrs) {
+    const e = document.createElementNS(svgNS, tag)
+    for (const k in attrs) {
+        e.setAttribute(k, attrs[k])
+    }
+    return e
+}
+
+function truncate(s, n) {
+    return s.length > n ? s.slice(0, n - 1) + "…" : s
+}
+
+const edgeEls = []
+const nodeEls = {}
+
+function render() {
+    const { pos, width, height } = layout()
+    const svg = el("svg", { width, height })
+    for (const edge of graph.Edges || []) {
+        const from = pos[edge.Caller]
+        const to = pos[edge.Callee]
+        const x1 = from.x + nodeWidth
+        const y1 = from.y + nodeHeight / 2
+        const x2 = to.x
+        const y2 = to.y + nodeHeight / 2
+        let d
+        if (x2 > x1) {
+            const mid = (x1 + x2) / 2
+            d = `M${x1},${y1} C${mid},${y1} ${mid},${y2} ${x2},${y2}`
+        } else {
+            // edges back to the same or an earlier column loop around
+            const top = Math.min(from.y, to.y) - rowGap / 2
+            d = `M${x1},${y1} C${x1 + colGap / 2},${top} ${x2 - colGap / 2},${top} ${x2},${y2}`
+        }
+        const path = el("path", {
+            d,
+            class: "graph-edge" + (edge.Errors > 0 ? " error" : ""),
+            "stroke-width": 1 + Math.log10(edge.Calls),
+            "marker-end": "url(#arrow)",
+        })
+        const title = el("title", {})
+        title.textContent = `${edge.Caller} -> ${edge.Callee}\n${describe(edge)}`
+        path.appendChild(title)
+        svg.appendChild(path)
+        edgeEls.push({ edge, path })
+    }
+    const defs = el("defs", {})
+    const marker = el("marker", { id: "arrow", viewBox: "0 0 10 10", refX: 10, refY: 5, markerWidth: 6, markerHeight: 6, orient: "auto" })
+    marker.appendChild(el("path", { d: "M0,0 L10,5 L0,10 z", fill: "rgb(150, 150, 150)" }))
+    defs.appendChild(marker)
+    svg.appendChild(defs)
+
+    for (const node of graph.Nodes || []) {
+        const p = pos[node.ID]
+        const g = el("g", {
+            class: "graph-node" + (node.Errors > 0 ? " error" : ""),
+            transform: `translate(${p.x},${p.y})`,
+        })
+        g.appendChild(el("rect", { width: nodeWidth, height: nodeHeight, rx: 4 }))
+        const name = el("text", { x: 6, y: 15 })
+        name.textContent = truncate(node.Name, 32)
+        g.appendChild(name)
+        const stats = el("text", { x: 6, y: 31, fill: "rgb(90, 90, 90)" })
+        stats.textContent = truncate(graph.ByPackage ? describe(node) : node.Pkg, 32)
+        g.appendChild(stats)
+        const title = el("title", {})
+        title.textContent = `${node.ID}\n${describe(node)}`
+        g.appendChild(title)
+        g.onclick = () => selectNode(node.ID)
+        svg.appendChild(g)
+        nodeEls[node.ID] = g
+    }
+    container.appendChild(svg)
+    graphStatus.innerText = `${(graph.Nodes || []).length} nodes, ${(graph.Edges || []).length} edges`
+}
+
+function selectNode(id) {
+    if (selectedID === id) {
+        id = ""
+    }
+    selectedID = id
+    const related = new Set([id])
+    for (const { edge, path } of edgeEls) {
+        const on = edge.Caller === id || edge.Callee === id
+        if (on) {
+            related.add(edge.Caller)
+            related.add(edge.Callee)
+        }
+        path.classList.toggle("highlight", !!id && on)
+        path.classList.toggle("dim", !!id && !on)
+    }
+    for (const nodeID in nodeEls) {
+        nodeEls[nodeID].classList.toggle("selected", nodeID === id)
+        nodeEls[nodeID].classList.toggle("dim", !!id && !related.has(nodeID))
+    }
+    if (!id) {
+        info.innerText = "click a node to see its callers and callees"
+        return
+    }
+    const node = nodeByID[id]
+    const lines = [node.ID, describe(node)]
+    if (node.File) {
+        lines.push(`${node.File}:${node.Line}`)
+    }
+    lines.push("", "Callers:")
+    for (const edge of inEdges[id]) {
+        lines.push(`  ${edge.Caller}`, `    ${describe(edge)}`)
+    }
+    lines.push("", "Callees:")
+    for (const edge of outEdges[id]) {
+        lines.push(`  ${edge.Callee}`, `    ${describe(edge)}`)
+    }
+    info.innerText = lines.join("\n")
+}
+
+function onGraphSearch(value) {
+    const s = value.trim().toLowerCase()
+    let first = null
+    for (const node of graph.Nodes || []) {
+        const matched = !!s && node.ID.toLowerCase().includes(s)
+        nodeEls[node.ID].classList.toggle("matched", matched)
+        if (matched && !first) {
+            first = nodeEls[node.ID]
+        }
+    }
+    if (first) {
+        first.scrollIntoView({ block: "nearest", inline: "nearest" })
+    }
+}
+
+render()
@@ -0,0 +1,147 @@
+package trace
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const callGraphHelp = `
+Xgo tool trace callgraph aggregates one or many trace files into
+a dynamic call graph, with call counts, durations and error rates
+of each caller-callee pair.
+
+Usage:
+    xgo tool trace callgraph [options] <file or dir>...
+
+Options:
+    --format <format>  dot or mermaid, default dot
+    -o <file>          write to file instead of stdout
+    --by-package       collapse functions of the same package into one node
+    --serve            serve an interactive call graph
+    --port <port>      port to serve, effective with --serve
+    --bind <addr>      address to bind, default localhost
+
+Examples:
+    xgo tool trace callgraph ./traces | dot -Tsvg -o callgraph.svg
+    xgo tool trace callgraph --format mermaid --by-package -o callgraph.md ./traces
+    xgo tool trace callgraph --serve TestA.json TestB.json
+
+See https://github.com/xhd2015/xgo for documentation.
+
+`
+
+func handleCallGraph(args []string) error {
+	var files []string
+	var format string = "dot"
+	var outFile string
+	var byPackage bool
+	var serve bool
+	var port string
+	var bind string
+
+	n := len(args)
+	for i := 0; i < n; i++ {
+		arg := args[i]
+		if arg == "--" {
+			files = append(files, args[i+1:]...)
+			break
+		}
+		if arg == "-h" || arg == "--help" {
+			fmt.Print(strings.TrimPrefix(callGraphHelp, "\n"))
+			return nil
+		}
+		if arg == "--by-package" {
+			byPackage = true
+			continue
+		}
+		if arg == "--serve" {
+			serve = true
+			continue
+		}
+		if arg == "--format" || arg == "-o" || arg == "--port" || arg == "--bind" {
+			if i+1 >= n {
+				return fmt.Errorf("%s requires arg", arg)
+			}
+			val := args[i+1]
+			i++
+			switch arg {
+			case "--format":
+				if val != "dot" && val != "mermaid" {
+					return fmt.Errorf("--format: expect dot or mermaid, given: %s", val)
+				}
+				format = val
+			case "-o":
+				outFile = val
+			case "--port":
+				port = val
+			case "--bind":
+				bind = val
+			}
+			continue
+		}
+		if !strings.HasPrefix(arg, "-") {
+			files = append(files, arg)
+			continue
+		}
+		return fmt.Errorf("unrecognized flag: %s", arg)
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("requires file")
+	}
+	traceFiles, err := listTraceFiles(files)
+	if err != nil {
+		return err
+	}
+	if len(traceFiles) == 0 {
+		return fmt.Errorf("no trace files found: %v", files)
+	}
+	roots := make([]*RootExport, 0, len(traceFiles))
+	for _, file := range traceFiles {
+		root, err := parseRecord(file)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		roots = append(roots, root)
+	}
+	if serve {
+		if bind == "" {
+			bind = "localhost"
+		}
+		title := strings.Join(files, " ")
+		server := http.NewServeMux()
+		server.HandleFunc("/callgraph", func(w http.ResponseWriter, r *http.Request) {
+			serveCallGraph(w, r, roots, title)
+		})
+		path := "/callgraph"
+		if byPackage {
+			path += "?by=package"
+		}
+		return serveHTTP(server, bind, port, path)
+	}
+
+	graph := BuildCallGraph(roots, &CallGraphOptions{ByPackage: byPackage})
+	var w io.Writer = os.Stdout
+	if outFile != "" {
+		f, err := os.Create(outFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if format == "mermaid" {
+		err = graph.WriteMermaid(w)
+	} else {
+		err = graph.WriteDOT(w)
+	}
+	if err != nil {
+		return err
+	}
+	if outFile != "" {
+		fmt.Fprintf(os.Stderr, "%d nodes, %d edges written to %s\n", len(graph.Nodes), len(graph.Edges), outFile)
+	}
+	return nil
+}
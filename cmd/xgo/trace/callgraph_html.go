@@ -0,0 +1,151 @@
+package trace
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+//go:embed callgraph.js
+var callGraphScript string
+
+// serveCallGraph responds the call graph of roots, query
+// by=package collapses by package, format=dot or mermaid
+// responds the source instead of the interactive view
+func serveCallGraph(w http.ResponseWriter, r *http.Request, roots []*RootExport, title string) {
+	q := r.URL.Query()
+	byPackage := q.Get("by") == "package"
+	graph := BuildCallGraph(roots, &CallGraphOptions{ByPackage: byPackage})
+	switch q.Get("format") {
+	case "dot":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		graph.WriteDOT(w)
+	case "mermaid":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		graph.WriteMermaid(w)
+	case "", "html":
+		w.Header().Set("Content-Type", "text/html")
+		renderCallGraphHTML(graph, title, r.URL, w)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, fmt.Sprintf("unknown format: %s", q.Get("format")))
+	}
+}
+
+func renderCallGraphHTML(graph *CallGraph, title string, u *url.URL, w io.Writer) {
+	data, err := json.Marshal(graph)
+	if err != nil {
+		data = []byte(`{"Nodes":[],"Edges":[]}`)
+	}
+	// links keep other query params such as file
+	link := func(key string, value string) string {
+		q := u.Query()
+		if value == "" {
+			q.Del(key)
+		} else {
+			q.Set(key, value)
+		}
+		return html.EscapeString(u.Path + "?" + q.Encode())
+	}
+	collapse := `<a href="` + link("by", "package") + `">Packages</a>`
+	if graph.ByPackage {
+		collapse = `<a href="` + link("by", "") + `">Functions</a>`
+	}
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="UTF-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1.0">
+	<title>Call graph of %s</title>
+	<style>%s</style>
+</head>
+<body>
+	<div class="graph-toolbar">
+		<input id="graph-search" placeholder="search function or package..." oninput="onGraphSearch(this.value)">
+		%s
+		<a href="%s" target="_blank">DOT</a>
+		<a href="%s" target="_blank">Mermaid</a>
+		<span id="graph-status"></span>
+	</div>
+	<div class="graph-main">
+		<div id="graph" class="graph"></div>
+		<div id="graph-info" class="graph-info">click a node to see its callers and callees</div>
+	</div>
+	<script>
+	const graph = %s
+	%s
+	</script>
+</body>
+</html>
+`, html.EscapeString(title), callGraphStyles, collapse, link("format", "dot"), link("format", "mermaid"), data, callGraphScript)
+}
+
+const callGraphStyles = `
+body {
+    font-family: monospace;
+    margin: 8px;
+}
+.graph-toolbar {
+    display: flex;
+    align-items: center;
+    gap: 12px;
+    margin-bottom: 8px;
+}
+#graph-search {
+    width: 300px;
+}
+#graph-status {
+    color: rgb(80, 80, 80);
+}
+.graph-main {
+    display: flex;
+    gap: 8px;
+    height: calc(100vh - 50px);
+}
+.graph {
+    flex: 1;
+    overflow: auto;
+    border: 1px solid rgb(220, 220, 220);
+}
+.graph-info {
+    width: 360px;
+    overflow: auto;
+    font-size: 12px;
+    white-space: pre-wrap;
+}
+.graph-node rect {
+    fill: rgb(240, 245, 255);
+    stroke: rgb(120, 140, 200);
+    cursor: pointer;
+}
+.graph-node.error rect {
+    stroke: rgb(220, 50, 50);
+}
+.graph-node.selected rect {
+    fill: rgb(255, 240, 200);
+}
+.graph-node.matched rect {
+    fill: rgb(240, 180, 240);
+}
+.graph-node text {
+    font-size: 11px;
+    pointer-events: none;
+}
+.graph-edge {
+    fill: none;
+    stroke: rgb(150, 150, 150);
+}
+.graph-edge.error {
+    stroke: rgb(220, 50, 50);
+}
+.graph-edge.highlight {
+    stroke: rgb(40, 90, 220);
+}
+.graph-edge.dim, .graph-node.dim {
+    opacity: 0.15;
+}
+`
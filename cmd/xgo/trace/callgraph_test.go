@@ -0,0 +1,92 @@
+package trace
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestBuildCallGraph(t *testing.T) {
+	fn := func(pkg string, name string) *FuncInfoExport {
+		return &FuncInfoExport{Pkg: pkg, IdentityName: name}
+	}
+	call := func(f *FuncInfoExport, begin int64, end int64, err string, children ...*StackExport) *StackExport {
+		return &StackExport{FuncInfo: f, Begin: begin, End: end, Error: err, Children: children}
+	}
+	handle := fn("example.com/api", "Handle")
+	get := fn("example.com/dao", "Get")
+	query := fn("example.com/dao", "query")
+	walk := fn("example.com/api", "walk")
+
+	root := &RootExport{
+		Children: []*StackExport{
+			call(handle, 0, 100, "",
+				call(get, 10, 40, "", call(query, 20, 30, "")),
+				call(get, 50, 60, "not found", call(query, 52, 58, "not found")),
+				// recursion is counted once in totals
+				call(walk, 60, 90, "", call(walk, 70, 80, "")),
+			),
+		},
+	}
+
+	graph := BuildCallGraph([]*RootExport{root, root}, nil)
+	if len(graph.Nodes) != 4 {
+		t.Fatalf("expect 4 nodes, actual: %d", len(graph.Nodes))
+	}
+	edges := make(map[string]*CallGraphEdge)
+	for _, edge := range graph.Edges {
+		edges[edge.Caller+" -> "+edge.Callee] = edge
+	}
+	e := edges["example.com/api.Handle -> example.com/dao.Get"]
+	if e == nil || e.Calls != 4 || e.Errors != 2 || e.Total != 80 || e.ErrorRate() != 0.5 {
+		t.Fatalf("bad Handle -> Get: %+v", e)
+	}
+	e = edges["example.com/api.walk -> example.com/api.walk"]
+	if e == nil || e.Calls != 2 || e.Total != 20 {
+		t.Fatalf("bad walk -> walk: %+v", e)
+	}
+	for _, node := range graph.Nodes {
+		if node.ID == "example.com/api.walk" && (node.Calls != 4 || node.Total != 60) {
+			t.Fatalf("bad walk: %+v", node)
+		}
+	}
+
+	pkgGraph := BuildCallGraph([]*RootExport{root}, &CallGraphOptions{ByPackage: true})
+	if len(pkgGraph.Nodes) != 2 || len(pkgGraph.Edges) != 1 {
+		t.Fatalf("expect 2 packages and 1 edge, actual: %d %d", len(pkgGraph.Nodes), len(pkgGraph.Edges))
+	}
+	e = pkgGraph.Edges[0]
+	if e.Caller != "example.com/api" || e.Callee != "example.com/dao" || e.Calls != 2 || e.Total != 40 {
+		t.Fatalf("bad package edge: %+v", e)
+	}
+
+	var dot bytes.Buffer
+	err := graph.WriteDOT(&dot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expect := range []string{
+		`subgraph cluster_0 {`,
+		`"example.com/api.Handle" -> "example.com/dao.Get" [label="4 calls, 80ns, 50% errors", color=red, fontcolor=red];`,
+	} {
+		if !strings.Contains(dot.String(), expect) {
+			t.Fatalf("expect dot to contain %q, actual:\n%s", expect, dot.String())
+		}
+	}
+
+	var mermaid bytes.Buffer
+	err = pkgGraph.WriteMermaid(&mermaid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectMermaid := `flowchart LR
+    n0["example.com/api<br/>3 calls, 100ns"]
+    n1["example.com/dao<br/>4 calls, 40ns"]
+    n0 -->|"2 calls, 40ns, 50% errors"| n1
+    linkStyle 0 stroke:red
+    style n1 stroke:red
+`
+	if mermaid.String() != expectMermaid {
+		t.Fatalf("expect:\n%s\nactual:\n%s", expectMermaid, mermaid.String())
+	}
+}
@@ -0,0 +1,126 @@
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const convertHelp = `
+Xgo tool trace convert converts a trace between the JSON format
+and the compact binary format, the binary format interns function
+info and is loaded incrementally by the viewer.
+
+Usage:
+    xgo tool trace convert [options] <file>
+
+Options:
+    --to <format>      json or binary, default the other format of <file>
+    -o <file>          output file, default <file> with the extension
+                       replaced by .json or .xtrace
+
+Examples:
+    xgo tool trace convert TestSomething.json
+    xgo tool trace convert --to json -o out.json TestSomething.xtrace
+
+Traces are written in the binary format directly by setting
+XGO_TRACE_FORMAT=binary when running tests.
+
+See https://github.com/xhd2015/xgo for documentation.
+
+`
+
+func handleConvert(args []string) error {
+	var files []string
+	var to string
+	var output string
+
+	n := len(args)
+	for i := 0; i < n; i++ {
+		arg := args[i]
+		if arg == "--" {
+			files = append(files, args[i+1:]...)
+			break
+		}
+		if arg == "-h" || arg == "--help" {
+			fmt.Print(strings.TrimPrefix(convertHelp, "\n"))
+			return nil
+		}
+		if arg == "--to" || arg == "-o" {
+			if i+1 >= n {
+				return fmt.Errorf("%s requires arg", arg)
+			}
+			if arg == "--to" {
+				to = args[i+1]
+			} else {
+				output = args[i+1]
+			}
+			i++
+			continue
+		}
+		if !strings.HasPrefix(arg, "-") {
+			files = append(files, arg)
+			continue
+		}
+		return fmt.Errorf("unrecognized flag: %s", arg)
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("requires file")
+	}
+	if len(files) > 1 {
+		return fmt.Errorf("convert accepts one file, given: %v", files)
+	}
+	file := files[0]
+	binTrace, closeFile, err := openBinaryRecord(file)
+	if err != nil {
+		return err
+	}
+	if binTrace != nil {
+		closeFile()
+	}
+	isBinary := binTrace != nil
+	switch to {
+	case "":
+		to = "binary"
+		if isBinary {
+			to = "json"
+		}
+	case "json", "binary":
+	default:
+		return fmt.Errorf("--to: expect json or binary, given: %s", to)
+	}
+	if output == "" {
+		base := strings.TrimSuffix(strings.TrimSuffix(file, ".json"), BinaryTraceExt)
+		if to == "json" {
+			output = base + ".json"
+		} else {
+			output = base + BinaryTraceExt
+		}
+		if output == file {
+			return fmt.Errorf("%s is already %s, use -o to specify output", file, to)
+		}
+	}
+
+	root, err := parseRecord(file)
+	if err != nil {
+		return err
+	}
+	if to == "json" {
+		data, err := json.Marshal(root)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(output, data, 0644)
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	err = WriteBinaryTrace(f, root, nil)
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
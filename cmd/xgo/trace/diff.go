@@ -0,0 +1,502 @@
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+type DiffKind string
+
+const (
+	DiffSame    DiffKind = "same"
+	DiffAdded   DiffKind = "added"
+	DiffRemoved DiffKind = "removed"
+	DiffChanged DiffKind = "changed"
+)
+
+// DiffOptions controls what counts as a timing regression
+type DiffOptions struct {
+	// min ratio of slow down, 0.5 means 50% slower
+	SlowRatio float64
+	// min absolute slow down in ns, avoids noise of tiny calls
+	SlowDelta int64
+}
+
+func defaultDiffOptions() *DiffOptions {
+	return &DiffOptions{
+		SlowRatio: 0.5,
+		SlowDelta: 1000000, // 1ms
+	}
+}
+
+// DiffNode is a node of the aligned tree,
+// Old or New is nil when the call is added or removed
+type DiffNode struct {
+	Name string
+	Kind DiffKind
+
+	Old *StackExport `json:"-"`
+	New *StackExport `json:"-"`
+
+	ArgsDiff    []string
+	ResultsDiff []string
+	// e.g. "new error: EOF", "error gone: EOF"
+	ErrorChange string
+	Slower      bool
+
+	// whether any node in this subtree differs
+	HasChange bool
+
+	Children []*DiffNode
+}
+
+func (c *DiffNode) OldCost() int64 {
+	if c.Old == nil {
+		return 0
+	}
+	return c.Old.End - c.Old.Begin
+}
+
+func (c *DiffNode) NewCost() int64 {
+	if c.New == nil {
+		return 0
+	}
+	return c.New.End - c.New.Begin
+}
+
+// DiffStat counts differences of a diff tree
+type DiffStat struct {
+	Added   int
+	Removed int
+	Changed int
+	Errors  int
+	Slower  int
+}
+
+func (c DiffStat) Total() int {
+	return c.Added + c.Removed + c.Changed + c.Errors + c.Slower
+}
+
+// DiffTrace aligns old and new trace by function
+// identity and call order, then compares matched calls
+func DiffTrace(old *RootExport, new *RootExport, opts *DiffOptions) *DiffNode {
+	if opts == nil {
+		opts = defaultDiffOptions()
+	}
+	var oldChildren, newChildren []*StackExport
+	if old != nil {
+		oldChildren = old.Children
+	}
+	if new != nil {
+		newChildren = new.Children
+	}
+	root := &DiffNode{
+		Name: "<root>",
+		Kind: DiffSame,
+	}
+	root.Children = diffChildren(oldChildren, newChildren, opts)
+	for _, child := range root.Children {
+		if child.HasChange {
+			root.HasChange = true
+		}
+	}
+	return root
+}
+
+// DiffChange is a differing node with its call path
+type DiffChange struct {
+	Path []string
+	Node *DiffNode
+}
+
+// Changes lists differing nodes in call order, calls inside
+// an added or removed subtree are not listed separately
+func (c *DiffNode) Changes() []DiffChange {
+	var changes []DiffChange
+	var walk func(path []string, node *DiffNode)
+	walk = func(path []string, node *DiffNode) {
+		if !node.HasChange {
+			return
+		}
+		if node.Kind != DiffSame {
+			changes = append(changes, DiffChange{Path: path, Node: node})
+		}
+		if node.Kind == DiffAdded || node.Kind == DiffRemoved {
+			return
+		}
+		for _, child := range node.Children {
+			walk(append(path[:len(path):len(path)], child.Name), child)
+		}
+	}
+	for _, child := range c.Children {
+		walk([]string{child.Name}, child)
+	}
+	return changes
+}
+
+// Stat counts differences, an added or removed
+// subtree counts as one
+func (c *DiffNode) Stat() DiffStat {
+	var stat DiffStat
+	for _, change := range c.Changes() {
+		node := change.Node
+		switch node.Kind {
+		case DiffAdded:
+			stat.Added++
+		case DiffRemoved:
+			stat.Removed++
+		case DiffChanged:
+			if len(node.ArgsDiff) > 0 || len(node.ResultsDiff) > 0 {
+				stat.Changed++
+			}
+		}
+		if node.ErrorChange != "" {
+			stat.Errors++
+		}
+		if node.Slower {
+			stat.Slower++
+		}
+	}
+	return stat
+}
+
+func stackKey(stack *StackExport) string {
+	if stack.FuncInfo == nil {
+		return "<unknown>"
+	}
+	return stack.FuncInfo.Pkg + "." + stack.FuncInfo.IdentityName
+}
+
+func stackName(stack *StackExport) string {
+	if stack.FuncInfo == nil || stack.FuncInfo.IdentityName == "" {
+		return "<unknown>"
+	}
+	return stack.FuncInfo.IdentityName
+}
+
+// beyond this, children are aligned greedily
+// instead of by longest common subsequence
+const maxLCSSize = 4000000
+
+func diffChildren(olds []*StackExport, news []*StackExport, opts *DiffOptions) []*DiffNode {
+	var nodes []*DiffNode
+	add := func(node *DiffNode) {
+		nodes = append(nodes, node)
+	}
+	for _, pair := range alignStacks(olds, news) {
+		if pair[0] >= 0 && pair[1] >= 0 {
+			add(diffStack(olds[pair[0]], news[pair[1]], opts))
+		} else if pair[0] >= 0 {
+			add(wholeDiff(olds[pair[0]], DiffRemoved))
+		} else {
+			add(wholeDiff(news[pair[1]], DiffAdded))
+		}
+	}
+	return nodes
+}
+
+// alignStacks returns index pairs, -1 stands for missing side
+func alignStacks(olds []*StackExport, news []*StackExport) [][2]int {
+	n, m := len(olds), len(news)
+	oldKeys := make([]string, n)
+	for i, s := range olds {
+		oldKeys[i] = stackKey(s)
+	}
+	newKeys := make([]string, m)
+	for i, s := range news {
+		newKeys[i] = stackKey(s)
+	}
+
+	var pairs [][2]int
+	// common prefix and suffix are the usual case,
+	// trim them to keep lcs small
+	prefix := 0
+	for prefix < n && prefix < m && oldKeys[prefix] == newKeys[prefix] {
+		pairs = append(pairs, [2]int{prefix, prefix})
+		prefix++
+	}
+	suffix := 0
+	for suffix < n-prefix && suffix < m-prefix && oldKeys[n-1-suffix] == newKeys[m-1-suffix] {
+		suffix++
+	}
+	oldMid := oldKeys[prefix : n-suffix]
+	newMid := newKeys[prefix : m-suffix]
+
+	var mid [][2]int
+	if len(oldMid)*len(newMid) <= maxLCSSize {
+		mid = lcsAlign(oldMid, newMid)
+	} else {
+		mid = greedyAlign(oldMid, newMid)
+	}
+	for _, p := range mid {
+		if p[0] >= 0 {
+			p[0] += prefix
+		}
+		if p[1] >= 0 {
+			p[1] += prefix
+		}
+		pairs = append(pairs, p)
+	}
+	for i := suffix; i > 0; i-- {
+		pairs = append(pairs, [2]int{n - i, m - i})
+	}
+	return pairs
+}
+
+func lcsAlign(a []string, b []string) [][2]int {
+	n, m := len(a), len(b)
+	// dp[i][j] is lcs length of a[i:] and b[j:]
+	dp := make([][]int, n+1)
+	for i := range dp {
+		dp[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				dp[i][j] = dp[i+1][j+1] + 1
+			} else if dp[i+1][j] >= dp[i][j+1] {
+				dp[i][j] = dp[i+1][j]
+			} else {
+				dp[i][j] = dp[i][j+1]
+			}
+		}
+	}
+	var pairs [][2]int
+	i, j := 0, 0
+	for i < n && j < m {
+		if a[i] == b[j] {
+			pairs = append(pairs, [2]int{i, j})
+			i++
+			j++
+		} else if dp[i+1][j] >= dp[i][j+1] {
+			pairs = append(pairs, [2]int{i, -1})
+			i++
+		} else {
+			pairs = append(pairs, [2]int{-1, j})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		pairs = append(pairs, [2]int{i, -1})
+	}
+	for ; j < m; j++ {
+		pairs = append(pairs, [2]int{-1, j})
+	}
+	return pairs
+}
+
+// greedyAlign matches the k-th call of a function
+// in old with the k-th call of it in new
+func greedyAlign(a []string, b []string) [][2]int {
+	pending := make(map[string][]int)
+	for j, key := range b {
+		pending[key] = append(pending[key], j)
+	}
+	matched := make([]int, len(a))
+	newMatched := make([]bool, len(b))
+	for i, key := range a {
+		matched[i] = -1
+		if js := pending[key]; len(js) > 0 {
+			matched[i] = js[0]
+			newMatched[js[0]] = true
+			pending[key] = js[1:]
+		}
+	}
+	var pairs [][2]int
+	j := 0
+	for i := range a {
+		if matched[i] >= 0 {
+			// emit new calls appeared before this one
+			for ; j < matched[i]; j++ {
+				if !newMatched[j] {
+					pairs = append(pairs, [2]int{-1, j})
+				}
+			}
+		}
+		pairs = append(pairs, [2]int{i, matched[i]})
+		if matched[i] >= j {
+			j = matched[i] + 1
+		}
+	}
+	for ; j < len(b); j++ {
+		if !newMatched[j] {
+			pairs = append(pairs, [2]int{-1, j})
+		}
+	}
+	return pairs
+}
+
+func wholeDiff(stack *StackExport, kind DiffKind) *DiffNode {
+	node := &DiffNode{
+		Name:      stackName(stack),
+		Kind:      kind,
+		HasChange: true,
+	}
+	if kind == DiffAdded {
+		node.New = stack
+	} else {
+		node.Old = stack
+	}
+	for _, child := range stack.Children {
+		node.Children = append(node.Children, wholeDiff(child, kind))
+	}
+	return node
+}
+
+func diffStack(old *StackExport, new *StackExport, opts *DiffOptions) *DiffNode {
+	node := &DiffNode{
+		Name: stackName(new),
+		Kind: DiffSame,
+		Old:  old,
+		New:  new,
+	}
+	node.ArgsDiff = diffJSON("", old.Args, new.Args)
+	node.ResultsDiff = diffJSON("", old.Results, new.Results)
+	node.ErrorChange = errorChange(old, new)
+
+	oldCost := old.End - old.Begin
+	newCost := new.End - new.Begin
+	delta := newCost - oldCost
+	if delta >= opts.SlowDelta && float64(delta) >= float64(oldCost)*opts.SlowRatio {
+		node.Slower = true
+	}
+	if len(node.ArgsDiff) > 0 || len(node.ResultsDiff) > 0 || node.ErrorChange != "" || node.Slower {
+		node.Kind = DiffChanged
+		node.HasChange = true
+	}
+	node.Children = diffChildren(old.Children, new.Children, opts)
+	for _, child := range node.Children {
+		if child.HasChange {
+			node.HasChange = true
+		}
+	}
+	return node
+}
+
+func errorChange(old *StackExport, new *StackExport) string {
+	describe := func(s *StackExport) string {
+		if s.Panic {
+			return "panic: " + s.Error
+		}
+		return s.Error
+	}
+	oldErr, newErr := describe(old), describe(new)
+	if oldErr == newErr {
+		return ""
+	}
+	if oldErr == "" {
+		return "new error: " + newErr
+	}
+	if newErr == "" {
+		return "error gone: " + oldErr
+	}
+	return fmt.Sprintf("error changed: %s -> %s", oldErr, newErr)
+}
+
+// limits lines of a single json diff
+const maxJSONDiffLines = 20
+
+// diffJSON compares decoded json values, returns
+// one line per differing path
+func diffJSON(path string, old interface{}, new interface{}) []string {
+	var lines []string
+	var truncated bool
+	add := func(line string) {
+		if len(lines) >= maxJSONDiffLines {
+			truncated = true
+			return
+		}
+		lines = append(lines, line)
+	}
+	var walk func(path string, old interface{}, new interface{})
+	walk = func(path string, old interface{}, new interface{}) {
+		if truncated {
+			return
+		}
+		switch o := old.(type) {
+		case map[string]interface{}:
+			n, ok := new.(map[string]interface{})
+			if !ok {
+				break
+			}
+			keys := make([]string, 0, len(o)+len(n))
+			for k := range o {
+				keys = append(keys, k)
+			}
+			for k := range n {
+				if _, ok := o[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				if truncated {
+					return
+				}
+				ov, oldOK := o[k]
+				nv, newOK := n[k]
+				sub := joinJSONPath(path, k)
+				if !oldOK {
+					add(fmt.Sprintf("%s: added %s", sub, shortJSON(nv)))
+				} else if !newOK {
+					add(fmt.Sprintf("%s: removed %s", sub, shortJSON(ov)))
+				} else {
+					walk(sub, ov, nv)
+				}
+			}
+			return
+		case []interface{}:
+			n, ok := new.([]interface{})
+			if !ok {
+				break
+			}
+			for i := 0; i < len(o) || i < len(n); i++ {
+				if truncated {
+					return
+				}
+				sub := path + "[" + strconv.Itoa(i) + "]"
+				if i >= len(o) {
+					add(fmt.Sprintf("%s: added %s", sub, shortJSON(n[i])))
+				} else if i >= len(n) {
+					add(fmt.Sprintf("%s: removed %s", sub, shortJSON(o[i])))
+				} else {
+					walk(sub, o[i], n[i])
+				}
+			}
+			return
+		}
+		if !reflect.DeepEqual(old, new) {
+			if path == "" {
+				path = "."
+			}
+			add(fmt.Sprintf("%s: %s -> %s", path, shortJSON(old), shortJSON(new)))
+		}
+	}
+	walk(path, old, new)
+	if truncated {
+		lines = append(lines, "...")
+	}
+	return lines
+}
+
+func joinJSONPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func shortJSON(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	const max = 80
+	if len(data) > max {
+		return string(data[:max]) + "..."
+	}
+	return string(data)
+}
@@ -0,0 +1,180 @@
+package trace
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const diffHelp = `
+Xgo tool trace diff compares two traces of the same test, aligning
+calls by function identity and call order.
+
+It reports added or removed calls, changed arguments or results,
+new errors and timing regressions.
+
+Usage:
+    xgo tool trace diff [options] <old.json> <new.json>
+
+Options:
+    --slow-ratio <r>   min slow down ratio to report, default 0.5(50%)
+    --slow-min <d>     min slow down duration to report, default 1ms
+    --exit-code        exit with 1 if there are differences
+    --serve            serve the diff as html
+    --port <port>      port to serve, effective with --serve
+    --bind <addr>      address to bind, default localhost
+
+Examples:
+    xgo tool trace diff before/TestA.json after/TestA.json
+    xgo tool trace diff --serve before/TestA.json after/TestA.json
+
+See https://github.com/xhd2015/xgo for documentation.
+
+`
+
+func handleDiff(args []string) error {
+	var files []string
+	var serve bool
+	var exitCode bool
+	var port string
+	var bind string
+	opts := defaultDiffOptions()
+
+	n := len(args)
+	for i := 0; i < n; i++ {
+		arg := args[i]
+		if arg == "--" {
+			files = append(files, args[i+1:]...)
+			break
+		}
+		if arg == "-h" || arg == "--help" {
+			fmt.Print(strings.TrimPrefix(diffHelp, "\n"))
+			return nil
+		}
+		if arg == "--serve" {
+			serve = true
+			continue
+		}
+		if arg == "--exit-code" {
+			exitCode = true
+			continue
+		}
+		if arg == "--slow-ratio" || arg == "--slow-min" || arg == "--port" || arg == "--bind" {
+			if i+1 >= n {
+				return fmt.Errorf("%s requires arg", arg)
+			}
+			val := args[i+1]
+			i++
+			switch arg {
+			case "--slow-ratio":
+				v, err := strconv.ParseFloat(val, 64)
+				if err != nil {
+					return fmt.Errorf("--slow-ratio: %w", err)
+				}
+				opts.SlowRatio = v
+			case "--slow-min":
+				d, err := time.ParseDuration(val)
+				if err != nil {
+					return fmt.Errorf("--slow-min: %w", err)
+				}
+				opts.SlowDelta = int64(d)
+			case "--port":
+				port = val
+			case "--bind":
+				bind = val
+			}
+			continue
+		}
+		if !strings.HasPrefix(arg, "-") {
+			files = append(files, arg)
+			continue
+		}
+		return fmt.Errorf("unrecognized flag: %s", arg)
+	}
+	if len(files) != 2 {
+		return fmt.Errorf("requires exactly 2 files: old and new, given: %v", files)
+	}
+	oldFile, newFile := files[0], files[1]
+	oldRoot, err := parseRecord(oldFile)
+	if err != nil {
+		return fmt.Errorf("%s: %w", oldFile, err)
+	}
+	newRoot, err := parseRecord(newFile)
+	if err != nil {
+		return fmt.Errorf("%s: %w", newFile, err)
+	}
+	diff := DiffTrace(oldRoot, newRoot, opts)
+	printDiffSummary(os.Stdout, diff, oldFile, newFile)
+
+	if serve {
+		if bind == "" {
+			bind = "localhost"
+		}
+		server := http.NewServeMux()
+		server.HandleFunc("/diff", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			renderDiffHTML(diff, oldFile, newFile, w)
+		})
+		return serveHTTP(server, bind, port, "/diff")
+	}
+	if exitCode && diff.HasChange {
+		os.Exit(1)
+	}
+	return nil
+}
+
+func printDiffSummary(w io.Writer, diff *DiffNode, oldFile string, newFile string) {
+	fmt.Fprintf(w, "--- %s\n", oldFile)
+	fmt.Fprintf(w, "+++ %s\n", newFile)
+	stat := diff.Stat()
+	if stat.Total() == 0 {
+		fmt.Fprintf(w, "no difference\n")
+		return
+	}
+	fmt.Fprintf(w, "%d added, %d removed, %d changed, %d errors, %d slower\n\n", stat.Added, stat.Removed, stat.Changed, stat.Errors, stat.Slower)
+	for _, change := range diff.Changes() {
+		node := change.Node
+		path := strings.Join(change.Path, " > ")
+		switch node.Kind {
+		case DiffAdded:
+			fmt.Fprintf(w, "+ %s\n", path)
+			continue
+		case DiffRemoved:
+			fmt.Fprintf(w, "- %s\n", path)
+			continue
+		}
+		fmt.Fprintf(w, "~ %s\n", path)
+		for _, line := range node.ArgsDiff {
+			fmt.Fprintf(w, "    args %s\n", line)
+		}
+		for _, line := range node.ResultsDiff {
+			fmt.Fprintf(w, "    results %s\n", line)
+		}
+		if node.ErrorChange != "" {
+			fmt.Fprintf(w, "    %s\n", node.ErrorChange)
+		}
+		if node.Slower {
+			fmt.Fprintf(w, "    slower %s\n", formatSlowDown(node))
+		}
+	}
+}
+
+func formatSlowDown(node *DiffNode) string {
+	oldCost, newCost := node.OldCost(), node.NewCost()
+	s := fmt.Sprintf("%s -> %s", formatDiffCost(oldCost), formatDiffCost(newCost))
+	if oldCost > 0 {
+		s += fmt.Sprintf(" (+%.0f%%)", float64(newCost-oldCost)*100/float64(oldCost))
+	}
+	return s
+}
+
+func formatDiffCost(cost int64) string {
+	if cost == 0 {
+		return "0ns"
+	}
+	return formatCost(0, cost)
+}
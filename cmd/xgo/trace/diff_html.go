@@ -0,0 +1,190 @@
+package trace
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"strings"
+)
+
+func renderDiffHTML(diff *DiffNode, oldFile string, newFile string, w io.Writer) {
+	h := func(s string) {
+		_, err := io.WriteString(w, s)
+		if err != nil {
+			panic(err)
+		}
+		_, err = io.WriteString(w, "\n")
+		if err != nil {
+			panic(err)
+		}
+	}
+	stat := diff.Stat()
+	h(`<!DOCTYPE html>
+	<html lang="en">
+	<head>
+		<meta charset="UTF-8">
+		<meta name="viewport" content="width=device-width, initial-scale=1.0">
+		<title>Diff of ` + html.EscapeString(oldFile) + ` and ` + html.EscapeString(newFile) + `</title>
+	</head>
+	<body>`)
+	h(`<style>`)
+	h(diffStyles)
+	h(`</style>`)
+	h(fmt.Sprintf(`<div class="diff-header">
+		<div class="diff-file removed">--- %s</div>
+		<div class="diff-file added">+++ %s</div>
+		<div class="diff-stat">%d added, %d removed, %d changed, %d errors, %d slower</div>
+		<label><input type="checkbox" onchange="document.body.classList.toggle('only-changes', this.checked)">only changes</label>
+	</div>`,
+		html.EscapeString(oldFile), html.EscapeString(newFile),
+		stat.Added, stat.Removed, stat.Changed, stat.Errors, stat.Slower,
+	))
+	h(`<ul class="diff-tree">`)
+	for _, child := range diff.Children {
+		renderDiffNode(h, child)
+	}
+	h(`</ul>`)
+	h(`</body>
+	</html>`)
+}
+
+func renderDiffNode(h func(s string), node *DiffNode) {
+	mark := " "
+	switch node.Kind {
+	case DiffAdded:
+		mark = "+"
+	case DiffRemoved:
+		mark = "-"
+	case DiffChanged:
+		mark = "~"
+	}
+	var cost string
+	switch node.Kind {
+	case DiffAdded:
+		cost = formatDiffCost(node.NewCost())
+	case DiffRemoved:
+		cost = formatDiffCost(node.OldCost())
+	default:
+		cost = formatDiffCost(node.OldCost()) + " → " + formatDiffCost(node.NewCost())
+	}
+	class := "diff-node " + string(node.Kind)
+	if !node.HasChange {
+		class += " unchanged"
+	}
+	summary := fmt.Sprintf(`<span class="diff-mark">%s</span><span class="diff-name">%s</span><span class="diff-cost">%s</span>`,
+		mark, html.EscapeString(node.Name), cost)
+
+	var details []string
+	for _, line := range node.ArgsDiff {
+		details = append(details, "args "+line)
+	}
+	for _, line := range node.ResultsDiff {
+		details = append(details, "results "+line)
+	}
+	if node.ErrorChange != "" {
+		details = append(details, node.ErrorChange)
+	}
+	if node.Slower {
+		details = append(details, "slower "+formatSlowDown(node))
+	}
+
+	h(fmt.Sprintf(`<li class="%s">`, class))
+	if len(node.Children) == 0 {
+		h(`<div class="diff-summary">` + summary + `</div>`)
+		renderDiffDetails(h, details)
+		h(`</li>`)
+		return
+	}
+	open := ""
+	if node.HasChange && node.Kind != DiffAdded && node.Kind != DiffRemoved {
+		open = " open"
+	}
+	h(fmt.Sprintf(`<details%s><summary class="diff-summary">%s</summary>`, open, summary))
+	renderDiffDetails(h, details)
+	h(`<ul>`)
+	if node.HasChange {
+		for _, child := range node.Children {
+			renderDiffNode(h, child)
+		}
+	} else {
+		// unchanged subtrees can be large, summarize them
+		h(fmt.Sprintf(`<li class="diff-omitted">%d calls unchanged</li>`, countDiffNodes(node)-1))
+	}
+	h(`</ul>`)
+	h(`</details>`)
+	h(`</li>`)
+}
+
+func renderDiffDetails(h func(s string), details []string) {
+	if len(details) == 0 {
+		return
+	}
+	h(`<pre class="diff-detail">` + html.EscapeString(strings.Join(details, "\n")) + `</pre>`)
+}
+
+func countDiffNodes(node *DiffNode) int {
+	n := 1
+	for _, child := range node.Children {
+		n += countDiffNodes(child)
+	}
+	return n
+}
+
+const diffStyles = `
+body {
+    font-family: monospace;
+    margin: 8px;
+}
+.diff-header {
+    margin-bottom: 8px;
+}
+.diff-file.removed {
+    color: #DA2829;
+}
+.diff-file.added {
+    color: rgb(30, 150, 60);
+}
+.diff-stat {
+    margin: 4px 0;
+}
+.diff-tree,
+.diff-tree ul {
+    list-style: none;
+    padding-left: 1.2em;
+}
+.diff-summary {
+    cursor: pointer;
+}
+.diff-mark {
+    display: inline-block;
+    width: 1.2em;
+}
+.diff-cost {
+    margin-left: 8px;
+    color: rgb(151, 145, 139);
+}
+.diff-node.added>.diff-summary,
+.diff-node.added>details>.diff-summary {
+    background-color: rgb(220, 255, 220);
+}
+.diff-node.removed>.diff-summary,
+.diff-node.removed>details>.diff-summary {
+    background-color: rgb(255, 225, 225);
+}
+.diff-node.changed>.diff-summary,
+.diff-node.changed>details>.diff-summary {
+    background-color: rgb(255, 245, 200);
+}
+.diff-detail {
+    margin: 2px 0 4px 1.2em;
+    padding: 2px 4px;
+    background-color: rgb(245, 245, 245);
+    white-space: pre-wrap;
+}
+.diff-omitted {
+    color: rgb(151, 145, 139);
+}
+.only-changes .diff-node.unchanged {
+    display: none;
+}
+`
@@ -0,0 +1,110 @@
+package trace
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDiffTrace(t *testing.T) {
+	call := func(name string, cost int64, args map[string]interface{}, err string, children ...*StackExport) *StackExport {
+		return &StackExport{
+			FuncInfo: &FuncInfoExport{Pkg: "example.com/svc", IdentityName: name},
+			End:      cost,
+			Args:     args,
+			Error:    err,
+			Children: children,
+		}
+	}
+	old := &RootExport{Children: []*StackExport{
+		call("TestSave", 10e6, nil, "",
+			call("Load", 1e6, map[string]interface{}{"id": 1.0}, ""),
+			call("CacheGet", 1e6, nil, ""),
+			call("Save", 2e6, nil, ""),
+		),
+	}}
+	new := &RootExport{Children: []*StackExport{
+		call("TestSave", 10e6, nil, "",
+			call("Load", 1e6, map[string]interface{}{"id": 2.0}, ""),
+			call("Save", 8e6, nil, "timeout"),
+			call("Notify", 1e6, nil, ""),
+		),
+	}}
+	diff := DiffTrace(old, new, nil)
+	if !diff.HasChange {
+		t.Fatalf("expect change")
+	}
+	stat := diff.Stat()
+	expect := DiffStat{Added: 1, Removed: 1, Changed: 1, Errors: 1, Slower: 1}
+	if stat != expect {
+		t.Fatalf("expect stat %+v, actual %+v", expect, stat)
+	}
+
+	var buf bytes.Buffer
+	printDiffSummary(&buf, diff, "old.json", "new.json")
+	out := buf.String()
+	for _, want := range []string{
+		"~ TestSave > Load\n    args id: 1 -> 2\n",
+		"- TestSave > CacheGet\n",
+		"~ TestSave > Save\n    new error: timeout\n    slower 2ms -> 8ms (+300%)\n",
+		"+ TestSave > Notify\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expect output to contain %q, actual:\n%s", want, out)
+		}
+	}
+}
+
+func TestDiffJSON(t *testing.T) {
+	old := map[string]interface{}{
+		"a": 1.0,
+		"b": []interface{}{"x", "y"},
+		"c": map[string]interface{}{"d": true},
+	}
+	new := map[string]interface{}{
+		"a": 1.0,
+		"b": []interface{}{"x"},
+		"c": map[string]interface{}{"d": false, "e": nil},
+	}
+	lines := diffJSON("", old, new)
+	expect := []string{
+		`b[1]: removed "y"`,
+		`c.d: true -> false`,
+		`c.e: added null`,
+	}
+	if strings.Join(lines, "\n") != strings.Join(expect, "\n") {
+		t.Fatalf("expect %q, actual %q", expect, lines)
+	}
+	if lines := diffJSON("", old, old); len(lines) != 0 {
+		t.Fatalf("expect no diff, actual %q", lines)
+	}
+}
+
+func TestDiffJSONTruncated(t *testing.T) {
+	added := make(map[string]interface{})
+	var removed []interface{}
+	oldNested := make(map[string]interface{})
+	newNested := make(map[string]interface{})
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("k%02d", i)
+		added[key] = i
+		removed = append(removed, i)
+		oldNested[key] = map[string]interface{}{"v": i}
+		newNested[key] = map[string]interface{}{"v": i + 1}
+	}
+	for name, lines := range map[string][]string{
+		"added keys":       diffJSON("", map[string]interface{}{}, added),
+		"removed elements": diffJSON("", removed, []interface{}{}),
+		"nested changes":   diffJSON("", oldNested, newNested),
+	} {
+		if len(lines) != maxJSONDiffLines+1 || lines[maxJSONDiffLines] != "..." {
+			t.Errorf("%s: expect %d lines and ..., actual %q", name, maxJSONDiffLines, lines)
+		}
+	}
+	// not truncated when all lines fit
+	lines := diffJSON("", removed[:maxJSONDiffLines], []interface{}{})
+	if len(lines) != maxJSONDiffLines || lines[len(lines)-1] == "..." {
+		t.Fatalf("expect %d lines without ..., actual %q", maxJSONDiffLines, lines)
+	}
+}
@@ -0,0 +1,85 @@
+package trace
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+)
+
+//go:embed flamegraph.js
+var flameGraphScript string
+
+func renderFlameGraphHTML(profile *ProfileNode, title string, w io.Writer) {
+	data, err := json.Marshal(profile)
+	if err != nil {
+		data = []byte(fmt.Sprintf(`{"Name":%q}`, err.Error()))
+	}
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="UTF-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1.0">
+	<title>Flame graph of %s</title>
+	<style>%s</style>
+</head>
+<body>
+	<div class="flame-toolbar">
+		<input id="flame-search" placeholder="search function..." oninput="onFlameSearch(this.value)">
+		<button onclick="onFlameReset()">Reset Zoom</button>
+		<span id="flame-info"></span>
+	</div>
+	<div id="flame-graph" class="flame-graph"></div>
+	<script>
+	const profile = %s
+	%s
+	</script>
+</body>
+</html>
+`, html.EscapeString(title), flameGraphStyles, data, flameGraphScript)
+}
+
+const flameGraphStyles = `
+body {
+    font-family: monospace;
+    margin: 8px;
+}
+.flame-toolbar {
+    display: flex;
+    align-items: center;
+    gap: 8px;
+    margin-bottom: 8px;
+}
+#flame-search {
+    width: 300px;
+}
+#flame-info {
+    color: rgb(80, 80, 80);
+    white-space: nowrap;
+    overflow: hidden;
+    text-overflow: ellipsis;
+}
+.flame-graph {
+    position: relative;
+    width: 100%;
+}
+.flame-frame {
+    position: absolute;
+    height: 17px;
+    box-sizing: border-box;
+    border: 1px solid white;
+    font-size: 12px;
+    line-height: 15px;
+    padding-left: 2px;
+    overflow: hidden;
+    white-space: nowrap;
+    cursor: pointer;
+}
+.flame-frame.error {
+    border-color: rgb(220, 50, 50);
+}
+.flame-frame.matched {
+    background-color: rgb(230, 90, 230) !important;
+}
+`
@@ -0,0 +1,128 @@
+// this script runs after profile is defined
+// profile: {Name, Pkg, File, Line, Calls, Errors, Inclusive, Exclusive, Children}
+
+const frameHeight = 17
+const container = document.getElementById("flame-graph")
+const info = document.getElementById("flame-info")
+
+let focused = profile
+let searchText = ""
+
+// parent links are needed to show ancestors when zoomed
+function link(node, parent) {
+    node.parent = parent
+    for (const child of node.Children || []) {
+        link(child, node)
+    }
+}
+link(profile, null)
+
+function formatNs(ns) {
+    const units = [["ns", 1], ["μs", 1000], ["ms", 1000], ["s", 1000]]
+    let v = ns
+    let name = units[0][0]
+    for (let i = 1; i < units.length; i++) {
+        if (v < units[i][1]) {
+            break
+        }
+        v = v / units[i][1]
+        name = units[i][0]
+    }
+    return (Math.round(v * 100) / 100) + name
+}
+
+function colorOf(node) {
+    // same package gets the same hue
+    const key = node.Pkg || node.Name || ""
+    let h = 0
+    for (let i = 0; i < key.length; i++) {
+        h = (h * 31 + key.charCodeAt(i)) % 360
+    }
+    return `hsl(${20 + h % 40}, 85%, ${60 + h % 15}%)`
+}
+
+function describe(node) {
+    if (!node.Name) {
+        return `total ${formatNs(node.Inclusive)}`
+    }
+    const total = profile.Inclusive || 1
+    const pct = (node.Inclusive * 100 / total).toFixed(2)
+    let s = `${node.Name}  calls=${node.Calls}  inclusive=${formatNs(node.Inclusive)}(${pct}%)  exclusive=${formatNs(node.Exclusive)}`
+    if (node.Errors) {
+        s += `  errors=${node.Errors}`
+    }
+    return s
+}
+
+function render() {
+    container.innerHTML = ""
+    let depth = 0
+
+    // ancestors of focused node take full width
+    const ancestors = []
+    for (let p = focused; p; p = p.parent) {
+        ancestors.unshift(p)
+    }
+    for (const node of ancestors) {
+        addFrame(node, 0, 100, depth)
+        depth++
+    }
+    const maxDepth = layout(focused, 0, 100, depth - 1)
+    container.style.height = ((maxDepth + 1) * frameHeight) + "px"
+    info.innerText = describe(focused)
+}
+
+function layout(node, left, width, depth) {
+    let maxDepth = depth
+    const total = node.Inclusive || 0
+    let x = left
+    for (const child of node.Children || []) {
+        const w = total > 0 ? width * child.Inclusive / total : 0
+        if (w < 0.05) {
+            x += w
+            continue
+        }
+        addFrame(child, x, w, depth + 1)
+        maxDepth = Math.max(maxDepth, layout(child, x, w, depth + 1))
+        x += w
+    }
+    return maxDepth
+}
+
+function addFrame(node, left, width, depth) {
+    const el = document.createElement("div")
+    el.className = "flame-frame"
+    if (node.Errors) {
+        el.classList.add("error")
+    }
+    if (searchText && node.Name && node.Name.toLowerCase().includes(searchText)) {
+        el.classList.add("matched")
+    }
+    el.style.left = left + "%"
+    el.style.width = width + "%"
+    el.style.top = (depth * frameHeight) + "px"
+    el.style.backgroundColor = node.Name ? colorOf(node) : "rgb(200, 200, 200)"
+    el.innerText = node.Name || "all"
+    el.title = describe(node)
+    el.onmouseenter = () => { info.innerText = describe(node) }
+    el.onclick = () => {
+        focused = node
+        render()
+    }
+    container.appendChild(el)
+}
+
+function onFlameSearch(text) {
+    searchText = (text || "").toLowerCase()
+    render()
+}
+
+function onFlameReset() {
+    focused = profile
+    render()
+}
+
+window.onFlameSearch = onFlameSearch
+window.onFlameReset = onFlameReset
+
+render()
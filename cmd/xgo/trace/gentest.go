@@ -0,0 +1,860 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const mockPkgPath = "github.com/xhd2015/xgo/runtime/mock"
+
+// GenTestOptions controls test generation from a trace
+type GenTestOptions struct {
+	// Func selects the entry call by name, matched against
+	// method name, IdentityName, or IdentityName qualified
+	// by the package name or path
+	Func string
+	// Index selects the n-th call of Func, 0-based
+	Index int
+	// Mocks selects downstream calls to patch, by the same
+	// matching as Func. Empty means direct callees from
+	// other packages, except stdlib.
+	Mocks []string
+	// Source names the trace file in the header comment
+	Source string
+}
+
+// GenerateTest emits a test calling the selected function with
+// recorded args, patching selected dependencies to return
+// recorded results and asserting recorded outputs.
+// Types are read from source files recorded in the trace.
+func GenerateTest(root *RootExport, opts *GenTestOptions) ([]byte, error) {
+	entry := findEntryStack(root, opts.Func, opts.Index)
+	if entry == nil {
+		if opts.Index > 0 {
+			return nil, fmt.Errorf("call #%d of %s not found in trace", opts.Index, opts.Func)
+		}
+		return nil, fmt.Errorf("%s not found in trace", opts.Func)
+	}
+	entryDecl, err := loadFuncDecl(entry.FuncInfo)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", entry.FuncInfo.IdentityName, err)
+	}
+	if entryDecl.decl.Type.TypeParams != nil {
+		return nil, fmt.Errorf("%s: generic function is not supported", entry.FuncInfo.IdentityName)
+	}
+
+	g := &testGen{
+		pkgPath: entry.FuncInfo.Pkg,
+		imports: newGenImports(),
+	}
+	g.imports.addAs("encoding/json", "json")
+	g.imports.addAs("reflect", "reflect")
+	g.imports.addAs("testing", "testing")
+
+	mocks := collectMockCalls(entry, opts.Mocks)
+	for _, m := range mocks {
+		g.genMock(m)
+	}
+	g.genCall(entry, entryDecl)
+
+	var out bytes.Buffer
+	source := opts.Source
+	if source == "" {
+		source = "trace"
+	}
+	fmt.Fprintf(&out, "// Generated by xgo tool trace gen-test from %s.\n", source)
+	fmt.Fprintf(&out, "// Recorded values are a starting point, review before committing.\n\n")
+	fmt.Fprintf(&out, "package %s\n\n", entryDecl.file.Name.Name)
+	out.WriteString(g.imports.render())
+	fmt.Fprintf(&out, "\nfunc Test%sRecorded(t *testing.T) {\n", exportedIdent(entry.FuncInfo.IdentityName))
+	out.WriteString(genTestHelpers)
+	out.WriteString(g.body.String())
+	out.WriteString("}\n")
+
+	formatted, err := format.Source(out.Bytes())
+	if err != nil {
+		return out.Bytes(), fmt.Errorf("format generated test: %w", err)
+	}
+	return formatted, nil
+}
+
+const genTestHelpers = `	unmarshal := func(data string, v interface{}) {
+		t.Helper()
+		if err := json.Unmarshal([]byte(data), v); err != nil {
+			t.Fatalf("unmarshal %s: %v", data, err)
+		}
+	}
+	assertJSON := func(name string, expect string, actual interface{}) {
+		t.Helper()
+		data, err := json.Marshal(actual)
+		if err != nil {
+			t.Fatalf("marshal %s: %v", name, err)
+		}
+		var expectVal, actualVal interface{}
+		json.Unmarshal([]byte(expect), &expectVal)
+		json.Unmarshal(data, &actualVal)
+		if !reflect.DeepEqual(expectVal, actualVal) {
+			t.Errorf("%s: expect %s, actual %s", name, expect, data)
+		}
+	}
+	_ = unmarshal
+	_ = assertJSON
+
+`
+
+// names used by the generated test body
+var genTestReserved = map[string]bool{
+	"t": true, "unmarshal": true, "assertJSON": true, "err": true, "recv": true,
+}
+
+func matchStackName(pattern string, stack *StackExport) bool {
+	if stack.FuncInfo == nil {
+		return false
+	}
+	f := stack.FuncInfo
+	return matchFuncName(pattern, f.Pkg, f.IdentityName, f.Name, f.RecvType)
+}
+
+func findEntryStack(root *RootExport, name string, index int) *StackExport {
+	var found *StackExport
+	n := 0
+	var walk func(stack *StackExport) bool
+	walk = func(stack *StackExport) bool {
+		if matchStackName(name, stack) {
+			if n == index {
+				found = stack
+				return true
+			}
+			n++
+		}
+		for _, child := range stack.Children {
+			if walk(child) {
+				return true
+			}
+		}
+		return false
+	}
+	if root == nil {
+		return nil
+	}
+	for _, stack := range root.Children {
+		if walk(stack) {
+			break
+		}
+	}
+	return found
+}
+
+// mockCalls are recorded calls of one function to be patched
+type mockCalls struct {
+	funcInfo *FuncInfoExport
+	calls    []*StackExport
+}
+
+func collectMockCalls(entry *StackExport, patterns []string) []*mockCalls {
+	selected := func(stack *StackExport, direct bool) bool {
+		f := stack.FuncInfo
+		if f == nil || f.Kind != "" && f.Kind != FuncKind_Func || f.Closure || f.Generic {
+			return false
+		}
+		if len(patterns) == 0 {
+			return direct && f.Pkg != entry.FuncInfo.Pkg && !f.Stdlib
+		}
+		for _, p := range patterns {
+			if matchStackName(p, stack) {
+				return true
+			}
+		}
+		return false
+	}
+	var list []*mockCalls
+	byKey := make(map[string]*mockCalls)
+	var walk func(stack *StackExport, direct bool)
+	walk = func(stack *StackExport, direct bool) {
+		if selected(stack, direct) {
+			key := stackKey(stack)
+			m := byKey[key]
+			if m == nil {
+				m = &mockCalls{funcInfo: stack.FuncInfo}
+				byKey[key] = m
+				list = append(list, m)
+			}
+			m.calls = append(m.calls, stack)
+			// patched calls do not run their callees
+			return
+		}
+		for _, child := range stack.Children {
+			walk(child, false)
+		}
+	}
+	for _, child := range entry.Children {
+		walk(child, true)
+	}
+	return list
+}
+
+type funcDecl struct {
+	file *ast.File
+	decl *ast.FuncDecl
+	fset *token.FileSet
+}
+
+// loadFuncDecl finds declaration of f in its recorded source file
+func loadFuncDecl(f *FuncInfoExport) (*funcDecl, error) {
+	if f.File == "" {
+		return nil, fmt.Errorf("source file not recorded")
+	}
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, f.File, nil, 0)
+	if err != nil {
+		return nil, fmt.Errorf("parse source: %w", err)
+	}
+	var byName *ast.FuncDecl
+	for _, d := range file.Decls {
+		decl, ok := d.(*ast.FuncDecl)
+		if !ok || decl.Name.Name != f.Name {
+			continue
+		}
+		if recvTypeName(decl) != strings.TrimPrefix(f.RecvType, "*") {
+			continue
+		}
+		if f.Line > 0 && fset.Position(decl.Pos()).Line == f.Line {
+			return &funcDecl{file: file, decl: decl, fset: fset}, nil
+		}
+		byName = decl
+	}
+	if byName == nil {
+		return nil, fmt.Errorf("declaration not found in %s", f.File)
+	}
+	return &funcDecl{file: file, decl: byName, fset: fset}, nil
+}
+
+func recvTypeName(decl *ast.FuncDecl) string {
+	if decl.Recv == nil || len(decl.Recv.List) == 0 {
+		return ""
+	}
+	expr := decl.Recv.List[0].Type
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	switch x := expr.(type) {
+	case *ast.Ident:
+		return x.Name
+	case *ast.IndexExpr:
+		if id, ok := x.X.(*ast.Ident); ok {
+			return id.Name
+		}
+	case *ast.IndexListExpr:
+		if id, ok := x.X.(*ast.Ident); ok {
+			return id.Name
+		}
+	}
+	return ""
+}
+
+type testGen struct {
+	pkgPath string
+	imports *genImports
+	body    bytes.Buffer
+	// counts generated variables to keep them unique
+	mockSeq int
+}
+
+func (c *testGen) line(format string, args ...interface{}) {
+	fmt.Fprintf(&c.body, "\t"+format+"\n", args...)
+}
+
+// param is a parameter or result of a declaration
+type param struct {
+	name     string
+	typ      string
+	variadic bool
+	isCtx    bool
+	isT      bool
+	isError  bool
+}
+
+func (c *testGen) params(d *funcDecl, fields *ast.FieldList, r *typeRenderer) []param {
+	if fields == nil {
+		return nil
+	}
+	var list []param
+	for _, field := range fields.List {
+		typ := field.Type
+		var variadic bool
+		if ell, ok := typ.(*ast.Ellipsis); ok {
+			variadic = true
+			typ = ell.Elt
+		}
+		p := param{
+			typ:      r.render(typ),
+			variadic: variadic,
+		}
+		p.isCtx = r.isSelector(typ, "context", "Context") && !isStar(field.Type)
+		p.isT = r.isSelector(typ, "testing", "T") && isStar(field.Type)
+		if id, ok := typ.(*ast.Ident); ok && id.Name == "error" {
+			p.isError = true
+		}
+		if len(field.Names) == 0 {
+			list = append(list, p)
+			continue
+		}
+		for _, name := range field.Names {
+			p.name = name.Name
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+func isStar(expr ast.Expr) bool {
+	_, ok := expr.(*ast.StarExpr)
+	return ok
+}
+
+func (c *testGen) renderer(d *funcDecl, pkgPath string) *typeRenderer {
+	return &typeRenderer{
+		imports:     c.imports,
+		fileImports: fileImports(d.file),
+		pkgPath:     pkgPath,
+		testPkgPath: c.pkgPath,
+		fset:        d.fset,
+	}
+}
+
+// genCall emits the call of entry with recorded args and
+// assertions of recorded results
+func (c *testGen) genCall(entry *StackExport, d *funcDecl) {
+	f := entry.FuncInfo
+	r := c.renderer(d, f.Pkg)
+	args := jsonFields(entry.Args)
+	results := jsonFields(entry.Results)
+
+	var callee string
+	if d.decl.Recv != nil {
+		recvType := r.render(d.decl.Recv.List[0].Type)
+		c.line("// receiver")
+		if strings.HasPrefix(recvType, "*") {
+			c.line("recv := new(%s)", recvType[1:])
+			c.declValue("recv", args, f.RecvName, true)
+		} else {
+			c.line("var recv %s", recvType)
+			c.declValue("&recv", args, f.RecvName, true)
+		}
+		callee = "recv." + d.decl.Name.Name
+	} else {
+		callee = d.decl.Name.Name
+	}
+
+	params := c.params(d, d.decl.Type.Params, r)
+	var callArgs []string
+	if len(params) > 0 {
+		c.line("")
+		c.line("// recorded args")
+	}
+	for i, p := range params {
+		switch {
+		case p.isCtx:
+			callArgs = append(callArgs, c.imports.add("context")+".Background()")
+			continue
+		case p.isT:
+			callArgs = append(callArgs, "t")
+			continue
+		}
+		name := c.localName(p.name, i)
+		typ := p.typ
+		if p.variadic {
+			typ = "[]" + typ
+		}
+		c.line("var %s %s", name, typ)
+		c.declValue("&"+name, args, p.name, false)
+		if p.variadic {
+			name += "..."
+		}
+		callArgs = append(callArgs, name)
+	}
+
+	resParams := c.params(d, d.decl.Type.Results, r)
+	var resNames []string
+	errIdx := -1
+	for i, p := range resParams {
+		if p.isError && i == len(resParams)-1 {
+			errIdx = i
+			resNames = append(resNames, "err")
+			continue
+		}
+		name := c.localName(p.name, i)
+		if name == "arg"+strconv.Itoa(i) || paramNamed(params, name) {
+			name = fmt.Sprintf("r%d", i)
+		}
+		resNames = append(resNames, name)
+	}
+
+	c.line("")
+	if entry.Panic {
+		c.line("defer func() {")
+		c.line("\tif e := recover(); e == nil {")
+		c.line("\t\tt.Fatalf(\"expect panic: %%s\", %s)", strconv.Quote(entry.Error))
+		c.line("\t}")
+		c.line("}()")
+	}
+	call := callee + "(" + strings.Join(callArgs, ", ") + ")"
+	if len(resNames) == 0 {
+		c.line("%s", call)
+		return
+	}
+	c.line("%s := %s", strings.Join(resNames, ", "), call)
+	for i, p := range resParams {
+		if i == errIdx {
+			continue
+		}
+		data, ok := results[p.name]
+		if !ok {
+			c.line("_ = %s // TODO: result not recorded", resNames[i])
+			continue
+		}
+		label := p.name
+		if label == "" {
+			label = resNames[i]
+		}
+		c.line("assertJSON(%s, %s, %s)", strconv.Quote(label), goString(data), resNames[i])
+	}
+	if errIdx >= 0 {
+		if entry.Error != "" && !entry.Panic {
+			c.line("if err == nil || err.Error() != %s {", strconv.Quote(entry.Error))
+			c.line("\tt.Errorf(\"expect error %%q, actual: %%v\", %s, err)", strconv.Quote(entry.Error))
+			c.line("}")
+		} else {
+			c.line("if err != nil {")
+			c.line("\tt.Fatalf(\"unexpected error: %%v\", err)")
+			c.line("}")
+		}
+	}
+}
+
+// declValue unmarshals recorded value of key into ptr
+func (c *testGen) declValue(ptr string, fields map[string]string, key string, optional bool) {
+	data, ok := fields[key]
+	if !ok {
+		if !optional {
+			c.line("// TODO: %s not recorded", strings.TrimPrefix(ptr, "&"))
+		}
+		return
+	}
+	c.line("unmarshal(%s, %s)", goString(data), ptr)
+}
+
+func paramNamed(params []param, name string) bool {
+	for _, p := range params {
+		if p.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *testGen) localName(name string, i int) string {
+	if name == "" || name == "_" {
+		return fmt.Sprintf("arg%d", i)
+	}
+	if genTestReserved[name] || c.imports.hasAlias(name) {
+		return name + "Arg"
+	}
+	return name
+}
+
+// genMock patches a dependency to return recorded results in call order
+func (c *testGen) genMock(m *mockCalls) {
+	f := m.funcInfo
+	d, err := loadFuncDecl(f)
+	if err != nil {
+		c.line("// TODO: cannot patch %s: %v", f.IdentityName, err)
+		c.line("")
+		return
+	}
+	if d.decl.Type.TypeParams != nil {
+		c.line("// TODO: cannot patch generic %s", f.IdentityName)
+		c.line("")
+		return
+	}
+	r := c.renderer(d, f.Pkg)
+
+	var target string
+	var paramTypes []string
+	if d.decl.Recv != nil {
+		recvType := r.render(d.decl.Recv.List[0].Type)
+		target = recvType + "." + d.decl.Name.Name
+		if strings.HasPrefix(recvType, "*") {
+			target = "(" + recvType + ")." + d.decl.Name.Name
+		}
+		paramTypes = append(paramTypes, recvType)
+	} else {
+		target = d.decl.Name.Name
+		if f.Pkg != c.pkgPath {
+			target = c.imports.add(f.Pkg) + "." + target
+		}
+	}
+	params := c.params(d, d.decl.Type.Params, r)
+	for _, p := range params {
+		if p.variadic {
+			paramTypes = append(paramTypes, "..."+p.typ)
+		} else {
+			paramTypes = append(paramTypes, p.typ)
+		}
+	}
+	resParams := c.params(d, d.decl.Type.Results, r)
+	if r.unexportedRef {
+		c.line("// TODO: cannot patch %s, it refers to unexported types of %s", f.IdentityName, f.Pkg)
+		c.line("")
+		return
+	}
+
+	var resDecls []string
+	for i, p := range resParams {
+		resDecls = append(resDecls, fmt.Sprintf("r%d %s", i, p.typ))
+	}
+	signature := "func(" + strings.Join(paramTypes, ", ") + ")"
+	if len(resDecls) > 0 {
+		signature += " (" + strings.Join(resDecls, ", ") + ")"
+	}
+
+	c.mockSeq++
+	counter := fmt.Sprintf("calls%d", c.mockSeq)
+	mockAlias := c.imports.add(mockPkgPath)
+	if len(m.calls) == 1 {
+		c.line("// %s was called once", f.IdentityName)
+	} else {
+		c.line("// %s was called %d times, in order", f.IdentityName, len(m.calls))
+	}
+	c.line("var %s int", counter)
+	c.line("%s.Patch(%s, %s {", mockAlias, target, signature)
+	c.line("\t%s++", counter)
+	c.line("\tswitch %s {", counter)
+	for i, call := range m.calls {
+		if i == len(m.calls)-1 {
+			c.line("\tdefault:")
+		} else {
+			c.line("\tcase %d:", i+1)
+		}
+		c.genMockResults(call, resParams)
+	}
+	c.line("\t}")
+	if len(resParams) > 0 {
+		c.line("\treturn")
+	}
+	c.line("})")
+	c.line("")
+}
+
+func (c *testGen) genMockResults(call *StackExport, resParams []param) {
+	if call.Panic {
+		c.line("\t\tpanic(%s)", strconv.Quote(call.Error))
+		return
+	}
+	results := jsonFields(call.Results)
+	for i, p := range resParams {
+		if p.isError && i == len(resParams)-1 {
+			if call.Error != "" {
+				c.line("\t\tr%d = %s.New(%s)", i, c.imports.add("errors"), strconv.Quote(call.Error))
+			}
+			continue
+		}
+		data, ok := results[p.name]
+		if !ok {
+			continue
+		}
+		c.line("\t\tunmarshal(%s, &r%d)", goString(data), i)
+	}
+}
+
+// jsonFields returns recorded args or results by name as JSON
+func jsonFields(v interface{}) map[string]string {
+	fields := make(map[string]string)
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return fields
+	}
+	for k, val := range m {
+		data, err := json.Marshal(val)
+		if err != nil {
+			continue
+		}
+		fields[k] = string(data)
+	}
+	return fields
+}
+
+// goString quotes s as a raw string when possible
+func goString(s string) string {
+	if !strings.Contains(s, "`") && !strings.Contains(s, "\r") {
+		return "`" + s + "`"
+	}
+	return strconv.Quote(s)
+}
+
+// exportedIdent turns (*User).Save into UserSave
+func exportedIdent(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			if upper && r >= 'a' && r <= 'z' {
+				r = r - 'a' + 'A'
+			}
+			upper = false
+			b.WriteRune(r)
+			continue
+		}
+		upper = true
+	}
+	return b.String()
+}
+
+// genImports assigns unique local names to imported packages
+type genImports struct {
+	byPath  map[string]string
+	byAlias map[string]string
+}
+
+func newGenImports() *genImports {
+	return &genImports{
+		byPath:  make(map[string]string),
+		byAlias: make(map[string]string),
+	}
+}
+
+func (c *genImports) addAs(pkgPath string, alias string) string {
+	if a, ok := c.byPath[pkgPath]; ok {
+		return a
+	}
+	base := alias
+	for i := 2; c.byAlias[alias] != "" || genTestReserved[alias]; i++ {
+		alias = base + strconv.Itoa(i)
+	}
+	c.byPath[pkgPath] = alias
+	c.byAlias[alias] = pkgPath
+	return alias
+}
+
+func (c *genImports) add(pkgPath string) string {
+	return c.addAs(pkgPath, guessPkgName(pkgPath))
+}
+
+func (c *genImports) hasAlias(name string) bool {
+	return c.byAlias[name] != ""
+}
+
+func (c *genImports) render() string {
+	paths := make([]string, 0, len(c.byPath))
+	for p := range c.byPath {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	// stdlib first, then others
+	sort.SliceStable(paths, func(i, j int) bool {
+		return isStdPkg(paths[i]) && !isStdPkg(paths[j])
+	})
+	var b strings.Builder
+	b.WriteString("import (\n")
+	for i, p := range paths {
+		if i > 0 && isStdPkg(paths[i-1]) && !isStdPkg(p) {
+			b.WriteString("\n")
+		}
+		alias := c.byPath[p]
+		if alias == guessPkgName(p) {
+			fmt.Fprintf(&b, "\t%q\n", p)
+		} else {
+			fmt.Fprintf(&b, "\t%s %q\n", alias, p)
+		}
+	}
+	b.WriteString(")\n")
+	return b.String()
+}
+
+func isStdPkg(pkgPath string) bool {
+	first := pkgPath
+	if idx := strings.Index(pkgPath, "/"); idx >= 0 {
+		first = pkgPath[:idx]
+	}
+	return !strings.Contains(first, ".")
+}
+
+var versionSuffix = regexp.MustCompile(`^v[0-9]+$`)
+
+// guessPkgName guesses package name from import path,
+// e.g. gopkg.in/yaml.v3 -> yaml, example.com/x/v2 -> x
+func guessPkgName(pkgPath string) string {
+	base := path.Base(pkgPath)
+	if versionSuffix.MatchString(base) && path.Dir(pkgPath) != "." {
+		base = path.Base(path.Dir(pkgPath))
+	}
+	if idx := strings.Index(base, ".v"); idx > 0 {
+		base = base[:idx]
+	}
+	base = strings.TrimPrefix(base, "go-")
+	base = strings.Map(func(r rune) rune {
+		if r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return -1
+	}, base)
+	if base == "" {
+		return "pkg"
+	}
+	return base
+}
+
+// fileImports maps local names to import paths
+func fileImports(file *ast.File) map[string]string {
+	m := make(map[string]string)
+	for _, spec := range file.Imports {
+		p, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		name := guessPkgName(p)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		m[name] = p
+	}
+	return m
+}
+
+var predeclaredTypes = map[string]bool{
+	"bool": true, "byte": true, "complex64": true, "complex128": true, "error": true,
+	"float32": true, "float64": true, "int": true, "int8": true, "int16": true,
+	"int32": true, "int64": true, "rune": true, "string": true, "uint": true,
+	"uint8": true, "uint16": true, "uint32": true, "uint64": true, "uintptr": true,
+	"any": true, "comparable": true,
+}
+
+// typeRenderer renders type expressions of a source file
+// so that they resolve in the generated test file
+type typeRenderer struct {
+	imports     *genImports
+	fileImports map[string]string
+	pkgPath     string
+	testPkgPath string
+	fset        *token.FileSet
+
+	// refers to unexported type of another package
+	unexportedRef bool
+}
+
+func (c *typeRenderer) qualify(pkgPath string, name string) string {
+	if pkgPath == c.testPkgPath {
+		return name
+	}
+	if !ast.IsExported(name) {
+		c.unexportedRef = true
+	}
+	return c.imports.add(pkgPath) + "." + name
+}
+
+func (c *typeRenderer) isSelector(expr ast.Expr, pkgPath string, name string) bool {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	sel, ok := expr.(*ast.SelectorExpr)
+	if !ok || sel.Sel.Name != name {
+		return false
+	}
+	x, ok := sel.X.(*ast.Ident)
+	return ok && c.fileImports[x.Name] == pkgPath
+}
+
+func (c *typeRenderer) render(expr ast.Expr) string {
+	switch x := expr.(type) {
+	case *ast.Ident:
+		if predeclaredTypes[x.Name] {
+			return x.Name
+		}
+		return c.qualify(c.pkgPath, x.Name)
+	case *ast.SelectorExpr:
+		if id, ok := x.X.(*ast.Ident); ok {
+			if p, ok := c.fileImports[id.Name]; ok {
+				return c.qualify(p, x.Sel.Name)
+			}
+		}
+	case *ast.StarExpr:
+		return "*" + c.render(x.X)
+	case *ast.ParenExpr:
+		return "(" + c.render(x.X) + ")"
+	case *ast.Ellipsis:
+		return "..." + c.render(x.Elt)
+	case *ast.ArrayType:
+		if x.Len == nil {
+			return "[]" + c.render(x.Elt)
+		}
+		return "[" + c.node(x.Len) + "]" + c.render(x.Elt)
+	case *ast.MapType:
+		return "map[" + c.render(x.Key) + "]" + c.render(x.Value)
+	case *ast.ChanType:
+		switch x.Dir {
+		case ast.SEND:
+			return "chan<- " + c.render(x.Value)
+		case ast.RECV:
+			return "<-chan " + c.render(x.Value)
+		}
+		return "chan " + c.render(x.Value)
+	case *ast.FuncType:
+		s := "func(" + c.fieldTypes(x.Params) + ")"
+		if x.Results != nil && len(x.Results.List) > 0 {
+			s += " (" + c.fieldTypes(x.Results) + ")"
+		}
+		return s
+	case *ast.InterfaceType:
+		if x.Methods == nil || len(x.Methods.List) == 0 {
+			return "interface{}"
+		}
+	case *ast.StructType:
+		if x.Fields == nil || len(x.Fields.List) == 0 {
+			return "struct{}"
+		}
+	case *ast.IndexExpr:
+		return c.render(x.X) + "[" + c.render(x.Index) + "]"
+	case *ast.IndexListExpr:
+		var args []string
+		for _, idx := range x.Indices {
+			args = append(args, c.render(idx))
+		}
+		return c.render(x.X) + "[" + strings.Join(args, ", ") + "]"
+	}
+	return c.node(expr)
+}
+
+func (c *typeRenderer) fieldTypes(fields *ast.FieldList) string {
+	if fields == nil {
+		return ""
+	}
+	var list []string
+	for _, field := range fields.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		typ := c.render(field.Type)
+		for i := 0; i < n; i++ {
+			list = append(list, typ)
+		}
+	}
+	return strings.Join(list, ", ")
+}
+
+func (c *typeRenderer) node(n ast.Node) string {
+	var buf bytes.Buffer
+	printer.Fprint(&buf, c.fset, n)
+	return buf.String()
+}
@@ -0,0 +1,110 @@
+package trace
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const genTestHelp = `
+Xgo tool trace gen-test turns a recorded call into a regression test.
+
+The generated test calls the chosen function with the recorded
+arguments, patches selected downstream calls with mock.Patch to
+return the recorded results, and asserts the recorded outputs.
+
+Types are read from the source files recorded in the trace, so
+run it inside the module where the trace was generated.
+
+Usage:
+    xgo tool trace gen-test [options] --func <name> <trace.json>
+
+Options:
+    --func <name>      function to test, e.g. UpdateUser, (*Service).Get,
+                       user.UpdateUser or example.com/app/user.UpdateUser,
+                       * matches any
+    --index <n>        test the n-th call of the function, default 0
+    --mock <names>     comma separated functions to patch, default
+                       direct callees from other non-stdlib packages
+    -o <file>          output file, default stdout
+
+Examples:
+    xgo tool trace gen-test --func UpdateUser TestUpdate.json
+    xgo tool trace gen-test --func UpdateUser --mock 'dao.*' -o user/update_gen_test.go TestUpdate.json
+
+See https://github.com/xhd2015/xgo for documentation.
+
+`
+
+func handleGenTest(args []string) error {
+	var files []string
+	var output string
+	opts := &GenTestOptions{}
+
+	n := len(args)
+	for i := 0; i < n; i++ {
+		arg := args[i]
+		if arg == "--" {
+			files = append(files, args[i+1:]...)
+			break
+		}
+		if arg == "-h" || arg == "--help" {
+			fmt.Print(strings.TrimPrefix(genTestHelp, "\n"))
+			return nil
+		}
+		if arg == "--func" || arg == "--index" || arg == "--mock" || arg == "-o" {
+			if i+1 >= n {
+				return fmt.Errorf("%s requires arg", arg)
+			}
+			val := args[i+1]
+			i++
+			switch arg {
+			case "--func":
+				opts.Func = val
+			case "--index":
+				v, err := strconv.Atoi(val)
+				if err != nil {
+					return fmt.Errorf("--index: %w", err)
+				}
+				opts.Index = v
+			case "--mock":
+				for _, name := range strings.Split(val, ",") {
+					name = strings.TrimSpace(name)
+					if name != "" {
+						opts.Mocks = append(opts.Mocks, name)
+					}
+				}
+			case "-o":
+				output = val
+			}
+			continue
+		}
+		if !strings.HasPrefix(arg, "-") {
+			files = append(files, arg)
+			continue
+		}
+		return fmt.Errorf("unrecognized flag: %s", arg)
+	}
+	if opts.Func == "" {
+		return fmt.Errorf("requires --func")
+	}
+	if len(files) != 1 {
+		return fmt.Errorf("requires exactly 1 trace file, given: %v", files)
+	}
+	file := files[0]
+	root, err := parseRecord(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	opts.Source = file
+	code, err := GenerateTest(root, opts)
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		_, err = os.Stdout.Write(code)
+		return err
+	}
+	return os.WriteFile(output, code, 0644)
+}
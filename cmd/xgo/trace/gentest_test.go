@@ -0,0 +1,101 @@
+package trace
+
+import (
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerateTest(t *testing.T) {
+	dir := t.TempDir()
+	daoFile := filepath.Join(dir, "dao.go")
+	userFile := filepath.Join(dir, "user.go")
+	writeFile := func(file string, content string) {
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(daoFile, `package dao
+
+import "context"
+
+type User struct{ Name string }
+
+func Load(ctx context.Context, id int64) (*User, error) { return nil, nil }
+`)
+	writeFile(userFile, `package user
+
+import (
+	"context"
+
+	"example.com/app/dao"
+)
+
+func Rename(ctx context.Context, id int64, name string) (string, error) {
+	return "", nil
+}
+`)
+	load := &FuncInfoExport{
+		Kind: FuncKind_Func, Pkg: "example.com/app/dao", IdentityName: "Load", Name: "Load",
+		File: daoFile, Line: 7, ArgNames: []string{"ctx", "id"}, ResNames: []string{"", ""},
+		FirstArgCtx: true, LastResultErr: true,
+	}
+	root := &RootExport{Children: []*StackExport{{
+		FuncInfo: &FuncInfoExport{
+			Kind: FuncKind_Func, Pkg: "example.com/app/user", IdentityName: "Rename", Name: "Rename",
+			File: userFile, Line: 9, ArgNames: []string{"ctx", "id", "name"}, ResNames: []string{"", ""},
+			FirstArgCtx: true, LastResultErr: true,
+		},
+		Args:  map[string]interface{}{"id": 1.0, "name": "b`c"},
+		Error: "not found",
+		Children: []*StackExport{
+			{FuncInfo: load, Args: map[string]interface{}{"id": 1.0}, Results: map[string]interface{}{"": map[string]interface{}{"Name": "a"}}},
+			{FuncInfo: load, Args: map[string]interface{}{"id": 1.0}, Error: "not found"},
+		},
+	}}}
+
+	code, err := GenerateTest(root, &GenTestOptions{Func: "user.Rename", Source: "TestRename.json"})
+	if err != nil {
+		t.Fatalf("%v\n%s", err, code)
+	}
+	out := string(code)
+	for _, want := range []string{
+		"package user\n",
+		"\"example.com/app/dao\"\n\t\"github.com/xhd2015/xgo/runtime/mock\"\n",
+		"mock.Patch(dao.Load, func(context.Context, int64) (r0 *dao.User, r1 error) {",
+		"case 1:\n\t\t\tunmarshal(`{\"Name\":\"a\"}`, &r0)\n",
+		"default:\n\t\t\tr1 = errors.New(\"not found\")\n",
+		"unmarshal(\"\\\"b`c\\\"\", &name)",
+		"r0, err := Rename(context.Background(), id, name)",
+		"if err == nil || err.Error() != \"not found\" {",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expect output to contain %q, actual:\n%s", want, out)
+		}
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "gen_test.go", code, 0); err != nil {
+		t.Fatalf("parse generated: %v", err)
+	}
+
+	_, err = GenerateTest(root, &GenTestOptions{Func: "Rename", Index: 1})
+	if err == nil || err.Error() != "call #1 of Rename not found in trace" {
+		t.Fatalf("expect not found error, actual: %v", err)
+	}
+}
+
+func TestGuessPkgName(t *testing.T) {
+	tests := map[string]string{
+		"context":                       "context",
+		"gopkg.in/yaml.v3":              "yaml",
+		"example.com/app/v2":            "app",
+		"github.com/xhd2015/go-inspect": "inspect",
+	}
+	for pkgPath, expect := range tests {
+		if name := guessPkgName(pkgPath); name != expect {
+			t.Errorf("guessPkgName(%q): expect %s, actual %s", pkgPath, expect, name)
+		}
+	}
+}
@@ -0,0 +1,444 @@
+package trace
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// traceSummary is one row of the index page
+type traceSummary struct {
+	File      string
+	Name      string
+	Goroutine string
+	Duration  int64 // ns
+	Calls     int
+	Errors    int
+	Panics    int
+	ParseErr  string
+
+	modTime time.Time
+	size    int64
+}
+
+// summaryCache avoids re-parsing unchanged
+// files each time the index is visited
+type summaryCache struct {
+	mutex sync.Mutex
+	files map[string]*traceSummary
+}
+
+func (c *summaryCache) get(file string) *traceSummary {
+	stat, err := os.Stat(file)
+	if err != nil {
+		return &traceSummary{File: file, Name: traceNameFromPath(file), ParseErr: err.Error()}
+	}
+	c.mutex.Lock()
+	summary := c.files[file]
+	c.mutex.Unlock()
+	if summary != nil && summary.modTime.Equal(stat.ModTime()) && summary.size == stat.Size() {
+		return summary
+	}
+	summary = summarizeTraceFile(file)
+	summary.modTime = stat.ModTime()
+	summary.size = stat.Size()
+
+	c.mutex.Lock()
+	if c.files == nil {
+		c.files = make(map[string]*traceSummary)
+	}
+	c.files[file] = summary
+	c.mutex.Unlock()
+	return summary
+}
+
+func summarizeTraceFile(file string) *traceSummary {
+	summary := &traceSummary{
+		File:      file,
+		Name:      traceNameFromPath(file),
+		Goroutine: goroutineFromPath(file),
+	}
+	root, err := parseRecord(file)
+	if err != nil {
+		summary.ParseErr = err.Error()
+		return summary
+	}
+	if root == nil {
+		return summary
+	}
+	// traces emitted without a test name are named by
+	// goroutine, the top level function tells more
+	if summary.Goroutine != "" && len(root.Children) > 0 && root.Children[0].FuncInfo != nil {
+		summary.Name = root.Children[0].FuncInfo.IdentityName
+	}
+	var begin, end int64
+	for i, stack := range root.Children {
+		if i == 0 || stack.Begin < begin {
+			begin = stack.Begin
+		}
+		if stack.End > end {
+			end = stack.End
+		}
+	}
+	summary.Duration = end - begin
+
+	var walk func(stack *StackExport)
+	walk = func(stack *StackExport) {
+		summary.Calls++
+		if stack.Error != "" {
+			summary.Errors++
+		}
+		if stack.Panic {
+			summary.Panics++
+		}
+		for _, child := range stack.Children {
+			walk(child)
+		}
+	}
+	for _, stack := range root.Children {
+		walk(stack)
+	}
+	return summary
+}
+
+// traceNameFromPath derives test name from the file name,
+// subtests are written into sub directories
+func traceNameFromPath(file string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(filepath.Base(file), ".json"), BinaryTraceExt)
+}
+
+// goroutineFromPath extracts the goroutine id from
+// paths like trace_<time>/g_<id>/t_<n>.json
+func goroutineFromPath(file string) string {
+	for _, part := range strings.Split(filepath.ToSlash(file), "/") {
+		if strings.HasPrefix(part, "g_") {
+			return strings.TrimPrefix(part, "g_")
+		}
+	}
+	return ""
+}
+
+// serveDir serves an index of all traces found in paths,
+// the list is refreshed on every visit so traces generated
+// after the server started show up as well
+func serveDir(bindStr string, portStr string, paths []string) error {
+	files, err := listTraceFiles(paths)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no trace files found: %v", paths)
+	}
+	cache := &summaryCache{}
+	binCache := &binaryCache{}
+
+	// lookup ensures only listed files are served
+	lookup := func(w http.ResponseWriter, r *http.Request) ([]string, int, bool) {
+		files, err := listTraceFiles(paths)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, err.Error())
+			return nil, 0, false
+		}
+		file := r.URL.Query().Get("file")
+		for i, f := range files {
+			if f == file {
+				return files, i, true
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, fmt.Sprintf("trace not found: %s", file))
+		return nil, 0, false
+	}
+
+	server := http.NewServeMux()
+	server.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		files, err := listTraceFiles(paths)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, err.Error())
+			return
+		}
+		summaries := make([]*traceSummary, 0, len(files))
+		for _, file := range files {
+			summaries = append(summaries, cache.get(file))
+		}
+		w.Header().Set("Content-Type", "text/html")
+		renderIndexHTML(summaries, strings.Join(paths, " "), w)
+	})
+	server.HandleFunc("/view", func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if e := recover(); e != nil {
+				stack := debug.Stack()
+				io.WriteString(w, fmt.Sprintf("<pre>panic: %v\n%s</pre>", e, stack))
+			}
+		}()
+		files, idx, ok := lookup(w, r)
+		if !ok {
+			return
+		}
+		file := files[idx]
+		binTrace, err := binCache.get(file)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, fmt.Sprintf("%v", err))
+			return
+		}
+		nav := &traceNav{
+			Index:      "/",
+			Flamegraph: "/flamegraph?" + fileQuery(file),
+			Callgraph:  "/callgraph?" + fileQuery(file),
+		}
+		if idx > 0 {
+			nav.Prev = "/view?" + fileQuery(files[idx-1])
+		}
+		if idx+1 < len(files) {
+			nav.Next = "/view?" + fileQuery(files[idx+1])
+		}
+		if binTrace != nil {
+			w.Header().Set("Content-Type", "text/html")
+			renderLazyRecordHTML(binTrace, file, nav, &lazySource{Query: fileQuery(file)}, w)
+			return
+		}
+		record, err := parseRecord(file)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, fmt.Sprintf("%v", err))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		renderRecordHTML(record, file, nav, w)
+	})
+	server.HandleFunc("/flamegraph", func(w http.ResponseWriter, r *http.Request) {
+		files, idx, ok := lookup(w, r)
+		if !ok {
+			return
+		}
+		record, err := parseRecord(files[idx])
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, fmt.Sprintf("%v", err))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		renderFlameGraphHTML(AggregateProfile(record), files[idx], w)
+	})
+	server.HandleFunc("/callgraph", func(w http.ResponseWriter, r *http.Request) {
+		files, idx, ok := lookup(w, r)
+		if !ok {
+			return
+		}
+		record, err := parseRecord(files[idx])
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, fmt.Sprintf("%v", err))
+			return
+		}
+		serveCallGraph(w, r, []*RootExport{record}, files[idx])
+	})
+	server.HandleFunc("/openVscodeFile", handleOpenVscodeFile)
+	serveLazy(server, func(w http.ResponseWriter, r *http.Request) (*BinaryTrace, bool) {
+		files, idx, ok := lookup(w, r)
+		if !ok {
+			return nil, false
+		}
+		binTrace, err := binCache.get(files[idx])
+		if err == nil && binTrace == nil {
+			err = fmt.Errorf("not a binary trace: %s", files[idx])
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, err.Error())
+			return nil, false
+		}
+		return binTrace, true
+	})
+
+	return serveHTTP(server, bindStr, portStr, "")
+}
+
+func fileQuery(file string) string {
+	return url.Values{"file": []string{file}}.Encode()
+}
+
+func renderIndexHTML(summaries []*traceSummary, title string, w io.Writer) {
+	h := func(s string) {
+		_, err := io.WriteString(w, s)
+		if err != nil {
+			panic(err)
+		}
+		_, err = io.WriteString(w, "\n")
+		if err != nil {
+			panic(err)
+		}
+	}
+	var numFailed int
+	for _, s := range summaries {
+		if s.Errors > 0 || s.Panics > 0 || s.ParseErr != "" {
+			numFailed++
+		}
+	}
+	h(`<!DOCTYPE html>
+	<html lang="en">
+	<head>
+		<meta charset="UTF-8">
+		<meta name="viewport" content="width=device-width, initial-scale=1.0">
+		<title>Traces of ` + html.EscapeString(title) + `</title>
+	</head>
+	<body>`)
+	h(`<style>`)
+	h(indexStyles)
+	h(`</style>`)
+	h(fmt.Sprintf(`<div class="index-toolbar">
+		<input id="index-search" placeholder="filter by name or file..." oninput="onIndexFilter()">
+		<label><input id="index-errors" type="checkbox" onchange="onIndexFilter()">errors only</label>
+		<span class="index-status">%d traces, %d with errors</span>
+	</div>`, len(summaries), numFailed))
+	h(`<table class="index-table">`)
+	h(`<thead><tr>
+		<th onclick="onIndexSort(0)">Name</th>
+		<th onclick="onIndexSort(1)">Goroutine</th>
+		<th onclick="onIndexSort(2)">Duration</th>
+		<th onclick="onIndexSort(3)">Calls</th>
+		<th onclick="onIndexSort(4)">Status</th>
+		<th onclick="onIndexSort(5)">File</th>
+	</tr></thead>`)
+	h(`<tbody id="index-body">`)
+	for _, s := range summaries {
+		status := "ok"
+		statusClass := "ok"
+		var failed bool
+		if s.ParseErr != "" {
+			status = "invalid: " + s.ParseErr
+			statusClass = "error"
+			failed = true
+		} else if s.Panics > 0 {
+			status = fmt.Sprintf("%d panics, %d errors", s.Panics, s.Errors)
+			statusClass = "panic"
+			failed = true
+		} else if s.Errors > 0 {
+			status = fmt.Sprintf("%d errors", s.Errors)
+			statusClass = "error"
+			failed = true
+		}
+		goroutine := s.Goroutine
+		if goroutine == "" {
+			goroutine = "-"
+		}
+		viewURL := "/view?" + fileQuery(s.File)
+		h(fmt.Sprintf(`<tr data-failed="%t">
+			<td data-sort="%s"><a href="%s">%s</a></td>
+			<td data-sort="%s">%s</td>
+			<td data-sort="%d">%s</td>
+			<td data-sort="%d">%d</td>
+			<td data-sort="%s" class="status %s">%s</td>
+			<td data-sort="%s" class="file">%s</td>
+		</tr>`,
+			failed,
+			html.EscapeString(s.Name), html.EscapeString(viewURL), html.EscapeString(s.Name),
+			html.EscapeString(s.Goroutine), html.EscapeString(goroutine),
+			s.Duration, formatCost(0, s.Duration),
+			s.Calls, s.Calls,
+			statusClass, statusClass, html.EscapeString(status),
+			html.EscapeString(s.File), html.EscapeString(s.File),
+		))
+	}
+	h(`</tbody>`)
+	h(`</table>`)
+	h(`<script>`)
+	h(indexScript)
+	h(`</script>`)
+	h(`</body>
+	</html>`)
+}
+
+const indexStyles = `
+body {
+    font-family: sans-serif;
+    margin: 8px;
+}
+.index-toolbar {
+    display: flex;
+    align-items: center;
+    gap: 8px;
+    margin-bottom: 8px;
+}
+#index-search {
+    width: 300px;
+}
+.index-status {
+    color: rgb(151, 145, 139);
+}
+.index-table {
+    border-collapse: collapse;
+    width: 100%;
+}
+.index-table th {
+    text-align: left;
+    cursor: pointer;
+    border-bottom: 1px solid grey;
+    user-select: none;
+}
+.index-table td {
+    padding: 2px 8px 2px 0;
+    white-space: nowrap;
+}
+.index-table tr:hover {
+    background-color: rgb(238, 238, 238);
+}
+.status.error {
+    color: #DA2829;
+}
+.status.panic {
+    color: #ffb500;
+}
+.file {
+    color: rgb(119, 119, 119);
+}
+`
+
+const indexScript = `
+let sortColumn = -1
+let sortAsc = true
+function onIndexSort(col) {
+    sortAsc = sortColumn === col ? !sortAsc : true
+    sortColumn = col
+    const body = document.getElementById("index-body")
+    const rows = Array.from(body.rows)
+    const numeric = col === 2 || col === 3
+    rows.sort((a, b) => {
+        let x = a.cells[col].dataset.sort
+        let y = b.cells[col].dataset.sort
+        let c = numeric ? Number(x) - Number(y) : x.localeCompare(y)
+        return sortAsc ? c : -c
+    })
+    for (const row of rows) {
+        body.appendChild(row)
+    }
+}
+function onIndexFilter() {
+    const text = document.getElementById("index-search").value.trim().toLowerCase()
+    const errorsOnly = document.getElementById("index-errors").checked
+    for (const row of document.getElementById("index-body").rows) {
+        const name = row.cells[0].dataset.sort.toLowerCase()
+        const file = row.cells[5].dataset.sort.toLowerCase()
+        let show = !text || name.includes(text) || file.includes(text)
+        if (errorsOnly && row.dataset.failed !== "true") {
+            show = false
+        }
+        row.style.display = show ? "" : "none"
+    }
+}
+`
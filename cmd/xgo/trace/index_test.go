@@ -0,0 +1,38 @@
+package trace
+
+import (
+	"testing"
+)
+
+func TestGoroutineFromPath(t *testing.T) {
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"TestA.json", ""},
+		{"trace_20240101_120000/g_c000123/t_1.json", "c000123"},
+		{"/tmp/out/g_1f/t_2.json", "1f"},
+	}
+	for _, tt := range tests {
+		got := goroutineFromPath(tt.file)
+		if got != tt.want {
+			t.Errorf("goroutineFromPath(%q): expect %q, actual %q", tt.file, tt.want, got)
+		}
+	}
+}
+
+func TestSummarizeTraceFile(t *testing.T) {
+	summary := summarizeTraceFile("testdata/TestUpdateUseInfo.json")
+	if summary.ParseErr != "" {
+		t.Fatal(summary.ParseErr)
+	}
+	if summary.Name != "TestUpdateUseInfo" {
+		t.Errorf("expect name %q, actual %q", "TestUpdateUseInfo", summary.Name)
+	}
+	if summary.Calls != 12 || summary.Errors != 1 {
+		t.Errorf("expect 12 calls 1 error, actual %d calls %d errors", summary.Calls, summary.Errors)
+	}
+	if summary.Duration <= 0 {
+		t.Errorf("expect positive duration, actual %d", summary.Duration)
+	}
+}
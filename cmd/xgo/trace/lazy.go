@@ -0,0 +1,243 @@
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// lazyLoadBudget is the number of nodes sent per
+// request when viewing a binary trace
+const lazyLoadBudget = 2000
+
+// lazySource tells the viewer where to fetch
+// children and details of a binary trace from
+type lazySource struct {
+	// query appended to /children and /detail
+	Query string
+}
+
+// lazyStack is a node sent to the viewer without children,
+// Unloaded counts children not sent yet, Lazy tells args
+// and results are to be fetched via /detail
+type lazyStack struct {
+	*StackExport
+	Unloaded int  `json:",omitempty"`
+	Lazy     bool `json:",omitempty"`
+}
+
+type lazyNode struct {
+	ID     string
+	Parent string
+	Trace  *lazyStack
+}
+
+// openBinaryRecord opens file if it is a binary trace,
+// returns nil when it is not
+func openBinaryRecord(file string) (*BinaryTrace, func(), error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	header := make([]byte, len(binaryTraceMagic))
+	n, _ := io.ReadFull(f, header)
+	if !IsBinaryTrace(header[:n]) {
+		f.Close()
+		return nil, nil, nil
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	trace, err := OpenBinaryTrace(f, stat.Size())
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return trace, func() { f.Close() }, nil
+}
+
+// binaryCache keeps binary traces open so that
+// expanding a node does not re-read the index
+type binaryCache struct {
+	mutex sync.Mutex
+	files map[string]*binaryCacheEntry
+}
+
+type binaryCacheEntry struct {
+	trace   *BinaryTrace
+	close   func()
+	modTime time.Time
+	size    int64
+}
+
+// get returns nil if file is not a binary trace
+func (c *binaryCache) get(file string) (*BinaryTrace, error) {
+	stat, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry := c.files[file]
+	if entry != nil && entry.modTime.Equal(stat.ModTime()) && entry.size == stat.Size() {
+		return entry.trace, nil
+	}
+	if entry != nil && entry.close != nil {
+		entry.close()
+	}
+	trace, closeFile, err := openBinaryRecord(file)
+	if err != nil {
+		return nil, err
+	}
+	if c.files == nil {
+		c.files = make(map[string]*binaryCacheEntry)
+	}
+	c.files[file] = &binaryCacheEntry{
+		trace:   trace,
+		close:   closeFile,
+		modTime: stat.ModTime(),
+		size:    stat.Size(),
+	}
+	return trace, nil
+}
+
+// node ids: "0" is <root>, node i is i+1
+func lazyNodeID(i int) string {
+	return strconv.Itoa(i + 1)
+}
+
+func parseLazyNodeID(trace *BinaryTrace, id string) (int, error) {
+	n, err := strconv.Atoi(id)
+	if err != nil || n < 0 || n > len(trace.Nodes) {
+		return 0, fmt.Errorf("invalid id: %s", id)
+	}
+	return n - 1, nil
+}
+
+// collectLazyNodes loads the children of from (-1 for <root>)
+// and then descendants breadth first until budget is reached,
+// children of a node are either all loaded or none.
+func collectLazyNodes(trace *BinaryTrace, from int, budget int) []*lazyNode {
+	childrenOf := func(i int) []int {
+		if i < 0 {
+			return trace.Roots
+		}
+		return trace.Nodes[i].Children
+	}
+	var nodes []*lazyNode
+	// children of queued nodes, counted before they are loaded
+	var reserved int
+	queue := []int{from}
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+		if parent != from {
+			reserved -= len(childrenOf(parent))
+		}
+		for _, i := range childrenOf(parent) {
+			node := trace.Nodes[i]
+			stack, _ := trace.Stack(i, 0, false)
+			lazy := &lazyNode{
+				ID:     lazyNodeID(i),
+				Parent: lazyNodeID(parent),
+				Trace: &lazyStack{
+					StackExport: stack,
+					Lazy:        node.HasData(),
+				},
+			}
+			nodes = append(nodes, lazy)
+			if len(node.Children) == 0 {
+				continue
+			}
+			if len(nodes)+reserved+len(node.Children) > budget {
+				lazy.Trace.Unloaded = len(node.Children)
+				continue
+			}
+			reserved += len(node.Children)
+			queue = append(queue, i)
+		}
+	}
+	return nodes
+}
+
+func renderLazyRecordHTML(trace *BinaryTrace, file string, nav *traceNav, lazy *lazySource, w io.Writer) {
+	renderTraceHTML(file, nav, &traceView{Lazy: lazy}, w, func(h func(s string)) {
+		h(` traces["0"] = {"FuncInfo":{"IdentityName":"<root>"}}`)
+		h(` ids.push("0")`)
+		for _, node := range collectLazyNodes(trace, -1, lazyLoadBudget) {
+			data, err := json.Marshal(node.Trace)
+			if err != nil {
+				data = []byte(fmt.Sprintf(`{"error":%q}`, err.Error()))
+			}
+			h(fmt.Sprintf(` traces["%s"] = %s`, node.ID, data))
+			h(fmt.Sprintf(` ids.push("%s")`, node.ID))
+			h(fmt.Sprintf(` parents["%s"] = "%s"`, node.ID, node.Parent))
+		}
+	})
+}
+
+// handleLazyChildren responds children of the node
+// given by id, along with descendants within budget
+func handleLazyChildren(w http.ResponseWriter, r *http.Request, trace *BinaryTrace) {
+	i, err := parseLazyNodeID(trace, r.URL.Query().Get("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, err.Error())
+		return
+	}
+	writeJSON(w, collectLazyNodes(trace, i, lazyLoadBudget))
+}
+
+// handleLazyDetail responds args and results of the node given by id
+func handleLazyDetail(w http.ResponseWriter, r *http.Request, trace *BinaryTrace) {
+	i, err := parseLazyNodeID(trace, r.URL.Query().Get("id"))
+	if err == nil && i < 0 {
+		err = fmt.Errorf("<root> has no detail")
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, err.Error())
+		return
+	}
+	args, err := trace.Args(i)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, err.Error())
+		return
+	}
+	results, err := trace.Results(i)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, err.Error())
+		return
+	}
+	writeJSON(w, map[string]json.RawMessage{
+		"Args":    orNull(args),
+		"Results": orNull(results),
+	})
+}
+
+func orNull(data json.RawMessage) json.RawMessage {
+	if data == nil {
+		return json.RawMessage("null")
+	}
+	return data
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
@@ -0,0 +1,96 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConvertRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	data, err := os.ReadFile("testdata/TestUpdateUseInfo.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonFile := filepath.Join(dir, "TestUpdateUseInfo.json")
+	err = os.WriteFile(jsonFile, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = handleConvert([]string{jsonFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	binFile := filepath.Join(dir, "TestUpdateUseInfo"+BinaryTraceExt)
+	backFile := filepath.Join(dir, "back.json")
+	err = handleConvert([]string{"-o", backFile, binFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect, err := parseRecord(jsonFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := parseRecord(backFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectJSON, _ := json.Marshal(expect)
+	actualJSON, _ := json.Marshal(actual)
+	if !bytes.Equal(expectJSON, actualJSON) {
+		t.Fatalf("expect:\n%s\nactual:\n%s", expectJSON, actualJSON)
+	}
+}
+
+func TestCollectLazyNodes(t *testing.T) {
+	leaf := func() *StackExport {
+		return &StackExport{FuncInfo: &FuncInfoExport{IdentityName: "leaf"}, Args: map[string]interface{}{"a": 1}}
+	}
+	root := &RootExport{
+		Children: []*StackExport{
+			{
+				FuncInfo: &FuncInfoExport{IdentityName: "A"},
+				Children: []*StackExport{
+					{FuncInfo: &FuncInfoExport{IdentityName: "B"}, Children: []*StackExport{leaf(), leaf(), leaf()}},
+					leaf(),
+				},
+			},
+		},
+	}
+	var buf bytes.Buffer
+	err := WriteBinaryTrace(&buf, root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binTrace, err := OpenBinaryTrace(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A and its two children fit, B's three do not
+	nodes := collectLazyNodes(binTrace, -1, 4)
+	if len(nodes) != 3 {
+		t.Fatalf("expect 3 nodes, actual: %d", len(nodes))
+	}
+	b := nodes[1]
+	if b.ID != "2" || b.Parent != "1" || b.Trace.Unloaded != 3 {
+		t.Fatalf("expect B with 3 unloaded children, actual: %+v %+v", b, b.Trace)
+	}
+	if b.Trace.Lazy || !nodes[2].Trace.Lazy || nodes[2].Trace.Args != nil {
+		t.Fatalf("expect only leaf to have lazy args")
+	}
+
+	// children of the expanded node are always loaded
+	nodes = collectLazyNodes(binTrace, 1, 1)
+	if len(nodes) != 3 {
+		t.Fatalf("expect 3 nodes, actual: %d", len(nodes))
+	}
+	for _, node := range nodes {
+		if node.Parent != "2" {
+			t.Fatalf("expect parent B, actual: %s", node.Parent)
+		}
+	}
+}
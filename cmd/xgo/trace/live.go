@@ -0,0 +1,148 @@
+package trace
+
+import (
+	"bufio"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xhd2015/xgo/support/netutil"
+)
+
+//go:embed live.js
+var liveScript string
+
+// liveHub keeps all calls pushed so far, so browsers
+// opened later or reconnecting see them too
+type liveHub struct {
+	mutex  sync.Mutex
+	events [][]byte
+	// notified when events are added
+	changed chan struct{}
+}
+
+func newLiveHub() *liveHub {
+	return &liveHub{changed: make(chan struct{})}
+}
+
+func (c *liveHub) add(events ...[]byte) {
+	c.mutex.Lock()
+	c.events = append(c.events, events...)
+	close(c.changed)
+	c.changed = make(chan struct{})
+	c.mutex.Unlock()
+}
+
+// since returns events from index i, and a channel
+// closed when more events arrive
+func (c *liveHub) since(i int) ([][]byte, <-chan struct{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if i > len(c.events) {
+		i = len(c.events)
+	}
+	return c.events[i:len(c.events):len(c.events)], c.changed
+}
+
+var liveSessionRegexp = regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+
+// handleLivePush reads calls streamed by an instrumented
+// program, one LiveSpanExport per line
+func (c *liveHub) handlePush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	session := r.Header.Get("X-Xgo-Trace-Session")
+	if !liveSessionRegexp.MatchString(session) {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "requires X-Xgo-Trace-Session")
+		return
+	}
+	prefix := []byte(fmt.Sprintf(`{"Session":%q,"Span":`, session))
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 || !json.Valid(line) {
+			continue
+		}
+		event := make([]byte, 0, len(prefix)+len(line)+1)
+		event = append(event, prefix...)
+		event = append(event, line...)
+		event = append(event, '}')
+		c.add(event)
+	}
+}
+
+// handleEvents streams calls to the browser as server-sent
+// events, the event id is the index to resume from
+func (c *liveHub) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, "streaming not supported")
+		return
+	}
+	var next int
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		n, err := strconv.Atoi(lastID)
+		if err == nil && n >= 0 {
+			next = n + 1
+		}
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher.Flush()
+	for {
+		events, changed := c.since(next)
+		for _, event := range events {
+			_, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", next, event)
+			if err != nil {
+				return
+			}
+			next++
+		}
+		flusher.Flush()
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func serveLive(bindStr string, portStr string) error {
+	hub := newLiveHub()
+	server := http.NewServeMux()
+	server.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		renderTraceHTML("live", nil, &traceView{Live: true}, w, func(h func(s string)) {
+			h(` traces["1"] = {"FuncInfo":{"IdentityName":"<live>"}}`)
+			h(` ids.push("1")`)
+		})
+	})
+	server.HandleFunc("/live/push", hub.handlePush)
+	server.HandleFunc("/live/events", hub.handleEvents)
+	server.HandleFunc("/openVscodeFile", handleOpenVscodeFile)
+
+	host, port := netutil.GetHostAndIP(bindStr, portStr)
+	autoIncrPort := true
+	return netutil.ServePortHTTP(server, host, port, autoIncrPort, 500*time.Millisecond, func(port int) {
+		url, extra := netutil.GetURLToOpen(host, port)
+		netutil.PrintUrls(url, extra...)
+		fmt.Printf("Push traces by running the program with:\n    XGO_TRACE_LIVE=%s:%d\n", host, port)
+		openURL(url)
+	})
+}
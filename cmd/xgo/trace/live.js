@@ -0,0 +1,159 @@
+// this script runs after script.js on the live page,
+// calls pushed by instrumented programs arrive from
+// /live/events, one {Session, Span: {Trace, Name, ID, Parent, Stack}}
+// each, ids are unique within a session, i.e. a program run.
+// Calls complete before their callers, so a caller seen
+// first as a parent is shown as running until it completes.
+
+let livePaused = false
+let liveCount = 0
+let liveConnected = false
+const liveQueue = []
+let liveRenderTimer
+
+function liveTraceID(session, span) {
+    return `t_${session}_${span.Trace}`
+}
+function liveSpanID(session, id) {
+    return `s_${session}_${id}`
+}
+
+function sortKey(id) {
+    const begin = traces[id].Begin
+    return typeof begin === "number" ? begin : Infinity
+}
+
+// attach moves id under parent, siblings are kept in call order
+function attach(id, parent) {
+    const old = parents[id]
+    if (old) {
+        children[old] = children[old].filter(c => c !== id)
+    }
+    parents[id] = parent
+    const list = children[parent]
+    const key = sortKey(id)
+    let i = list.length
+    while (i > 0 && sortKey(list[i - 1]) > key) {
+        i--
+    }
+    list.splice(i, 0, id)
+}
+
+function ensureNode(id, parent, trace) {
+    if (traces[id]) {
+        return false
+    }
+    traces[id] = trace
+    ids.push(id)
+    children[id] = []
+    attach(id, parent)
+    return true
+}
+
+function addSpan(event) {
+    const span = event.Span
+    const traceID = liveTraceID(event.Session, span)
+    if (ensureNode(traceID, rootID, { FuncInfo: { IdentityName: span.Name || `trace ${span.Trace}` } })) {
+        expanded.add(rootID)
+        expanded.add(traceID)
+    }
+    const parent = span.Parent ? liveSpanID(event.Session, span.Parent) : traceID
+    ensureNode(parent, traceID, { FuncInfo: { IdentityName: "<running>" }, Running: true })
+
+    const id = liveSpanID(event.Session, span.ID)
+    const trace = span.Stack || { error: "missing stack" }
+    if (traces[id]) {
+        // a running caller completed
+        traces[id] = trace
+        delete searchTexts[id]
+    } else {
+        traces[id] = trace
+        ids.push(id)
+        children[id] = []
+    }
+    attach(id, parent)
+
+    // a trace spans from its first to its last call
+    const t = traces[traceID]
+    if (typeof trace.Begin === "number" && (t.Begin === undefined || trace.Begin < t.Begin)) {
+        t.Begin = trace.Begin
+    }
+    if (typeof trace.End === "number" && (t.End === undefined || trace.End > t.End)) {
+        t.End = trace.End
+    }
+    liveCount++
+}
+
+function renderLiveStatus() {
+    const el = document.getElementById("live-status")
+    let text = liveConnected ? `live: ${liveCount} calls` : "disconnected, retrying..."
+    if (livePaused) {
+        text = `paused: ${liveQueue.length} calls pending`
+    }
+    el.innerText = text
+}
+
+// scheduleRender batches updates, rendering on every call
+// would not keep up with busy programs
+function scheduleRender() {
+    if (liveRenderTimer) {
+        return
+    }
+    liveRenderTimer = setTimeout(() => {
+        liveRenderTimer = null
+        if (filter) {
+            applyFilter()
+        } else {
+            renderTree()
+        }
+        if (selectedID && traces[selectedID]) {
+            renderDetail(selectedID)
+        }
+        renderLiveStatus()
+    }, 200)
+}
+
+function applyQueued() {
+    for (const event of liveQueue) {
+        addSpan(event)
+    }
+    liveQueue.length = 0
+    scheduleRender()
+}
+
+function onClickLivePause() {
+    livePaused = !livePaused
+    document.getElementById("live-pause").innerText = livePaused ? "Resume" : "Pause"
+    if (!livePaused) {
+        applyQueued()
+    }
+    renderLiveStatus()
+}
+
+const liveSource = new EventSource("/live/events")
+liveSource.onopen = () => {
+    liveConnected = true
+    renderLiveStatus()
+}
+liveSource.onerror = () => {
+    liveConnected = false
+    renderLiveStatus()
+}
+// the browser resumes from the last event id after reconnecting
+liveSource.onmessage = (e) => {
+    let event
+    try {
+        event = JSON.parse(e.data)
+    } catch (err) {
+        return
+    }
+    liveQueue.push(event)
+    if (livePaused) {
+        renderLiveStatus()
+        return
+    }
+    applyQueued()
+}
+
+window.onClickLivePause = onClickLivePause
+renderLiveStatus()
@@ -0,0 +1,59 @@
+package trace
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLiveHub(t *testing.T) {
+	hub := newLiveHub()
+
+	push := httptest.NewRequest(http.MethodPost, "/live/push", strings.NewReader(
+		`{"Trace":1,"ID":2,"Parent":1,"Stack":{"Begin":1,"End":2}}`+"\n"+
+			"not json\n"+
+			`{"Trace":1,"ID":1,"Stack":{"Begin":0,"End":3}}`+"\n",
+	))
+	push.Header.Set("X-Xgo-Trace-Session", "42_1")
+	rec := httptest.NewRecorder()
+	hub.handlePush(rec, push)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("push: %d %s", rec.Code, rec.Body.String())
+	}
+
+	noSession := httptest.NewRequest(http.MethodPost, "/live/push", strings.NewReader("{}\n"))
+	rec = httptest.NewRecorder()
+	hub.handlePush(rec, noSession)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expect bad request without session, actual: %d", rec.Code)
+	}
+
+	events := func(lastID string) string {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		req := httptest.NewRequest(http.MethodGet, "/live/events", nil).WithContext(ctx)
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		rec := httptest.NewRecorder()
+		hub.handleEvents(rec, req)
+		return rec.Body.String()
+	}
+	expect := `id: 0
+data: {"Session":"42_1","Span":{"Trace":1,"ID":2,"Parent":1,"Stack":{"Begin":1,"End":2}}}
+
+id: 1
+data: {"Session":"42_1","Span":{"Trace":1,"ID":1,"Stack":{"Begin":0,"End":3}}}
+
+`
+	if actual := events(""); actual != expect {
+		t.Fatalf("expect:\n%s\nactual:\n%s", expect, actual)
+	}
+	// resume after reconnecting
+	if actual := events("0"); actual != expect[strings.Index(expect, "id: 1"):] {
+		t.Fatalf("expect resume from 1, actual:\n%s", actual)
+	}
+}
@@ -23,22 +23,61 @@ Xgo tool trace visualize a generated trace file.
 
 Usage:
     xgo tool trace <file>
+    xgo tool trace <dir or glob>...
+    xgo tool trace --live
+    xgo tool trace <cmd> [arguments]
+
+The commands are:
+    profile        aggregate traces into a pprof profile and flame graph
+    diff           compare two traces of the same test
+    print          print traces as text or markdown
+    gen-test       generate a regression test from a recorded call
+    convert        convert a trace between JSON and binary format
+    callgraph      aggregate traces into a call graph
 
 Examples:
     xgo test -run TestSomething --strace ./   generate trace file
     xgo tool trace TestSomething.json         visualize a generated trace
+    xgo tool trace ./                         list all traces under current dir
+    xgo tool trace profile -o trace.pprof ./  aggregate all traces under current dir
+    xgo tool trace diff old.json new.json     show what changed between two runs
+    xgo tool trace --live                     watch calls of a running program, which
+                                              is started with XGO_TRACE_LIVE=<host:port>
 
 See https://github.com/xhd2015/xgo for documentation.
 
 `
 
 func Main(args []string) {
+	if len(args) > 0 && (args[0] == "profile" || args[0] == "diff" || args[0] == "print" || args[0] == "gen-test" || args[0] == "convert" || args[0] == "callgraph") {
+		var err error
+		switch args[0] {
+		case "profile":
+			err = handleProfile(args[1:])
+		case "diff":
+			err = handleDiff(args[1:])
+		case "print":
+			err = handlePrint(args[1:])
+		case "gen-test":
+			err = handleGenTest(args[1:])
+		case "convert":
+			err = handleConvert(args[1:])
+		case "callgraph":
+			err = handleCallGraph(args[1:])
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 	var files []string
 	var port string
 	var bind string
 	n := len(args)
 
 	var showHelp bool
+	var live bool
 	for i := 0; i < n; i++ {
 		arg := args[i]
 		if arg == "--" {
@@ -49,6 +88,10 @@ func Main(args []string) {
 			showHelp = true
 			break
 		}
+		if arg == "--live" {
+			live = true
+			continue
+		}
 		if arg == "--port" {
 			if i+1 >= n {
 				fmt.Fprintf(os.Stderr, "--port requires arg\n")
@@ -86,27 +129,37 @@ func Main(args []string) {
 		fmt.Print(strings.TrimPrefix(help, "\n"))
 		return
 	}
-	if len(files) == 0 {
-		fmt.Fprintf(os.Stderr, "requires file\n")
+	if live && len(files) > 0 {
+		fmt.Fprintf(os.Stderr, "--live does not accept files\n")
 		os.Exit(1)
 	}
-	if len(files) != 1 {
-		fmt.Fprintf(os.Stderr, "xgo tool trace requires exactly 1 file, given: %v", files)
+	if !live && len(files) == 0 {
+		fmt.Fprintf(os.Stderr, "requires file\n")
 		os.Exit(1)
 	}
-	file := files[0]
 	if port == "" {
 		port = os.Getenv("PORT")
 	}
 	if bind == "" {
 		bind = "localhost"
 	}
-	err := serveFile(bind, port, file)
+	var err error
+	if live {
+		err = serveLive(bind, port)
+	} else if len(files) == 1 && isRegularFile(files[0]) {
+		err = serveFile(bind, port, files[0])
+	} else {
+		err = serveDir(bind, port, files)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
+}
 
+func isRegularFile(file string) bool {
+	stat, err := os.Stat(file)
+	return err == nil && !stat.IsDir()
 }
 
 func serveFile(bindStr string, portStr string, file string) error {
@@ -117,6 +170,7 @@ func serveFile(bindStr string, portStr string, file string) error {
 	if stat.IsDir() {
 		return fmt.Errorf("expect a trace file, given dir: %s", file)
 	}
+	cache := &binaryCache{}
 	server := http.NewServeMux()
 	server.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
@@ -125,6 +179,17 @@ func serveFile(bindStr string, portStr string, file string) error {
 				io.WriteString(w, fmt.Sprintf("<pre>panic: %v\n%s</pre>", e, stack))
 			}
 		}()
+		binTrace, err := cache.get(file)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, fmt.Sprintf("%v", err))
+			return
+		}
+		if binTrace != nil {
+			w.Header().Set("Content-Type", "text/html")
+			renderLazyRecordHTML(binTrace, file, &traceNav{Flamegraph: "/flamegraph", Callgraph: "/callgraph"}, &lazySource{}, w)
+			return
+		}
 		record, err := parseRecord(file)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
@@ -132,47 +197,102 @@ func serveFile(bindStr string, portStr string, file string) error {
 			return
 		}
 		w.Header().Set("Content-Type", "text/html")
-		renderRecordHTML(record, file, w)
+		renderRecordHTML(record, file, &traceNav{Flamegraph: "/flamegraph", Callgraph: "/callgraph"}, w)
 	})
-	server.HandleFunc("/openVscodeFile", func(w http.ResponseWriter, r *http.Request) {
-		q := r.URL.Query()
-		file := q.Get("file")
-		if file == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			io.WriteString(w, "no file\n")
+	server.HandleFunc("/flamegraph", func(w http.ResponseWriter, r *http.Request) {
+		record, err := parseRecord(file)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, fmt.Sprintf("%v", err))
 			return
 		}
-		_, err := os.Stat(file)
+		w.Header().Set("Content-Type", "text/html")
+		renderFlameGraphHTML(AggregateProfile(record), file, w)
+	})
+	server.HandleFunc("/callgraph", func(w http.ResponseWriter, r *http.Request) {
+		record, err := parseRecord(file)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			io.WriteString(w, err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			io.WriteString(w, fmt.Sprintf("%v", err))
 			return
 		}
-		line := q.Get("line")
-
-		fileLine := file
-		if line != "" {
-			fileLine = file + ":" + line
+		serveCallGraph(w, r, []*RootExport{record}, file)
+	})
+	server.HandleFunc("/openVscodeFile", handleOpenVscodeFile)
+	serveLazy(server, func(w http.ResponseWriter, r *http.Request) (*BinaryTrace, bool) {
+		binTrace, err := cache.get(file)
+		if err == nil && binTrace == nil {
+			err = fmt.Errorf("not a binary trace: %s", file)
 		}
-		output, err := exec.Command("code", "--goto", fileLine).Output()
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
-			if exitErr, ok := err.(*exec.ExitError); ok {
-				w.Write(exitErr.Stderr)
-			} else {
-				io.WriteString(w, err.Error())
-			}
+			io.WriteString(w, err.Error())
+			return nil, false
+		}
+		return binTrace, true
+	})
+
+	return serveHTTP(server, bindStr, portStr, "")
+}
+
+// serveLazy adds the endpoints binary traces are loaded from
+func serveLazy(server *http.ServeMux, getTrace func(w http.ResponseWriter, r *http.Request) (*BinaryTrace, bool)) {
+	server.HandleFunc("/children", func(w http.ResponseWriter, r *http.Request) {
+		binTrace, ok := getTrace(w, r)
+		if !ok {
 			return
 		}
-		w.Write(output)
+		handleLazyChildren(w, r, binTrace)
 	})
+	server.HandleFunc("/detail", func(w http.ResponseWriter, r *http.Request) {
+		binTrace, ok := getTrace(w, r)
+		if !ok {
+			return
+		}
+		handleLazyDetail(w, r, binTrace)
+	})
+}
 
+func handleOpenVscodeFile(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	file := q.Get("file")
+	if file == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "no file\n")
+		return
+	}
+	_, err := os.Stat(file)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, err.Error())
+		return
+	}
+	line := q.Get("line")
+
+	fileLine := file
+	if line != "" {
+		fileLine = file + ":" + line
+	}
+	output, err := exec.Command("code", "--goto", fileLine).Output()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			w.Write(exitErr.Stderr)
+		} else {
+			io.WriteString(w, err.Error())
+		}
+		return
+	}
+	w.Write(output)
+}
+
+func serveHTTP(server *http.ServeMux, bindStr string, portStr string, path string) error {
 	host, port := netutil.GetHostAndIP(bindStr, portStr)
 	autoIncrPort := true
-	err = netutil.ServePortHTTP(server, host, port, autoIncrPort, 500*time.Millisecond, func(port int) {
+	err := netutil.ServePortHTTP(server, host, port, autoIncrPort, 500*time.Millisecond, func(port int) {
 		url, extra := netutil.GetURLToOpen(host, port)
-		netutil.PrintUrls(url, extra...)
-		openURL(url)
+		netutil.PrintUrls(url+path, extra...)
+		openURL(url + path)
 	})
 	if err != nil {
 		return err
@@ -198,6 +318,14 @@ var script string
 var vscodeIconSVG string
 
 func parseRecord(file string) (*RootExport, error) {
+	binTrace, closeFile, err := openBinaryRecord(file)
+	if err != nil {
+		return nil, err
+	}
+	if binTrace != nil {
+		defer closeFile()
+		return binTrace.Root(true)
+	}
 	data, err := os.ReadFile(file)
 	if err != nil {
 		return nil, err
@@ -210,7 +338,59 @@ func parseRecord(file string) (*RootExport, error) {
 	return root, nil
 }
 
-func renderRecordHTML(root *RootExport, file string, w io.Writer) {
+// traceNav holds links rendered in the toolbar,
+// empty links are omitted
+type traceNav struct {
+	Index      string
+	Prev       string
+	Next       string
+	Flamegraph string
+	Callgraph  string
+}
+
+func renderRecordHTML(root *RootExport, file string, nav *traceNav, w io.Writer) {
+	top := &StackExport{
+		FuncInfo: &FuncInfoExport{
+			IdentityName: "<root>",
+		},
+		Children: root.Children,
+	}
+	renderTraceHTML(file, nav, &traceView{}, w, func(h func(s string)) {
+		nextID := int64(1)
+		var walk func(stack *StackExport, parentID int64)
+		walk = func(stack *StackExport, parentID int64) {
+			id := nextID
+			nextID++
+
+			stackData, err := marshalStackWithoutChildren(stack)
+			if err != nil {
+				stackData = []byte(fmt.Sprintf(`{"error":%q}`, err.Error()))
+			}
+			h(fmt.Sprintf(` traces["%d"] = %s`, id, stackData))
+			h(fmt.Sprintf(` ids.push("%d")`, id))
+			if parentID > 0 {
+				h(fmt.Sprintf(` parents["%d"] = "%d"`, id, parentID))
+			}
+			for _, child := range stack.Children {
+				walk(child, id)
+			}
+		}
+		walk(top, 0)
+	})
+}
+
+// traceView tells how the viewer page gets its traces
+type traceView struct {
+	// Lazy fetches missing children and details
+	// from the server on demand
+	Lazy *lazySource
+	// Live receives calls from /live/events as they complete
+	Live bool
+}
+
+// renderTraceHTML renders the viewer page,
+// writeTraces fills traces, ids and parents
+func renderTraceHTML(file string, nav *traceNav, view *traceView, w io.Writer, writeTraces func(h func(s string))) {
 	h := func(s string) {
 		_, err := io.WriteString(w, s)
 		if err != nil {
@@ -226,7 +406,7 @@ func renderRecordHTML(root *RootExport, file string, w io.Writer) {
 	<head>
 		<meta charset="UTF-8">
 		<meta name="viewport" content="width=device-width, initial-scale=1.0">
-		<title>Trace of ` + file + `</title>
+		<title>Trace of ` + html.EscapeString(file) + `</title>
 	</head>
 	<body style="height: 100%;">
 	`,
@@ -237,38 +417,23 @@ func renderRecordHTML(root *RootExport, file string, w io.Writer) {
 	h(styles)
 	h(`</style>`)
 
-	top := &StackExport{
-		FuncInfo: &FuncInfoExport{
-			IdentityName: "<root>",
-		},
-		Children: root.Children,
-	}
-
 	h("<script>")
 	h("window.onload = function(){")
 	h(" const traces = {}")
 	h(" const ids = []")
-	traceIDMapping := make(map[*StackExport]int64)
-	nextID := int64(1)
-	var walk func(stack *StackExport)
-	walk = func(stack *StackExport) {
-		id := nextID
-		nextID++
-		traceIDMapping[stack] = id
-
-		stackData, err := marshalStackWithoutChildren(stack)
-		if err != nil {
-			stackData = []byte(fmt.Sprintf(`{"error":%q}`, err.Error()))
-		}
-		h(fmt.Sprintf(` traces["%d"] = %s`, id, stackData))
-		h(fmt.Sprintf(` ids.push("%d")`, id))
-		for _, child := range stack.Children {
-			walk(child)
-		}
+	h(" const parents = {}")
+	h(fmt.Sprintf(" const svgToggle = %s", jsString(makeSvg(svgIconDown, `class="toggle-icon-down"`)+makeSvg(svgIconRight, `class="toggle-icon-right"`))))
+	if view.Lazy != nil {
+		h(fmt.Sprintf(" const lazyQuery = %s", jsString(view.Lazy.Query)))
+	} else {
+		h(" const lazyQuery = null")
 	}
-	walk(top)
+	writeTraces(h)
 
 	h(script)
+	if view.Live {
+		h(liveScript)
+	}
 	h("}")
 	h("</script>")
 
@@ -276,12 +441,11 @@ func renderRecordHTML(root *RootExport, file string, w io.Writer) {
 
 	h(`<div class="trace-list-root">`)
 	h(`<div>`)
-	renderToolbar(h)
+	renderToolbar(h, nav, view.Live)
 	h(`</div>`)
-	// h(fmt.Sprintf(`<ul id="%s" class="trace-list">`, getTraceListID(traceIDMapping[top])))
-	h(`<ul class="trace-list">`)
-	add(h, top, traceIDMapping)
-	h("</ul>")
+	// the tree is rendered lazily by script.js,
+	// only expanded nodes are put into the DOM
+	h(`<ul id="trace-list" class="trace-list"></ul>`)
 	h(`</div>`)
 
 	vscode := vscodeIconSVG
@@ -291,14 +455,17 @@ func renderRecordHTML(root *RootExport, file string, w io.Writer) {
 	vscode = `<div id="vscode-icon" class="vscode-icon" onclick="onClickVscodeIcon(arguments[0])">` + vscode + `</div>`
 
 	h(`<div class="detail">`)
+	h(`<div id="breadcrumb" class="breadcrumb"></div>`)
 	h(`<div id="detail-info">
 	   <div class="label-value"> <label>Pkg:</label>	   <div id="detail-info-pkg"> </div> </div>
-	   <div class="label-value"> <label>Func:</label>    <div id="detail-info-func"> </div> ` + vscode + `</div>
+	   <div class="label-value"> <label>Func:</label>    <div id="detail-info-func"> </div> ` + vscode + `<span id="detail-info-mock" class="head-tag mock hidden">mock</span></div>
 	</div>`)
 	h(`<label>Request</label>`)
 	h(`<textarea id="detail-request"  placeholder="request..."></textarea>`)
 	h(`<label>Response</label>`)
 	h(`<textarea id="detail-response" placeholder="response..."></textarea>`)
+	h(`<label id="detail-logs-label" class="hidden">Logs</label>`)
+	h(`<textarea id="detail-logs" class="hidden" readonly></textarea>`)
 	h("</div>")
 
 	h("</div>")
@@ -307,12 +474,39 @@ func renderRecordHTML(root *RootExport, file string, w io.Writer) {
 	h(`</body>
 	</html>`)
 }
-func renderToolbar(h func(s string)) {
+func renderToolbar(h func(s string), nav *traceNav, live bool) {
+	link := func(href string, text string, target string) {
+		if href == "" {
+			return
+		}
+		if target != "" {
+			target = fmt.Sprintf(` target="%s"`, target)
+		}
+		h(fmt.Sprintf(`<a class="toolbar-link" href="%s"%s>%s</a>`, html.EscapeString(href), target, text))
+	}
+	h(`<div class="toolbar-row">`)
 	h(fmt.Sprintf(`<div id="toolbar" class="toggle-all-on" onClick="onClickExpandAll(arguments[0])">%s</div>`, svgExpand))
-}
-
-func getTraceListID(id int64) string {
-	return fmt.Sprintf("trace_list_%d", id)
+	if nav != nil {
+		link(nav.Flamegraph, "Flame Graph", "_blank")
+		link(nav.Callgraph, "Call Graph", "_blank")
+		link(nav.Index, "Index", "")
+		link(nav.Prev, "&lt; Prev", "")
+		link(nav.Next, "Next &gt;", "")
+	}
+	if live {
+		h(`<button id="live-pause" class="live-pause" onclick="onClickLivePause()">Pause</button>`)
+		h(`<span id="live-status" class="filter-status"></span>`)
+	}
+	h(`</div>`)
+	h(`<div class="toolbar-row">`)
+	h(`<input id="search" class="search" placeholder="search func, pkg, args, results, error..." oninput="onFilterChange()">`)
+	h(`</div>`)
+	h(`<div class="toolbar-row filters">`)
+	h(`<label><input id="filter-error" type="checkbox" onchange="onFilterChange()">errors/panics</label>`)
+	h(`<label><input id="filter-stdlib" type="checkbox" onchange="onFilterChange()">hide stdlib</label>`)
+	h(`<label>duration &gt; <input id="filter-duration" class="filter-duration" placeholder="e.g. 10ms" oninput="onFilterChange()"></label>`)
+	h(`<span id="filter-status" class="filter-status"></span>`)
+	h(`</div>`)
 }
 
 const svgIconDown = `<svg stroke="currentColor" fill="currentColor" stroke-width="0" viewBox="0 0 16 16" height="1em" width="1em" xmlns="http://www.w3.org/2000/svg"><path fill-rule="evenodd" d="M1.646 4.646a.5.5 0 0 1 .708 0L8 10.293l5.646-5.647a.5.5 0 0 1 .708.708l-6 6a.5.5 0 0 1-.708 0l-6-6a.5.5 0 0 1 0-.708z"></path></svg>`
@@ -342,75 +536,13 @@ func marshalStackWithoutChildren(stack *StackExport) ([]byte, error) {
 	return json.Marshal(stack)
 }
 
-const allowPkgName = false
-
-func add(h func(string), stack *StackExport, traceIDMapping map[*StackExport]int64) {
-	var name string
-	if stack.FuncInfo != nil {
-		name = stack.FuncInfo.IdentityName
-		if stack.FuncInfo.Pkg != "" && allowPkgName {
-			name = lastPart(stack.FuncInfo.Pkg) + "." + name
-		}
-	}
-	if name == "" {
-		name = "<unknown>"
-	}
-	id := traceIDMapping[stack]
-
-	var indicator string
-
-	if len(stack.Children) > 0 {
-		// NOTE: onclick on svg does not work, must wrap it with div
-		makeIcon := func(status string, down string, right string) string {
-			svgDown := makeSvg(down, `class="toggle-icon-down"`)
-			svgRight := makeSvg(right, `class="toggle-icon-right"`)
-			toggleID := fmt.Sprintf("toggle_%d", id)
-			return fmt.Sprintf(`<div  id="%s" class="toggle %s" onclick="onClickToggle(arguments[0],'%d')">%s%s</div>`, toggleID, status, id, svgDown, svgRight)
-		}
-		indicator = makeIcon("down", svgIconDown, svgIconRight)
-	}
-	headClass := "head-block"
-	if stack.Panic {
-		headClass = headClass + " panic"
-	}
-	if stack.Error != "" {
-		headClass = headClass + " error"
-	}
-
-	h(fmt.Sprintf(`<div class="head">
-	%s
-	<div class="head-info" id="head_%d" onclick="onClickHead('%d')">
-		<div class="%s"></div>
-		<span class="head-name">%s</span>
-		<span class="head-cost">%s</span>
-	</div>
-	</div>
-	`,
-		indicator,
-		id, id,
-		headClass,
-		html.EscapeString(name),
-		formatCost(stack.Begin, stack.End),
-	))
-
-	if len(stack.Children) == 0 {
-		return
-	}
-	h(fmt.Sprintf(`<ul id="%s" class="trace-sub-list">`, getTraceListID(id)))
-	for _, child := range stack.Children {
-		h("<li>")
-		add(h, child, traceIDMapping)
-		h("</li>")
-	}
-	h("</ul>")
-}
-
-func lastPart(pkg string) string {
-	idx := strings.LastIndex(pkg, "/")
-	if idx < 0 {
-		return pkg
+// jsString quotes s as a javascript string literal
+func jsString(s string) string {
+	data, err := json.Marshal(s)
+	if err != nil {
+		panic(err)
 	}
-	return pkg[idx+1:]
+	return string(data)
 }
 
 func formatCost(begin int64, end int64) string {
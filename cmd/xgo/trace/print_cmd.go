@@ -0,0 +1,126 @@
+package trace
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const printHelp = `
+Xgo tool trace print renders traces as an indented call tree
+with durations, args, results, errors and panics, for terminals
+and CI logs where a browser is not available.
+
+Usage:
+    xgo tool trace print [options] <file or dir>...
+
+Options:
+    --markdown         render as markdown, e.g. for CI job summary
+    --depth <n>        max depth of call tree, default unlimited
+    --width <n>        max calls listed under one call, default 50
+    --max-len <n>      max length of args and results, default 120
+    --color <mode>     auto, always or never, default auto
+
+Examples:
+    xgo tool trace print TestSomething.json
+    xgo tool trace print --markdown ./traces >> "$GITHUB_STEP_SUMMARY"
+
+See https://github.com/xhd2015/xgo for documentation.
+
+`
+
+func handlePrint(args []string) error {
+	var files []string
+	var colorMode string = "auto"
+	opts := DefaultTextOptions()
+
+	n := len(args)
+	for i := 0; i < n; i++ {
+		arg := args[i]
+		if arg == "--" {
+			files = append(files, args[i+1:]...)
+			break
+		}
+		if arg == "-h" || arg == "--help" {
+			fmt.Print(strings.TrimPrefix(printHelp, "\n"))
+			return nil
+		}
+		if arg == "--markdown" || arg == "--md" {
+			opts.Markdown = true
+			continue
+		}
+		if arg == "--depth" || arg == "--width" || arg == "--max-len" || arg == "--color" {
+			if i+1 >= n {
+				return fmt.Errorf("%s requires arg", arg)
+			}
+			val := args[i+1]
+			i++
+			if arg == "--color" {
+				if val != "auto" && val != "always" && val != "never" {
+					return fmt.Errorf("--color: expect auto, always or never, given: %s", val)
+				}
+				colorMode = val
+				continue
+			}
+			v, err := strconv.Atoi(val)
+			if err != nil {
+				return fmt.Errorf("%s: %w", arg, err)
+			}
+			switch arg {
+			case "--depth":
+				opts.MaxDepth = v
+			case "--width":
+				opts.MaxChildren = v
+			case "--max-len":
+				opts.MaxValueLen = v
+			}
+			continue
+		}
+		if !strings.HasPrefix(arg, "-") {
+			files = append(files, arg)
+			continue
+		}
+		return fmt.Errorf("unrecognized flag: %s", arg)
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("requires file")
+	}
+	traceFiles, err := listTraceFiles(files)
+	if err != nil {
+		return err
+	}
+	if len(traceFiles) == 0 {
+		return fmt.Errorf("no trace files found: %v", files)
+	}
+	switch colorMode {
+	case "always":
+		opts.Color = true
+	case "auto":
+		opts.Color = isTerminal(os.Stdout)
+	}
+	for _, file := range traceFiles {
+		root, err := parseRecord(file)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		opts.Title = traceNameFromPath(file)
+		if len(traceFiles) > 1 {
+			// disambiguate traces of the same name
+			opts.Title = file
+		}
+		err = WriteText(os.Stdout, root, opts)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
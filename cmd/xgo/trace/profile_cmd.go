@@ -0,0 +1,210 @@
+package trace
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+const profileHelp = `
+Xgo tool trace profile aggregates one or many trace files into
+a pprof-compatible profile with inclusive and exclusive wall time
+per function and call path.
+
+Usage:
+    xgo tool trace profile [options] <file or dir>...
+
+Options:
+    -o <file>          write pprof profile to file, view it with: go tool pprof -http=: <file>
+    --top <n>          print top n functions by exclusive time, default 20
+    --serve            serve an interactive flame graph
+    --port <port>      port to serve, effective with --serve
+    --bind <addr>      address to bind, default localhost
+
+Examples:
+    xgo tool trace profile -o trace.pprof ./traces     aggregate all traces under ./traces
+    xgo tool trace profile --serve TestA.json TestB.json
+
+See https://github.com/xhd2015/xgo for documentation.
+
+`
+
+func handleProfile(args []string) error {
+	var files []string
+	var outFile string
+	var top int = 20
+	var serve bool
+	var port string
+	var bind string
+
+	n := len(args)
+	for i := 0; i < n; i++ {
+		arg := args[i]
+		if arg == "--" {
+			files = append(files, args[i+1:]...)
+			break
+		}
+		if arg == "-h" || arg == "--help" {
+			fmt.Print(strings.TrimPrefix(profileHelp, "\n"))
+			return nil
+		}
+		if arg == "--serve" {
+			serve = true
+			continue
+		}
+		if arg == "-o" || arg == "--top" || arg == "--port" || arg == "--bind" {
+			if i+1 >= n {
+				return fmt.Errorf("%s requires arg", arg)
+			}
+			val := args[i+1]
+			i++
+			switch arg {
+			case "-o":
+				outFile = val
+			case "--top":
+				v, err := strconv.Atoi(val)
+				if err != nil {
+					return fmt.Errorf("--top: %w", err)
+				}
+				top = v
+			case "--port":
+				port = val
+			case "--bind":
+				bind = val
+			}
+			continue
+		}
+		if !strings.HasPrefix(arg, "-") {
+			files = append(files, arg)
+			continue
+		}
+		return fmt.Errorf("unrecognized flag: %s", arg)
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("requires file")
+	}
+	traceFiles, err := listTraceFiles(files)
+	if err != nil {
+		return err
+	}
+	if len(traceFiles) == 0 {
+		return fmt.Errorf("no trace files found: %v", files)
+	}
+	roots := make([]*RootExport, 0, len(traceFiles))
+	for _, file := range traceFiles {
+		root, err := parseRecord(file)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		roots = append(roots, root)
+	}
+	if outFile != "" {
+		data, err := MarshalProfile(roots...)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(outFile, data, 0755)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "aggregated %d traces into %s\n", len(roots), outFile)
+	}
+	profile := AggregateProfile(roots...)
+	if outFile == "" || top > 0 {
+		printProfileTop(os.Stdout, profile, top)
+	}
+	if !serve {
+		return nil
+	}
+	if bind == "" {
+		bind = "localhost"
+	}
+	title := strings.Join(files, " ")
+	server := http.NewServeMux()
+	server.HandleFunc("/flamegraph", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		renderFlameGraphHTML(profile, title, w)
+	})
+	return serveHTTP(server, bind, port, "/flamegraph")
+}
+
+// listTraceFiles expands globs and directories into
+// the trace files they contain, recursively
+func listTraceFiles(paths []string) ([]string, error) {
+	var expanded []string
+	for _, path := range paths {
+		if !strings.ContainsAny(path, "*?[") {
+			expanded = append(expanded, path)
+			continue
+		}
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no match: %s", path)
+		}
+		expanded = append(expanded, matches...)
+	}
+	var files []string
+	for _, path := range expanded {
+		stat, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !stat.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				if file != path && strings.HasPrefix(info.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasSuffix(file, ".json") || strings.HasSuffix(file, BinaryTraceExt) {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+func printProfileTop(w io.Writer, profile *ProfileNode, top int) {
+	funcs := profile.Funcs()
+	if top > 0 && len(funcs) > top {
+		funcs = funcs[:top]
+	}
+	total := profile.Inclusive
+	fmt.Fprintf(w, "Total: %s\n", formatCost(0, total))
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "flat\tflat%%\tcum\tcum%%\tcalls\terrors\t \n")
+	for _, fn := range funcs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\t %s\n",
+			formatCost(0, fn.Exclusive), percent(fn.Exclusive, total),
+			formatCost(0, fn.Inclusive), percent(fn.Inclusive, total),
+			fn.Calls, fn.Errors, fn.Name,
+		)
+	}
+	tw.Flush()
+}
+
+func percent(v int64, total int64) string {
+	if total <= 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f%%", float64(v)*100/float64(total))
+}
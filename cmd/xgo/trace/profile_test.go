@@ -0,0 +1,68 @@
+package trace
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+)
+
+func TestAggregateProfile(t *testing.T) {
+	fn := func(name string) *FuncInfoExport {
+		return &FuncInfoExport{Pkg: "main", IdentityName: name}
+	}
+	root := &RootExport{
+		Children: []*StackExport{
+			{
+				FuncInfo: fn("A"), Begin: 0, End: 100,
+				Children: []*StackExport{
+					{FuncInfo: fn("B"), Begin: 10, End: 40},
+					{FuncInfo: fn("B"), Begin: 50, End: 70, Error: "fail"},
+				},
+			},
+		},
+	}
+	profile := AggregateProfile(root, root)
+	if profile.Inclusive != 200 {
+		t.Fatalf("expect total 200, actual: %d", profile.Inclusive)
+	}
+	if len(profile.Children) != 1 {
+		t.Fatalf("expect 1 child, actual: %d", len(profile.Children))
+	}
+	a := profile.Children[0]
+	if a.Name != "main.A" || a.Calls != 2 || a.Inclusive != 200 || a.Exclusive != 100 {
+		t.Fatalf("unexpected A: %+v", a)
+	}
+	b := a.Children[0]
+	if b.Name != "main.B" || b.Calls != 4 || b.Errors != 2 || b.Inclusive != 100 || b.Exclusive != 100 {
+		t.Fatalf("unexpected B: %+v", b)
+	}
+
+	funcs := profile.Funcs()
+	if len(funcs) != 2 {
+		t.Fatalf("expect 2 funcs, actual: %d", len(funcs))
+	}
+}
+
+func TestMarshalProfile(t *testing.T) {
+	root := &RootExport{
+		Children: []*StackExport{
+			{FuncInfo: &FuncInfoExport{Pkg: "main", IdentityName: "A"}, Begin: 0, End: 100},
+		},
+	}
+	data, err := MarshalProfile(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(raw, []byte("main.A")) {
+		t.Fatalf("expect function name in profile")
+	}
+}
@@ -1,9 +1,82 @@
 // this script runs after window.onload
 // const traces = {}
+// const ids = []
+// const parents = {}
+// const svgToggle = "..."
+// const lazyQuery = null  // binary traces: query for /children and /detail
 
 // trace example:
 ///   {"FuncInfo":{"Pkg":"github.com/xhd2015/xgo","IdentityName":"TestHelloWorld","Name":"TestHelloWorld","RecvType":"","RecvPtr":false,"Generic":false,"RecvName":"","ArgNames":["t"],"ResNames":[],"FirstArgCtx":false,"LastResultErr":false},"Begin":0,"End":0,"Args":{"t":{}},"Results":{},"Children":null}
 
+// the tree is rendered lazily: only children of
+// expanded nodes are put into the DOM, so traces
+// with tens of thousands of nodes stay responsive.
+
+// max nodes rendered by expand all and by filtering
+const renderBudget = 5000
+
+const rootID = ids[0]
+const children = {}
+for (const id of ids) {
+    children[id] = []
+    const parent = parents[id]
+    if (parent) {
+        children[parent].push(id)
+    }
+}
+
+// max nodes rendered initially
+const initialBudget = 500
+
+// lazyURL builds the url to fetch children or detail of id
+function lazyURL(path, id) {
+    const query = lazyQuery ? lazyQuery + "&" : ""
+    return `${path}?${query}id=${encodeURIComponent(id)}`
+}
+
+// loadChildren fetches children of id not sent with the page,
+// together with some of their descendants
+async function loadChildren(id) {
+    const resp = await fetch(lazyURL("/children", id))
+    if (!resp.ok) {
+        throw new Error(await resp.text())
+    }
+    const nodes = await resp.json()
+    for (const node of nodes || []) {
+        if (traces[node.ID]) {
+            continue
+        }
+        traces[node.ID] = node.Trace
+        ids.push(node.ID)
+        parents[node.ID] = node.Parent
+        children[node.ID] = []
+        children[node.Parent].push(node.ID)
+    }
+    traces[id].Unloaded = 0
+}
+
+// loadDetail fetches args and results of id
+async function loadDetail(id) {
+    const resp = await fetch(lazyURL("/detail", id))
+    if (!resp.ok) {
+        throw new Error(await resp.text())
+    }
+    const detail = await resp.json()
+    const trace = traces[id]
+    trace.Args = detail.Args
+    trace.Results = detail.Results
+    trace.Lazy = false
+    delete searchTexts[id]
+}
+
+const expanded = new Set()
+
+let selectedID = ""
+
+// active filter, null means no filter
+let filter = null
+// ids of matched nodes and their ancestors
+let visible = null
 
 function getHeadID(id) {
     return `head_${id}`
@@ -15,32 +88,400 @@ function getTraceListID(id) {
     return `trace_list_${id}`
 }
 
-let selectedID = ""
-function onClickHead(id) {
-    const headID = getHeadID(id)
-    const el = document.getElementById(headID)
-    if (!el) {
+function formatCost(begin, end) {
+    if (!begin && !end) {
+        return ""
+    }
+    let cost = end - begin
+    let sign = ""
+    if (cost < 0) {
+        sign = "-"
+        cost = -cost
+    }
+    const units = [["ns", 1], ["μs", 1000], ["ms", 1000], ["s", 1000], ["m", 60], ["h", 60], ["d", 24]]
+    let name = units[0][0]
+    for (let i = 1; i < units.length; i++) {
+        if (cost < units[i][1]) {
+            break
+        }
+        cost = cost / units[i][1]
+        name = units[i][0]
+    }
+    return sign + Math.floor(cost) + name
+}
+
+// parseDuration parses 10ms, 1.5s, 200us into nanoseconds,
+// a plain number is treated as milliseconds
+function parseDuration(s) {
+    s = (s || "").trim()
+    if (!s) {
+        return 0
+    }
+    const m = /^([0-9.]+)\s*(ns|us|μs|ms|s|m)?$/.exec(s)
+    if (!m) {
+        return NaN
+    }
+    const scale = { "ns": 1, "us": 1e3, "μs": 1e3, "ms": 1e6, "s": 1e9, "m": 60e9 }
+    return Number(m[1]) * scale[m[2] || "ms"]
+}
+
+function nameOf(trace) {
+    return trace.FuncInfo?.IdentityName || "<unknown>"
+}
+
+function isStdlib(id) {
+    return id !== rootID && !!traces[id].FuncInfo?.Stdlib
+}
+
+const searchTexts = {}
+function searchTextOf(id) {
+    let text = searchTexts[id]
+    if (text === undefined) {
+        const trace = traces[id]
+        const parts = [nameOf(trace), trace.FuncInfo?.Pkg || ""]
+        if (trace.Args) {
+            parts.push(JSON.stringify(trace.Args))
+        }
+        if (trace.Results) {
+            parts.push(JSON.stringify(trace.Results))
+        }
+        if (trace.Error) {
+            parts.push(trace.Error)
+        }
+        if (trace.Panic) {
+            parts.push("panic")
+        }
+        if (trace.Mocked) {
+            parts.push("mock")
+        }
+        if (trace.Logs) {
+            parts.push(...trace.Logs.map(log => log.Msg))
+        }
+        text = parts.join("\n").toLowerCase()
+        searchTexts[id] = text
+    }
+    return text
+}
+
+function matchFilter(id) {
+    if (id === rootID) {
+        return false
+    }
+    const trace = traces[id]
+    if (filter.errorOnly && !trace.Error && !trace.Panic) {
+        return false
+    }
+    if (filter.minDuration > 0 && (trace.End - trace.Begin) <= filter.minDuration) {
+        return false
+    }
+    if (filter.hideStdlib && isStdlib(id)) {
+        return false
+    }
+    if (filter.search && !searchTextOf(id).includes(filter.search)) {
+        return false
+    }
+    return true
+}
+
+// visibleChildren returns the children to render under id,
+// hidden stdlib calls are replaced by their own children
+function visibleChildren(id) {
+    const result = []
+    for (const child of children[id]) {
+        if (filter?.hideStdlib && isStdlib(child)) {
+            result.push(...visibleChildren(child))
+            continue
+        }
+        if (visible && !visible.has(child)) {
+            continue
+        }
+        result.push(child)
+    }
+    return result
+}
+
+function renderHead(id) {
+    const trace = traces[id]
+    const head = document.createElement("div")
+    head.className = "head"
+
+    if (visibleChildren(id).length > 0 || trace.Unloaded > 0) {
+        // NOTE: onclick on svg does not work, must wrap it with div
+        const toggle = document.createElement("div")
+        toggle.id = getToggleID(id)
+        toggle.className = "toggle " + (expanded.has(id) ? "down" : "right")
+        toggle.innerHTML = svgToggle
+        toggle.onclick = (e) => onClickToggle(e, id)
+        head.appendChild(toggle)
+    }
+
+    const info = document.createElement("div")
+    info.className = "head-info"
+    info.id = getHeadID(id)
+    if (id === selectedID) {
+        info.classList.add("selected")
+    }
+    info.onclick = () => onClickHead(id)
+
+    const block = document.createElement("div")
+    block.className = "head-block"
+    if (trace.Panic) {
+        block.classList.add("panic")
+    }
+    if (trace.Error) {
+        block.classList.add("error")
+    }
+    info.appendChild(block)
+
+    const name = document.createElement("span")
+    name.className = "head-name"
+    if (visible && matchFilter(id)) {
+        name.classList.add("matched")
+    }
+    name.innerText = nameOf(trace)
+    info.appendChild(name)
+
+    if (trace.Mocked) {
+        const tag = document.createElement("span")
+        tag.className = "head-tag mock"
+        tag.innerText = "mock"
+        tag.title = "answered by a mock"
+        info.appendChild(tag)
+    }
+    if (trace.Running) {
+        const tag = document.createElement("span")
+        tag.className = "head-tag running"
+        tag.innerText = "running"
+        tag.title = "some calls inside have completed, this one has not yet"
+        info.appendChild(tag)
+    }
+
+    const cost = document.createElement("span")
+    cost.className = "head-cost"
+    cost.innerText = formatCost(trace.Begin, trace.End)
+    info.appendChild(cost)
+
+    head.appendChild(info)
+    return head
+}
+
+function renderNode(id) {
+    const li = document.createElement("li")
+    li.appendChild(renderHead(id))
+    if (expanded.has(id)) {
+        renderSubList(li, id)
+    }
+    return li
+}
+
+function renderSubList(li, id) {
+    const childIDs = visibleChildren(id)
+    if (childIDs.length === 0) {
         return
     }
-    if (selectedID === id) {
+    const ul = document.createElement("ul")
+    ul.id = getTraceListID(id)
+    ul.className = "trace-sub-list"
+    for (const child of childIDs) {
+        ul.appendChild(renderNode(child))
+    }
+    li.appendChild(ul)
+}
+
+function renderTree() {
+    const list = document.getElementById("trace-list")
+    list.innerHTML = ""
+    list.appendChild(renderNode(rootID))
+}
+
+function setExpanded(id, expand) {
+    if (expand === expanded.has(id)) {
+        return
+    }
+    const head = document.getElementById(getHeadID(id))
+    if (expand) {
+        expanded.add(id)
+    } else {
+        expanded.delete(id)
+    }
+    if (!head) {
         return
     }
-    const classList = el.classList
-    classList.toggle("selected")
+    const li = head.parentElement.parentElement
+    const toggle = document.getElementById(getToggleID(id))
+    if (toggle) {
+        toggle.classList.toggle("down", expand)
+        toggle.classList.toggle("right", !expand)
+    }
+    if (expand) {
+        renderSubList(li, id)
+    } else {
+        // drop collapsed subtree from DOM
+        const ul = document.getElementById(getTraceListID(id))
+        if (ul) {
+            ul.remove()
+        }
+    }
+}
+
+async function onClickToggle(e, id) {
+    e.stopPropagation()
+    const expand = !expanded.has(id)
+    if (expand && traces[id].Unloaded > 0) {
+        try {
+            await loadChildren(id)
+        } catch (err) {
+            alert(`failed to load children: ${err.message}`)
+            return
+        }
+    }
+    setExpanded(id, expand)
+}
+
+// expandBreadthFirst expands nodes level by level
+// until about budget nodes are rendered
+function expandBreadthFirst(budget) {
+    let level = [rootID]
+    let count = 1
+    while (level.length > 0 && count < budget) {
+        const next = []
+        for (const id of level) {
+            const childIDs = visibleChildren(id)
+            if (childIDs.length === 0) {
+                continue
+            }
+            if (count + childIDs.length > budget) {
+                level = []
+                break
+            }
+            expanded.add(id)
+            count += childIDs.length
+            next.push(...childIDs)
+        }
+        if (level.length === 0) {
+            break
+        }
+        level = next
+    }
+}
+
+function onClickExpandAll(e) {
+    const el = document.getElementById("toolbar")
+    const toggleAllOn = "toggle-all-on"
+    if (el.classList.contains(toggleAllOn)) {
+        el.classList.remove(toggleAllOn)
+        // collapse all
+        expanded.clear()
+    } else {
+        // expand all, limited by budget
+        el.classList.add(toggleAllOn)
+        expandBreadthFirst(renderBudget)
+    }
+    renderTree()
+}
+
+function pathOf(id) {
+    const path = []
+    for (let p = id; p; p = parents[p]) {
+        path.unshift(p)
+    }
+    return path
+}
+
+function renderBreadcrumb(id) {
+    const el = document.getElementById("breadcrumb")
+    el.innerHTML = ""
+    // skip <root>
+    const path = pathOf(id).slice(1)
+    path.forEach((p, i) => {
+        if (i > 0) {
+            const sep = document.createElement("span")
+            sep.className = "breadcrumb-sep"
+            sep.innerText = ">"
+            el.appendChild(sep)
+        }
+        const item = document.createElement("span")
+        item.className = "breadcrumb-item"
+        if (p === id) {
+            item.classList.add("current")
+        }
+        item.innerText = nameOf(traces[p])
+        item.title = traces[p].FuncInfo?.Pkg || ""
+        item.onclick = () => selectNode(p)
+        el.appendChild(item)
+    })
+}
+
+// selectNode expands all ancestors of id so
+// that it gets rendered, then selects it
+function selectNode(id) {
+    const path = pathOf(id)
+    let changed = false
+    for (const p of path.slice(0, -1)) {
+        if (!expanded.has(p)) {
+            expanded.add(p)
+            changed = true
+        }
+    }
+    if (changed || !document.getElementById(getHeadID(id))) {
+        if (visible && !visible.has(id)) {
+            clearFilter()
+        }
+        renderTree()
+    }
+    onClickHead(id)
+    const el = document.getElementById(getHeadID(id))
+    if (el) {
+        el.scrollIntoView({ block: "nearest" })
+    }
+}
 
+function onClickHead(id) {
+    if (selectedID === id) {
+        return
+    }
     const prev = document.getElementById(getHeadID(selectedID))
     if (prev) {
         prev.classList.remove("selected")
     }
     selectedID = id
-    const info = document.getElementById("detail-info")
+    const el = document.getElementById(getHeadID(id))
+    if (el) {
+        el.classList.add("selected")
+    }
+    renderBreadcrumb(id)
+    renderDetail(id)
+
+    if (traces[id].Lazy) {
+        loadDetail(id).then(() => {
+            if (selectedID === id) {
+                renderDetail(id)
+            }
+        }, (err) => {
+            if (selectedID === id) {
+                document.getElementById("detail-request").value = `failed to load: ${err.message}`
+            }
+        })
+    }
+}
+
+function renderDetail(id) {
     const infoPkg = document.getElementById("detail-info-pkg")
     const infoFunc = document.getElementById("detail-info-func")
     const vscodeIcon = document.getElementById("vscode-icon")
     const req = document.getElementById("detail-request")
     const resp = document.getElementById("detail-response")
+    const logs = document.getElementById("detail-logs")
+    const logsLabel = document.getElementById("detail-logs-label")
+    const mockTag = document.getElementById("detail-info-mock")
     const traceData = traces[id]
 
+    const logLines = (traceData.Logs || []).map(log => log.Msg)
+    logs.value = logLines.join("\n")
+    logs.classList.toggle("hidden", logLines.length === 0)
+    logsLabel.classList.toggle("hidden", logLines.length === 0)
+    mockTag.classList.toggle("hidden", !traceData.Mocked)
+
     if (traceData.error) {
         infoPkg.innerText = "<unknown>"
         infoFunc.innerText = "<unknown>"
@@ -57,6 +498,11 @@ function onClickHead(id) {
     }
     infoPkg.innerText = traceData.FuncInfo?.Pkg || ""
     infoFunc.innerText = traceData.FuncInfo?.IdentityName || ""
+    if (traceData.Lazy) {
+        req.value = "loading..."
+        resp.value = "loading..."
+        return
+    }
     req.value = JSON.stringify(traceData.Args, null, "    ")
     if (traceData.Error) {
         let msg = traceData.Error
@@ -69,54 +515,86 @@ function onClickHead(id) {
     }
 }
 
-function onClickToggle(e, id) {
-    e.stopPropagation()
+function readFilter() {
+    const search = document.getElementById("search").value.trim().toLowerCase()
+    const errorOnly = document.getElementById("filter-error").checked
+    const hideStdlib = document.getElementById("filter-stdlib").checked
+    const durationInput = document.getElementById("filter-duration")
+    let minDuration = parseDuration(durationInput.value)
+    durationInput.classList.toggle("invalid", isNaN(minDuration))
+    if (isNaN(minDuration)) {
+        minDuration = 0
+    }
+    if (!search && !errorOnly && !hideStdlib && !minDuration) {
+        return null
+    }
+    return { search, errorOnly, hideStdlib, minDuration }
+}
 
-    setToggle(id, true)
-    toggleTraceList(id, true)
+function clearFilter() {
+    document.getElementById("search").value = ""
+    document.getElementById("filter-error").checked = false
+    document.getElementById("filter-stdlib").checked = false
+    document.getElementById("filter-duration").value = ""
+    filter = null
+    visible = null
+    document.getElementById("filter-status").innerText = ""
 }
 
-function setToggle(id, toggle, collapsed) {
-    const el = document.getElementById(getToggleID(id))
-    if (!el) {
-        return
-    }
-    const cl = el.classList
-    if (toggle) {
-        if (cl.contains("down")) {
-            collapsed = true
-        } else if (cl.contains("right")) {
-            collapsed = false
+let filterTimer
+function onFilterChange() {
+    // debounce typing on large traces
+    clearTimeout(filterTimer)
+    filterTimer = setTimeout(applyFilter, 150)
+}
+
+function applyFilter() {
+    filter = readFilter()
+    const status = document.getElementById("filter-status")
+    if (!filter) {
+        visible = null
+        status.innerText = ""
+        expanded.clear()
+        expandBreadthFirst(initialBudget)
+        // keep the selected node in sight
+        for (const p of pathOf(selectedID).slice(0, -1)) {
+            expanded.add(p)
         }
+        renderTree()
+        return
     }
-    if (collapsed) {
-        cl.remove("down")
-        cl.add("right")
-    } else {
-        cl.add("down")
-        cl.remove("right")
+    const onlyStdlib = filter.hideStdlib && !filter.search && !filter.errorOnly && !filter.minDuration
+    if (onlyStdlib) {
+        // hiding stdlib alone keeps every other node
+        visible = null
+        status.innerText = ""
+        renderTree()
+        return
     }
-}
-
-function onClickExpandAll(e) {
-    const el = document.getElementById("toolbar")
-    const toggleAllOn = "toggle-all-on"
-    if (el.classList.contains(toggleAllOn)) {
-        el.classList.remove(toggleAllOn)
-        // collapse all
-        for (const id of ids) {
-            toggleTraceList(id, false, true)
-            setToggle(id, false, true)
+    visible = new Set([rootID])
+    expanded.clear()
+    let matched = 0
+    for (const id of ids) {
+        if (!matchFilter(id)) {
+            continue
         }
-    } else {
-        // expand all
-        el.classList.add(toggleAllOn)
-        for (const id of ids) {
-            toggleTraceList(id, false, false)
-            setToggle(id, false, false)
+        matched++
+        // reveal the first matches, the rest can be
+        // found by refining the filter
+        if (visible.size >= renderBudget) {
+            continue
+        }
+        for (let p = id; p && !visible.has(p); p = parents[p]) {
+            visible.add(p)
+        }
+        for (let p = parents[id]; p; p = parents[p]) {
+            expanded.add(p)
         }
     }
+    status.innerText = matched === 0 ? "no match" : `${matched} matched`
+    renderTree()
 }
+
 function onClickVscodeIcon(e) {
     if (!selectedID) {
         return
@@ -138,27 +616,12 @@ function onClickVscodeIcon(e) {
     fetch("/openVscodeFile" + "?" + new URLSearchParams(args).toString())
 }
 
-function toggleTraceList(id, toggle, collapsed) {
-    const traceList = document.getElementById(getTraceListID(id))
-    if (!traceList) {
-        return
-    }
-    if (toggle) {
-        traceList.classList.toggle("collapsed")
-        return
-    }
-    if (collapsed) {
-        traceList.classList.add("collapsed")
-        return
-    }
-    traceList.classList.remove("collapsed")
-}
-
 // event listeners
 window.onClickHead = onClickHead
 window.onClickToggle = onClickToggle
 window.onClickExpandAll = onClickExpandAll
 window.onClickVscodeIcon = onClickVscodeIcon
+window.onFilterChange = onFilterChange
 
 // for debugging
 window.traces = traces
@@ -166,5 +629,10 @@ window.debug = function () {
     debugger
     alert("debug")
 }
+
+expandBreadthFirst(initialBudget)
+renderTree()
 // go to head
-onClickHead("2")
+if (children[rootID].length > 0) {
+    onClickHead(children[rootID][0])
+}
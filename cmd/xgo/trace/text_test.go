@@ -0,0 +1,86 @@
+package trace
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	call := func(name string, cost int64, err string, children ...*StackExport) *StackExport {
+		return &StackExport{
+			FuncInfo: &FuncInfoExport{IdentityName: name},
+			End:      cost,
+			Args:     map[string]interface{}{"name": name},
+			Error:    err,
+			Children: children,
+		}
+	}
+	root := &RootExport{Children: []*StackExport{
+		call("TestA", 2e6, "",
+			call("Load", 1000, ""),
+			call("Save", 2000, "timeout",
+				call("Write", 500, ""),
+			),
+			call("Notify", 100, ""),
+		),
+	}}
+	root.Children[0].Children[0].Mocked = true
+
+	tests := []struct {
+		opts   *TextOptions
+		expect string
+	}{
+		{
+			opts: &TextOptions{Title: "TestA"},
+			expect: `=== TRACE TestA
+TestA 2ms args={"name":"TestA"}
+├── Load [mock] 1μs args={"name":"Load"}
+├── Save 2μs args={"name":"Save"} ERROR: timeout
+│   └── Write 500ns args={"name":"Write"}
+└── Notify 100ns args={"name":"Notify"}
+`,
+		},
+		{
+			opts: &TextOptions{MaxDepth: 2, MaxChildren: 1, MaxValueLen: 10},
+			expect: `TestA 2ms args={"name":"T...
+├── Load [mock] 1μs args={"name":"L...
+└── ... 3 calls omitted, 1 with error
+`,
+		},
+		{
+			opts: &TextOptions{Title: "TestA", Markdown: true, MaxDepth: 2},
+			expect: "### ❌ Trace of `TestA`\n\n" +
+				"- **TestA** 2ms args: `{\"name\":\"TestA\"}`\n" +
+				"  - **Load** *mock* 1μs args: `{\"name\":\"Load\"}`\n" +
+				"  - ❌ **Save** 2μs args: `{\"name\":\"Save\"}` error: `timeout`\n" +
+				"    - *... 1 calls omitted*\n" +
+				"  - **Notify** 100ns args: `{\"name\":\"Notify\"}`\n\n",
+		},
+	}
+	for i, tt := range tests {
+		var buf bytes.Buffer
+		err := WriteText(&buf, root, tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tt.expect {
+			t.Errorf("case %d: expect:\n%s\nactual:\n%s", i, tt.expect, buf.String())
+		}
+	}
+}
+
+func TestMarkdownCode(t *testing.T) {
+	tests := []struct {
+		s      string
+		expect string
+	}{
+		{"a", "`a`"},
+		{"a`b", "`` a`b ``"},
+		{"a\nb", "`a b`"},
+	}
+	for _, tt := range tests {
+		if got := markdownCode(tt.s); got != tt.expect {
+			t.Errorf("markdownCode(%q): expect %q, actual %q", tt.s, tt.expect, got)
+		}
+	}
+}
@@ -136,8 +136,10 @@ func mock(mockRecvPtr interface{}, mockFnInfo *core.FuncInfo, funcPC uintptr, tr
 					// continue
 					return nil, nil
 				}
+				trap.MarkMocked()
 				return nil, err
 			}
+			trap.MarkMocked()
 
 			// when match func, default to use mock
 			return nil, trap.ErrAbort
@@ -0,0 +1,96 @@
+package trace
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/xhd2015/xgo/runtime/core"
+)
+
+type recordT struct {
+	errors []string
+}
+
+func (c *recordT) Helper() {}
+func (c *recordT) Errorf(format string, args ...interface{}) {
+	c.errors = append(c.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertCalls(t *testing.T) {
+	call := func(name string, args core.Object, err error, children ...*Stack) *Stack {
+		return &Stack{
+			FuncInfo: &core.FuncInfo{Pkg: "example.com/app/svc", IdentityName: name, Name: name},
+			Args:     args,
+			Error:    err,
+			Children: children,
+		}
+	}
+	root := &Root{Children: []*Stack{
+		call("Handle", nil, nil,
+			call("Validate", testObject{{"id", int64(1)}}, nil),
+			call("Save", testObject{{"id", int64(1)}}, errors.New("timeout"),
+				call("Exec", nil, nil),
+			),
+			call("Notify", nil, nil),
+		),
+	}}
+
+	tests := []struct {
+		name   string
+		assert func(a *CallAssert) bool
+		expect string
+	}{
+		{"in order", func(a *CallAssert) bool {
+			return a.InOrder(Call("Validate").ArgEquals("id", 1), Call("svc.Save").Failed())
+		}, ""},
+		{"wrong order", func(a *CallAssert) bool {
+			return a.InOrder(Call("Save"), Call("Validate"))
+		}, "expect Validate to be called after Save, actually called before"},
+		{"unordered", func(a *CallAssert) bool {
+			return a.Unordered(Call("*"), Call("Validate"), Call("Save"))
+		}, ""},
+		{"unordered missing", func(a *CallAssert) bool {
+			return a.Unordered(Call("Save"), Call("Save"))
+		}, "expect Save to be called"},
+		{"arg mismatch", func(a *CallAssert) bool {
+			return a.Called(Call("Save").ArgEquals("id", 2))
+		}, "expect Save(id=2) to be called"},
+		{"within", func(a *CallAssert) bool {
+			return a.Within(Call("Save")).CalledTimes(Call("Exec"), 1)
+		}, ""},
+		{"never after failure", func(a *CallAssert) bool {
+			return a.After(Call("Save").Failed()).Never(Call("Notify"))
+		}, "expect Notify not to be called, actual 1 times\nactual calls after Save(failed):\n  > Notify"},
+		{"before", func(a *CallAssert) bool {
+			return a.Before(Call("Save")).Never(Call("Handle"))
+		}, ""},
+	}
+	for _, tt := range tests {
+		rt := &recordT{}
+		ok := tt.assert(AssertCalls(rt, root))
+		if tt.expect == "" {
+			if !ok || len(rt.errors) > 0 {
+				t.Errorf("%s: expect pass, actual: %v", tt.name, rt.errors)
+			}
+			continue
+		}
+		if ok || len(rt.errors) != 1 || !strings.HasPrefix(rt.errors[0], tt.expect) {
+			t.Errorf("%s: expect failure %q, actual: %q", tt.name, tt.expect, rt.errors)
+		}
+	}
+
+	rt := &recordT{}
+	AssertCalls(rt, root).Never(Call("Exec"))
+	expectTree := `expect Exec not to be called, actual 1 times
+actual calls:
+    Handle
+      Validate {"id":1}
+      Save {"id":1} ERROR: timeout
+      > Exec
+      Notify`
+	if len(rt.errors) != 1 || rt.errors[0] != expectTree {
+		t.Errorf("expect tree:\n%s\nactual:\n%s", expectTree, strings.Join(rt.errors, "\n"))
+	}
+}
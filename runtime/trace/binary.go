@@ -0,0 +1,636 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// binary trace layout, all integers are varints
+// unless noted otherwise:
+//
+//	header:  magic, version byte
+//	blobs:   args and results of each call, as JSON
+//	index:   root begin, string table, func table, nodes
+//	footer:  index offset (uint64 little endian), magic
+//
+// blobs come first so the writer can stream them out,
+// the index is small compared to them and is read
+// upfront, blobs are read only when asked for.
+const binaryTraceMagic = "XGOTRACE"
+
+const binaryTraceVersion = 1
+
+const binaryFooterSize = 8 + len(binaryTraceMagic)
+
+// BinaryTraceExt is the file extension of binary traces
+const BinaryTraceExt = ".xtrace"
+
+const (
+	funcFlagRecvPtr = 1 << iota
+	funcFlagInterface
+	funcFlagGeneric
+	funcFlagClosure
+	funcFlagStdlib
+	funcFlagFirstArgCtx
+	funcFlagLastResultErr
+)
+
+const (
+	nodeFlagSnapshot = 1 << iota
+	nodeFlagPanic
+	nodeFlagMocked
+)
+
+// IsBinaryTrace reports whether data starts
+// with the binary trace header
+func IsBinaryTrace(data []byte) bool {
+	return len(data) >= len(binaryTraceMagic) && string(data[:len(binaryTraceMagic)]) == binaryTraceMagic
+}
+
+// BinaryOptions controls encoding of a binary trace
+type BinaryOptions struct {
+	// Marshal encodes args and results, defaults to json.Marshal
+	Marshal func(v interface{}) ([]byte, error)
+}
+
+// WriteBinaryTrace encodes root in the binary format,
+// opts can be nil
+func WriteBinaryTrace(w io.Writer, root *RootExport, opts *BinaryOptions) error {
+	bw := bufio.NewWriter(w)
+	enc := &binaryEncoder{
+		w:       bw,
+		marshal: json.Marshal,
+		strings: make(map[string]uint64),
+		funcs:   make(map[string]uint64),
+	}
+	if opts != nil && opts.Marshal != nil {
+		enc.marshal = opts.Marshal
+	}
+	enc.writeRaw([]byte(binaryTraceMagic))
+	enc.writeRaw([]byte{binaryTraceVersion})
+
+	if root != nil {
+		for _, stack := range root.Children {
+			enc.encodeStack(stack, 0)
+		}
+	}
+	if enc.err != nil {
+		return enc.err
+	}
+	indexOffset := enc.offset
+
+	var begin []byte
+	if root != nil && !root.Begin.IsZero() {
+		var err error
+		begin, err = root.Begin.MarshalBinary()
+		if err != nil {
+			return err
+		}
+	}
+	enc.writeBytes(begin)
+	enc.writeUvarint(uint64(len(enc.stringList)))
+	for _, s := range enc.stringList {
+		enc.writeBytes([]byte(s))
+	}
+	enc.writeUvarint(uint64(len(enc.funcList)))
+	for _, f := range enc.funcList {
+		enc.writeRaw(f)
+	}
+	enc.writeUvarint(uint64(enc.numNodes))
+	enc.writeRaw(enc.nodes)
+
+	var footer [8]byte
+	binary.LittleEndian.PutUint64(footer[:], uint64(indexOffset))
+	enc.writeRaw(footer[:])
+	enc.writeRaw([]byte(binaryTraceMagic))
+	if enc.err != nil {
+		return enc.err
+	}
+	return bw.Flush()
+}
+
+type binaryEncoder struct {
+	w       io.Writer
+	offset  int64
+	err     error
+	marshal func(v interface{}) ([]byte, error)
+
+	strings    map[string]uint64
+	stringList []string
+
+	// keyed by the encoded record
+	funcs    map[string]uint64
+	funcList [][]byte
+
+	numNodes int
+	nodes    []byte
+}
+
+func (c *binaryEncoder) writeRaw(data []byte) {
+	if c.err != nil {
+		return
+	}
+	n, err := c.w.Write(data)
+	c.offset += int64(n)
+	c.err = err
+}
+
+func (c *binaryEncoder) writeUvarint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	c.writeRaw(buf[:binary.PutUvarint(buf[:], v)])
+}
+
+func (c *binaryEncoder) writeBytes(data []byte) {
+	c.writeUvarint(uint64(len(data)))
+	c.writeRaw(data)
+}
+
+// writeBlob writes v as JSON, returns its offset and length,
+// a nil v is written as nothing
+func (c *binaryEncoder) writeBlob(v interface{}) (int64, int) {
+	if v == nil {
+		return 0, 0
+	}
+	data, err := c.marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(map[string]string{"error": err.Error()})
+	}
+	offset := c.offset
+	c.writeRaw(data)
+	return offset, len(data)
+}
+
+// stringRef interns s, 0 is the empty string
+func (c *binaryEncoder) stringRef(s string) uint64 {
+	if s == "" {
+		return 0
+	}
+	ref, ok := c.strings[s]
+	if !ok {
+		c.stringList = append(c.stringList, s)
+		ref = uint64(len(c.stringList))
+		c.strings[s] = ref
+	}
+	return ref
+}
+
+// funcRef interns f, 0 is nil
+func (c *binaryEncoder) funcRef(f *FuncInfoExport) uint64 {
+	if f == nil {
+		return 0
+	}
+	var flags uint64
+	setFlag := func(flag uint64, v bool) {
+		if v {
+			flags |= flag
+		}
+	}
+	setFlag(funcFlagRecvPtr, f.RecvPtr)
+	setFlag(funcFlagInterface, f.Interface)
+	setFlag(funcFlagGeneric, f.Generic)
+	setFlag(funcFlagClosure, f.Closure)
+	setFlag(funcFlagStdlib, f.Stdlib)
+	setFlag(funcFlagFirstArgCtx, f.FirstArgCtx)
+	setFlag(funcFlagLastResultErr, f.LastResultErr)
+
+	var buf []byte
+	buf = appendUvarint(buf, c.stringRef(string(f.Kind)))
+	buf = appendUvarint(buf, c.stringRef(f.Pkg))
+	buf = appendUvarint(buf, c.stringRef(f.IdentityName))
+	buf = appendUvarint(buf, c.stringRef(f.Name))
+	buf = appendUvarint(buf, c.stringRef(f.RecvType))
+	buf = appendUvarint(buf, flags)
+	buf = appendUvarint(buf, c.stringRef(f.File))
+	buf = appendVarint(buf, int64(f.Line))
+	buf = appendUvarint(buf, c.stringRef(f.RecvName))
+	buf = c.appendStrings(buf, f.ArgNames)
+	buf = c.appendStrings(buf, f.ResNames)
+
+	ref, ok := c.funcs[string(buf)]
+	if !ok {
+		c.funcList = append(c.funcList, buf)
+		ref = uint64(len(c.funcList))
+		c.funcs[string(buf)] = ref
+	}
+	return ref
+}
+
+// appendStrings keeps nil and empty apart,
+// 0 is nil, n+1 is a list of n strings
+func (c *binaryEncoder) appendStrings(buf []byte, list []string) []byte {
+	if list == nil {
+		return appendUvarint(buf, 0)
+	}
+	buf = appendUvarint(buf, uint64(len(list))+1)
+	for _, s := range list {
+		buf = appendUvarint(buf, c.stringRef(s))
+	}
+	return buf
+}
+
+// encodeStack writes nodes in pre-order, parent
+// is 0 for top level calls, otherwise index+1
+func (c *binaryEncoder) encodeStack(stack *StackExport, parent int) {
+	if stack == nil {
+		return
+	}
+	argsOffset, argsLen := c.writeBlob(stack.Args)
+	resultsOffset, resultsLen := c.writeBlob(stack.Results)
+
+	var flags uint64
+	if stack.Snapshot {
+		flags |= nodeFlagSnapshot
+	}
+	if stack.Panic {
+		flags |= nodeFlagPanic
+	}
+	if stack.Mocked {
+		flags |= nodeFlagMocked
+	}
+
+	buf := c.nodes
+	buf = appendUvarint(buf, uint64(parent))
+	buf = appendUvarint(buf, c.funcRef(stack.FuncInfo))
+	buf = appendVarint(buf, stack.Begin)
+	buf = appendVarint(buf, stack.End)
+	buf = appendUvarint(buf, flags)
+	buf = appendUvarint(buf, c.stringRef(stack.Error))
+	buf = appendUvarint(buf, uint64(argsOffset))
+	buf = appendUvarint(buf, uint64(argsLen))
+	buf = appendUvarint(buf, uint64(resultsOffset))
+	buf = appendUvarint(buf, uint64(resultsLen))
+	buf = appendUvarint(buf, uint64(len(stack.Logs)))
+	for _, log := range stack.Logs {
+		buf = appendVarint(buf, log.Time)
+		buf = appendUvarint(buf, c.stringRef(log.Msg))
+	}
+	c.nodes = buf
+	c.numNodes++
+
+	id := c.numNodes
+	for _, child := range stack.Children {
+		c.encodeStack(child, id)
+	}
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(buf, tmp[:binary.PutUvarint(tmp[:], v)]...)
+}
+
+func appendVarint(buf []byte, v int64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(buf, tmp[:binary.PutVarint(tmp[:], v)]...)
+}
+
+// BinaryTrace is an opened binary trace, the node
+// index is decoded upfront, args and results are
+// read from the underlying reader on demand.
+type BinaryTrace struct {
+	r io.ReaderAt
+
+	Begin time.Time
+	// Nodes in pre-order
+	Nodes []*BinaryNode
+	// Roots are indexes of top level calls
+	Roots []int
+}
+
+// BinaryNode is a call without its args and results
+type BinaryNode struct {
+	Parent   int // -1 for top level calls
+	Children []int
+
+	FuncInfo *FuncInfoExport
+
+	Begin int64
+	End   int64
+
+	Snapshot bool
+	Panic    bool
+	Error    string
+	Mocked   bool
+	Logs     []*LogEntryExport
+
+	argsOffset    int64
+	argsLen       int
+	resultsOffset int64
+	resultsLen    int
+}
+
+// HasData reports whether the node recorded args or results
+func (c *BinaryNode) HasData() bool {
+	return c.argsLen > 0 || c.resultsLen > 0
+}
+
+var errInvalidBinaryTrace = errors.New("invalid binary trace")
+
+// OpenBinaryTrace reads the index of a binary
+// trace of size bytes, r must stay readable for
+// as long as args and results are requested.
+func OpenBinaryTrace(r io.ReaderAt, size int64) (*BinaryTrace, error) {
+	headerSize := int64(len(binaryTraceMagic) + 1)
+	if size < headerSize+int64(binaryFooterSize) {
+		return nil, errInvalidBinaryTrace
+	}
+	header := make([]byte, headerSize)
+	_, err := r.ReadAt(header, 0)
+	if err != nil {
+		return nil, err
+	}
+	if !IsBinaryTrace(header) {
+		return nil, errInvalidBinaryTrace
+	}
+	if header[len(binaryTraceMagic)] != binaryTraceVersion {
+		return nil, fmt.Errorf("unsupported binary trace version: %d", header[len(binaryTraceMagic)])
+	}
+	footer := make([]byte, binaryFooterSize)
+	_, err = r.ReadAt(footer, size-int64(binaryFooterSize))
+	if err != nil {
+		return nil, err
+	}
+	if !IsBinaryTrace(footer[8:]) {
+		return nil, fmt.Errorf("%w: truncated", errInvalidBinaryTrace)
+	}
+	indexOffset := int64(binary.LittleEndian.Uint64(footer))
+	indexEnd := size - int64(binaryFooterSize)
+	if indexOffset < headerSize || indexOffset > indexEnd {
+		return nil, errInvalidBinaryTrace
+	}
+	index := make([]byte, indexEnd-indexOffset)
+	_, err = r.ReadAt(index, indexOffset)
+	if err != nil {
+		return nil, err
+	}
+	trace, err := decodeBinaryIndex(index, indexOffset)
+	if err != nil {
+		return nil, err
+	}
+	trace.r = r
+	return trace, nil
+}
+
+type binaryDecoder struct {
+	data []byte
+	err  error
+}
+
+func (c *binaryDecoder) uvarint() uint64 {
+	if c.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(c.data)
+	if n <= 0 {
+		c.err = errInvalidBinaryTrace
+		return 0
+	}
+	c.data = c.data[n:]
+	return v
+}
+
+func (c *binaryDecoder) varint() int64 {
+	if c.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(c.data)
+	if n <= 0 {
+		c.err = errInvalidBinaryTrace
+		return 0
+	}
+	c.data = c.data[n:]
+	return v
+}
+
+func (c *binaryDecoder) bytes() []byte {
+	n := c.uvarint()
+	if c.err != nil {
+		return nil
+	}
+	if n > uint64(len(c.data)) {
+		c.err = errInvalidBinaryTrace
+		return nil
+	}
+	v := c.data[:n]
+	c.data = c.data[n:]
+	return v
+}
+
+// count reads a length that must be
+// plausible for the remaining data
+func (c *binaryDecoder) count() int {
+	n := c.uvarint()
+	if n > uint64(len(c.data)) {
+		c.err = errInvalidBinaryTrace
+		return 0
+	}
+	return int(n)
+}
+
+func decodeBinaryIndex(data []byte, blobsEnd int64) (*BinaryTrace, error) {
+	dec := &binaryDecoder{data: data}
+	trace := &BinaryTrace{}
+
+	begin := dec.bytes()
+	if len(begin) > 0 {
+		err := trace.Begin.UnmarshalBinary(begin)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	strs := make([]string, dec.count()+1)
+	for i := 1; i < len(strs); i++ {
+		strs[i] = string(dec.bytes())
+	}
+	str := func() string {
+		ref := dec.uvarint()
+		if ref >= uint64(len(strs)) {
+			dec.err = errInvalidBinaryTrace
+			return ""
+		}
+		return strs[ref]
+	}
+	strList := func() []string {
+		n := dec.count()
+		if n == 0 {
+			return nil
+		}
+		list := make([]string, n-1)
+		for i := range list {
+			list[i] = str()
+		}
+		return list
+	}
+
+	funcs := make([]*FuncInfoExport, dec.count()+1)
+	for i := 1; i < len(funcs); i++ {
+		f := &FuncInfoExport{
+			Kind:         FuncKind(str()),
+			Pkg:          str(),
+			IdentityName: str(),
+			Name:         str(),
+			RecvType:     str(),
+		}
+		flags := dec.uvarint()
+		f.RecvPtr = flags&funcFlagRecvPtr != 0
+		f.Interface = flags&funcFlagInterface != 0
+		f.Generic = flags&funcFlagGeneric != 0
+		f.Closure = flags&funcFlagClosure != 0
+		f.Stdlib = flags&funcFlagStdlib != 0
+		f.FirstArgCtx = flags&funcFlagFirstArgCtx != 0
+		f.LastResultErr = flags&funcFlagLastResultErr != 0
+		f.File = str()
+		f.Line = int(dec.varint())
+		f.RecvName = str()
+		f.ArgNames = strList()
+		f.ResNames = strList()
+		funcs[i] = f
+	}
+
+	blob := func() (int64, int) {
+		offset := dec.uvarint()
+		n := dec.uvarint()
+		// offset+n can overflow
+		if n > uint64(blobsEnd) || offset > uint64(blobsEnd)-n {
+			dec.err = errInvalidBinaryTrace
+			return 0, 0
+		}
+		return int64(offset), int(n)
+	}
+
+	trace.Nodes = make([]*BinaryNode, dec.count())
+	for i := range trace.Nodes {
+		parent := dec.uvarint()
+		if parent > uint64(i) {
+			// parents always precede their children
+			return nil, errInvalidBinaryTrace
+		}
+		funcRef := dec.uvarint()
+		if funcRef >= uint64(len(funcs)) {
+			return nil, errInvalidBinaryTrace
+		}
+		node := &BinaryNode{
+			Parent:   int(parent) - 1,
+			FuncInfo: funcs[funcRef],
+			Begin:    dec.varint(),
+			End:      dec.varint(),
+		}
+		flags := dec.uvarint()
+		node.Snapshot = flags&nodeFlagSnapshot != 0
+		node.Panic = flags&nodeFlagPanic != 0
+		node.Mocked = flags&nodeFlagMocked != 0
+		node.Error = str()
+		node.argsOffset, node.argsLen = blob()
+		node.resultsOffset, node.resultsLen = blob()
+		if n := dec.count(); n > 0 {
+			node.Logs = make([]*LogEntryExport, n)
+			for j := range node.Logs {
+				node.Logs[j] = &LogEntryExport{Time: dec.varint(), Msg: str()}
+			}
+		}
+		if dec.err != nil {
+			return nil, dec.err
+		}
+		trace.Nodes[i] = node
+		if node.Parent < 0 {
+			trace.Roots = append(trace.Roots, i)
+		} else {
+			p := trace.Nodes[node.Parent]
+			p.Children = append(p.Children, i)
+		}
+	}
+	if dec.err != nil {
+		return nil, dec.err
+	}
+	return trace, nil
+}
+
+func (c *BinaryTrace) readBlob(offset int64, n int) (json.RawMessage, error) {
+	if n == 0 {
+		return nil, nil
+	}
+	data := make([]byte, n)
+	_, err := c.r.ReadAt(data, offset)
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(data), nil
+}
+
+// Args reads the args of node i,
+// nil if none was recorded
+func (c *BinaryTrace) Args(i int) (json.RawMessage, error) {
+	node := c.Nodes[i]
+	return c.readBlob(node.argsOffset, node.argsLen)
+}
+
+// Results reads the results of node i,
+// nil if none was recorded
+func (c *BinaryTrace) Results(i int) (json.RawMessage, error) {
+	node := c.Nodes[i]
+	return c.readBlob(node.resultsOffset, node.resultsLen)
+}
+
+// Stack expands node i into a StackExport with up to depth
+// levels of children, depth < 0 means the whole subtree.
+// Args and Results are read only if withData is set.
+func (c *BinaryTrace) Stack(i int, depth int, withData bool) (*StackExport, error) {
+	node := c.Nodes[i]
+	stack := &StackExport{
+		FuncInfo: node.FuncInfo,
+		Begin:    node.Begin,
+		End:      node.End,
+		Snapshot: node.Snapshot,
+		Panic:    node.Panic,
+		Error:    node.Error,
+		Mocked:   node.Mocked,
+		Logs:     node.Logs,
+	}
+	if withData {
+		// assign only when present, a nil RawMessage
+		// inside an interface marshals as null
+		args, err := c.Args(i)
+		if err != nil {
+			return nil, err
+		}
+		if args != nil {
+			stack.Args = args
+		}
+		results, err := c.Results(i)
+		if err != nil {
+			return nil, err
+		}
+		if results != nil {
+			stack.Results = results
+		}
+	}
+	if depth == 0 {
+		return stack, nil
+	}
+	for _, child := range node.Children {
+		childStack, err := c.Stack(child, depth-1, withData)
+		if err != nil {
+			return nil, err
+		}
+		stack.Children = append(stack.Children, childStack)
+	}
+	return stack, nil
+}
+
+// Root expands the whole trace
+func (c *BinaryTrace) Root(withData bool) (*RootExport, error) {
+	root := &RootExport{Begin: c.Begin}
+	for _, i := range c.Roots {
+		stack, err := c.Stack(i, -1, withData)
+		if err != nil {
+			return nil, err
+		}
+		root.Children = append(root.Children, stack)
+	}
+	return root, nil
+}
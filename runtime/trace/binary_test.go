@@ -0,0 +1,171 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBinaryTraceRoundTrip(t *testing.T) {
+	f := &FuncInfoExport{
+		Kind:          FuncKind_Func,
+		Pkg:           "example.com/svc",
+		IdentityName:  "(*Service).Get",
+		Name:          "Get",
+		RecvType:      "Service",
+		RecvPtr:       true,
+		File:          "svc.go",
+		Line:          12,
+		RecvName:      "s",
+		ArgNames:      []string{"ctx", "id"},
+		ResNames:      []string{},
+		FirstArgCtx:   true,
+		LastResultErr: true,
+	}
+	root := &RootExport{
+		Begin: time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC),
+		Children: []*StackExport{
+			{
+				FuncInfo: f,
+				Begin:    1,
+				End:      100,
+				Args:     map[string]interface{}{"id": 1},
+				Results:  map[string]interface{}{"": "a"},
+				Logs:     []*LogEntryExport{{Time: 5, Msg: "hello"}},
+				Children: []*StackExport{
+					{FuncInfo: f, Begin: 2, End: 3, Error: "not found", Mocked: true},
+					{FuncInfo: f, Begin: 4, End: 5, Panic: true, Snapshot: true},
+				},
+			},
+			{Begin: 200, End: 300},
+		},
+	}
+	var buf bytes.Buffer
+	err := WriteBinaryTrace(&buf, root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if !IsBinaryTrace(data) {
+		t.Fatalf("expect binary trace header")
+	}
+
+	trace, err := OpenBinaryTrace(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.Nodes) != 4 || len(trace.Roots) != 2 {
+		t.Fatalf("expect 4 nodes and 2 roots, actual: %d %d", len(trace.Nodes), len(trace.Roots))
+	}
+	// funcs are interned
+	if trace.Nodes[0].FuncInfo != trace.Nodes[1].FuncInfo {
+		t.Fatalf("expect func info shared")
+	}
+	if trace.Nodes[2].HasData() || !trace.Nodes[0].HasData() {
+		t.Fatalf("bad HasData")
+	}
+	args, err := trace.Args(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(args) != `{"id":1}` {
+		t.Fatalf("args: %s", args)
+	}
+
+	top, err := trace.Stack(0, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if top.Args != nil || len(top.Children) != 0 {
+		t.Fatalf("expect no data and no children")
+	}
+
+	decoded, err := trace.Root(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect, err := json.Marshal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := json.Marshal(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(expect) != string(actual) {
+		t.Fatalf("expect:\n%s\nactual:\n%s", expect, actual)
+	}
+}
+
+func TestOpenBinaryTraceInvalid(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteBinaryTrace(&buf, &RootExport{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	for _, bad := range [][]byte{
+		nil,
+		[]byte(`{"Children":[]}`),
+		data[:len(data)-1],
+	} {
+		_, err := OpenBinaryTrace(bytes.NewReader(bad), int64(len(bad)))
+		if err == nil {
+			t.Fatalf("expect error for %q", bad)
+		}
+	}
+}
+
+func TestDecodeBinaryIndexBlobOverflow(t *testing.T) {
+	var index []byte
+	buf := make([]byte, binary.MaxVarintLen64)
+	for _, v := range []uint64{
+		0,             // begin
+		0,             // strings
+		0,             // funcs
+		1,             // nodes
+		0, 0, 0, 0, 0, // parent, func, begin, end, flags
+		0,                 // error
+		1, math.MaxUint64, // args, wraps around to 0
+		0, 0, // results
+		0, // logs
+	} {
+		n := binary.PutUvarint(buf, v)
+		index = append(index, buf[:n]...)
+	}
+	_, err := decodeBinaryIndex(index, 100)
+	if err == nil {
+		t.Fatalf("expect error for overflowed blob")
+	}
+}
+
+func TestBinaryTraceMarshal(t *testing.T) {
+	root := &RootExport{Children: []*StackExport{{
+		FuncInfo: &FuncInfoExport{IdentityName: "Save"},
+		Args:     map[string]interface{}{"id": 1, "callback": func() {}},
+	}}}
+	var buf bytes.Buffer
+	err := WriteBinaryTrace(&buf, root, &BinaryOptions{Marshal: (&MarshalOptions{}).Marshal})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	trace, err := OpenBinaryTrace(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	args, err := trace.Args(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect, err := (&MarshalOptions{}).Marshal(root.Children[0].Args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(args) != string(expect) {
+		t.Fatalf("expect args %s, actual %s", expect, args)
+	}
+}
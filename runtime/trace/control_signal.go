@@ -0,0 +1,24 @@
+//go:build !windows && !plan9 && !js && !wasip1
+// +build !windows,!plan9,!js,!wasip1
+
+package trace
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/xhd2015/xgo/runtime/trap"
+)
+
+// SIGUSR1 toggles collection
+func setupControlSignal() error {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1)
+	go trap.Direct(func() {
+		for range ch {
+			toggleControl()
+		}
+	})
+	return nil
+}
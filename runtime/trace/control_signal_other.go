@@ -0,0 +1,13 @@
+//go:build windows || plan9 || js || wasip1
+// +build windows plan9 js wasip1
+
+package trace
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func setupControlSignal() error {
+	return fmt.Errorf("signal control is not supported on %s, use http instead", runtime.GOOS)
+}
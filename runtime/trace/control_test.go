@@ -0,0 +1,170 @@
+package trace
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/xhd2015/xgo/runtime/core"
+)
+
+func TestMatchFunc(t *testing.T) {
+	f := &core.FuncInfo{
+		Pkg:          "github.com/org/svc/api",
+		IdentityName: "(*Handler).ServeHTTP",
+	}
+	patterns := parseMatchPatterns("main.run, github.com/org/svc/api.*")
+	if !matchFunc(patterns, f) {
+		t.Fatalf("expect match by pkg")
+	}
+	if !matchFunc(parseMatchPatterns("*ServeHTTP"), f) {
+		t.Fatalf("expect match by identity name")
+	}
+	if matchFunc(parseMatchPatterns("main.*"), f) {
+		t.Fatalf("expect no match")
+	}
+}
+
+// setupTestControl enables control as if XGO_TRACE_CONTROL
+// was set, returns a func to restore the state
+func setupTestControl() func() {
+	installed := controlInstalled
+	controlInstalled = true
+	return func() {
+		controlInstalled = installed
+		controlMutex.Lock()
+		currentControl = nil
+		controlMutex.Unlock()
+		atomic.StoreInt32(&controlActive, 0)
+	}
+}
+
+func TestStartStopControl(t *testing.T) {
+	defer setupTestControl()()
+	controlInstalled = false
+	if err := StartControl(nil); err == nil {
+		t.Fatalf("expect error without %s", XGO_TRACE_CONTROL)
+	}
+	controlInstalled = true
+	if err := StartControl(&ControlOptions{Limit: -1}); err == nil {
+		t.Fatalf("expect error for negative limit")
+	}
+	if err := StartControl(&ControlOptions{Output: "traces", Match: "main.*"}); err != nil {
+		t.Fatal(err)
+	}
+	status := GetControlStatus()
+	if !status.Active || status.Output != "traces" || status.Match != "main.*" {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	StopControl()
+	if GetControlStatus().Active {
+		t.Fatalf("expect stopped")
+	}
+	if acquireControl(&core.FuncInfo{Pkg: "main", IdentityName: "run"}) != nil {
+		t.Fatalf("expect no trace after stopped")
+	}
+}
+
+func TestControlLimitAndMatch(t *testing.T) {
+	defer setupTestControl()()
+	if err := StartControl(&ControlOptions{Limit: 2, Match: "main.handle*"}); err != nil {
+		t.Fatal(err)
+	}
+	if acquireControl(&core.FuncInfo{Pkg: "main", IdentityName: "run"}) != nil {
+		t.Fatalf("expect main.run not matched")
+	}
+	handle := &core.FuncInfo{Pkg: "main", IdentityName: "handleOrder"}
+	first := acquireControl(handle)
+	second := acquireControl(handle)
+	if first == nil || second == nil {
+		t.Fatalf("expect 2 traces started")
+	}
+	// started reaches the limit before any trace is emitted
+	if acquireControl(handle) != nil {
+		t.Fatalf("expect no more than limit traces")
+	}
+	first.onEmitted()
+	if !GetControlStatus().Active {
+		t.Fatalf("expect active before limit emitted")
+	}
+	second.onEmitted()
+	status := GetControlStatus()
+	if status.Active || status.Started != 3 || status.Emitted != 2 {
+		t.Fatalf("expect stopped after limit, actual: %+v", status)
+	}
+}
+
+func TestControlHTTP(t *testing.T) {
+	defer setupTestControl()()
+	dir, err := ioutil.TempDir("", "trace-control")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	savedOutput := traceOutput
+	traceOutput = dir
+	defer func() { traceOutput = savedOutput }()
+
+	server := httptest.NewServer(newControlMux())
+	defer server.Close()
+
+	do := func(method string, path string, params url.Values, header http.Header) (int, map[string]interface{}) {
+		t.Helper()
+		req, err := http.NewRequest(method, server.URL+path+"?"+params.Encode(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var res map[string]interface{}
+		data, _ := ioutil.ReadAll(resp.Body)
+		if err := json.Unmarshal(data, &res); err != nil {
+			t.Fatalf("%s %s: invalid json %q: %v", method, path, data, err)
+		}
+		return resp.StatusCode, res
+	}
+
+	if code, _ := do(http.MethodGet, "/trace/start", url.Values{"limit": {"1"}}, nil); code != http.StatusMethodNotAllowed {
+		t.Fatalf("expect GET /trace/start rejected, actual: %d", code)
+	}
+	if code, _ := do(http.MethodPost, "/trace/start", nil, http.Header{"Origin": {"http://example.com"}}); code != http.StatusForbidden {
+		t.Fatalf("expect cross origin rejected, actual: %d", code)
+	}
+	for _, output := range []string{"../outside", "/tmp"} {
+		code, res := do(http.MethodPost, "/trace/start", url.Values{"output": {output}}, nil)
+		if code != http.StatusBadRequest || !strings.Contains(res["error"].(string), "output must be inside") {
+			t.Fatalf("expect output %s rejected, actual: %d %v", output, code, res)
+		}
+	}
+	// error messages with arbitrary bytes are still valid json
+	if code, res := do(http.MethodPost, "/trace/start", url.Values{"limit": {"\xff\""}}, nil); code != http.StatusBadRequest || res["error"] == nil {
+		t.Fatalf("expect invalid limit, actual: %d %v", code, res)
+	}
+	if GetControlStatus().Active {
+		t.Fatalf("expect not started by rejected requests")
+	}
+
+	code, res := do(http.MethodPost, "/trace/start", url.Values{"output": {"sub"}, "limit": {"3"}, "match": {"main.*"}}, nil)
+	if code != http.StatusOK || res["active"] != true || res["output"] != filepath.Join(dir, "sub") || res["limit"] != float64(3) {
+		t.Fatalf("unexpected start: %d %v", code, res)
+	}
+	if code, res := do(http.MethodGet, "/trace/status", nil, nil); code != http.StatusOK || res["active"] != true || res["match"] != "main.*" {
+		t.Fatalf("unexpected status: %d %v", code, res)
+	}
+	if code, res := do(http.MethodPost, "/trace/stop", nil, nil); code != http.StatusOK || res["active"] != false {
+		t.Fatalf("unexpected stop: %d %v", code, res)
+	}
+}
@@ -0,0 +1,224 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/xhd2015/xgo/runtime/core"
+	"github.com/xhd2015/xgo/runtime/functab"
+	"github.com/xhd2015/xgo/runtime/tls"
+	"github.com/xhd2015/xgo/runtime/trap"
+)
+
+// XGO_COVER_PER_TEST=<file> appends functions called by each
+// top level test to file, one TestCoverageExport per line,
+// set by: xgo test --cover-per-test
+var coverPerTestFile = os.Getenv("XGO_COVER_PER_TEST")
+
+// XGO_CALL_COVERAGE=<file> appends functions invoked during
+// each top level test to file, one CallCoverageExport per line,
+// set by: xgo test --call-coverage
+var callCoverageFile = os.Getenv("XGO_CALL_COVERAGE")
+
+// goroutines started by a test inherit its hits
+var testHitsKey = tls.DeclareInherit("trace_test_hits")
+
+var coverFileMutex sync.Mutex
+
+var callCounts sync.Map // *core.FuncInfo -> *int64
+
+// all functions are written with the first line,
+// guarded by coverFileMutex
+var callFuncsWritten bool
+
+type testHits struct {
+	pkg  string
+	name string
+
+	mutex sync.Mutex
+	funcs map[*core.FuncInfo]int64
+	// in call order
+	order []*core.FuncInfo
+}
+
+func init() {
+	if coverPerTestFile == "" && callCoverageFile == "" {
+		return
+	}
+	trap.AddInterceptor(&trap.Interceptor{
+		Pre: func(ctx context.Context, f *core.FuncInfo, args, result core.Object) (interface{}, error) {
+			if coverPerTestFile != "" {
+				recordTestHit(f)
+			}
+			if callCoverageFile != "" {
+				recordCall(f)
+			}
+			return nil, nil
+		},
+	})
+}
+
+func recordTestHit(f *core.FuncInfo) {
+	if f.Stdlib {
+		return
+	}
+	hits, ok := testHitsKey.Get().(*testHits)
+	if !ok {
+		return
+	}
+	hits.mutex.Lock()
+	if _, ok := hits.funcs[f]; !ok {
+		hits.order = append(hits.order, f)
+	}
+	hits.funcs[f]++
+	hits.mutex.Unlock()
+}
+
+func startTestHits(t *testing.T, fn func(t *testing.T)) {
+	name := t.Name()
+	if strings.Contains(name, "/") {
+		// subtests are attributed to the top level test
+		return
+	}
+	testHitsKey.Set(&testHits{
+		pkg:   testPkg(fn),
+		name:  name,
+		funcs: make(map[*core.FuncInfo]int64),
+	})
+}
+
+func endTestHits(t *testing.T) {
+	if strings.Contains(t.Name(), "/") {
+		return
+	}
+	hits, ok := testHitsKey.Get().(*testHits)
+	if !ok {
+		return
+	}
+	testHitsKey.Set(nil)
+
+	hits.mutex.Lock()
+	export := &TestCoverageExport{
+		Pkg:   hits.pkg,
+		Test:  hits.name,
+		Funcs: make([]*FuncHitExport, 0, len(hits.order)),
+	}
+	for _, f := range hits.order {
+		export.Funcs = append(export.Funcs, newFuncHitExport(f, hits.funcs[f]))
+	}
+	hits.mutex.Unlock()
+
+	coverFileMutex.Lock()
+	defer coverFileMutex.Unlock()
+	appendJSONLine(coverPerTestFile, export)
+}
+
+// callCoverageFunc tells whether f is reported by call
+// coverage, closures are reported as part of their parents
+func callCoverageFunc(f *core.FuncInfo) bool {
+	return f.Kind == core.Kind_Func && !f.Interface && !f.Closure && !f.Stdlib &&
+		!strings.HasPrefix(f.Pkg, "github.com/xhd2015/xgo/runtime/") &&
+		!strings.HasSuffix(f.File, "_test.go")
+}
+
+func recordCall(f *core.FuncInfo) {
+	if !callCoverageFunc(f) {
+		return
+	}
+	v, ok := callCounts.Load(f)
+	if !ok {
+		v, _ = callCounts.LoadOrStore(f, new(int64))
+	}
+	atomic.AddInt64(v.(*int64), 1)
+}
+
+// flushCallCoverage appends calls since the previous flush,
+// the first line also contains functions never invoked, so
+// the report can tell which ones are not invoked
+func flushCallCoverage(t *testing.T) {
+	if strings.Contains(t.Name(), "/") {
+		return
+	}
+	coverFileMutex.Lock()
+	defer coverFileMutex.Unlock()
+
+	export := &CallCoverageExport{}
+	seen := make(map[*core.FuncInfo]bool)
+	callCounts.Range(func(key, value interface{}) bool {
+		count := atomic.SwapInt64(value.(*int64), 0)
+		if count == 0 {
+			return true
+		}
+		f := key.(*core.FuncInfo)
+		seen[f] = true
+		export.Funcs = append(export.Funcs, newFuncHitExport(f, count))
+		return true
+	})
+	if !callFuncsWritten {
+		callFuncsWritten = true
+		for _, f := range functab.GetFuncs() {
+			if seen[f] || !callCoverageFunc(f) {
+				continue
+			}
+			export.Funcs = append(export.Funcs, newFuncHitExport(f, 0))
+		}
+	}
+	if len(export.Funcs) == 0 {
+		return
+	}
+	appendJSONLine(callCoverageFile, export)
+}
+
+func newFuncHitExport(f *core.FuncInfo, count int64) *FuncHitExport {
+	return &FuncHitExport{
+		Pkg:          f.Pkg,
+		IdentityName: f.IdentityName,
+		File:         f.File,
+		Line:         f.Line,
+		Count:        count,
+	}
+}
+
+// appendJSONLine appends v as a line to file, test binaries
+// of different packages may run in parallel, each line is
+// written with a single append
+func appendJSONLine(file string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	f.Write(data)
+}
+
+// testPkg returns package of the test function,
+// e.g. github.com/xhd2015/xgo/runtime/trace for
+// github.com/xhd2015/xgo/runtime/trace.TestPushLive
+func testPkg(fn func(t *testing.T)) string {
+	if fn == nil {
+		return ""
+	}
+	rfn := runtime.FuncForPC(reflect.ValueOf(fn).Pointer())
+	if rfn == nil {
+		return ""
+	}
+	name := rfn.Name()
+	slashIdx := strings.LastIndex(name, "/")
+	dotIdx := strings.Index(name[slashIdx+1:], ".")
+	if dotIdx < 0 {
+		return ""
+	}
+	return name[:slashIdx+1+dotIdx]
+}
@@ -0,0 +1,108 @@
+package trace
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xhd2015/xgo/runtime/core"
+)
+
+func TestCoverPerTest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cover-per-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	prevFile := coverPerTestFile
+	coverPerTestFile = filepath.Join(dir, "cover.jsonl")
+	defer func() { coverPerTestFile = prevFile }()
+
+	a := &core.FuncInfo{Pkg: "example.com/a", IdentityName: "A", File: "/src/a.go", Line: 3}
+	b := &core.FuncInfo{Pkg: "example.com/a", IdentityName: "B", File: "/src/a.go", Line: 7}
+	std := &core.FuncInfo{Pkg: "strings", IdentityName: "Index", Stdlib: true}
+
+	startTestHits(t, TestCoverPerTest)
+	recordTestHit(b)
+	recordTestHit(a)
+	recordTestHit(b)
+	recordTestHit(std)
+	endTestHits(t)
+	// not collected after the test ends
+	recordTestHit(a)
+
+	data, err := ioutil.ReadFile(coverPerTestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expect 1 line, actual: %q", data)
+	}
+	var export *TestCoverageExport
+	if err := json.Unmarshal([]byte(lines[0]), &export); err != nil {
+		t.Fatal(err)
+	}
+	if export.Pkg != "github.com/xhd2015/xgo/runtime/trace" || export.Test != "TestCoverPerTest" {
+		t.Fatalf("unexpected test: %s %s", export.Pkg, export.Test)
+	}
+	if len(export.Funcs) != 2 || export.Funcs[0].IdentityName != "B" || export.Funcs[0].Count != 2 || export.Funcs[1].IdentityName != "A" || export.Funcs[1].Count != 1 {
+		t.Fatalf("unexpected funcs: %s", lines[0])
+	}
+}
+
+func TestCallCoverage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "call-coverage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	prevFile := callCoverageFile
+	callCoverageFile = filepath.Join(dir, "calls.jsonl")
+	defer func() { callCoverageFile = prevFile }()
+
+	a := &core.FuncInfo{Pkg: "example.com/a", IdentityName: "A", File: "/src/a.go", Line: 3}
+	b := &core.FuncInfo{Pkg: "example.com/a", IdentityName: "B", File: "/src/a.go", Line: 7}
+	closure := &core.FuncInfo{Pkg: "example.com/a", IdentityName: "A.func1", Closure: true}
+	test := &core.FuncInfo{Pkg: "example.com/a", IdentityName: "TestA", File: "/src/a_test.go"}
+
+	recordCall(a)
+	recordCall(b)
+	recordCall(b)
+	recordCall(closure)
+	recordCall(test)
+	flushCallCoverage(t)
+	recordCall(a)
+	flushCallCoverage(t)
+	// nothing new
+	flushCallCoverage(t)
+
+	data, err := ioutil.ReadFile(callCoverageFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expect 2 lines, actual: %q", data)
+	}
+	counts := make([]map[string]int64, len(lines))
+	for i, line := range lines {
+		var export *CallCoverageExport
+		if err := json.Unmarshal([]byte(line), &export); err != nil {
+			t.Fatal(err)
+		}
+		counts[i] = make(map[string]int64)
+		for _, f := range export.Funcs {
+			counts[i][f.IdentityName] = f.Count
+		}
+	}
+	if len(counts[0]) != 2 || counts[0]["A"] != 1 || counts[0]["B"] != 2 {
+		t.Fatalf("unexpected first line: %s", lines[0])
+	}
+	if len(counts[1]) != 1 || counts[1]["A"] != 1 {
+		t.Fatalf("unexpected second line: %s", lines[1])
+	}
+}
@@ -0,0 +1,125 @@
+package trace
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xhd2015/xgo/runtime/core"
+)
+
+type encodeNode struct {
+	Name string
+	Next *encodeNode `json:",omitempty"`
+}
+
+type encodePanic struct{}
+
+func (c encodePanic) MarshalJSON() ([]byte, error) {
+	panic("boom")
+}
+
+type encodeEmbedded struct {
+	ID int `json:"id"`
+}
+
+type encodeOuter struct {
+	encodeEmbedded
+	Secret  string `xgo:"redact"`
+	private int
+	Ch      chan int
+	Fn      func()
+	At      time.Time
+	Err     error
+}
+
+type encodeMoney struct {
+	Cents int64
+}
+
+func TestMarshalOptions(t *testing.T) {
+	cyclic := &encodeNode{Name: "a"}
+	cyclic.Next = &encodeNode{Name: "b", Next: cyclic}
+
+	deep := &encodeNode{Name: "1", Next: &encodeNode{Name: "2", Next: &encodeNode{Name: "3"}}}
+
+	selfMap := map[string]interface{}{"k": 1}
+	selfMap["self"] = selfMap
+
+	tests := []struct {
+		name   string
+		opts   *MarshalOptions
+		value  interface{}
+		expect string
+	}{
+		{"cycle", nil, cyclic, `{"Name":"a","Next":{"Name":"b","Next":{"$ref":"$"}}}`},
+		{"map cycle", nil, selfMap, `{"k":1,"self":{"$ref":"$"}}`},
+		{"depth", &MarshalOptions{MaxDepth: 2}, deep, `{"Name":"1","Next":{"Name":"2","Next":"[max depth]"}}`},
+		{"elements", &MarshalOptions{MaxElements: 2}, []int{1, 2, 3, 4}, `[1,2,"[2 more]"]`},
+		{"map elements", &MarshalOptions{MaxElements: 1}, map[int]string{1: "a", 2: "a"}, `{"?":"a","[more]":1}`},
+		{"string", &MarshalOptions{MaxStringLen: 4}, "héllo", `"hél...(2 more bytes)"`},
+		{"invalid utf8", nil, "a\xffb\n", `"a�b\n"`},
+		{"panic", nil, []interface{}{encodePanic{}, 1}, `["[panic: boom]",1]`},
+		{"skip marshalers", &MarshalOptions{SkipMarshalers: true}, encodePanic{}, `{}`},
+		{"struct", nil, &encodeOuter{
+			encodeEmbedded: encodeEmbedded{ID: 1},
+			Secret:         "s",
+			Ch:             make(chan int),
+			Fn:             func() {},
+			At:             time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			Err:            errors.New("failed"),
+		}, `{"id":1,"Secret":"[redacted]","Ch":"[chan int]","Fn":"[func]","At":"2024-01-02T03:04:05Z","Err":"failed"}`},
+		{"formatter", &MarshalOptions{Formatters: map[reflect.Type]Formatter{
+			reflect.TypeOf(encodeMoney{}): func(v interface{}) interface{} {
+				return float64(v.(encodeMoney).Cents) / 100
+			},
+		}}, []encodeMoney{{Cents: 150}}, `[1.5]`},
+		{"formatter same type", &MarshalOptions{Formatters: map[reflect.Type]Formatter{
+			reflect.TypeOf(encodeMoney{}): func(v interface{}) interface{} {
+				return encodeMoney{Cents: v.(encodeMoney).Cents * 2}
+			},
+		}}, encodeMoney{Cents: 1}, `{"Cents":2}`},
+		{"raw formatter", &MarshalOptions{Formatters: map[reflect.Type]Formatter{
+			reflect.TypeOf((*json.Marshaler)(nil)).Elem(): func(v interface{}) interface{} {
+				return json.RawMessage(`{"raw":true}`)
+			},
+		}}, encodePanic{}, `{"raw":true}`},
+		{"object", nil, redactObject(&core.FuncInfo{}, testObject{{"id", 1}, {"node", &encodeNode{Name: "x"}}}), `{"id":1,"node":{"Name":"x"}}`},
+	}
+	for _, tt := range tests {
+		data, err := tt.opts.Marshal(tt.value)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		expect := tt.expect
+		if strings.Contains(expect, "?") {
+			// which map entries are kept is not deterministic
+			expect = strings.Replace(expect, "?", string(data[2]), 1)
+		}
+		if string(data) != expect {
+			t.Errorf("%s: expect %s, actual %s", tt.name, expect, data)
+		}
+		if !json.Valid(data) {
+			t.Errorf("%s: invalid json %s", tt.name, data)
+		}
+	}
+}
+
+func TestExportWithMarshal(t *testing.T) {
+	root := &Root{Children: []*Stack{{
+		FuncInfo: &core.FuncInfo{IdentityName: "Save"},
+		Args:     testObject{{"list", strings.Split("a,b,c", ",")}},
+	}}}
+	exported := root.Export(&ExportOptions{Marshal: &MarshalOptions{MaxElements: 1}})
+	data, err := json.Marshal(exported.Children[0].Args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"list":["a","[2 more]"]}`
+	if string(data) != expect {
+		t.Errorf("expect %s, actual %s", expect, data)
+	}
+}
@@ -0,0 +1,184 @@
+package trace
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xhd2015/xgo/runtime/core"
+	"github.com/xhd2015/xgo/runtime/trap"
+)
+
+// XGO_TRACE_LIVE=<host:port> pushes each completed call
+// to a live server started by: xgo tool trace --live
+var liveAddr = strings.TrimPrefix(os.Getenv("XGO_TRACE_LIVE"), "http://")
+
+// spans waiting to be sent, pushing never blocks
+// the traced program, spans are dropped when full
+const liveQueueSize = 4096
+
+const liveBatchSize = 256
+
+// a server that stops reading must not hang the sender
+const liveWriteTimeout = time.Second
+
+var liveSeq int64 // atomic, ids of traces and calls
+
+var liveOnce sync.Once
+
+// ids are unique within a session, which tells
+// apart runs and processes pushing to one server
+var liveSession string
+var liveQueue chan []byte
+var liveFlushReq chan chan struct{}
+
+func liveEnabled() bool {
+	return liveAddr != ""
+}
+
+func newLiveID() int64 {
+	return atomic.AddInt64(&liveSeq, 1)
+}
+
+// pushLive marshals stack without its children, which
+// are pushed on their own, and queues it for sending
+func pushLive(root *Root, stack *Stack, parent *Stack, name string, opts *ExportOptions) {
+	liveOnce.Do(startLiveSender)
+
+	children := stack.Children
+	stack.Children = nil
+	exportStack := stack.Export(opts)
+	stack.Children = children
+	if exportStack == nil {
+		return
+	}
+	span := &LiveSpanExport{
+		Trace: root.liveID,
+		Name:  name,
+		ID:    stack.liveID,
+		Stack: exportStack,
+	}
+	if parent != nil {
+		span.Parent = parent.liveID
+	}
+	data, err := MarshalAnyJSON(span)
+	if err != nil {
+		return
+	}
+	select {
+	case liveQueue <- data:
+	default:
+	}
+}
+
+// flushLive waits shortly for queued spans to be sent,
+// called only when the process or a test is about to end
+func flushLive() {
+	liveOnce.Do(startLiveSender)
+	done := make(chan struct{})
+	select {
+	case liveFlushReq <- done:
+	default:
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+	}
+}
+
+// isMainFunc reports whether f is main.main,
+// the process exits right after it returns
+func isMainFunc(f *core.FuncInfo) bool {
+	return f != nil && f.Pkg == "main" && f.IdentityName == "main"
+}
+
+func startLiveSender() {
+	liveSession = fmt.Sprintf("%d_%d", os.Getpid(), timeNow().UnixNano())
+	liveQueue = make(chan []byte, liveQueueSize)
+	liveFlushReq = make(chan chan struct{}, 1)
+	go trap.Direct(runLiveSender)
+}
+
+// runLiveSender streams spans as one chunked
+// HTTP request, reconnecting when it breaks
+func runLiveSender() {
+	var conn net.Conn
+	var lastDial time.Time
+	send := func(batch []byte) {
+		if conn == nil {
+			// retry at most once a second
+			if time.Since(lastDial) < time.Second {
+				return
+			}
+			lastDial = time.Now()
+			c, err := net.DialTimeout("tcp", liveAddr, time.Second)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "trace live: %v\n", err)
+				return
+			}
+			header := "POST /live/push HTTP/1.1\r\n" +
+				"Host: " + liveAddr + "\r\n" +
+				"Content-Type: application/x-ndjson\r\n" +
+				"X-Xgo-Trace-Session: " + liveSession + "\r\n" +
+				"Transfer-Encoding: chunked\r\n\r\n"
+			c.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+			_, err = c.Write([]byte(header))
+			if err != nil {
+				c.Close()
+				return
+			}
+			conn = c
+		}
+		err := writeLiveChunk(conn, batch)
+		if err != nil {
+			conn.Close()
+			conn = nil
+		}
+	}
+	var batch bytes.Buffer
+	drain := func() {
+		for i := 0; i < liveBatchSize; i++ {
+			select {
+			case data := <-liveQueue:
+				batch.Write(data)
+				batch.WriteByte('\n')
+			default:
+				return
+			}
+		}
+	}
+	for {
+		select {
+		case data := <-liveQueue:
+			batch.Write(data)
+			batch.WriteByte('\n')
+			drain()
+			send(batch.Bytes())
+			batch.Reset()
+		case done := <-liveFlushReq:
+			for len(liveQueue) > 0 {
+				drain()
+				send(batch.Bytes())
+				batch.Reset()
+			}
+			close(done)
+		}
+	}
+}
+
+// writeLiveChunk writes batch as one chunk of the request body
+func writeLiveChunk(conn net.Conn, batch []byte) error {
+	chunk := make([]byte, 0, len(batch)+16)
+	chunk = append(chunk, fmt.Sprintf("%x\r\n", len(batch))...)
+	chunk = append(chunk, batch...)
+	chunk = append(chunk, "\r\n"...)
+	conn.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+	_, err := conn.Write(chunk)
+	return err
+}
@@ -0,0 +1,105 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/xhd2015/xgo/runtime/core"
+)
+
+func TestPushLive(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	spans := make(chan *LiveSpanExport, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		// the sender keeps its request open
+		defer conn.Close()
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			t.Errorf("bad request: %v", err)
+			return
+		}
+		if req.URL.Path != "/live/push" || req.Header.Get("X-Xgo-Trace-Session") == "" {
+			t.Errorf("unexpected request: %s %v", req.URL.Path, req.Header)
+			return
+		}
+		scanner := bufio.NewScanner(req.Body)
+		for scanner.Scan() {
+			var span *LiveSpanExport
+			err := json.Unmarshal(scanner.Bytes(), &span)
+			if err != nil {
+				t.Errorf("bad span: %v", err)
+				return
+			}
+			spans <- span
+		}
+	}()
+
+	prevAddr := liveAddr
+	liveAddr = ln.Addr().String()
+	defer func() { liveAddr = prevAddr }()
+
+	root := &Root{liveID: newLiveID()}
+	parent := &Stack{FuncInfo: &core.FuncInfo{Pkg: "example.com/a", IdentityName: "A"}, liveID: newLiveID()}
+	child := &Stack{FuncInfo: &core.FuncInfo{Pkg: "example.com/a", IdentityName: "B"}, liveID: newLiveID(), Begin: 1, End: 2}
+	parent.Children = []*Stack{child}
+
+	pushLive(root, child, parent, "TestA", nil)
+	pushLive(root, parent, nil, "TestA", nil)
+	flushLive()
+
+	for i, expect := range []struct {
+		name   string
+		id     int64
+		parent int64
+	}{
+		{"B", child.liveID, parent.liveID},
+		{"A", parent.liveID, 0},
+	} {
+		select {
+		case span := <-spans:
+			if span.Trace != root.liveID || span.Name != "TestA" || span.ID != expect.id || span.Parent != expect.parent {
+				t.Fatalf("span %d: unexpected %+v", i, span)
+			}
+			if span.Stack.FuncInfo.IdentityName != expect.name || len(span.Stack.Children) != 0 {
+				t.Fatalf("span %d: unexpected stack %+v", i, span.Stack)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("span %d not received", i)
+		}
+	}
+	if len(parent.Children) != 1 {
+		t.Fatalf("expect children restored")
+	}
+}
+
+func TestWriteLiveChunkTimeout(t *testing.T) {
+	// the other end never reads
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- writeLiveChunk(conn, []byte("{}\n"))
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatalf("expect write timeout")
+		}
+	case <-time.After(liveWriteTimeout + 3*time.Second):
+		t.Fatalf("write not timed out")
+	}
+}
@@ -0,0 +1,65 @@
+package trace
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Log attaches msg to the innermost call being collected
+// on current goroutine, it does nothing if no trace is
+// being collected
+func Log(msg string) {
+	root := collectingRoot()
+	if root == nil || root.Top == nil {
+		return
+	}
+	root.Top.Logs = append(root.Top.Logs, &LogEntry{
+		Time: int64(timeSince(root.Begin)),
+		Msg:  msg,
+	})
+}
+
+// Logf is like Log, but formats msg with fmt.Sprintf
+func Logf(format string, args ...interface{}) {
+	Log(fmt.Sprintf(format, args...))
+}
+
+// LogWriter returns a writer that attaches each written line
+// with Log, so logs of existing loggers can be captured, e.g.
+//
+//	log.SetOutput(io.MultiWriter(os.Stderr, trace.LogWriter()))
+//
+// Each Write is expected to contain whole lines, as
+// standard loggers do.
+func LogWriter() io.Writer {
+	return logWriter{}
+}
+
+type logWriter struct{}
+
+func (c logWriter) Write(p []byte) (int, error) {
+	if collectingRoot() == nil {
+		return len(p), nil
+	}
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		Log(strings.TrimSuffix(line, "\r"))
+	}
+	return len(p), nil
+}
+
+// collectingRoot returns the root being collected on current goroutine
+func collectingRoot() *Root {
+	key := uintptr(__xgo_link_getcurg())
+	if v, ok := collectingMap.Load(key); ok {
+		l := v.(*optStack)
+		if len(l.list) == 0 {
+			return nil
+		}
+		return l.list[len(l.list)-1].root
+	}
+	if v, ok := stackMap.Load(key); ok {
+		return v.(*Root)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package trace
+
+import (
+	"fmt"
+	"log"
+	"testing"
+)
+
+func TestLog(t *testing.T) {
+	// not collecting
+	Log("dropped")
+
+	top := &Stack{}
+	root := &Root{Top: top, Begin: timeNow(), Children: []*Stack{top}}
+	key := uintptr(__xgo_link_getcurg())
+	stackMap.Store(key, root)
+	defer stackMap.Delete(key)
+
+	Logf("id=%d", 1)
+	logger := log.New(LogWriter(), "", 0)
+	logger.Printf("a\nb")
+
+	var msgs []string
+	for _, l := range top.Logs {
+		msgs = append(msgs, l.Msg)
+	}
+	if fmt.Sprint(msgs) != "[id=1 a b]" {
+		t.Fatalf("expect logs [id=1 a b], actual %v", msgs)
+	}
+}
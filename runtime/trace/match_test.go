@@ -0,0 +1,60 @@
+package trace
+
+import "testing"
+
+func TestMatchWildcard(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"main.handle", "main.handle", true},
+		{"main.handle", "main.handleX", false},
+		{"*", "anything", true},
+		{"main.*", "main.handle", true},
+		{"Save*", "SaveAll", true},
+		{"*All", "SaveAll", true},
+		{"*.ServeHTTP", "(*Handler).ServeHTTP", true},
+		{"a*c*e", "abcde", true},
+		{"a*c*e", "abcd", false},
+		{"a*b*c", "acb", false},
+		{"a*a", "a", false},
+		{"ab*ba", "aba", false},
+	}
+	for _, tt := range tests {
+		got := matchWildcard(tt.pattern, tt.s)
+		if got != tt.want {
+			t.Errorf("matchWildcard(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestMatchFuncName(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		pkg      string
+		name     string
+		recvType string
+		want     bool
+	}{
+		{"github.com/org/svc/api.(*Handler).ServeHTTP", "github.com/org/svc/api", "ServeHTTP", "Handler", true},
+		{"api.(*Handler).ServeHTTP", "github.com/org/svc/api", "ServeHTTP", "Handler", true},
+		{"(*Handler).ServeHTTP", "github.com/org/svc/api", "ServeHTTP", "Handler", true},
+		{"ServeHTTP", "github.com/org/svc/api", "ServeHTTP", "Handler", true},
+		{"api.*", "github.com/org/svc/api", "ServeHTTP", "Handler", true},
+		{"svc.*", "github.com/org/svc/api", "ServeHTTP", "Handler", false},
+		{"main.run", "main", "run", "", true},
+		{"run", "main", "run", "", true},
+		{"Save", "main", "SaveAll", "", false},
+	}
+	for _, tt := range tests {
+		identityName := tt.name
+		if tt.recvType != "" {
+			identityName = "(*" + tt.recvType + ")." + tt.name
+		}
+		got := matchFuncName(tt.pattern, tt.pkg, identityName, tt.name, tt.recvType)
+		if got != tt.want {
+			t.Errorf("matchFuncName(%q, %s.%s) = %v, want %v", tt.pattern, tt.pkg, identityName, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package trace
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xhd2015/xgo/runtime/core"
+)
+
+type redactCredential struct {
+	User     string
+	Password string
+	Token    string `json:"access_token"`
+	Note     string `xgo:"redact"`
+	hidden   string
+}
+
+type redactSecret struct {
+	Value string
+}
+
+type redactBase struct {
+	APIKey string `json:"apiKey,omitempty"`
+}
+
+type redactRequest struct {
+	redactBase
+	ID      int
+	Cred    *redactCredential
+	Secret  redactSecret
+	Extra   interface{}
+	Headers map[string]string
+	When    time.Time
+	Skip    string `json:"-"`
+}
+
+type redactNode struct {
+	Name string
+	Next *redactNode
+}
+
+func TestRedact(t *testing.T) {
+	defer SetRedactOptions(GetRedactOptions())
+	SetRedactOptions(&RedactOptions{
+		Fields: []string{"password", "*token*", "apikey", "authorization"},
+		Types:  []string{"*.redactSecret"},
+	})
+	when := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	req := &redactRequest{
+		redactBase: redactBase{APIKey: "k"},
+		ID:         1,
+		Cred:       &redactCredential{User: "u", Password: "p", Token: "t", Note: "n", hidden: "h"},
+		Secret:     redactSecret{Value: "s"},
+		Extra:      map[string]interface{}{"Authorization": "Bearer x", "keep": []interface{}{1}},
+		Headers:    map[string]string{"X-Token": "t", "Accept": "json"},
+		When:       when,
+		Skip:       "skip",
+	}
+	data, err := json.Marshal(Redact(req))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"apiKey":"[redacted]","ID":1,"Cred":{"User":"u","Password":"[redacted]","access_token":"[redacted]","Note":"[redacted]"},"Secret":"[redacted]","Extra":{"Authorization":"[redacted]","keep":[1]},"Headers":{"Accept":"json","X-Token":"[redacted]"},"When":"2024-01-02T03:04:05Z"}`
+	if string(data) != expect {
+		t.Fatalf("expect:\n%s\nactual:\n%s", expect, data)
+	}
+	// original value is untouched
+	if req.Cred.Password != "p" {
+		t.Fatalf("original modified: %s", req.Cred.Password)
+	}
+}
+
+func TestRedactUnchanged(t *testing.T) {
+	defer SetRedactOptions(GetRedactOptions())
+	SetRedactOptions(&RedactOptions{Fields: []string{"password"}})
+
+	node := &redactNode{Name: "a", Next: &redactNode{Name: "b"}}
+	if Redact(node) != interface{}(node) {
+		t.Fatalf("expect values without sensitive fields to be returned as is")
+	}
+	list := []int{1, 2}
+	if !reflect.DeepEqual(Redact(list), list) {
+		t.Fatalf("expect slice to be returned as is")
+	}
+}
+
+// testObject implements core.Object for args
+type testObject []testField
+
+type testField struct {
+	name string
+	val  interface{}
+}
+
+func (c testObject) GetField(name string) core.Field {
+	for _, f := range c {
+		if f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+func (c testObject) GetFieldIndex(i int) core.Field { return c[i] }
+func (c testObject) NumField() int                  { return len(c) }
+
+func (c testField) Name() string        { return c.name }
+func (c testField) Value() interface{}  { return c.val }
+func (c testField) Ptr() interface{}    { return &c.val }
+func (c testField) Set(val interface{}) {}
+
+func TestRedactObject(t *testing.T) {
+	defer SetRedactOptions(GetRedactOptions())
+	SetRedactOptions(&RedactOptions{
+		Fields: []string{"password"},
+		Funcs:  []string{"example.com/auth.Login"},
+	})
+	args := testObject{
+		{"user", "u"},
+		{"password", "p"},
+		{"cred", &redactCredential{User: "u", Password: "p"}},
+	}
+	tests := []struct {
+		fn     *core.FuncInfo
+		expect string
+	}{
+		{
+			fn:     &core.FuncInfo{Pkg: "example.com/auth", IdentityName: "Check"},
+			expect: `{"user":"u","password":"[redacted]","cred":{"User":"u","Password":"[redacted]","access_token":"","Note":"[redacted]"}}`,
+		},
+		{
+			fn:     &core.FuncInfo{Pkg: "example.com/auth", IdentityName: "Login"},
+			expect: `{"user":"[redacted]","password":"[redacted]","cred":"[redacted]"}`,
+		},
+	}
+	for _, tt := range tests {
+		data, err := json.Marshal(redactObject(tt.fn, args))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tt.expect {
+			t.Errorf("%s: expect %s, actual %s", tt.fn.IdentityName, tt.expect, data)
+		}
+	}
+}
@@ -19,6 +19,12 @@ type Root struct {
 	Top      *Stack
 	Begin    time.Time
 	Children []*Stack
+
+	// set when started by trace control
+	control *controlState
+
+	// set when pushing to a live server
+	liveID int64
 }
 
 type Stack struct {
@@ -33,9 +39,23 @@ type Stack struct {
 	// is recorded as snapshot
 	Snapshot bool
 
-	Panic    bool
-	Error    error
+	Panic bool
+	Error error
+
+	// answered by a mock instead of the real function
+	Mocked bool
+	// lines logged via Log or LogWriter during the call
+	Logs []*LogEntry
+
 	Children []*Stack
+
+	// set when pushing to a live server
+	liveID int64
+}
+
+type LogEntry struct {
+	Time int64 // relative to root begin
+	Msg  string
 }
 
 // allow skip some packages
@@ -49,6 +69,11 @@ type ExportOptions struct {
 	SizeLimit       int // 0: default limit 16K
 	AppearanceLimit int // 0: default limit 100
 
+	// Marshal selects the marshaling engine for args and
+	// results, which limits depth and size, detects cycles
+	// and applies formatters. nil uses MarshalAnyJSON.
+	Marshal *MarshalOptions
+
 	FilterStack func(stack *StackExport) *StackExport
 
 	FilterRoot  func(root *RootExport) *RootExport
@@ -163,8 +188,12 @@ func (c *Stack) Export(opts *ExportOptions) *StackExport {
 	if c.Error != nil {
 		errMsg = c.Error.Error()
 	}
-	var args interface{} = c.Args
-	var results interface{} = c.Results
+	var args interface{} = redactObject(c.FuncInfo, c.Args)
+	var results interface{} = redactObject(c.FuncInfo, c.Results)
+	if opts != nil && opts.Marshal != nil {
+		args = &marshalValue{args, opts.Marshal}
+		results = &marshalValue{results, opts.Marshal}
+	}
 
 	sizeLimit := opts.getSizeLimit()
 	if sizeLimit > 0 {
@@ -184,6 +213,8 @@ func (c *Stack) Export(opts *ExportOptions) *StackExport {
 		Snapshot: c.Snapshot,
 		Panic:    c.Panic,
 		Error:    errMsg,
+		Mocked:   c.Mocked,
+		Logs:     exportLogs(c.Logs),
 		Children: ((stacks)(c.Children)).Export(opts),
 	}
 
@@ -193,6 +224,20 @@ func (c *Stack) Export(opts *ExportOptions) *StackExport {
 	return stack
 }
 
+func exportLogs(logs []*LogEntry) []*LogEntryExport {
+	if logs == nil {
+		return nil
+	}
+	list := make([]*LogEntryExport, 0, len(logs))
+	for _, log := range logs {
+		list = append(list, &LogEntryExport{
+			Time: log.Time,
+			Msg:  log.Msg,
+		})
+	}
+	return list
+}
+
 func ExportFuncInfo(c *core.FuncInfo, opts *ExportOptions) *FuncInfoExport {
 	if c == nil {
 		return nil
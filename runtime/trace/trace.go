@@ -1,12 +1,14 @@
 package trace
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 	"unsafe"
@@ -38,11 +40,24 @@ func init() {
 			name: name,
 		}
 		testInfoMapping.LoadOrStore(key, tInfo)
+		if coverPerTestFile != "" {
+			startTestHits(t, fn)
+		}
 		if flags.STRACE == "on" || flags.STRACE == "true" {
 			tInfo.onFinish = Begin()
 		}
 	})
 	__xgo_link_on_test_end(func(t *testing.T, fn func(t *testing.T)) {
+		if coverPerTestFile != "" {
+			endTestHits(t)
+		}
+		if callCoverageFile != "" {
+			flushCallCoverage(t)
+		}
+		if liveEnabled() {
+			// the test binary may exit right after
+			flushLive()
+		}
 		key := uintptr(__xgo_link_getcurg())
 		val, ok := testInfoMapping.Load(key)
 		if !ok {
@@ -202,13 +217,8 @@ func (c *collectOpts) Begin() func() {
 }
 
 func setupInterceptor() func() {
-	if enabledGlobally {
-		setupOnceGlobally.Do(func() {
-			trap.AddInterceptorHead(&trap.Interceptor{
-				Pre:  handleTracePre,
-				Post: handleTracePost,
-			})
-		})
+	if enabledGlobally || controlInstalled {
+		setupGlobalInterceptor()
 		return func() {}
 	}
 
@@ -219,6 +229,34 @@ func setupInterceptor() func() {
 	})
 }
 
+// must be called from init
+func setupGlobalInterceptor() {
+	setupOnceGlobally.Do(func() {
+		trap.AddInterceptorHead(&trap.Interceptor{
+			Pre:  handleTracePre,
+			Post: handleTracePost,
+		})
+	})
+}
+
+// globalCollecting tells whether calls on the goroutine
+// should be collected into a global root, either enabled
+// globally, or started by trace control
+func globalCollecting(key uintptr) bool {
+	if enabledGlobally {
+		return true
+	}
+	if !controlInstalled {
+		return false
+	}
+	if isControlActive() {
+		return true
+	}
+	// finish traces already started
+	_, ok := stackMap.Load(key)
+	return ok
+}
+
 // returns a value to be passed to post
 // if returns err trap.ErrSkip, this interceptor is skipped, handleTracePost is not called, next interceptors will
 // be normally executed
@@ -239,7 +277,7 @@ func handleTracePre(ctx context.Context, f *core.FuncInfo, args core.Object, res
 		if len(l.list) > 0 {
 			localOpts = l.list[len(l.list)-1]
 		}
-	} else if !enabledGlobally {
+	} else if !globalCollecting(key) {
 		return nil, trap.ErrSkip
 	}
 	stack := &Stack{
@@ -247,14 +285,24 @@ func handleTracePre(ctx context.Context, f *core.FuncInfo, args core.Object, res
 		Args:     args,
 		Results:  results,
 	}
+	if liveEnabled() {
+		stack.liveID = newLiveID()
+	}
 	var globalRoot interface{}
 	var localRoot *Root
 	var initial bool
+	var control *controlState
 	if localOpts == nil {
 		var globalLoaded bool
 		globalRoot, globalLoaded = stackMap.Load(key)
 		if !globalLoaded {
 			initial = true
+			if !enabledGlobally {
+				control = acquireControl(f)
+				if control == nil {
+					return nil, trap.ErrSkip
+				}
+			}
 		}
 	} else {
 		if !checkFilters(stack, localOpts.filters) {
@@ -270,7 +318,7 @@ func handleTracePre(ctx context.Context, f *core.FuncInfo, args core.Object, res
 		}
 		if anySnapshot {
 			stack.Snapshot = true
-			stack.Args = premarshal(stack.Args)
+			stack.Args = premarshal(stack.FuncInfo, stack.Args, localOpts.exportOptions)
 		}
 
 		localRoot = localOpts.root
@@ -294,6 +342,10 @@ func handleTracePre(ctx context.Context, f *core.FuncInfo, args core.Object, res
 			Children: []*Stack{
 				stack,
 			},
+			control: control,
+		}
+		if liveEnabled() {
+			root.liveID = newLiveID()
 		}
 		stack.Begin = int64(timeSince(root.Begin))
 		if localOpts == nil {
@@ -337,7 +389,7 @@ func handleTracePost(ctx context.Context, f *core.FuncInfo, args core.Object, re
 		if len(l.list) > 0 {
 			localOpts = l.list[len(l.list)-1]
 		}
-	} else if !enabledGlobally {
+	} else if !globalCollecting(key) {
 		return nil
 	}
 	var root *Root
@@ -357,7 +409,11 @@ func handleTracePost(ctx context.Context, f *core.FuncInfo, args core.Object, re
 		root = v.(*Root)
 	}
 	if root.Top != nil && root.Top.Snapshot {
-		root.Top.Results = premarshal(root.Top.Results)
+		var exportOpts *ExportOptions
+		if localOpts != nil {
+			exportOpts = localOpts.exportOptions
+		}
+		root.Top.Results = premarshal(root.Top.FuncInfo, root.Top.Results, exportOpts)
 	}
 
 	// detect panic
@@ -377,9 +433,25 @@ func handleTracePost(ctx context.Context, f *core.FuncInfo, args core.Object, re
 			}
 		}
 	}
+	root.Top.Mocked = trap.IsMocked()
 	root.Top.End = int64(timeSince(root.Begin))
+	if liveEnabled() {
+		var exportOpts *ExportOptions
+		var name string
+		if localOpts != nil {
+			exportOpts = localOpts.exportOptions
+			name = localOpts.name
+		}
+		parent, _ := data.(*Stack)
+		pushLive(root, root.Top, parent, name, exportOpts)
+	}
 	if data == nil {
+		top := root.Top
 		root.Top = nil
+		if liveEnabled() && isMainFunc(top.FuncInfo) {
+			// the program exits when main returns
+			flushLive()
+		}
 		// stack finished
 		if localOpts != nil {
 			// handled by local options
@@ -389,6 +461,9 @@ func handleTracePost(ctx context.Context, f *core.FuncInfo, args core.Object, re
 		// global
 		stackMap.Delete(key)
 		emitTraceNoErr("", root, nil)
+		if root.control != nil {
+			root.control.onEmitted()
+		}
 		return nil
 	}
 	// pop stack
@@ -464,6 +539,12 @@ func enableLocal(collOpts *collectOpts) func() {
 
 var traceOutput = os.Getenv("XGO_TRACE_OUTPUT")
 
+// XGO_TRACE_FORMAT=binary writes trace files in the
+// compact binary format instead of JSON
+var traceFormat = os.Getenv("XGO_TRACE_FORMAT")
+
+var traceSeq int64 // atomic
+
 func getTraceOutput() string {
 	return traceOutput
 }
@@ -500,6 +581,34 @@ func fmtStack(root *Root, opts *ExportOptions) (data []byte, err error) {
 	return MarshalAnyJSON(exportRoot)
 }
 
+func fmtBinaryStack(root *Root, opts *ExportOptions) (data []byte, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			if pe, ok := e.(error); ok {
+				err = pe
+			} else {
+				err = fmt.Errorf("panic: %v", e)
+			}
+			return
+		}
+	}()
+	exportRoot := root.Export(opts)
+	if opts != nil && opts.FilterRoot != nil {
+		exportRoot = opts.FilterRoot(exportRoot)
+	}
+	var buf bytes.Buffer
+	// values are marshaled the same as the json trace
+	binaryOpts := &BinaryOptions{Marshal: MarshalAnyJSON}
+	if opts != nil && opts.Marshal != nil {
+		binaryOpts.Marshal = opts.Marshal.Marshal
+	}
+	err = WriteBinaryTrace(&buf, exportRoot, binaryOpts)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 func emitTraceNoErr(name string, root *Root, opts *ExportOptions) {
 	var err error
 	defer func() {
@@ -528,15 +637,23 @@ func formatTime(t time.Time, layout string) (output string) {
 // TODO: may add callback for this
 func emitTrace(name string, root *Root, opts *ExportOptions) error {
 	xgoTraceOutput := getTraceOutput()
+	if root.control != nil && root.control.opts.Output != "" {
+		xgoTraceOutput = root.control.opts.Output
+	}
 
 	if xgoTraceOutput == "off" {
 		return nil
 	}
-	useStdout := xgoTraceOutput == "stdout"
+	// text and markdown are rendered for reading in
+	// terminals and CI logs, so they go to stdout
+	textFormat := xgoTraceOutput == "text" || xgoTraceOutput == "markdown"
+	useStdout := xgoTraceOutput == "stdout" || textFormat
 	subName := name
 	canUseFlagDir := true
 	if name == "" {
-		traceIDNum := int64(1)
+		// goroutines are reused, so the id alone
+		// is not unique
+		traceIDNum := atomic.AddInt64(&traceSeq, 1)
 		ghex := fmt.Sprintf("g_%x", __xgo_link_getcurg())
 		traceID := "t_" + strconv.FormatInt(traceIDNum, 10)
 		if xgoTraceOutput == "" {
@@ -550,11 +667,22 @@ func emitTrace(name string, root *Root, opts *ExportOptions) error {
 		}
 	}
 
+	if textFormat {
+		return printText(subName, root, opts, xgoTraceOutput == "markdown")
+	}
 	if useStdout {
 		fmt.Printf("%s: ", subName)
 	}
+	// binary is not readable on stdout
+	binaryFormat := traceFormat == "binary" && !useStdout
 	var traceOut []byte
-	trace, stackErr := fmtStack(root, opts)
+	var trace []byte
+	var stackErr error
+	if binaryFormat {
+		trace, stackErr = fmtBinaryStack(root, opts)
+	} else {
+		trace, stackErr = fmtStack(root, opts)
+	}
 	if stackErr != nil {
 		traceOut = []byte("error:" + stackErr.Error())
 	} else {
@@ -567,6 +695,9 @@ func emitTrace(name string, root *Root, opts *ExportOptions) error {
 	}
 
 	subFile := subName + ".json"
+	if binaryFormat {
+		subFile = subName + BinaryTraceExt
+	}
 	if canUseFlagDir && flags.STRACE_DIR != "" {
 		// ensure strace dir exists
 		stat, err := os.Stat(flags.STRACE_DIR)
@@ -597,7 +728,40 @@ func emitTrace(name string, root *Root, opts *ExportOptions) error {
 	return err
 }
 
-func premarshal(v core.Object) (res core.Object) {
+func printText(name string, root *Root, opts *ExportOptions, markdown bool) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			if pe, ok := e.(error); ok {
+				err = pe
+			} else {
+				err = fmt.Errorf("panic: %v", e)
+			}
+		}
+	}()
+	exportRoot := root.Export(opts)
+	if opts != nil && opts.FilterRoot != nil {
+		exportRoot = opts.FilterRoot(exportRoot)
+	}
+	textOpts := DefaultTextOptions()
+	textOpts.Title = name
+	textOpts.Markdown = markdown
+	textOpts.Marshal = MarshalAnyJSON
+	if opts != nil && opts.Marshal != nil {
+		textOpts.Marshal = opts.Marshal.Marshal
+	}
+
+	var buf bytes.Buffer
+	err = WriteText(&buf, exportRoot, textOpts)
+	if err != nil {
+		return err
+	}
+	trap.Direct(func() {
+		_, err = os.Stdout.Write(buf.Bytes())
+	})
+	return err
+}
+
+func premarshal(f *core.FuncInfo, v core.Object, opts *ExportOptions) (res core.Object) {
 	var err error
 	var data []byte
 	defer func() {
@@ -613,7 +777,11 @@ func premarshal(v core.Object) (res core.Object) {
 		}
 		res = &premarshaled{Object: v, data: data}
 	}()
-	data, err = MarshalAnyJSON(v)
+	if opts != nil && opts.Marshal != nil {
+		data, err = opts.Marshal.Marshal(redactObject(f, v))
+		return
+	}
+	data, err = MarshalAnyJSON(redactObject(f, v))
 	return
 }
 
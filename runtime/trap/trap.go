@@ -95,6 +95,7 @@ type stack struct {
 	funcInfo *core.FuncInfo
 	stage    stage
 	pc       uintptr // the actual pc
+	mocked   bool    // answered by a mock, see MarkMocked
 }
 
 type stage int
@@ -369,16 +370,37 @@ func trap(f *core.FuncInfo, pc uintptr, recv interface{}, args []interface{}, re
 }
 
 func GetTrappingPC() uintptr {
+	top := currentStack()
+	if top == nil {
+		return 0
+	}
+	return top.pc
+}
+
+func currentStack() *stack {
 	key := uintptr(__xgo_link_getcurg())
 	val, ok := stackMapping.Load(key)
 	if !ok {
-		return 0
+		return nil
 	}
-	top := val.(*root).top
-	if top == nil {
-		return 0
+	return val.(*root).top
+}
+
+// MarkMocked marks the call being trapped on current
+// goroutine as answered by a mock, so that interceptors
+// like trace can tell real calls from faked ones in Post
+func MarkMocked() {
+	top := currentStack()
+	if top != nil {
+		top.mocked = true
 	}
-	return top.pc
+}
+
+// IsMocked tells whether the call being trapped on
+// current goroutine was marked by MarkMocked
+func IsMocked() bool {
+	top := currentStack()
+	return top != nil && top.mocked
 }
 
 func clearLocalInterceptorsAndMark() {
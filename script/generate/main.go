@@ -108,12 +108,15 @@ func generate(rootDir string, subGens SubGens) error {
 		}
 	}
 	if subGens.Has(GenernateType_StackTraceDef) {
-		err := copyTraceExport(
-			filepath.Join(rootDir, "runtime", "trace", "stack_export.go"),
-			filepath.Join(rootDir, "cmd", "xgo", "trace", "stack_export.go"),
-		)
-		if err != nil {
-			return err
+		// shared by runtime and xgo tool trace
+		for _, file := range []string{"stack_export.go", "profile.go", "text.go", "binary.go", "match.go"} {
+			err := copyTraceExport(
+				filepath.Join(rootDir, "runtime", "trace", file),
+				filepath.Join(rootDir, "cmd", "xgo", "trace", file),
+			)
+			if err != nil {
+				return err
+			}
 		}
 	}
 	if subGens.Has(GenernateType_CompilerPatternCode) {
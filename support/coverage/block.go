@@ -0,0 +1,62 @@
+package coverage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Block is a parsed profile line:
+//
+//	name.go:line.column,line.column numberOfStatements count
+type Block struct {
+	// import path based, e.g. github.com/xhd2015/xgo/support/coverage/block.go
+	File      string
+	StartLine int
+	StartCol  int
+	EndLine   int
+	EndCol    int
+	NumStmt   int
+	Count     int64
+}
+
+func ParseBlock(line *CovLine) (*Block, error) {
+	prefix := line.Prefix
+	colonIdx := strings.LastIndex(prefix, ":")
+	if colonIdx < 0 {
+		return nil, fmt.Errorf("bad block: %s", prefix)
+	}
+	var block Block
+	block.File = prefix[:colonIdx]
+	_, err := fmt.Sscanf(prefix[colonIdx+1:], "%d.%d,%d.%d %d", &block.StartLine, &block.StartCol, &block.EndLine, &block.EndCol, &block.NumStmt)
+	if err != nil {
+		return nil, fmt.Errorf("bad block: %s %w", prefix, err)
+	}
+	block.Count = line.Count
+	return &block, nil
+}
+
+// ParseBlocks parses lines into blocks, grouped by file
+// in the order they first appear
+func ParseBlocks(lines []*CovLine) (files []string, blocksByFile map[string][]*Block, err error) {
+	blocksByFile = make(map[string][]*Block)
+	for _, line := range lines {
+		block, err := ParseBlock(line)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := blocksByFile[block.File]; !ok {
+			files = append(files, block.File)
+		}
+		blocksByFile[block.File] = append(blocksByFile[block.File], block)
+	}
+	return files, blocksByFile, nil
+}
+
+// Pkg returns the import path of the package
+func (c *Block) Pkg() string {
+	idx := strings.LastIndex(c.File, "/")
+	if idx < 0 {
+		return ""
+	}
+	return c.File[:idx]
+}
@@ -0,0 +1,105 @@
+package coverage
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strings"
+)
+
+// CallCoverage lists functions instrumented by xgo and how
+// many times each was invoked, written by xgo test --call-coverage
+type CallCoverage struct {
+	Funcs []*FuncCall
+}
+
+type FuncCall struct {
+	Pkg string
+	// e.g. A, (*T).B
+	Name string
+	// slash separated, relative to the project root, absolute
+	// if outside of it
+	File  string
+	Line  int
+	Count int64
+}
+
+// Key is the package joined with function name, e.g. example.com/a.(*T).B
+func (c *FuncCall) Key() string {
+	return c.Pkg + "." + c.Name
+}
+
+// Exported reports whether the function is part of the package
+// API, i.e. both the function and its receiver type are exported
+func (c *FuncCall) Exported() bool {
+	name := c.Name
+	if idx := strings.LastIndex(name, "."); idx >= 0 {
+		recv := strings.TrimSuffix(strings.TrimPrefix(name[:idx], "(*"), ")")
+		if !isExported(recv) {
+			return false
+		}
+		name = name[idx+1:]
+	}
+	return isExported(name)
+}
+
+func isExported(name string) bool {
+	return name != "" && name[0] >= 'A' && name[0] <= 'Z'
+}
+
+func LoadCallCoverage(file string) (*CallCoverage, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var cov *CallCoverage
+	err = json.Unmarshal(data, &cov)
+	if err != nil {
+		return nil, err
+	}
+	if cov == nil {
+		cov = &CallCoverage{}
+	}
+	return cov, nil
+}
+
+func (c *CallCoverage) Save(file string) error {
+	data, err := json.MarshalIndent(c, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0644)
+}
+
+// MergeCallCoverage sums counts of the same function,
+// the result is sorted by package, file and line
+func MergeCallCoverage(covs ...*CallCoverage) *CallCoverage {
+	byKey := make(map[string]*FuncCall)
+	merged := &CallCoverage{Funcs: []*FuncCall{}}
+	for _, cov := range covs {
+		for _, f := range cov.Funcs {
+			key := f.Key()
+			if prev := byKey[key]; prev != nil {
+				prev.Count += f.Count
+				continue
+			}
+			copyF := *f
+			byKey[key] = &copyF
+			merged.Funcs = append(merged.Funcs, &copyF)
+		}
+	}
+	sort.Slice(merged.Funcs, func(i, j int) bool {
+		a, b := merged.Funcs[i], merged.Funcs[j]
+		if a.Pkg != b.Pkg {
+			return a.Pkg < b.Pkg
+		}
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Name < b.Name
+	})
+	return merged
+}
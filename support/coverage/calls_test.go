@@ -0,0 +1,45 @@
+package coverage
+
+import "testing"
+
+func TestMergeCallCoverage(t *testing.T) {
+	a := &CallCoverage{Funcs: []*FuncCall{
+		{Pkg: "example.com/a", Name: "B", File: "a/a.go", Line: 7, Count: 0},
+		{Pkg: "example.com/a", Name: "A", File: "a/a.go", Line: 3, Count: 1},
+	}}
+	b := &CallCoverage{Funcs: []*FuncCall{
+		{Pkg: "example.com/a", Name: "A", File: "a/a.go", Line: 3, Count: 2},
+		{Pkg: "example.com/a", Name: "(*t).C", File: "a/c.go", Line: 1, Count: 1},
+	}}
+	merged := MergeCallCoverage(a, b)
+	if len(merged.Funcs) != 3 {
+		t.Fatalf("expect 3 funcs, actual: %d", len(merged.Funcs))
+	}
+	if merged.Funcs[0].Name != "A" || merged.Funcs[0].Count != 3 || merged.Funcs[1].Name != "B" || merged.Funcs[2].Name != "(*t).C" {
+		t.Fatalf("unexpected merge result: %+v %+v %+v", merged.Funcs[0], merged.Funcs[1], merged.Funcs[2])
+	}
+	// inputs are not modified
+	if a.Funcs[1].Count != 1 {
+		t.Fatalf("input modified: %d", a.Funcs[1].Count)
+	}
+}
+
+func TestFuncCallExported(t *testing.T) {
+	testCases := []struct {
+		name     string
+		exported bool
+	}{
+		{"A", true},
+		{"a", false},
+		{"T.A", true},
+		{"(*T).A", true},
+		{"(*t).A", false},
+		{"T.a", false},
+	}
+	for _, tt := range testCases {
+		f := &FuncCall{Name: tt.name}
+		if f.Exported() != tt.exported {
+			t.Fatalf("%s: expect exported=%v", tt.name, tt.exported)
+		}
+	}
+}
@@ -42,3 +42,21 @@ github.com/xhd2015/xgo/runtime/core/func.go:44.41,45.22 1 2`)
 		t.Fatalf("cmpLines[1].Count: %d", cmpLines[1].Count)
 	}
 }
+
+func TestParseBlock(t *testing.T) {
+	block, err := ParseBlock(&CovLine{Prefix: "github.com/xhd2015/xgo/runtime/core/func.go:44.41,45.22 3", Count: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := Block{File: "github.com/xhd2015/xgo/runtime/core/func.go", StartLine: 44, StartCol: 41, EndLine: 45, EndCol: 22, NumStmt: 3, Count: 2}
+	if *block != expect {
+		t.Fatalf("expect %+v, actual %+v", expect, *block)
+	}
+	if block.Pkg() != "github.com/xhd2015/xgo/runtime/core" {
+		t.Fatalf("Pkg(): %s", block.Pkg())
+	}
+	_, err = ParseBlock(&CovLine{Prefix: "bad"})
+	if err == nil {
+		t.Fatalf("expect error")
+	}
+}
@@ -0,0 +1,177 @@
+package coverage
+
+import (
+	"encoding/json"
+	"errors"
+	"go/ast"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// TestIndexFile is where xgo test --cover-per-test
+// saves the index, relative to the project root
+const TestIndexFile = ".xgo/test-index.json"
+
+// TestIndex maps tests to functions they call
+type TestIndex struct {
+	// keyed by TestEntry.Key()
+	Tests map[string]*TestEntry
+}
+
+type TestEntry struct {
+	Pkg   string
+	Name  string
+	Funcs []*FuncHit
+}
+
+type FuncHit struct {
+	Pkg string
+	// e.g. A, (*T).B
+	Name string
+	// slash separated, relative to the project root, absolute
+	// if outside of it
+	File  string
+	Line  int
+	Count int64
+}
+
+// FuncName returns name of fn in the form of
+// FuncHit.Name, e.g. A, T.A, (*T).B
+func FuncName(fn *ast.FuncDecl) string {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return fn.Name.Name
+	}
+	recv := fn.Recv.List[0].Type
+	var ptr bool
+	if star, ok := recv.(*ast.StarExpr); ok {
+		ptr = true
+		recv = star.X
+	}
+	// generic receiver: T[K]
+	switch t := recv.(type) {
+	case *ast.IndexExpr:
+		recv = t.X
+	case *ast.IndexListExpr:
+		recv = t.X
+	}
+	var typeName string
+	if ident, ok := recv.(*ast.Ident); ok {
+		typeName = ident.Name
+	}
+	if ptr {
+		return "(*" + typeName + ")." + fn.Name.Name
+	}
+	return typeName + "." + fn.Name.Name
+}
+
+// Key is the package joined with test name, e.g. example.com/a.TestA
+func (c *TestEntry) Key() string {
+	return c.Pkg + "." + c.Name
+}
+
+func LoadTestIndex(file string) (*TestIndex, error) {
+	index := &TestIndex{Tests: make(map[string]*TestEntry)}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return index, nil
+		}
+		return nil, err
+	}
+	err = json.Unmarshal(data, index)
+	if err != nil {
+		return nil, err
+	}
+	if index.Tests == nil {
+		index.Tests = make(map[string]*TestEntry)
+	}
+	return index, nil
+}
+
+func (c *TestIndex) Save(file string) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0644)
+}
+
+// Update replaces entries of tests run again, entries
+// of other tests are kept
+func (c *TestIndex) Update(entries []*TestEntry) {
+	for _, entry := range entries {
+		c.Tests[entry.Key()] = entry
+	}
+}
+
+// SortedTests returns tests sorted by key
+func (c *TestIndex) SortedTests() []*TestEntry {
+	tests := make([]*TestEntry, 0, len(c.Tests))
+	for _, entry := range c.Tests {
+		tests = append(tests, entry)
+	}
+	sortTests(tests)
+	return tests
+}
+
+// TestsOfFunc returns tests calling the function, all
+// functions of pkg are matched if name is empty
+func (c *TestIndex) TestsOfFunc(pkg string, name string) []*TestEntry {
+	return c.filter(func(f *FuncHit) bool {
+		return f.Pkg == pkg && (name == "" || f.Name == name)
+	})
+}
+
+// TestsOfFile returns tests calling functions in file, file
+// is matched by path suffix. If line is positive, only the
+// function containing line is matched, that is the function
+// with the nearest start line before it
+func (c *TestIndex) TestsOfFile(file string, line int) []*TestEntry {
+	file = filepath.ToSlash(file)
+	matchFile := func(f *FuncHit) bool {
+		return f.File == file || strings.HasSuffix(f.File, "/"+file)
+	}
+	if line <= 0 {
+		return c.filter(matchFile)
+	}
+	var funcLine int
+	for _, entry := range c.Tests {
+		for _, f := range entry.Funcs {
+			if matchFile(f) && f.Line <= line && f.Line > funcLine {
+				funcLine = f.Line
+			}
+		}
+	}
+	if funcLine == 0 {
+		return nil
+	}
+	return c.filter(func(f *FuncHit) bool {
+		return matchFile(f) && f.Line == funcLine
+	})
+}
+
+func (c *TestIndex) filter(check func(f *FuncHit) bool) []*TestEntry {
+	var tests []*TestEntry
+	for _, entry := range c.Tests {
+		for _, f := range entry.Funcs {
+			if check(f) {
+				tests = append(tests, entry)
+				break
+			}
+		}
+	}
+	sortTests(tests)
+	return tests
+}
+
+func sortTests(tests []*TestEntry) {
+	sort.Slice(tests, func(i, j int) bool {
+		return tests[i].Key() < tests[j].Key()
+	})
+}
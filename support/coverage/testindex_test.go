@@ -0,0 +1,61 @@
+package coverage
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestTestIndex(t *testing.T) {
+	file := filepath.Join(t.TempDir(), ".xgo", "test-index.json")
+	index, err := LoadTestIndex(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Update([]*TestEntry{
+		{Pkg: "example.com/a", Name: "TestA", Funcs: []*FuncHit{
+			{Pkg: "example.com/a", Name: "A", File: "a/a.go", Line: 3, Count: 1},
+			{Pkg: "example.com/a", Name: "(*T).B", File: "a/a.go", Line: 10, Count: 2},
+		}},
+		{Pkg: "example.com/a", Name: "TestB", Funcs: []*FuncHit{
+			{Pkg: "example.com/a", Name: "(*T).B", File: "a/a.go", Line: 10, Count: 1},
+		}},
+	})
+	err = index.Save(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err = LoadTestIndex(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// TestB run again
+	index.Update([]*TestEntry{{Pkg: "example.com/a", Name: "TestB"}})
+
+	testCases := []struct {
+		name   string
+		tests  []*TestEntry
+		expect []string
+	}{
+		{"all", index.SortedTests(), []string{"example.com/a.TestA", "example.com/a.TestB"}},
+		{"func", index.TestsOfFunc("example.com/a", "(*T).B"), []string{"example.com/a.TestA"}},
+		{"pkg", index.TestsOfFunc("example.com/a", ""), []string{"example.com/a.TestA"}},
+		{"file", index.TestsOfFile("a.go", 0), []string{"example.com/a.TestA"}},
+		{"line", index.TestsOfFile("a/a.go", 5), []string{"example.com/a.TestA"}},
+		{"line before funcs", index.TestsOfFile("a/a.go", 1), nil},
+		{"other file", index.TestsOfFile("b.go", 0), nil},
+	}
+	for _, tt := range testCases {
+		var keys []string
+		for _, test := range tt.tests {
+			keys = append(keys, test.Key())
+		}
+		if len(keys) != len(tt.expect) {
+			t.Fatalf("%s: expect %v, actual: %v", tt.name, tt.expect, keys)
+		}
+		for i := range keys {
+			if keys[i] != tt.expect[i] {
+				t.Fatalf("%s: expect %v, actual: %v", tt.name, tt.expect, keys)
+			}
+		}
+	}
+}
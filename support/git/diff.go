@@ -0,0 +1,237 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/xhd2015/xgo/support/cmd"
+)
+
+// LineRange is an inclusive range of line numbers, starting from 1
+type LineRange struct {
+	Start int
+	End   int
+}
+
+// FileChange describes lines added or modified in a file
+type FileChange struct {
+	// slash separated, relative to the worktree root
+	File string
+	// true if the file does not exist in the base,
+	// or is untracked
+	New   bool
+	Lines []LineRange
+	// lines after which old lines are deleted
+	// without replacement, 0 for the beginning
+	DeletedAfter []int
+}
+
+// HasLine reports whether line is changed
+func (c *FileChange) HasLine(line int) bool {
+	for _, r := range c.Lines {
+		if line >= r.Start && line <= r.End {
+			return true
+		}
+	}
+	return false
+}
+
+// RefExists reports whether ref can be resolved to a commit
+func RefExists(dir string, ref string) bool {
+	_, err := cmd.Dir(dir).Stderr(io.Discard).Output("git", "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	return err == nil
+}
+
+// DefaultBaseRefs are tried in order by ResolveBaseRef
+var DefaultBaseRefs = []string{"origin/HEAD", "origin/main", "origin/master", "main", "master"}
+
+// ResolveBaseRef returns ref if not empty, otherwise
+// the first existing one of DefaultBaseRefs
+func ResolveBaseRef(dir string, ref string) (string, error) {
+	if ref != "" {
+		return ref, nil
+	}
+	for _, defaultRef := range DefaultBaseRefs {
+		if RefExists(dir, defaultRef) {
+			return defaultRef, nil
+		}
+	}
+	return "", fmt.Errorf("cannot find a base ref, requires --base")
+}
+
+func MergeBase(dir string, ref string, other string) (string, error) {
+	return cmd.Dir(dir).Output("git", "merge-base", ref, other)
+}
+
+// ChangedFiles returns files changed by the working tree since
+// the merge base of ref and HEAD, including deleted and untracked
+// ones. Renamed files are reported as both the old and new path.
+// Paths are slash separated, relative to the worktree root.
+func ChangedFiles(dir string, ref string) ([]string, error) {
+	if ref == "" {
+		return nil, fmt.Errorf("requires ref")
+	}
+	base, err := MergeBase(dir, ref, "HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("merge-base %s: %w", ref, err)
+	}
+	topLevel, err := ShowTopLevel(dir)
+	if err != nil {
+		return nil, err
+	}
+	changed, err := cmd.Dir(topLevel).Output("git", "diff", "--name-only", "--no-renames", "--no-ext-diff", base)
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := cmd.Dir(topLevel).Output("git", "ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, file := range strings.Split(changed+"\n"+untracked, "\n") {
+		if file == "" {
+			continue
+		}
+		files = append(files, unquotePath(file))
+	}
+	return files, nil
+}
+
+// DiffLines returns lines changed by the working tree since the
+// merge base of ref and HEAD, i.e. changes introduced by the current
+// branch, including uncommitted ones. Untracked files are treated
+// as new files.
+// Deleted lines are not reported.
+func DiffLines(dir string, ref string) ([]*FileChange, error) {
+	if ref == "" {
+		return nil, fmt.Errorf("requires ref")
+	}
+	base, err := MergeBase(dir, ref, "HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("merge-base %s: %w", ref, err)
+	}
+	diff, err := cmd.Dir(dir).Output("git", "diff", "-U0", "--no-color", "--no-ext-diff", "--src-prefix=a/", "--dst-prefix=b/", base)
+	if err != nil {
+		return nil, err
+	}
+	changes := ParseDiffLines(diff)
+
+	topLevel, err := ShowTopLevel(dir)
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := cmd.Dir(topLevel).Output("git", "ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range strings.Split(untracked, "\n") {
+		if file == "" {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(topLevel, filepath.FromSlash(file)))
+		if err != nil {
+			return nil, err
+		}
+		change := &FileChange{File: file, New: true}
+		if n := countLines(content); n > 0 {
+			change.Lines = []LineRange{{Start: 1, End: n}}
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// ParseDiffLines parses output of git diff, hunks
+// are expected to have no context lines, i.e. -U0
+func ParseDiffLines(diff string) []*FileChange {
+	var changes []*FileChange
+	var cur *FileChange
+	var fromNull bool
+	// removed or added lines may look like headers
+	var inHunk bool
+	for _, line := range strings.Split(diff, "\n") {
+		if strings.HasPrefix(line, "diff --git ") {
+			cur = nil
+			fromNull = false
+			inHunk = false
+			continue
+		}
+		if inHunk {
+			if !strings.HasPrefix(line, "@@ ") {
+				continue
+			}
+		} else if strings.HasPrefix(line, "--- ") {
+			fromNull = line == "--- /dev/null"
+			continue
+		}
+		if strings.HasPrefix(line, "+++ ") {
+			file := unquotePath(strings.TrimPrefix(line, "+++ "))
+			if file == "/dev/null" {
+				// deleted
+				cur = nil
+				continue
+			}
+			cur = &FileChange{
+				File: strings.TrimPrefix(file, "b/"),
+				New:  fromNull,
+			}
+			changes = append(changes, cur)
+			continue
+		}
+		if cur == nil || !strings.HasPrefix(line, "@@ ") {
+			continue
+		}
+		inHunk = true
+		// @@ -a,b +c,d @@
+		fields := strings.Fields(line)
+		if len(fields) < 3 || !strings.HasPrefix(fields[2], "+") {
+			continue
+		}
+		start, count := parseHunkRange(fields[2][1:])
+		if count == 0 {
+			// pure deletion
+			cur.DeletedAfter = append(cur.DeletedAfter, start)
+			continue
+		}
+		cur.Lines = append(cur.Lines, LineRange{Start: start, End: start + count - 1})
+	}
+	return changes
+}
+
+// "c,d" or "c", which means d is 1
+func parseHunkRange(s string) (start int, count int) {
+	count = 1
+	if idx := strings.Index(s, ","); idx >= 0 {
+		count, _ = strconv.Atoi(s[idx+1:])
+		s = s[:idx]
+	}
+	start, _ = strconv.Atoi(s)
+	return start, count
+}
+
+// git quotes paths with special characters
+func unquotePath(s string) string {
+	if strings.HasPrefix(s, `"`) {
+		unquoted, err := strconv.Unquote(s)
+		if err == nil {
+			return unquoted
+		}
+	}
+	return s
+}
+
+func countLines(content []byte) int {
+	if len(content) == 0 {
+		return 0
+	}
+	n := bytes.Count(content, []byte{'\n'})
+	if content[len(content)-1] != '\n' {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,49 @@
+package git
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDiffLines(t *testing.T) {
+	diff := `diff --git a/a.go b/a.go
+index 1111111..2222222 100644
+--- a/a.go
++++ b/a.go
+@@ -3,0 +4,2 @@ func A() {
++	x := 1
++--- not a header
+@@ -10 +12 @@ func B() {
+-	y := 2
++	y := 3
+@@ -20,2 +21,0 @@ func C() {
+-	z := 1
+-	z++
+diff --git a/new.go b/new.go
+new file mode 100644
+index 0000000..3333333
+--- /dev/null
++++ b/new.go
+@@ -0,0 +1,3 @@
++package a
++
++func N() {}
+diff --git a/old.go b/old.go
+deleted file mode 100644
+--- a/old.go
++++ /dev/null
+@@ -1 +0,0 @@
+-package a
+`
+	changes := ParseDiffLines(diff)
+	expect := []*FileChange{
+		{File: "a.go", Lines: []LineRange{{Start: 4, End: 5}, {Start: 12, End: 12}}, DeletedAfter: []int{21}},
+		{File: "new.go", New: true, Lines: []LineRange{{Start: 1, End: 3}}},
+	}
+	if !reflect.DeepEqual(changes, expect) {
+		t.Fatalf("expect %+v, actual %+v", expect, changes)
+	}
+	if !changes[0].HasLine(5) || changes[0].HasLine(6) {
+		t.Fatalf("HasLine mismatch")
+	}
+}